@@ -5,12 +5,34 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/xelathan/golang_backend/config"
+	"github.com/xelathan/golang_backend/db"
+	"github.com/xelathan/golang_backend/money"
 	"github.com/xelathan/golang_backend/services/cart"
+	"github.com/xelathan/golang_backend/services/catalog"
+	"github.com/xelathan/golang_backend/services/currency"
+	"github.com/xelathan/golang_backend/services/giftcard"
+	"github.com/xelathan/golang_backend/services/idempotency"
+	"github.com/xelathan/golang_backend/services/inventory"
+	"github.com/xelathan/golang_backend/services/loyalty"
+	"github.com/xelathan/golang_backend/services/notification"
 	"github.com/xelathan/golang_backend/services/order"
+	"github.com/xelathan/golang_backend/services/payment"
+	"github.com/xelathan/golang_backend/services/pricing"
 	"github.com/xelathan/golang_backend/services/product"
+	"github.com/xelathan/golang_backend/services/promotion"
+	"github.com/xelathan/golang_backend/services/refund"
+	"github.com/xelathan/golang_backend/services/review"
+	"github.com/xelathan/golang_backend/services/rma"
+	"github.com/xelathan/golang_backend/services/shipment"
+	"github.com/xelathan/golang_backend/services/shipping"
+	"github.com/xelathan/golang_backend/services/subscription"
+	"github.com/xelathan/golang_backend/services/tax"
 	"github.com/xelathan/golang_backend/services/user"
+	"github.com/xelathan/golang_backend/utils"
 )
 
 type APIServer struct {
@@ -26,24 +48,158 @@ func NewAPIServer(addr string, db *sql.DB) *APIServer {
 }
 
 func (s *APIServer) Run() error {
+	// amounts sent without a currency are in the base currency
+	money.DefaultCurrency = config.Envs.BaseCurrency
+
 	router := mux.NewRouter()
 	subRouter := router.PathPrefix("/api/v1").Subrouter()
+	subRouter.Use(requestTimeouts().Middleware)
 
 	userStore := user.NewStore(s.db)
-	userHandler := user.NewHandler(userStore)
-	userHandler.RegisterRoutes(subRouter)
 
-	productStore := product.NewStore(s.db)
-	productHandler := product.NewHandler(productStore)
+	notifier := notification.NewNotifierFromConfig()
+
+	inventoryStore := inventory.NewStore(s.db)
+	stockMonitor := inventory.NewMonitor(
+		product.NewStore(s.db),
+		inventoryStore,
+		notifier,
+		notification.StaffRecipients(),
+		time.Second*time.Duration(config.Envs.StockSweepIntervalInSeconds),
+	)
+
+	// every stock change made through the API is checked against thresholds
+	productStore := inventory.NewMonitoredProductStore(product.NewStore(s.db), stockMonitor)
+	reviewStore := review.NewStore(s.db)
+	// base prices scheduled for later take effect on products as they come due
+	priceStore := pricing.NewStore(s.db)
+	priceStore.Start(time.Second * time.Duration(config.Envs.PriceSweepIntervalInSeconds))
+	currencyStore := currency.NewStore(s.db)
+	productHandler := product.NewHandler(productStore, userStore, reviewStore, priceStore, currencyStore)
 	productHandler.RegisterRoutes(subRouter)
 
+	pricingHandler := pricing.NewHandler(priceStore, productStore, userStore)
+	pricingHandler.RegisterRoutes(subRouter)
+
+	currencyHandler := currency.NewHandler(currencyStore, productStore, userStore)
+	currencyHandler.RegisterRoutes(subRouter)
+
+	catalogHandler := catalog.NewHandler(productStore, userStore)
+	catalogHandler.RegisterRoutes(subRouter)
+
+	inventoryHandler := inventory.NewHandler(inventoryStore, productStore, userStore)
+	inventoryHandler.RegisterRoutes(subRouter)
+
+	promotionStore := promotion.NewStore(s.db)
+	promotionHandler := promotion.NewHandler(promotionStore, productStore, userStore)
+	promotionHandler.RegisterRoutes(subRouter)
+
+	taxStore := tax.NewStore(s.db)
+	taxHandler := tax.NewHandler(taxStore, userStore)
+	taxHandler.RegisterRoutes(subRouter)
+
+	shippingStore := shipping.NewStore(s.db)
+	shippingHandler := shipping.NewHandler(shippingStore, userStore)
+	shippingHandler.RegisterRoutes(subRouter)
+
+	// retried checkouts, cancellations, order edits, shipments, refunds,
+	// returns, payments, gift card issues and subscribes are answered from
+	// the first attempt
+	idempotencyKeys := idempotency.NewKeys(
+		idempotency.NewStore(s.db),
+		time.Second*time.Duration(config.Envs.IdempotencyKeyTTLInSeconds),
+		time.Second*time.Duration(config.Envs.IdempotencyKeySweepIntervalInSeconds),
+	)
+	idempotencyKeys.Start()
+
 	orderStore := order.NewStore(s.db)
-	orderHandler := order.NewHandler(userStore, orderStore, productStore, s.db)
+	unitOfWork := db.NewUnitOfWork(s.db)
+
+	// replenished stock goes to backordered order items first
+	stockMonitor.SetAllocator(inventory.NewAllocator(unitOfWork, productStore, orderStore))
+	stockMonitor.Start()
+
+	paymentStore := payment.NewStore(s.db)
+	orderStateMachine := order.NewStateMachine(unitOfWork, orderStore, paymentStore, productStore, promotionStore)
+
+	// gift cards, loyalty points and store credit pay for orders alongside
+	// the provider
+	giftCardStore := giftcard.NewStore(s.db)
+	loyaltyStore := loyalty.NewStore(s.db)
+	loyaltyPoints := loyalty.NewPointsFromConfig(loyaltyStore)
+	paymentProcessor := payment.NewProcessor(unitOfWork, payment.NewProviderFromConfig(), paymentStore, orderStateMachine,
+		giftcard.NewGiftCards(giftCardStore), giftcard.NewStoreCredit(giftCardStore), loyaltyPoints,
+	)
+	paymentHandler := payment.NewHandler(paymentProcessor)
+	paymentHandler.RegisterRoutes(subRouter)
+
+	// the fake provider delivers its webhooks in-process
+	if fake, ok := paymentProcessor.Provider().(*payment.FakeProvider); ok {
+		fake.SetWebhookReceiver(paymentHandler.DeliverWebhook)
+	}
+
+	refundStore := refund.NewStore(s.db)
+	returnStore := rma.NewStore(s.db)
+	shipmentStore := shipment.NewStore(s.db)
+	refunder := refund.NewRefunder(unitOfWork, refundStore, orderStore, productStore, paymentStore, paymentProcessor, orderStateMachine)
+
+	// edits to orders that are paid for refund the difference, retried
+	// until it is made
+	orderEditor := order.NewEditor(unitOfWork, orderStore, productStore, promotionStore, taxStore, shippingStore, currencyStore, refundStore, shipmentStore, paymentStore, refunder)
+	orderEditor.Start(time.Second * time.Duration(config.Envs.RevisionRefundIntervalInSeconds))
+	orderHandler := order.NewHandler(userStore, orderStore, refundStore, returnStore, shipmentStore, orderEditor, paymentProcessor, orderStateMachine, idempotencyKeys)
 	orderHandler.RegisterRoutes(subRouter)
 
-	cartHandler := cart.NewHandler(orderStore, productStore, userStore, s.db)
+	// shipments with the simulated carrier are delivered by tracking them
+	fulfilment := shipment.NewFulfilment(unitOfWork, shipmentStore, orderStore, orderStateMachine,
+		shipment.NewSimulatedCarrier(time.Second*time.Duration(config.Envs.SimulatedCarrierTransitInSeconds)),
+	)
+	fulfilment.Start(time.Second * time.Duration(config.Envs.ShipmentTrackingIntervalInSeconds))
+	shipmentHandler := shipment.NewHandler(fulfilment, shipmentStore, userStore, idempotencyKeys)
+	shipmentHandler.RegisterRoutes(subRouter)
+
+	// gift cards bought in the shop are issued once their order is paid for
+	giftCardIssuer := giftcard.NewIssuer(giftCardStore, notifier, 24*time.Hour*time.Duration(config.Envs.GiftCardValidityInDays))
+	giftCardIssuer.Start(time.Second * time.Duration(config.Envs.GiftCardSweepIntervalInSeconds))
+	giftCardHandler := giftcard.NewHandler(giftCardIssuer, giftCardStore, userStore, idempotencyKeys)
+	giftCardHandler.RegisterRoutes(subRouter)
+
+	refundHandler := refund.NewHandler(refunder, refundStore, userStore, idempotencyKeys)
+	refundHandler.RegisterRoutes(subRouter)
+
+	returns := rma.NewReturns(unitOfWork, returnStore, orderStore, productStore, refunder, 24*time.Hour*time.Duration(config.Envs.ReturnWindowInDays))
+	returnHandler := rma.NewHandler(returns, returnStore, userStore, idempotencyKeys)
+	returnHandler.RegisterRoutes(subRouter)
+
+	// points earned on delivered orders are pending for the return window
+	loyaltyProgram := loyalty.NewProgram(loyaltyStore, orderStore, productStore, promotionStore, config.Envs.BaseCurrency,
+		24*time.Hour*time.Duration(config.Envs.ReturnWindowInDays),
+		24*time.Hour*time.Duration(config.Envs.LoyaltyInactivityInDays),
+	)
+	loyaltyProgram.Start(time.Second * time.Duration(config.Envs.LoyaltySweepIntervalInSeconds))
+	loyaltyHandler := loyalty.NewHandler(loyaltyStore, promotionStore, userStore, loyaltyPoints)
+	loyaltyHandler.RegisterRoutes(subRouter)
+
+	reviewHandler := review.NewHandler(reviewStore, orderStore, userStore)
+	reviewHandler.RegisterRoutes(subRouter)
+
+	// logging in or registering with a cart token merges the guest cart
+	cartStore := cart.NewStore(s.db)
+	userHandler := user.NewHandler(userStore, cart.NewMerger(cartStore, productStore))
+	userHandler.RegisterRoutes(subRouter)
+
+	cartHandler := cart.NewHandler(cartStore, orderStore, productStore, userStore, priceStore, currencyStore, promotionStore, tax.NewTableCalculator(taxStore), taxStore, shippingStore, giftCardStore, paymentStore, loyaltyStore, paymentProcessor, idempotencyKeys, unitOfWork)
 	cartHandler.RegisterRoutes(subRouter)
 
+	// subscription orders are placed the way checkout places them
+	subscriptionStore := subscription.NewStore(s.db)
+	subscriptionScheduler := subscription.NewScheduler(subscriptionStore, cartHandler, paymentProcessor, userStore, notifier,
+		time.Hour*time.Duration(config.Envs.SubscriptionRetryDelayInHours), int(config.Envs.SubscriptionMaxRetries),
+	)
+	subscriptionScheduler.Start(time.Second * time.Duration(config.Envs.SubscriptionSweepIntervalInSeconds))
+	subscriptionHandler := subscription.NewHandler(subscriptionStore, productStore, userStore, idempotencyKeys)
+	subscriptionHandler.RegisterRoutes(subRouter)
+
 	subRouter.HandleFunc("/", handleHome).Methods("GET")
 
 	log.Println("Listening on", s.addr)
@@ -56,3 +212,16 @@ func handleHome(w http.ResponseWriter, req *http.Request) {
 
 	fmt.Fprintf(w, "Success!")
 }
+
+// requestTimeouts sets the deadline of every route. Catalog files can take
+// minutes to move, and checkout waits on the payment provider.
+func requestTimeouts() utils.RouteTimeouts {
+	return utils.RouteTimeouts{
+		Default: time.Second * time.Duration(config.Envs.RequestTimeoutInSeconds),
+		Routes: map[string]time.Duration{
+			"/api/v1/admin/catalog/import": 10 * time.Minute,
+			"/api/v1/admin/catalog/export": 10 * time.Minute,
+			"/api/v1/cart/checkout":        30 * time.Second,
+		},
+	}
+}
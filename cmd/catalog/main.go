@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"os"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/xelathan/golang_backend/config"
+	"github.com/xelathan/golang_backend/db"
+	"github.com/xelathan/golang_backend/money"
+	"github.com/xelathan/golang_backend/services/catalog"
+	"github.com/xelathan/golang_backend/services/product"
+)
+
+// usage:
+//
+//	go run cmd/catalog/main.go import -format csv -dry-run catalog.csv
+//	go run cmd/catalog/main.go export -format jsonl > catalog.jsonl
+func main() {
+	if len(os.Args) < 2 {
+		log.Fatal("expected 'import' or 'export' subcommand")
+	}
+
+	db, err := db.NewMySQLStorage(mysql.Config{
+		User:                 config.Envs.DBUser,
+		Passwd:               config.Envs.DBPassword,
+		Addr:                 config.Envs.DBAddress,
+		DBName:               config.Envs.DBName,
+		Net:                  "tcp",
+		AllowNativePasswords: true,
+		ParseTime:            true,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	money.DefaultCurrency = config.Envs.BaseCurrency
+	store := product.NewStore(db)
+
+	switch os.Args[1] {
+	case "import":
+		cmd := flag.NewFlagSet("import", flag.ExitOnError)
+		format := cmd.String("format", catalog.FormatCSV, "input format: csv or jsonl")
+		dryRun := cmd.Bool("dry-run", false, "validate and report without writing")
+		batchSize := cmd.Int("batch-size", catalog.DefaultBatchSize, "rows committed per transaction")
+		cmd.Parse(os.Args[2:])
+
+		var input io.Reader = os.Stdin
+		if cmd.NArg() > 0 {
+			file, err := os.Open(cmd.Arg(0))
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer file.Close()
+			input = file
+		}
+
+		report, err := catalog.Import(context.Background(), store, input, catalog.ImportOptions{Format: *format, DryRun: *dryRun, BatchSize: *batchSize})
+		if report != nil {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			encoder.Encode(report)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		if report.Failed > 0 {
+			os.Exit(1)
+		}
+	case "export":
+		cmd := flag.NewFlagSet("export", flag.ExitOnError)
+		format := cmd.String("format", catalog.FormatCSV, "output format: csv or jsonl")
+		cmd.Parse(os.Args[2:])
+
+		if err := catalog.Export(context.Background(), store, os.Stdout, *format); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		log.Fatalf("unknown subcommand %q", os.Args[1])
+	}
+}
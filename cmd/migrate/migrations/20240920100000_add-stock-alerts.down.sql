@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS `stock_subscriptions`;
+DROP TABLE IF EXISTS `product_stock_levels`;
+ALTER TABLE products DROP COLUMN `reorderThreshold`;
@@ -0,0 +1,24 @@
+ALTER TABLE products ADD COLUMN `reorderThreshold` INT UNSIGNED NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS `product_stock_levels` (
+    `productId` INT UNSIGNED NOT NULL,
+    `lastQuantity` INT UNSIGNED NOT NULL,
+    `belowThreshold` BOOLEAN NOT NULL DEFAULT FALSE,
+    `updatedAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (`productId`),
+    FOREIGN KEY (`productId`) REFERENCES products(`id`)
+);
+
+CREATE TABLE IF NOT EXISTS `stock_subscriptions` (
+    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `productId` INT UNSIGNED NOT NULL,
+    `userId` INT UNSIGNED NOT NULL,
+    `email` VARCHAR(255) NOT NULL,
+    `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `notifiedAt` TIMESTAMP NULL DEFAULT NULL,
+
+    PRIMARY KEY (`id`),
+    FOREIGN KEY (`productId`) REFERENCES products(`id`),
+    FOREIGN KEY (`userId`) REFERENCES users(`id`)
+);
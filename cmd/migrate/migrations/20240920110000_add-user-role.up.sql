@@ -0,0 +1 @@
+ALTER TABLE users ADD COLUMN `role` ENUM('customer', 'admin') NOT NULL DEFAULT 'customer';
@@ -0,0 +1 @@
+ALTER TABLE products DROP INDEX `unique_sku`, DROP COLUMN `sku`;
@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS `reviews` (
+    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `productId` INT UNSIGNED NOT NULL,
+    `userId` INT UNSIGNED NOT NULL,
+    `rating` TINYINT UNSIGNED NOT NULL,
+    `title` VARCHAR(255) NOT NULL,
+    `body` TEXT NOT NULL,
+    `status` ENUM('pending', 'approved', 'rejected') NOT NULL DEFAULT 'pending',
+    `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `updatedAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `unique_user_product_review` (`userId`, `productId`),
+    KEY `product_status` (`productId`, `status`),
+    FOREIGN KEY (`productId`) REFERENCES products(`id`),
+    FOREIGN KEY (`userId`) REFERENCES users(`id`),
+    CHECK (`rating` BETWEEN 1 AND 5)
+);
+
+CREATE TABLE IF NOT EXISTS `review_votes` (
+    `reviewId` INT UNSIGNED NOT NULL,
+    `userId` INT UNSIGNED NOT NULL,
+    `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (`reviewId`, `userId`),
+    FOREIGN KEY (`reviewId`) REFERENCES reviews(`id`),
+    FOREIGN KEY (`userId`) REFERENCES users(`id`)
+);
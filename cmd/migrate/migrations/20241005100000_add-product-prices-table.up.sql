@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS `product_prices` (
+    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `productId` INT UNSIGNED NOT NULL,
+    `price` DECIMAL(10, 2) NOT NULL,
+    `compareAtPrice` DECIMAL(10, 2) NULL DEFAULT NULL,
+    `effectiveFrom` TIMESTAMP NOT NULL,
+    `effectiveTo` TIMESTAMP NULL DEFAULT NULL,
+    `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (`id`),
+    KEY `product_effective` (`productId`, `effectiveFrom`),
+    FOREIGN KEY (`productId`) REFERENCES products(`id`)
+);
+
+INSERT INTO product_prices (productId, price, effectiveFrom) SELECT id, price, createdAt FROM products;
@@ -0,0 +1,22 @@
+ALTER TABLE product_prices
+    ADD COLUMN `priceDecimal` DECIMAL(10, 2) NOT NULL DEFAULT 0 AFTER `price`,
+    ADD COLUMN `compareAtPriceDecimal` DECIMAL(10, 2) NULL DEFAULT NULL AFTER `compareAtPrice`;
+UPDATE product_prices SET priceDecimal = price / 100, compareAtPriceDecimal = compareAtPrice / 100;
+ALTER TABLE product_prices
+    DROP COLUMN `price`,
+    DROP COLUMN `compareAtPrice`,
+    DROP COLUMN `currency`,
+    CHANGE COLUMN `priceDecimal` `price` DECIMAL(10, 2) NOT NULL,
+    CHANGE COLUMN `compareAtPriceDecimal` `compareAtPrice` DECIMAL(10, 2) NULL DEFAULT NULL;
+
+ALTER TABLE order_items ADD COLUMN `priceDecimal` DECIMAL(10, 2) NOT NULL DEFAULT 0 AFTER `price`;
+UPDATE order_items SET priceDecimal = price / 100;
+ALTER TABLE order_items DROP COLUMN `price`, DROP COLUMN `currency`, CHANGE COLUMN `priceDecimal` `price` DECIMAL(10, 2) NOT NULL;
+
+ALTER TABLE orders ADD COLUMN `totalDecimal` DECIMAL(10, 2) NOT NULL DEFAULT 0 AFTER `total`;
+UPDATE orders SET totalDecimal = total / 100;
+ALTER TABLE orders DROP COLUMN `total`, DROP COLUMN `currency`, CHANGE COLUMN `totalDecimal` `total` DECIMAL(10, 2) NOT NULL;
+
+ALTER TABLE products ADD COLUMN `priceDecimal` DECIMAL(10, 2) NOT NULL DEFAULT 0 AFTER `price`;
+UPDATE products SET priceDecimal = price / 100;
+ALTER TABLE products DROP COLUMN `price`, DROP COLUMN `currency`, CHANGE COLUMN `priceDecimal` `price` DECIMAL(10, 2) NOT NULL;
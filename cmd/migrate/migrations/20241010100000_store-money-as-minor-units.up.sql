@@ -0,0 +1,30 @@
+-- money columns hold integer minor units of the row's currency, e.g. 1234 USD is $12.34
+
+ALTER TABLE products
+    ADD COLUMN `priceMinor` BIGINT NOT NULL DEFAULT 0 AFTER `price`,
+    ADD COLUMN `currency` CHAR(3) NOT NULL DEFAULT 'USD' AFTER `priceMinor`;
+UPDATE products SET priceMinor = ROUND(price * 100);
+ALTER TABLE products DROP COLUMN `price`, CHANGE COLUMN `priceMinor` `price` BIGINT NOT NULL;
+
+ALTER TABLE orders
+    ADD COLUMN `totalMinor` BIGINT NOT NULL DEFAULT 0 AFTER `total`,
+    ADD COLUMN `currency` CHAR(3) NOT NULL DEFAULT 'USD' AFTER `totalMinor`;
+UPDATE orders SET totalMinor = ROUND(total * 100);
+ALTER TABLE orders DROP COLUMN `total`, CHANGE COLUMN `totalMinor` `total` BIGINT NOT NULL;
+
+ALTER TABLE order_items
+    ADD COLUMN `priceMinor` BIGINT NOT NULL DEFAULT 0 AFTER `price`,
+    ADD COLUMN `currency` CHAR(3) NOT NULL DEFAULT 'USD' AFTER `priceMinor`;
+UPDATE order_items SET priceMinor = ROUND(price * 100);
+ALTER TABLE order_items DROP COLUMN `price`, CHANGE COLUMN `priceMinor` `price` BIGINT NOT NULL;
+
+ALTER TABLE product_prices
+    ADD COLUMN `priceMinor` BIGINT NOT NULL DEFAULT 0 AFTER `price`,
+    ADD COLUMN `compareAtPriceMinor` BIGINT NULL DEFAULT NULL AFTER `compareAtPrice`,
+    ADD COLUMN `currency` CHAR(3) NOT NULL DEFAULT 'USD' AFTER `compareAtPriceMinor`;
+UPDATE product_prices SET priceMinor = ROUND(price * 100), compareAtPriceMinor = ROUND(compareAtPrice * 100);
+ALTER TABLE product_prices
+    DROP COLUMN `price`,
+    DROP COLUMN `compareAtPrice`,
+    CHANGE COLUMN `priceMinor` `price` BIGINT NOT NULL,
+    CHANGE COLUMN `compareAtPriceMinor` `compareAtPrice` BIGINT NULL DEFAULT NULL;
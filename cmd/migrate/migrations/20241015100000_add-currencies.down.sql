@@ -0,0 +1,4 @@
+ALTER TABLE orders DROP COLUMN `exchangeRate`;
+
+DROP TABLE IF EXISTS `product_currency_prices`;
+DROP TABLE IF EXISTS `exchange_rates`;
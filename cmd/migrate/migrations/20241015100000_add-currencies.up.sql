@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS `exchange_rates` (
+    `currency` CHAR(3) NOT NULL,
+    `rate` DECIMAL(18, 8) NOT NULL,
+    `updatedAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (`currency`)
+);
+
+CREATE TABLE IF NOT EXISTS `product_currency_prices` (
+    `productId` INT UNSIGNED NOT NULL,
+    `currency` CHAR(3) NOT NULL,
+    `price` BIGINT NOT NULL,
+    `updatedAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (`productId`, `currency`),
+    FOREIGN KEY (`productId`) REFERENCES products(`id`)
+);
+
+ALTER TABLE orders ADD COLUMN `exchangeRate` DECIMAL(18, 8) NOT NULL DEFAULT 1 AFTER `currency`;
@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS `carts` (
+    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `userId` INT UNSIGNED NOT NULL,
+    `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `updatedAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `unique_user_cart` (`userId`),
+    FOREIGN KEY (`userId`) REFERENCES users(`id`)
+);
+
+CREATE TABLE IF NOT EXISTS `cart_items` (
+    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `cartId` INT UNSIGNED NOT NULL,
+    `productId` INT UNSIGNED NOT NULL,
+    `quantity` INT UNSIGNED NOT NULL,
+    `addedPrice` BIGINT NOT NULL,
+    `addedCurrency` CHAR(3) NOT NULL,
+    `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `unique_cart_product` (`cartId`, `productId`),
+    FOREIGN KEY (`cartId`) REFERENCES carts(`id`) ON DELETE CASCADE,
+    FOREIGN KEY (`productId`) REFERENCES products(`id`)
+);
@@ -0,0 +1,4 @@
+DELETE FROM carts WHERE `userId` IS NULL;
+ALTER TABLE carts DROP INDEX `unique_guest_cart`;
+ALTER TABLE carts DROP COLUMN `guestToken`;
+ALTER TABLE carts MODIFY `userId` INT UNSIGNED NOT NULL;
@@ -0,0 +1,3 @@
+ALTER TABLE carts MODIFY `userId` INT UNSIGNED NULL;
+ALTER TABLE carts ADD COLUMN `guestToken` CHAR(32) NULL AFTER `userId`;
+ALTER TABLE carts ADD UNIQUE KEY `unique_guest_cart` (`guestToken`);
@@ -0,0 +1,9 @@
+ALTER TABLE orders DROP COLUMN `discount`;
+
+DROP TABLE IF EXISTS `order_item_discounts`;
+DROP TABLE IF EXISTS `promotion_redemptions`;
+DROP TABLE IF EXISTS `promotion_targets`;
+DROP TABLE IF EXISTS `promotions`;
+
+ALTER TABLE products DROP KEY `product_category`;
+ALTER TABLE products DROP COLUMN `category`;
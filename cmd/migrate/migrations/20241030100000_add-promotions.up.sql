@@ -0,0 +1,76 @@
+ALTER TABLE products ADD COLUMN `category` VARCHAR(64) NULL;
+ALTER TABLE products ADD KEY `product_category` (`category`);
+
+CREATE TABLE IF NOT EXISTS `promotions` (
+    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `name` VARCHAR(255) NOT NULL,
+    `code` VARCHAR(64) NULL,
+    `type` ENUM('percentage', 'fixed', 'buy_x_get_y', 'free_shipping') NOT NULL,
+    `percentOff` INT UNSIGNED NOT NULL DEFAULT 0,
+    `amountOff` BIGINT NOT NULL DEFAULT 0,
+    `buyQuantity` INT UNSIGNED NOT NULL DEFAULT 0,
+    `getQuantity` INT UNSIGNED NOT NULL DEFAULT 0,
+    `minOrderValue` BIGINT NOT NULL DEFAULT 0,
+    `currency` CHAR(3) NOT NULL,
+    `startsAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `endsAt` TIMESTAMP NULL,
+    `usageLimit` INT UNSIGNED NULL,
+    `perUserLimit` INT UNSIGNED NULL,
+    `exclusive` BOOLEAN NOT NULL DEFAULT FALSE,
+    `priority` INT NOT NULL DEFAULT 0,
+    `active` BOOLEAN NOT NULL DEFAULT TRUE,
+    `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `unique_promotion_code` (`code`),
+    CHECK (`percentOff` <= 100)
+);
+
+CREATE TABLE IF NOT EXISTS `promotion_targets` (
+    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `promotionId` INT UNSIGNED NOT NULL,
+    `productId` INT UNSIGNED NULL,
+    `category` VARCHAR(64) NULL,
+
+    PRIMARY KEY (`id`),
+    KEY `promotion_target` (`promotionId`),
+    FOREIGN KEY (`promotionId`) REFERENCES promotions(`id`) ON DELETE CASCADE,
+    FOREIGN KEY (`productId`) REFERENCES products(`id`)
+);
+
+CREATE TABLE IF NOT EXISTS `promotion_redemptions` (
+    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `promotionId` INT UNSIGNED NOT NULL,
+    `orderId` INT UNSIGNED NOT NULL,
+    `userId` INT UNSIGNED NOT NULL,
+    `code` VARCHAR(64) NULL,
+    `amount` BIGINT NOT NULL,
+    `currency` CHAR(3) NOT NULL,
+    `freeShipping` BOOLEAN NOT NULL DEFAULT FALSE,
+    `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `reversedAt` TIMESTAMP NULL,
+
+    PRIMARY KEY (`id`),
+    KEY `promotion_usage` (`promotionId`, `userId`),
+    KEY `order_redemptions` (`orderId`),
+    FOREIGN KEY (`promotionId`) REFERENCES promotions(`id`),
+    FOREIGN KEY (`orderId`) REFERENCES orders(`id`),
+    FOREIGN KEY (`userId`) REFERENCES users(`id`)
+);
+
+CREATE TABLE IF NOT EXISTS `order_item_discounts` (
+    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `orderId` INT UNSIGNED NOT NULL,
+    `orderItemId` INT UNSIGNED NOT NULL,
+    `promotionId` INT UNSIGNED NOT NULL,
+    `amount` BIGINT NOT NULL,
+    `currency` CHAR(3) NOT NULL,
+
+    PRIMARY KEY (`id`),
+    KEY `order_discounts` (`orderId`),
+    FOREIGN KEY (`orderId`) REFERENCES orders(`id`),
+    FOREIGN KEY (`orderItemId`) REFERENCES order_items(`id`),
+    FOREIGN KEY (`promotionId`) REFERENCES promotions(`id`)
+);
+
+ALTER TABLE orders ADD COLUMN `discount` BIGINT NOT NULL DEFAULT 0 AFTER `total`;
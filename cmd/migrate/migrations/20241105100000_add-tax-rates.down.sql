@@ -0,0 +1,6 @@
+ALTER TABLE orders DROP COLUMN `tax`;
+
+DROP TABLE IF EXISTS `order_item_taxes`;
+DROP TABLE IF EXISTS `tax_rates`;
+
+ALTER TABLE products DROP COLUMN `taxClass`;
@@ -0,0 +1,40 @@
+ALTER TABLE products ADD COLUMN `taxClass` VARCHAR(32) NOT NULL DEFAULT 'standard';
+
+CREATE TABLE IF NOT EXISTS `tax_rates` (
+    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `country` CHAR(2) NOT NULL,
+    `region` VARCHAR(64) NULL,
+    `postalPrefix` VARCHAR(16) NULL,
+    `taxClass` VARCHAR(32) NOT NULL DEFAULT 'standard',
+    `name` VARCHAR(64) NOT NULL,
+    `rate` DECIMAL(9, 6) NOT NULL,
+    `inclusive` BOOLEAN NOT NULL DEFAULT FALSE,
+    `effectiveFrom` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `effectiveTo` TIMESTAMP NULL,
+    `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (`id`),
+    KEY `country_effective` (`country`, `effectiveFrom`),
+    CHECK (`rate` >= 0 AND `rate` < 1)
+);
+
+CREATE TABLE IF NOT EXISTS `order_item_taxes` (
+    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `orderId` INT UNSIGNED NOT NULL,
+    `orderItemId` INT UNSIGNED NOT NULL,
+    `taxRateId` INT UNSIGNED NOT NULL,
+    `name` VARCHAR(64) NOT NULL,
+    `rate` DECIMAL(9, 6) NOT NULL,
+    `inclusive` BOOLEAN NOT NULL,
+    `taxable` BIGINT NOT NULL,
+    `amount` BIGINT NOT NULL,
+    `currency` CHAR(3) NOT NULL,
+
+    PRIMARY KEY (`id`),
+    KEY `order_taxes` (`orderId`),
+    FOREIGN KEY (`orderId`) REFERENCES orders(`id`),
+    FOREIGN KEY (`orderItemId`) REFERENCES order_items(`id`),
+    FOREIGN KEY (`taxRateId`) REFERENCES tax_rates(`id`)
+);
+
+ALTER TABLE orders ADD COLUMN `tax` BIGINT NOT NULL DEFAULT 0 AFTER `discount`;
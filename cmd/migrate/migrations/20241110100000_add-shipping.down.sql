@@ -0,0 +1,14 @@
+ALTER TABLE orders DROP FOREIGN KEY `orders_shipping_method`;
+ALTER TABLE orders DROP COLUMN `shippingMethod`;
+ALTER TABLE orders DROP COLUMN `shippingMethodId`;
+ALTER TABLE orders DROP COLUMN `shippingCost`;
+
+DROP TABLE IF EXISTS `shipping_rate_tiers`;
+DROP TABLE IF EXISTS `shipping_methods`;
+DROP TABLE IF EXISTS `shipping_zone_regions`;
+DROP TABLE IF EXISTS `shipping_zones`;
+
+ALTER TABLE products DROP COLUMN `heightMm`;
+ALTER TABLE products DROP COLUMN `widthMm`;
+ALTER TABLE products DROP COLUMN `lengthMm`;
+ALTER TABLE products DROP COLUMN `weightGrams`;
@@ -0,0 +1,56 @@
+ALTER TABLE products ADD COLUMN `weightGrams` INT UNSIGNED NOT NULL DEFAULT 0;
+ALTER TABLE products ADD COLUMN `lengthMm` INT UNSIGNED NOT NULL DEFAULT 0;
+ALTER TABLE products ADD COLUMN `widthMm` INT UNSIGNED NOT NULL DEFAULT 0;
+ALTER TABLE products ADD COLUMN `heightMm` INT UNSIGNED NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS `shipping_zones` (
+    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `name` VARCHAR(64) NOT NULL,
+    `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (`id`)
+);
+
+CREATE TABLE IF NOT EXISTS `shipping_zone_regions` (
+    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `zoneId` INT UNSIGNED NOT NULL,
+    `country` CHAR(2) NOT NULL,
+    `region` VARCHAR(64) NULL,
+    `postalPrefix` VARCHAR(16) NULL,
+
+    PRIMARY KEY (`id`),
+    KEY `zone_country` (`country`),
+    FOREIGN KEY (`zoneId`) REFERENCES shipping_zones(`id`) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS `shipping_methods` (
+    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `zoneId` INT UNSIGNED NOT NULL,
+    `name` VARCHAR(64) NOT NULL,
+    `kind` ENUM('standard', 'express', 'pickup') NOT NULL,
+    `rateType` ENUM('flat', 'weight', 'price') NOT NULL,
+    `flatRate` BIGINT NOT NULL DEFAULT 0,
+    `currency` CHAR(3) NOT NULL,
+    `minDays` INT UNSIGNED NOT NULL DEFAULT 0,
+    `maxDays` INT UNSIGNED NOT NULL DEFAULT 0,
+    `active` BOOLEAN NOT NULL DEFAULT TRUE,
+    `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (`id`),
+    FOREIGN KEY (`zoneId`) REFERENCES shipping_zones(`id`)
+);
+
+CREATE TABLE IF NOT EXISTS `shipping_rate_tiers` (
+    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `methodId` INT UNSIGNED NOT NULL,
+    `upTo` BIGINT NULL,
+    `rate` BIGINT NOT NULL,
+
+    PRIMARY KEY (`id`),
+    FOREIGN KEY (`methodId`) REFERENCES shipping_methods(`id`) ON DELETE CASCADE
+);
+
+ALTER TABLE orders ADD COLUMN `shippingCost` BIGINT NOT NULL DEFAULT 0 AFTER `tax`;
+ALTER TABLE orders ADD COLUMN `shippingMethodId` INT UNSIGNED NULL AFTER `shippingCost`;
+ALTER TABLE orders ADD COLUMN `shippingMethod` VARCHAR(64) NULL AFTER `shippingMethodId`;
+ALTER TABLE orders ADD CONSTRAINT `orders_shipping_method` FOREIGN KEY (`shippingMethodId`) REFERENCES shipping_methods(`id`);
@@ -0,0 +1,20 @@
+ALTER TABLE orders MODIFY COLUMN `status` ENUM('pending', 'paid', 'completed', 'cancelled') NOT NULL DEFAULT 'pending';
+
+CREATE TABLE IF NOT EXISTS `payments` (
+    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `orderId` INT UNSIGNED NOT NULL,
+    `provider` VARCHAR(32) NOT NULL,
+    `reference` VARCHAR(128) NOT NULL,
+    `status` ENUM('requires_action', 'authorized', 'captured', 'declined', 'failed', 'voided', 'partially_refunded', 'refunded') NOT NULL,
+    `amount` BIGINT NOT NULL,
+    `currency` CHAR(3) NOT NULL,
+    `refunded` BIGINT NOT NULL DEFAULT 0,
+    `failureReason` VARCHAR(255) NULL,
+    `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `updatedAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `provider_reference` (`provider`, `reference`),
+    KEY `payment_order` (`orderId`),
+    FOREIGN KEY (`orderId`) REFERENCES orders(`id`)
+);
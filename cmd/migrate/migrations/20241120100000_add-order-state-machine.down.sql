@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS `order_status_history`;
+
+ALTER TABLE orders MODIFY COLUMN `status` ENUM('pending', 'paid', 'completed', 'processing', 'shipped', 'delivered', 'cancelled', 'refunded', 'partially_refunded', 'returned') NOT NULL DEFAULT 'pending';
+UPDATE orders SET status = 'completed' WHERE status IN ('delivered', 'returned');
+UPDATE orders SET status = 'paid' WHERE status IN ('processing', 'shipped');
+UPDATE orders SET status = 'cancelled' WHERE status IN ('refunded', 'partially_refunded');
+ALTER TABLE orders MODIFY COLUMN `status` ENUM('pending', 'paid', 'completed', 'cancelled') NOT NULL DEFAULT 'pending';
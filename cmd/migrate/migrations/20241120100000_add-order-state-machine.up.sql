@@ -0,0 +1,19 @@
+-- completed orders were delivered ones
+ALTER TABLE orders MODIFY COLUMN `status` ENUM('pending', 'paid', 'completed', 'processing', 'shipped', 'delivered', 'cancelled', 'refunded', 'partially_refunded', 'returned') NOT NULL DEFAULT 'pending';
+UPDATE orders SET status = 'delivered' WHERE status = 'completed';
+ALTER TABLE orders MODIFY COLUMN `status` ENUM('pending', 'paid', 'processing', 'shipped', 'delivered', 'cancelled', 'refunded', 'partially_refunded', 'returned') NOT NULL DEFAULT 'pending';
+
+CREATE TABLE IF NOT EXISTS `order_status_history` (
+    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `orderId` INT UNSIGNED NOT NULL,
+    `fromStatus` VARCHAR(32) NOT NULL,
+    `toStatus` VARCHAR(32) NOT NULL,
+    `changedBy` INT UNSIGNED NULL,
+    `reason` VARCHAR(255) NOT NULL DEFAULT '',
+    `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (`id`),
+    KEY `history_order` (`orderId`),
+    FOREIGN KEY (`orderId`) REFERENCES orders(`id`),
+    FOREIGN KEY (`changedBy`) REFERENCES users(`id`)
+);
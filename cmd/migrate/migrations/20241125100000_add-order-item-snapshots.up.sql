@@ -0,0 +1,7 @@
+ALTER TABLE order_items
+    ADD COLUMN `productName` VARCHAR(255) NOT NULL DEFAULT '',
+    ADD COLUMN `productImage` VARCHAR(255) NOT NULL DEFAULT '';
+
+-- the best we have for orders placed before items kept their own copy
+UPDATE order_items oi JOIN products p ON p.id = oi.productId
+SET oi.productName = p.name, oi.productImage = p.image;
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    `scope` VARCHAR(64) NOT NULL,
+    `key` VARCHAR(255) NOT NULL,
+    `fingerprint` CHAR(64) NOT NULL,
+    `status` SMALLINT UNSIGNED NOT NULL DEFAULT 0,
+    `contentType` VARCHAR(255) NOT NULL DEFAULT '',
+    `body` MEDIUMBLOB NULL,
+    `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `expiresAt` TIMESTAMP NOT NULL,
+
+    PRIMARY KEY (`scope`, `key`),
+    INDEX idempotency_keys_expires (`expiresAt`)
+);
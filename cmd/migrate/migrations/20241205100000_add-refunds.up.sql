@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS `refunds` (
+    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `orderId` INT UNSIGNED NOT NULL,
+    `amount` BIGINT NOT NULL,
+    `currency` CHAR(3) NOT NULL,
+    `reason` ENUM('customer_request', 'damaged', 'wrong_item', 'not_received', 'duplicate', 'other') NOT NULL,
+    `note` VARCHAR(255) NOT NULL DEFAULT '',
+    `restocked` BOOLEAN NOT NULL DEFAULT FALSE,
+    `createdBy` INT UNSIGNED NULL,
+    `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (`id`),
+    KEY `refund_order` (`orderId`),
+    FOREIGN KEY (`orderId`) REFERENCES orders(`id`),
+    FOREIGN KEY (`createdBy`) REFERENCES users(`id`)
+);
+
+CREATE TABLE IF NOT EXISTS `refund_items` (
+    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `refundId` INT UNSIGNED NOT NULL,
+    `orderItemId` INT UNSIGNED NOT NULL,
+    `quantity` INT UNSIGNED NOT NULL,
+    `amount` BIGINT NOT NULL,
+
+    PRIMARY KEY (`id`),
+    FOREIGN KEY (`refundId`) REFERENCES refunds(`id`) ON DELETE CASCADE,
+    FOREIGN KEY (`orderItemId`) REFERENCES order_items(`id`)
+);
@@ -0,0 +1,34 @@
+CREATE TABLE IF NOT EXISTS `returns` (
+    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `orderId` INT UNSIGNED NOT NULL,
+    `userId` INT UNSIGNED NOT NULL,
+    `status` ENUM('requested', 'approved', 'rejected', 'refunded') NOT NULL DEFAULT 'requested',
+    `reason` ENUM('customer_request', 'damaged', 'wrong_item', 'not_received', 'duplicate', 'other') NOT NULL,
+    `note` VARCHAR(255) NOT NULL DEFAULT '',
+    `staffNote` VARCHAR(255) NOT NULL DEFAULT '',
+    `labelReference` VARCHAR(64) NULL,
+    `refundId` INT UNSIGNED NULL,
+    `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `decidedAt` TIMESTAMP NULL,
+    `receivedAt` TIMESTAMP NULL,
+
+    PRIMARY KEY (`id`),
+    KEY `return_order` (`orderId`),
+    KEY `return_status` (`status`),
+    UNIQUE KEY `return_label` (`labelReference`),
+    FOREIGN KEY (`orderId`) REFERENCES orders(`id`),
+    FOREIGN KEY (`userId`) REFERENCES users(`id`),
+    FOREIGN KEY (`refundId`) REFERENCES refunds(`id`)
+);
+
+CREATE TABLE IF NOT EXISTS `return_items` (
+    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `returnId` INT UNSIGNED NOT NULL,
+    `orderItemId` INT UNSIGNED NOT NULL,
+    `quantity` INT UNSIGNED NOT NULL,
+    `disposition` ENUM('restock', 'write_off') NULL,
+
+    PRIMARY KEY (`id`),
+    FOREIGN KEY (`returnId`) REFERENCES returns(`id`) ON DELETE CASCADE,
+    FOREIGN KEY (`orderItemId`) REFERENCES order_items(`id`)
+);
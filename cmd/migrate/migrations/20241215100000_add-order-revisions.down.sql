@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS `order_revision_items`;
+DROP TABLE IF EXISTS `order_revisions`;
+
+UPDATE refunds SET reason = 'other' WHERE reason = 'order_edited';
+ALTER TABLE refunds MODIFY COLUMN `reason` ENUM('customer_request', 'damaged', 'wrong_item', 'not_received', 'duplicate', 'other') NOT NULL;
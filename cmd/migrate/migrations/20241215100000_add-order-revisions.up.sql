@@ -0,0 +1,31 @@
+ALTER TABLE refunds MODIFY COLUMN `reason` ENUM('customer_request', 'damaged', 'wrong_item', 'not_received', 'duplicate', 'other', 'order_edited') NOT NULL;
+
+CREATE TABLE IF NOT EXISTS `order_revisions` (
+    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `orderId` INT UNSIGNED NOT NULL,
+    `changedBy` INT UNSIGNED NULL,
+    `reason` VARCHAR(255) NOT NULL DEFAULT '',
+    `previousTotal` BIGINT NOT NULL,
+    `total` BIGINT NOT NULL,
+    `currency` CHAR(3) NOT NULL,
+    `previousAddress` TEXT NULL,
+    `address` TEXT NULL,
+    `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (`id`),
+    KEY `revision_order` (`orderId`),
+    FOREIGN KEY (`orderId`) REFERENCES orders(`id`),
+    FOREIGN KEY (`changedBy`) REFERENCES users(`id`)
+);
+
+CREATE TABLE IF NOT EXISTS `order_revision_items` (
+    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `revisionId` INT UNSIGNED NOT NULL,
+    `orderItemId` INT UNSIGNED NOT NULL,
+    `fromQuantity` INT UNSIGNED NOT NULL,
+    `toQuantity` INT UNSIGNED NOT NULL,
+
+    PRIMARY KEY (`id`),
+    FOREIGN KEY (`revisionId`) REFERENCES order_revisions(`id`) ON DELETE CASCADE,
+    FOREIGN KEY (`orderItemId`) REFERENCES order_items(`id`)
+);
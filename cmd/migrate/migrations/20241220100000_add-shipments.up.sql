@@ -0,0 +1,27 @@
+CREATE TABLE IF NOT EXISTS `shipments` (
+    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `orderId` INT UNSIGNED NOT NULL,
+    `carrier` VARCHAR(64) NOT NULL,
+    `trackingNumber` VARCHAR(128) NOT NULL,
+    `status` ENUM('in_transit', 'delivered') NOT NULL DEFAULT 'in_transit',
+    `createdBy` INT UNSIGNED NULL,
+    `shippedAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `deliveredAt` TIMESTAMP NULL,
+
+    PRIMARY KEY (`id`),
+    KEY `shipment_order` (`orderId`),
+    KEY `shipment_status` (`status`),
+    FOREIGN KEY (`orderId`) REFERENCES orders(`id`),
+    FOREIGN KEY (`createdBy`) REFERENCES users(`id`)
+);
+
+CREATE TABLE IF NOT EXISTS `shipment_items` (
+    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `shipmentId` INT UNSIGNED NOT NULL,
+    `orderItemId` INT UNSIGNED NOT NULL,
+    `quantity` INT UNSIGNED NOT NULL,
+
+    PRIMARY KEY (`id`),
+    FOREIGN KEY (`shipmentId`) REFERENCES shipments(`id`) ON DELETE CASCADE,
+    FOREIGN KEY (`orderItemId`) REFERENCES order_items(`id`)
+);
@@ -0,0 +1,10 @@
+ALTER TABLE order_items
+    DROP KEY `order_item_backorders`,
+    DROP COLUMN `expectedAt`,
+    DROP COLUMN `fulfilmentStatus`;
+
+ALTER TABLE products
+    DROP COLUMN `availableAt`,
+    DROP COLUMN `backordered`,
+    DROP COLUMN `backorderLimit`,
+    DROP COLUMN `stockPolicy`;
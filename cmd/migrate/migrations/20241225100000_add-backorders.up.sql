@@ -0,0 +1,10 @@
+ALTER TABLE products
+    ADD COLUMN `stockPolicy` ENUM('deny', 'backorder', 'preorder') NOT NULL DEFAULT 'deny',
+    ADD COLUMN `backorderLimit` INT UNSIGNED NOT NULL DEFAULT 0,
+    ADD COLUMN `backordered` INT UNSIGNED NOT NULL DEFAULT 0,
+    ADD COLUMN `availableAt` TIMESTAMP NULL;
+
+ALTER TABLE order_items
+    ADD COLUMN `fulfilmentStatus` ENUM('allocated', 'backordered') NOT NULL DEFAULT 'allocated',
+    ADD COLUMN `expectedAt` TIMESTAMP NULL,
+    ADD KEY `order_item_backorders` (`productId`, `fulfilmentStatus`);
@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS `wallet_transactions`;
+DROP TABLE IF EXISTS `wallets`;
+DROP TABLE IF EXISTS `gift_card_transactions`;
+DROP TABLE IF EXISTS `gift_cards`;
+
+ALTER TABLE refunds DROP COLUMN `storeCredit`;
+
+ALTER TABLE products DROP COLUMN `giftCard`;
@@ -0,0 +1,63 @@
+ALTER TABLE products ADD COLUMN `giftCard` BOOLEAN NOT NULL DEFAULT FALSE;
+
+ALTER TABLE refunds ADD COLUMN `storeCredit` BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE IF NOT EXISTS `gift_cards` (
+    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `codeHash` CHAR(64) NOT NULL,
+    `last4` CHAR(4) NOT NULL,
+    `initialAmount` BIGINT NOT NULL,
+    `balance` BIGINT NOT NULL,
+    `currency` CHAR(3) NOT NULL,
+    `status` ENUM('active', 'disabled', 'expired') NOT NULL DEFAULT 'active',
+    `recipient` VARCHAR(255) NOT NULL DEFAULT '',
+    `orderItemId` INT UNSIGNED NULL,
+    `issuedBy` INT UNSIGNED NULL,
+    `expiresAt` TIMESTAMP NULL,
+    `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `gift_card_code` (`codeHash`),
+    KEY `gift_card_order_item` (`orderItemId`),
+    KEY `gift_card_expiry` (`status`, `expiresAt`),
+    FOREIGN KEY (`orderItemId`) REFERENCES order_items(`id`),
+    FOREIGN KEY (`issuedBy`) REFERENCES users(`id`)
+);
+
+CREATE TABLE IF NOT EXISTS `gift_card_transactions` (
+    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `giftCardId` INT UNSIGNED NOT NULL,
+    `kind` ENUM('issue', 'debit', 'reversal', 'credit', 'expire') NOT NULL,
+    `amount` BIGINT NOT NULL,
+    `orderId` INT UNSIGNED NULL,
+    `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (`id`),
+    KEY `gift_card_transaction_card` (`giftCardId`),
+    FOREIGN KEY (`giftCardId`) REFERENCES gift_cards(`id`),
+    FOREIGN KEY (`orderId`) REFERENCES orders(`id`)
+);
+
+CREATE TABLE IF NOT EXISTS `wallets` (
+    `userId` INT UNSIGNED NOT NULL,
+    `balance` BIGINT NOT NULL DEFAULT 0,
+    `currency` CHAR(3) NOT NULL,
+    `updatedAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (`userId`),
+    FOREIGN KEY (`userId`) REFERENCES users(`id`)
+);
+
+CREATE TABLE IF NOT EXISTS `wallet_transactions` (
+    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `userId` INT UNSIGNED NOT NULL,
+    `kind` ENUM('issue', 'debit', 'reversal', 'credit', 'expire') NOT NULL,
+    `amount` BIGINT NOT NULL,
+    `orderId` INT UNSIGNED NULL,
+    `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (`id`),
+    KEY `wallet_transaction_user` (`userId`),
+    FOREIGN KEY (`userId`) REFERENCES wallets(`userId`),
+    FOREIGN KEY (`orderId`) REFERENCES orders(`id`)
+);
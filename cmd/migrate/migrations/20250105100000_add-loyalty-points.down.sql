@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS `loyalty_transactions`;
+DROP TABLE IF EXISTS `loyalty_accounts`;
+DROP TABLE IF EXISTS `loyalty_rules`;
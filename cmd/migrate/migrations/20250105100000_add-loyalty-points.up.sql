@@ -0,0 +1,46 @@
+CREATE TABLE IF NOT EXISTS `loyalty_rules` (
+    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `name` VARCHAR(255) NOT NULL,
+    `kind` ENUM('per_unit', 'category', 'promotion') NOT NULL,
+    `points` INT UNSIGNED NOT NULL,
+    `category` VARCHAR(64) NOT NULL DEFAULT '',
+    `promotionId` INT UNSIGNED NULL,
+    `active` BOOLEAN NOT NULL DEFAULT TRUE,
+    `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (`id`),
+    FOREIGN KEY (`promotionId`) REFERENCES promotions(`id`)
+);
+
+CREATE TABLE IF NOT EXISTS `loyalty_accounts` (
+    `userId` INT UNSIGNED NOT NULL,
+    `balance` INT NOT NULL DEFAULT 0,
+    `pending` INT NOT NULL DEFAULT 0,
+    `lastActivityAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (`userId`),
+    KEY `loyalty_account_activity` (`lastActivityAt`),
+    FOREIGN KEY (`userId`) REFERENCES users(`id`)
+);
+
+-- earned points stay pending until availableAt
+CREATE TABLE IF NOT EXISTS `loyalty_transactions` (
+    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `userId` INT UNSIGNED NOT NULL,
+    `kind` ENUM('earn', 'redeem', 'refund', 'reversal', 'expire') NOT NULL,
+    `points` INT NOT NULL,
+    `orderId` INT UNSIGNED NULL,
+    `refundId` INT UNSIGNED NULL,
+    `pending` BOOLEAN NOT NULL DEFAULT FALSE,
+    `availableAt` TIMESTAMP NULL,
+    `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (`id`),
+    KEY `loyalty_transaction_user` (`userId`),
+    KEY `loyalty_transaction_order` (`orderId`, `kind`),
+    KEY `loyalty_transaction_pending` (`pending`, `availableAt`),
+    UNIQUE KEY `loyalty_transaction_refund` (`refundId`),
+    FOREIGN KEY (`userId`) REFERENCES loyalty_accounts(`userId`),
+    FOREIGN KEY (`orderId`) REFERENCES orders(`id`),
+    FOREIGN KEY (`refundId`) REFERENCES refunds(`id`)
+);
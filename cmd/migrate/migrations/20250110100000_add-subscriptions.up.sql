@@ -0,0 +1,54 @@
+CREATE TABLE IF NOT EXISTS `subscription_plans` (
+    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `productId` INT UNSIGNED NOT NULL,
+    `name` VARCHAR(255) NOT NULL,
+    `interval` ENUM('day', 'week', 'month') NOT NULL,
+    `intervalCount` INT UNSIGNED NOT NULL,
+    `percentOff` INT UNSIGNED NOT NULL DEFAULT 0,
+    `active` BOOLEAN NOT NULL DEFAULT TRUE,
+    `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (`id`),
+    KEY `subscription_plan_product` (`productId`),
+    FOREIGN KEY (`productId`) REFERENCES products(`id`)
+);
+
+CREATE TABLE IF NOT EXISTS `subscriptions` (
+    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `userId` INT UNSIGNED NOT NULL,
+    `planId` INT UNSIGNED NOT NULL,
+    `quantity` INT UNSIGNED NOT NULL,
+    `status` ENUM('active', 'paused', 'past_due', 'cancelled') NOT NULL DEFAULT 'active',
+    `currency` CHAR(3) NOT NULL,
+    `paymentSource` VARCHAR(128) NOT NULL,
+    `country` CHAR(2) NULL,
+    `region` VARCHAR(64) NOT NULL DEFAULT '',
+    `postalCode` VARCHAR(16) NOT NULL DEFAULT '',
+    `shippingMethodId` INT UNSIGNED NULL,
+    `nextRunAt` TIMESTAMP NOT NULL,
+    `retryAt` TIMESTAMP NULL,
+    `failedAttempts` INT UNSIGNED NOT NULL DEFAULT 0,
+    `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `cancelledAt` TIMESTAMP NULL,
+
+    PRIMARY KEY (`id`),
+    KEY `subscription_user` (`userId`),
+    KEY `subscription_due` (`status`, `nextRunAt`),
+    FOREIGN KEY (`userId`) REFERENCES users(`id`),
+    FOREIGN KEY (`planId`) REFERENCES subscription_plans(`id`),
+    FOREIGN KEY (`shippingMethodId`) REFERENCES shipping_methods(`id`)
+);
+
+CREATE TABLE IF NOT EXISTS `subscription_runs` (
+    `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    `subscriptionId` INT UNSIGNED NOT NULL,
+    `orderId` INT UNSIGNED NULL,
+    `status` ENUM('paid', 'pending', 'failed') NOT NULL,
+    `reason` VARCHAR(255) NOT NULL DEFAULT '',
+    `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (`id`),
+    KEY `subscription_run_subscription` (`subscriptionId`),
+    FOREIGN KEY (`subscriptionId`) REFERENCES subscriptions(`id`),
+    FOREIGN KEY (`orderId`) REFERENCES orders(`id`)
+);
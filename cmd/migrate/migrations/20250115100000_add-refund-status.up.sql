@@ -0,0 +1,2 @@
+-- the refunds made so far all went through
+ALTER TABLE refunds ADD COLUMN `status` ENUM('pending', 'completed', 'failed') NOT NULL DEFAULT 'completed' AFTER `currency`;
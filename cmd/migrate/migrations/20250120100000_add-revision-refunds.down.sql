@@ -0,0 +1,2 @@
+ALTER TABLE order_revisions DROP FOREIGN KEY `order_revisions_refund`;
+ALTER TABLE order_revisions DROP COLUMN `refundId`, DROP COLUMN `refundDue`;
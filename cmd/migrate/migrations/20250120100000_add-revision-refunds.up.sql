@@ -0,0 +1,6 @@
+-- what a revision owes the customer is recorded with it and cleared once
+-- the refund is made
+ALTER TABLE order_revisions
+    ADD COLUMN `refundDue` BIGINT NOT NULL DEFAULT 0 AFTER `currency`,
+    ADD COLUMN `refundId` INT UNSIGNED NULL AFTER `refundDue`,
+    ADD CONSTRAINT `order_revisions_refund` FOREIGN KEY (`refundId`) REFERENCES refunds(`id`);
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"io"
+	"log"
+	"os"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/xelathan/golang_backend/config"
+	"github.com/xelathan/golang_backend/db"
+	"github.com/xelathan/golang_backend/services/currency"
+	"github.com/xelathan/golang_backend/types"
+)
+
+// usage: go run cmd/rates/main.go rates.csv
+//
+// The file has a currency,rate header and one row per currency, where rate is
+// how many units of that currency one unit of the base currency buys.
+func main() {
+	var input io.Reader = os.Stdin
+	if len(os.Args) > 1 {
+		file, err := os.Open(os.Args[1])
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+		input = file
+	}
+
+	reader := csv.NewReader(input)
+	records, err := reader.ReadAll()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(records) < 2 || len(records[0]) < 2 || records[0][0] != "currency" || records[0][1] != "rate" {
+		log.Fatal("expected a currency,rate header followed by at least one row")
+	}
+
+	rates := []types.ExchangeRate{}
+	for _, record := range records[1:] {
+		rates = append(rates, types.ExchangeRate{Currency: record[0], Rate: record[1]})
+	}
+
+	rates, err = currency.ValidateRates(rates, config.Envs.BaseCurrency)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db, err := db.NewMySQLStorage(mysql.Config{
+		User:                 config.Envs.DBUser,
+		Passwd:               config.Envs.DBPassword,
+		Addr:                 config.Envs.DBAddress,
+		DBName:               config.Envs.DBName,
+		Net:                  "tcp",
+		AllowNativePasswords: true,
+		ParseTime:            true,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := currency.NewStore(db).UpsertExchangeRates(context.Background(), rates); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("loaded %d exchange rates against %s", len(rates), config.Envs.BaseCurrency)
+}
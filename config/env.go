@@ -5,6 +5,7 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"testing"
 
 	"github.com/joho/godotenv"
 )
@@ -19,16 +20,73 @@ type Config struct {
 	JWTExpirationInSeconds int64
 	JWTSecret              string
 	EncryptionKey          string
+	CartTokenSecret        string
+	BaseCurrency           string
+
+	// notifications
+	Notifier                    string
+	NotifierWebhookURL          string
+	SMTPHost                    string
+	SMTPPort                    string
+	SMTPUser                    string
+	SMTPPassword                string
+	SMTPFrom                    string
+	StaffNotificationEmails     string
+	StockSweepIntervalInSeconds int64
+
+	// scheduled base prices are copied into products.price once due
+	PriceSweepIntervalInSeconds int64
+
+	// payments
+	PaymentProvider                  string
+	PaymentWebhookSecret             string
+	FakePaymentWebhookDelayInSeconds int64
+
+	// idempotency keys
+	IdempotencyKeyTTLInSeconds           int64
+	IdempotencyKeySweepIntervalInSeconds int64
+
+	// returns
+	ReturnWindowInDays int64
+
+	// refunds owed by order edits that could not be made at the time
+	RevisionRefundIntervalInSeconds int64
+
+	// shipments
+	ShipmentTrackingIntervalInSeconds int64
+	SimulatedCarrierTransitInSeconds  int64
+
+	// gift cards, which never expire when the validity is 0
+	GiftCardValidityInDays         int64
+	GiftCardSweepIntervalInSeconds int64
+
+	// loyalty points, worth the value each in the base currency's minor
+	// units; balances never expire when the inactivity is 0
+	LoyaltyPointValueInMinorUnits int64
+	LoyaltyInactivityInDays       int64
+	LoyaltySweepIntervalInSeconds int64
+
+	// subscription payments that fail are retried after the delay, doubling
+	// every attempt, up to the max retries
+	SubscriptionSweepIntervalInSeconds int64
+	SubscriptionRetryDelayInHours      int64
+	SubscriptionMaxRetries             int64
+
+	// request deadlines, see cmd/api for the routes that get longer ones
+	RequestTimeoutInSeconds int64
 }
 
 var Envs = initConfig()
 
 func initConfig() Config {
-	err := godotenv.Load()
-	if err != nil {
+	// tests run from their package's directory, which has no .env
+	if err := godotenv.Load(); err != nil && !testing.Testing() {
 		log.Fatal("Error loading .env file")
 	}
 
+	// the built-in secrets are only allowed in development
+	environment := getEnv("APP_ENV", "development")
+
 	return Config{
 		PublicHost:             getEnv("PUBLIC_HOST", "http://localhost"),
 		Port:                   getEnv("PORT", "8080"),
@@ -39,6 +97,47 @@ func initConfig() Config {
 		JWTExpirationInSeconds: getEnvInt("JWT_EXPIRATION_IN_SECONDS", 86400),
 		JWTSecret:              getEnv("JWT_SECRET", "fG*7j_2L@9m$3k-5n1*1p^6q&4r!0s(8t)"),
 		EncryptionKey:          getEnv("ENCRYPTION_KEY", "8e2RlP9aTnC6d5sB"),
+		CartTokenSecret:        getSecret(environment, "CART_TOKEN_SECRET", "q3$Vx9!mB7@kL2#pZ5&wR8*tY4^nH6%e"),
+		BaseCurrency:           getEnv("BASE_CURRENCY", "USD"),
+
+		Notifier:                    getEnv("NOTIFIER", "log"),
+		NotifierWebhookURL:          getEnv("NOTIFIER_WEBHOOK_URL", ""),
+		SMTPHost:                    getEnv("SMTP_HOST", "localhost"),
+		SMTPPort:                    getEnv("SMTP_PORT", "25"),
+		SMTPUser:                    getEnv("SMTP_USER", ""),
+		SMTPPassword:                getEnv("SMTP_PASSWORD", ""),
+		SMTPFrom:                    getEnv("SMTP_FROM", "no-reply@localhost"),
+		StaffNotificationEmails:     getEnv("STAFF_NOTIFICATION_EMAILS", ""),
+		StockSweepIntervalInSeconds: getEnvInt("STOCK_SWEEP_INTERVAL_IN_SECONDS", 300),
+
+		PriceSweepIntervalInSeconds: getEnvInt("PRICE_SWEEP_INTERVAL_IN_SECONDS", 60),
+
+		PaymentProvider:                  getEnv("PAYMENT_PROVIDER", "fake"),
+		PaymentWebhookSecret:             getSecret(environment, "PAYMENT_WEBHOOK_SECRET", "w7@Kd2!rP9#xT4$mQ6&vB1*zN8^hF3%j"),
+		FakePaymentWebhookDelayInSeconds: getEnvInt("FAKE_PAYMENT_WEBHOOK_DELAY_IN_SECONDS", 2),
+
+		IdempotencyKeyTTLInSeconds:           getEnvInt("IDEMPOTENCY_KEY_TTL_IN_SECONDS", 86400),
+		IdempotencyKeySweepIntervalInSeconds: getEnvInt("IDEMPOTENCY_KEY_SWEEP_INTERVAL_IN_SECONDS", 3600),
+
+		ReturnWindowInDays: getEnvInt("RETURN_WINDOW_IN_DAYS", 30),
+
+		RevisionRefundIntervalInSeconds: getEnvInt("REVISION_REFUND_INTERVAL_IN_SECONDS", 300),
+
+		ShipmentTrackingIntervalInSeconds: getEnvInt("SHIPMENT_TRACKING_INTERVAL_IN_SECONDS", 900),
+		SimulatedCarrierTransitInSeconds:  getEnvInt("SIMULATED_CARRIER_TRANSIT_IN_SECONDS", 86400),
+
+		GiftCardValidityInDays:         getEnvInt("GIFT_CARD_VALIDITY_IN_DAYS", 365),
+		GiftCardSweepIntervalInSeconds: getEnvInt("GIFT_CARD_SWEEP_INTERVAL_IN_SECONDS", 300),
+
+		LoyaltyPointValueInMinorUnits: getEnvInt("LOYALTY_POINT_VALUE_IN_MINOR_UNITS", 1),
+		LoyaltyInactivityInDays:       getEnvInt("LOYALTY_INACTIVITY_IN_DAYS", 365),
+		LoyaltySweepIntervalInSeconds: getEnvInt("LOYALTY_SWEEP_INTERVAL_IN_SECONDS", 3600),
+
+		SubscriptionSweepIntervalInSeconds: getEnvInt("SUBSCRIPTION_SWEEP_INTERVAL_IN_SECONDS", 300),
+		SubscriptionRetryDelayInHours:      getEnvInt("SUBSCRIPTION_RETRY_DELAY_IN_HOURS", 24),
+		SubscriptionMaxRetries:             getEnvInt("SUBSCRIPTION_MAX_RETRIES", 3),
+
+		RequestTimeoutInSeconds: getEnvInt("REQUEST_TIMEOUT_IN_SECONDS", 10),
 	}
 }
 
@@ -50,6 +149,21 @@ func getEnv(key string, fallback string) string {
 	return fallback
 }
 
+// getSecret is getEnv for secrets whose fallback is only fit for
+// development. Anywhere else the secret has to be set.
+func getSecret(environment string, key string, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+
+	if environment != "development" {
+		log.Fatalf("%s must be set when APP_ENV is %s", key, environment)
+	}
+
+	log.Printf("WARNING: %s is not set, using the development default; set it before deploying", key)
+	return fallback
+}
+
 func getEnvInt(key string, fallback int64) int64 {
 	if value, ok := os.LookupEnv(key); ok {
 		i, err := strconv.ParseInt(value, 10, 64)
@@ -0,0 +1,81 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/xelathan/golang_backend/types"
+)
+
+const (
+	// maxAttempts bounds how often a unit of work is run when its
+	// transaction keeps getting rolled back by deadlocks.
+	maxAttempts = 3
+	retryDelay  = 20 * time.Millisecond
+
+	errDeadlock        = 1213
+	errLockWaitTimeout = 1205
+)
+
+// UnitOfWork runs work in transactions on the database, retrying it when
+// MySQL rolls the transaction back to break a deadlock.
+type UnitOfWork struct {
+	db *sql.DB
+}
+
+func NewUnitOfWork(db *sql.DB) *UnitOfWork {
+	return &UnitOfWork{db: db}
+}
+
+func (u *UnitOfWork) Do(ctx context.Context, fn func(tx types.DB) error) error {
+	var err error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		if err = InTxContext(ctx, u.db, fn); !isRetryable(err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(attempt) * retryDelay):
+		}
+	}
+
+	return err
+}
+
+// InTxContext runs fn in a transaction on conn that is rolled back if ctx is
+// done before it commits. When conn already is a transaction fn joins it,
+// leaving the commit to whoever started it.
+func InTxContext(ctx context.Context, conn types.DB, fn func(tx types.DB) error) error {
+	db, ok := conn.(*sql.DB)
+	if !ok {
+		return fn(conn)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// isRetryable reports whether err rolled the transaction back for reasons
+// that trying again can fix.
+func isRetryable(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if !errors.As(err, &mysqlErr) {
+		return false
+	}
+
+	return mysqlErr.Number == errDeadlock || mysqlErr.Number == errLockWaitTimeout
+}
@@ -0,0 +1,411 @@
+package money
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// DefaultCurrency is used when an amount arrives without a currency, e.g. a
+// bare JSON number in a request payload.
+var DefaultCurrency = "USD"
+
+// minorUnits is the number of decimal places of each supported ISO 4217
+// currency. Currencies missing from the table are rejected.
+var minorUnits = map[string]int{
+	"USD": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"CAD": 2,
+	"AUD": 2,
+	"CHF": 2,
+	"SEK": 2,
+	"NOK": 2,
+	"DKK": 2,
+	"PLN": 2,
+	"MXN": 2,
+	"BRL": 2,
+	"INR": 2,
+	"CNY": 2,
+	"SGD": 2,
+	"HKD": 2,
+	"NZD": 2,
+	"JPY": 0,
+	"KRW": 0,
+}
+
+// Money is an exact amount in the minor units of its currency, e.g. 1234 USD
+// is $12.34. Arithmetic never goes through floating point.
+//
+// Operations combining two amounts panic when the currencies differ, since
+// that is always a programming error; validate input with SameCurrency at the
+// edges instead. A zero value with no currency adopts the currency of the
+// other operand so totals can start from Money{}.
+type Money struct {
+	Amount   int64
+	Currency string
+}
+
+func New(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+func Zero(currency string) Money {
+	return Money{Currency: currency}
+}
+
+// IsValidCurrency reports whether the code is a supported ISO 4217 currency.
+func IsValidCurrency(currency string) bool {
+	_, ok := minorUnits[currency]
+	return ok
+}
+
+// Exponent returns the number of decimal places of the currency.
+func Exponent(currency string) int {
+	if exp, ok := minorUnits[currency]; ok {
+		return exp
+	}
+	return 2
+}
+
+// Parse reads a decimal string such as "12.34" or "-0.5" in the given
+// currency. It is exact: more decimal places than the currency allows is an
+// error rather than a silent rounding.
+func Parse(value string, currency string) (Money, error) {
+	if !IsValidCurrency(currency) {
+		return Money{}, fmt.Errorf("unsupported currency %q", currency)
+	}
+
+	value = strings.TrimSpace(value)
+	negative := strings.HasPrefix(value, "-")
+	value = strings.TrimPrefix(strings.TrimPrefix(value, "-"), "+")
+
+	whole, fraction, _ := strings.Cut(value, ".")
+	if whole == "" && fraction == "" {
+		return Money{}, fmt.Errorf("invalid amount %q", value)
+	}
+
+	exp := Exponent(currency)
+	fraction = strings.TrimRight(fraction, "0")
+	if len(fraction) > exp {
+		return Money{}, fmt.Errorf("amount %q has more than %d decimal places", value, exp)
+	}
+	fraction += strings.Repeat("0", exp-len(fraction))
+
+	if whole == "" {
+		whole = "0"
+	}
+
+	amount, err := strconv.ParseInt(whole+fraction, 10, 64)
+	if err != nil || strings.ContainsAny(whole+fraction, "+-") {
+		return Money{}, fmt.Errorf("invalid amount %q", value)
+	}
+
+	if negative {
+		amount = -amount
+	}
+
+	return Money{Amount: amount, Currency: currency}, nil
+}
+
+func MustParse(value string, currency string) Money {
+	m, err := Parse(value, currency)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// Decimal formats the amount without currency, e.g. "12.34".
+func (m Money) Decimal() string {
+	exp := Exponent(m.Currency)
+	amount := m.Amount
+	sign := ""
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+
+	digits := strconv.FormatInt(amount, 10)
+	if exp == 0 {
+		return sign + digits
+	}
+
+	if len(digits) <= exp {
+		digits = strings.Repeat("0", exp-len(digits)+1) + digits
+	}
+
+	return sign + digits[:len(digits)-exp] + "." + digits[len(digits)-exp:]
+}
+
+func (m Money) String() string {
+	return m.Decimal() + " " + m.Currency
+}
+
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+func (m Money) IsNegative() bool {
+	return m.Amount < 0
+}
+
+func (m Money) IsPositive() bool {
+	return m.Amount > 0
+}
+
+// SameCurrency reports whether both amounts can be combined.
+func (m Money) SameCurrency(other Money) bool {
+	return m.Currency == other.Currency || m.Currency == "" || other.Currency == ""
+}
+
+func (m Money) currencyWith(other Money) string {
+	if !m.SameCurrency(other) {
+		panic(fmt.Sprintf("money: currency mismatch %s and %s", m.Currency, other.Currency))
+	}
+
+	if m.Currency == "" {
+		return other.Currency
+	}
+	return m.Currency
+}
+
+func (m Money) Add(other Money) Money {
+	return Money{Amount: m.Amount + other.Amount, Currency: m.currencyWith(other)}
+}
+
+func (m Money) Sub(other Money) Money {
+	return Money{Amount: m.Amount - other.Amount, Currency: m.currencyWith(other)}
+}
+
+func (m Money) Neg() Money {
+	return Money{Amount: -m.Amount, Currency: m.Currency}
+}
+
+// Cmp returns -1, 0 or 1 as m is less than, equal to or greater than other.
+func (m Money) Cmp(other Money) int {
+	m.currencyWith(other)
+
+	switch {
+	case m.Amount < other.Amount:
+		return -1
+	case m.Amount > other.Amount:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func Min(a Money, b Money) Money {
+	if a.Cmp(b) <= 0 {
+		return a
+	}
+	return b
+}
+
+func Max(a Money, b Money) Money {
+	if a.Cmp(b) >= 0 {
+		return a
+	}
+	return b
+}
+
+// Mul multiplies by an integer quantity, which is always exact.
+func (m Money) Mul(quantity int) Money {
+	return Money{Amount: m.Amount * int64(quantity), Currency: m.Currency}
+}
+
+// RoundingMode decides what happens to the fraction of a minor unit left by a
+// division. Every operation that can produce one takes a mode explicitly.
+type RoundingMode int
+
+const (
+	// HalfUp rounds to the nearest minor unit, ties away from zero. It is
+	// the default for prices, discounts and tax.
+	HalfUp RoundingMode = iota
+	// HalfEven rounds to the nearest minor unit, ties to the even neighbour.
+	HalfEven
+	// Down truncates towards zero.
+	Down
+)
+
+// MulRatio returns m * numerator / denominator rounded with the given mode.
+// Percentages are expressed as basis points, e.g. MulRatio(1250, 10000, HalfUp)
+// is 12.5%.
+func (m Money) MulRatio(numerator int64, denominator int64, mode RoundingMode) Money {
+	if denominator == 0 {
+		panic("money: division by zero")
+	}
+
+	product := new(big.Int).Mul(big.NewInt(m.Amount), big.NewInt(numerator))
+	return Money{Amount: divRound(product, big.NewInt(denominator), mode), Currency: m.Currency}
+}
+
+// MulRat multiplies by an arbitrary precision rational such as an exchange
+// rate and rounds the result with the given mode.
+func (m Money) MulRat(rate *big.Rat, mode RoundingMode) Money {
+	product := new(big.Int).Mul(big.NewInt(m.Amount), rate.Num())
+	return Money{Amount: divRound(product, rate.Denom(), mode), Currency: m.Currency}
+}
+
+func divRound(numerator *big.Int, denominator *big.Int, mode RoundingMode) int64 {
+	if denominator.Sign() < 0 {
+		numerator = new(big.Int).Neg(numerator)
+		denominator = new(big.Int).Neg(denominator)
+	}
+
+	quotient, remainder := new(big.Int).QuoRem(numerator, denominator, new(big.Int))
+	if remainder.Sign() == 0 || mode == Down {
+		return quotient.Int64()
+	}
+
+	// compare twice the remainder with the denominator to find the half
+	twice := new(big.Int).Abs(remainder)
+	twice.Lsh(twice, 1)
+	half := twice.Cmp(denominator)
+
+	roundAway := half > 0 || (half == 0 && (mode == HalfUp || quotient.Bit(0) == 1))
+	if roundAway {
+		if numerator.Sign() < 0 {
+			quotient.Sub(quotient, big.NewInt(1))
+		} else {
+			quotient.Add(quotient, big.NewInt(1))
+		}
+	}
+
+	return quotient.Int64()
+}
+
+// Allocate splits m into parts proportional to the weights without losing or
+// inventing a minor unit: the parts always add up to m. Leftover units go to
+// the parts with the largest remainders, earlier parts first on ties.
+func (m Money) Allocate(weights []int64) []Money {
+	parts := make([]Money, len(weights))
+	total := int64(0)
+	for _, w := range weights {
+		total += w
+	}
+
+	if total == 0 {
+		for i := range parts {
+			parts[i] = Zero(m.Currency)
+		}
+		if len(parts) > 0 {
+			parts[0].Amount = m.Amount
+		}
+		return parts
+	}
+
+	allocated := int64(0)
+	remainders := make([]int64, len(weights))
+	for i, w := range weights {
+		product := new(big.Int).Mul(big.NewInt(m.Amount), big.NewInt(w))
+		quotient, remainder := new(big.Int).QuoRem(product, big.NewInt(total), new(big.Int))
+		parts[i] = Money{Amount: quotient.Int64(), Currency: m.Currency}
+		remainders[i] = remainder.Int64()
+		allocated += quotient.Int64()
+	}
+
+	step := int64(1)
+	if m.Amount < 0 {
+		step = -1
+	}
+
+	for leftover := m.Amount - allocated; leftover != 0; leftover -= step {
+		best := -1
+		for i := range remainders {
+			if weights[i] == 0 {
+				continue
+			}
+			if best == -1 || abs(remainders[i]) > abs(remainders[best]) {
+				best = i
+			}
+		}
+		parts[best].Amount += step
+		remainders[best] = 0
+	}
+
+	return parts
+}
+
+func abs(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+type jsonMoney struct {
+	Amount   json.Number `json:"amount"`
+	Currency string      `json:"currency"`
+}
+
+// MarshalJSON encodes the amount as a decimal string so clients never see a
+// float: {"amount":"12.34","currency":"USD"}.
+func (m Money) MarshalJSON() ([]byte, error) {
+	currency := m.Currency
+	if currency == "" {
+		currency = DefaultCurrency
+	}
+
+	return json.Marshal(map[string]string{"amount": Money{Amount: m.Amount, Currency: currency}.Decimal(), "currency": currency})
+}
+
+// UnmarshalJSON accepts the object form produced by MarshalJSON, with the
+// amount as a string or number, or a bare number or string in the default
+// currency. The literal text is parsed, so 0.1 stays exactly 0.1.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+
+	value := jsonMoney{Currency: DefaultCurrency}
+	if len(data) > 0 && data[0] == '{' {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		raw := map[string]any{}
+		if err := decoder.Decode(&raw); err != nil {
+			return err
+		}
+
+		switch amount := raw["amount"].(type) {
+		case json.Number:
+			value.Amount = amount
+		case string:
+			value.Amount = json.Number(amount)
+		default:
+			return fmt.Errorf("money amount must be a number or string")
+		}
+
+		if currency, ok := raw["currency"].(string); ok && currency != "" {
+			value.Currency = strings.ToUpper(currency)
+		}
+	} else {
+		var amount any
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		if err := decoder.Decode(&amount); err != nil {
+			return err
+		}
+
+		switch amount := amount.(type) {
+		case json.Number:
+			value.Amount = amount
+		case string:
+			value.Amount = json.Number(amount)
+		default:
+			return fmt.Errorf("money must be an object, number or string")
+		}
+	}
+
+	parsed, err := Parse(value.Amount.String(), value.Currency)
+	if err != nil {
+		return err
+	}
+
+	*m = parsed
+	return nil
+}
@@ -0,0 +1,92 @@
+package money
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMoney(t *testing.T) {
+	t.Run("should parse decimal strings exactly", func(t *testing.T) {
+		cases := map[string]int64{"12.34": 1234, "0.1": 10, "-5": -500, ".5": 50, "100.00": 10000}
+		for input, expected := range cases {
+			m, err := Parse(input, "USD")
+			if err != nil {
+				t.Fatalf("parse %q: %v", input, err)
+			}
+			if m.Amount != expected {
+				t.Errorf("parse %q: expected %d, got %d", input, expected, m.Amount)
+			}
+		}
+	})
+
+	t.Run("should reject more decimal places than the currency allows", func(t *testing.T) {
+		for _, input := range []string{"1.234", "abc", "", "1e2"} {
+			if _, err := Parse(input, "USD"); err == nil {
+				t.Errorf("expected %q to be rejected", input)
+			}
+		}
+
+		if _, err := Parse("1.5", "JPY"); err == nil {
+			t.Errorf("expected fractional yen to be rejected")
+		}
+	})
+
+	t.Run("should format amounts in the currency exponent", func(t *testing.T) {
+		cases := map[Money]string{New(1234, "USD"): "12.34", New(5, "USD"): "0.05", New(-5, "USD"): "-0.05", New(1500, "JPY"): "1500"}
+		for m, expected := range cases {
+			if m.Decimal() != expected {
+				t.Errorf("expected %s, got %s", expected, m.Decimal())
+			}
+		}
+	})
+
+	t.Run("should round ratios with the requested mode", func(t *testing.T) {
+		price := New(250, "USD")
+		if got := price.MulRatio(1, 100, HalfUp).Amount; got != 3 {
+			t.Errorf("half up: expected 3, got %d", got)
+		}
+		if got := price.MulRatio(1, 100, HalfEven).Amount; got != 2 {
+			t.Errorf("half even: expected 2, got %d", got)
+		}
+		if got := price.MulRatio(1, 100, Down).Amount; got != 2 {
+			t.Errorf("down: expected 2, got %d", got)
+		}
+		if got := price.Neg().MulRatio(1, 100, HalfUp).Amount; got != -3 {
+			t.Errorf("negative half up: expected -3, got %d", got)
+		}
+	})
+
+	t.Run("should allocate without losing a minor unit", func(t *testing.T) {
+		parts := New(100, "USD").Allocate([]int64{1, 1, 1})
+		sum := Zero("USD")
+		for _, p := range parts {
+			sum = sum.Add(p)
+		}
+		if sum.Amount != 100 || parts[0].Amount != 34 || parts[2].Amount != 33 {
+			t.Errorf("unexpected allocation %v", parts)
+		}
+	})
+
+	t.Run("should round trip through JSON and accept bare numbers", func(t *testing.T) {
+		encoded, err := json.Marshal(New(1999, "EUR"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(encoded) != `{"amount":"19.99","currency":"EUR"}` {
+			t.Errorf("unexpected encoding %s", encoded)
+		}
+
+		decoded := Money{}
+		if err := json.Unmarshal(encoded, &decoded); err != nil || decoded != New(1999, "EUR") {
+			t.Errorf("round trip failed: %v %v", decoded, err)
+		}
+
+		if err := json.Unmarshal([]byte(`0.3`), &decoded); err != nil || decoded != New(30, DefaultCurrency) {
+			t.Errorf("bare number failed: %v %v", decoded, err)
+		}
+
+		if err := json.Unmarshal([]byte(`12.345`), &decoded); err == nil {
+			t.Errorf("expected three decimal places to be rejected")
+		}
+	})
+}
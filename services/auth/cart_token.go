@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+const CartTokenHeader = "X-Cart-Token"
+
+// CreateCartToken returns a new guest cart id and the signed token handed to
+// the client for it. The id is random and carries no information; the
+// signature only stops clients from guessing the ids of other carts.
+func CreateCartToken(secret []byte) (token string, id string, err error) {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", err
+	}
+
+	id = hex.EncodeToString(raw)
+
+	return id + "." + signCartId(secret, id), id, nil
+}
+
+// ParseCartToken verifies a token made by CreateCartToken and returns the
+// cart id it names.
+func ParseCartToken(secret []byte, token string) (string, error) {
+	id, signature, ok := strings.Cut(token, ".")
+	if !ok || len(id) != 32 {
+		return "", fmt.Errorf("invalid cart token")
+	}
+
+	if !hmac.Equal([]byte(signature), []byte(signCartId(secret, id))) {
+		return "", fmt.Errorf("invalid cart token")
+	}
+
+	return id, nil
+}
+
+func signCartId(secret []byte, id string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(id))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -54,7 +54,7 @@ func WithJWTAuth(funcToInvoke http.HandlerFunc, store types.UserStore) http.Hand
 
 		userId, _ := strconv.Atoi(str)
 
-		user, err := store.GetUserById(userId)
+		user, err := store.GetUserById(r.Context(), userId)
 		if err != nil {
 			utils.WriteError(w, http.StatusInternalServerError, err)
 			return
@@ -67,6 +67,48 @@ func WithJWTAuth(funcToInvoke http.HandlerFunc, store types.UserStore) http.Hand
 	}
 }
 
+// WithAdminAuth is WithJWTAuth restricted to users with the admin role.
+func WithAdminAuth(funcToInvoke http.HandlerFunc, store types.UserStore) http.HandlerFunc {
+	return WithJWTAuth(func(w http.ResponseWriter, r *http.Request) {
+		user, err := store.GetUserById(r.Context(), GetUserIdFromContext(r.Context()))
+		if err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		if user.Role != types.RoleAdmin {
+			permissionDenied(w)
+			return
+		}
+
+		funcToInvoke(w, r)
+	}, store)
+}
+
+// WithOptionalJWTAuth authenticates requests that carry a valid token the
+// same way as WithJWTAuth and lets the rest through without a user. A token
+// that has expired or cannot be read is ignored, so a guest holding a stale
+// one is not locked out of their cart.
+func WithOptionalJWTAuth(funcToInvoke http.HandlerFunc, store types.UserStore) http.HandlerFunc {
+	authenticated := WithJWTAuth(funcToInvoke, store)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, err := validateJWTToken(getTokenFromRequest(r))
+		if err != nil {
+			funcToInvoke(w, r)
+			return
+		}
+
+		claims, ok := token.Claims.(jwt.MapClaims)
+		if _, hasUser := claims["userId"].(string); !ok || !hasUser {
+			funcToInvoke(w, r)
+			return
+		}
+
+		authenticated(w, r)
+	}
+}
+
 func getTokenFromRequest(r *http.Request) string {
 	token := r.Header.Get("Authorization")
 
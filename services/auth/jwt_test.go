@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/xelathan/golang_backend/config"
+	"github.com/xelathan/golang_backend/types"
+)
+
+func TestWithOptionalJWTAuth(t *testing.T) {
+	serve := func(token string) (int, int) {
+		userId := 0
+		handler := WithOptionalJWTAuth(func(w http.ResponseWriter, r *http.Request) {
+			userId = GetUserIdFromContext(r.Context())
+			w.WriteHeader(http.StatusOK)
+		}, &mockUserStore{})
+
+		req := httptest.NewRequest(http.MethodGet, "/cart", nil)
+		if token != "" {
+			req.Header.Set("Authorization", token)
+		}
+
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+
+		return rr.Code, userId
+	}
+
+	sign := func(secret string, claims jwt.MapClaims) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return token
+	}
+
+	t.Run("should authenticate a valid token", func(t *testing.T) {
+		token, err := CreateJWT([]byte(config.Envs.JWTSecret), 7)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if code, userId := serve(token); code != http.StatusOK || userId != 7 {
+			t.Errorf("expected user 7 to get through, got status code %d and user %d", code, userId)
+		}
+	})
+
+	cases := []struct {
+		name  string
+		token string
+	}{
+		{"no token", ""},
+		{"a malformed token", "not-a-token"},
+		{"a token signed with another secret", sign("another secret", jwt.MapClaims{"userId": "7"})},
+		{"an expired token", sign(config.Envs.JWTSecret, jwt.MapClaims{"userId": "7", "exp": time.Now().Add(-time.Hour).Unix()})},
+		{"a token without a user", sign(config.Envs.JWTSecret, jwt.MapClaims{"userId": 7})},
+	}
+
+	for _, c := range cases {
+		t.Run("should let a guest through with "+c.name, func(t *testing.T) {
+			if code, userId := serve(c.token); code != http.StatusOK || userId != -1 {
+				t.Errorf("expected a guest to get through, got status code %d and user %d", code, userId)
+			}
+		})
+	}
+}
+
+type mockUserStore struct {
+	types.UserStore
+}
+
+func (m *mockUserStore) GetUserById(ctx context.Context, id int) (*types.User, error) {
+	return &types.User{ID: id}, nil
+}
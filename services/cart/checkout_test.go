@@ -0,0 +1,855 @@
+package cart
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xelathan/golang_backend/config"
+	"github.com/xelathan/golang_backend/money"
+	"github.com/xelathan/golang_backend/services/auth"
+	"github.com/xelathan/golang_backend/services/giftcard"
+	"github.com/xelathan/golang_backend/services/idempotency"
+	"github.com/xelathan/golang_backend/services/loyalty"
+	"github.com/xelathan/golang_backend/types"
+)
+
+func TestCheckout(t *testing.T) {
+	address, err := auth.EncryptAES("1 Main St", []byte(config.Envs.EncryptionKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	setup := func(stock int) (*Handler, *fakeDatabase) {
+		database := &fakeDatabase{stock: map[int]int{1: stock}, locks: map[int]*sync.Mutex{1: {}}}
+		handler := NewHandler(
+			nil,
+			&fakeOrderStore{database: database},
+			&fakeProductStore{database: database},
+			&mockCheckoutUserStore{address: address},
+			&mockCheckoutPriceStore{},
+			&mockCheckoutCurrencyStore{},
+			&mockCheckoutPromotionStore{},
+			nil,
+			&mockCheckoutTaxStore{},
+			nil,
+			&mockCheckoutGiftCardStore{},
+			&mockCheckoutPaymentStore{},
+			&mockCheckoutLoyaltyStore{},
+			&mockPayments{},
+			nil,
+			&fakeUnitOfWork{database: database},
+		)
+
+		return handler, database
+	}
+
+	checkout := func(handler *Handler, userId int) int {
+		req := httptest.NewRequest(http.MethodPost, "/cart/checkout", strings.NewReader(`{"items":[{"productID":1,"quantity":1}]}`))
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserKey, userId))
+
+		rr := httptest.NewRecorder()
+		handler.handleCheckout(rr, req)
+
+		return rr.Code
+	}
+
+	t.Run("should sell the last unit only once", func(t *testing.T) {
+		handler, database := setup(1)
+
+		var wg sync.WaitGroup
+		codes := make([]int, 20)
+		for i := range codes {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				codes[i] = checkout(handler, i+1)
+			}(i)
+		}
+		wg.Wait()
+
+		sold := 0
+		for _, code := range codes {
+			switch code {
+			case http.StatusOK:
+				sold++
+			case http.StatusBadRequest:
+			default:
+				t.Errorf("expected the checkout to succeed or find the product sold out, got status code %d", code)
+			}
+		}
+
+		if sold != 1 || database.orders != 1 || database.stock[1] != 0 {
+			t.Errorf("expected one order for the last unit, got %d sold, %d orders and %d left", sold, database.orders, database.stock[1])
+		}
+	})
+
+	t.Run("should keep the stock when the order cannot be placed", func(t *testing.T) {
+		handler, database := setup(5)
+		database.failOrders = true
+
+		if code := checkout(handler, 1); code != http.StatusInternalServerError {
+			t.Errorf("expected status code %d, got %d", http.StatusInternalServerError, code)
+		}
+
+		if database.stock[1] != 5 {
+			t.Errorf("expected the stock to be left at 5, got %d", database.stock[1])
+		}
+	})
+
+	checkoutStoredCart := func(handler *Handler, store *mockCartStore) int {
+		handler.store = store
+
+		req := httptest.NewRequest(http.MethodPost, "/cart/checkout", strings.NewReader(""))
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserKey, 1))
+
+		rr := httptest.NewRecorder()
+		handler.handleCheckout(rr, req)
+
+		return rr.Code
+	}
+
+	t.Run("should check out the stored cart and empty it", func(t *testing.T) {
+		handler, database := setup(5)
+		store := &mockCartStore{lines: []types.CartLine{{ID: 1, CartID: 1, ProductID: 1, Quantity: 2}}}
+
+		if code := checkoutStoredCart(handler, store); code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, code)
+		}
+
+		if database.orders != 1 || database.stock[1] != 3 {
+			t.Errorf("expected one order taking 2 from stock, got %d orders and %d left", database.orders, database.stock[1])
+		}
+
+		if !store.cleared {
+			t.Error("expected the stored cart to be emptied")
+		}
+	})
+
+	t.Run("should refuse to check out an empty stored cart", func(t *testing.T) {
+		handler, database := setup(5)
+
+		if code := checkoutStoredCart(handler, &mockCartStore{}); code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, code)
+		}
+
+		if database.orders != 0 {
+			t.Errorf("expected no order, got %d", database.orders)
+		}
+	})
+
+	t.Run("should leave the stored cart as it was when the payment is declined", func(t *testing.T) {
+		handler, _ := setup(5)
+		handler.payments = &mockPayments{status: types.PaymentDeclined}
+		store := &mockCartStore{lines: []types.CartLine{{ID: 1, CartID: 1, ProductID: 1, Quantity: 2}}}
+
+		if code := checkoutStoredCart(handler, store); code != http.StatusPaymentRequired {
+			t.Errorf("expected status code %d, got %d", http.StatusPaymentRequired, code)
+		}
+
+		if store.cleared {
+			t.Error("expected the stored cart to be kept")
+		}
+	})
+
+	checkoutWithKey := func(handler *Handler, store *mockCartStore) int {
+		handler.store = store
+
+		req := httptest.NewRequest(http.MethodPost, "/cart/checkout", strings.NewReader(""))
+		req.Header.Set(idempotency.KeyHeader, "checkout-1")
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserKey, 1))
+
+		rr := httptest.NewRecorder()
+		handler.keys.Wrap(handler.handleCheckout)(rr, req)
+
+		return rr.Code
+	}
+
+	t.Run("should not place the order again when the charge fails", func(t *testing.T) {
+		handler, database := setup(5)
+		payments := &mockPayments{err: fmt.Errorf("provider unavailable")}
+		handler.payments = payments
+		handler.keys = idempotency.NewKeys(&mockCheckoutIdempotencyStore{records: map[string]types.IdempotencyRecord{}}, time.Hour, time.Hour)
+		store := &mockCartStore{lines: []types.CartLine{{ID: 1, CartID: 1, ProductID: 1, Quantity: 2}}}
+
+		for range 2 {
+			if code := checkoutWithKey(handler, store); code != http.StatusInternalServerError {
+				t.Errorf("expected status code %d, got %d", http.StatusInternalServerError, code)
+			}
+		}
+
+		if database.orders != 1 || payments.charges != 1 {
+			t.Errorf("expected one order charged once, got %d orders and %d charges", database.orders, payments.charges)
+		}
+	})
+
+	t.Run("should answer with the order when the stored cart cannot be emptied", func(t *testing.T) {
+		handler, database := setup(5)
+		payments := &mockPayments{}
+		handler.payments = payments
+		handler.keys = idempotency.NewKeys(&mockCheckoutIdempotencyStore{records: map[string]types.IdempotencyRecord{}}, time.Hour, time.Hour)
+		store := &mockCartStore{lines: []types.CartLine{{ID: 1, CartID: 1, ProductID: 1, Quantity: 2}}, clearErr: fmt.Errorf("connection lost")}
+
+		for range 2 {
+			if code := checkoutWithKey(handler, store); code != http.StatusOK {
+				t.Errorf("expected status code %d, got %d", http.StatusOK, code)
+			}
+		}
+
+		if database.orders != 1 || payments.charges != 1 || database.stock[1] != 3 {
+			t.Errorf("expected one order charged once taking 2 from stock, got %d orders, %d charges and %d left", database.orders, payments.charges, database.stock[1])
+		}
+	})
+
+	t.Run("should run the checkout again when the stored cart could not be read", func(t *testing.T) {
+		handler, database := setup(5)
+		handler.keys = idempotency.NewKeys(&mockCheckoutIdempotencyStore{records: map[string]types.IdempotencyRecord{}}, time.Hour, time.Hour)
+		store := &mockCartStore{lines: []types.CartLine{{ID: 1, CartID: 1, ProductID: 1, Quantity: 2}}, linesErr: fmt.Errorf("connection lost")}
+
+		if code := checkoutWithKey(handler, store); code != http.StatusInternalServerError {
+			t.Fatalf("expected status code %d, got %d", http.StatusInternalServerError, code)
+		}
+
+		store.linesErr = nil
+		if code := checkoutWithKey(handler, store); code != http.StatusOK {
+			t.Errorf("expected status code %d, got %d", http.StatusOK, code)
+		}
+
+		if database.orders != 1 {
+			t.Errorf("expected one order, got %d", database.orders)
+		}
+	})
+
+	t.Run("should place an order on a customer's behalf at the percent off", func(t *testing.T) {
+		handler, database := setup(5)
+
+		order, err := handler.PlaceOrder(context.Background(), types.PlaceOrderRequest{
+			UserID:     1,
+			Items:      []types.CartItem{{ProductID: 1, Quantity: 2}},
+			Currency:   "USD",
+			PercentOff: 15,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if order.Total != money.New(1700, "USD") || order.Status != types.Pending {
+			t.Errorf("expected a pending order of 17.00, got %s %s", order.Status, order.Total)
+		}
+
+		if database.orders != 1 || database.stock[1] != 3 {
+			t.Errorf("expected one order taking 2 from stock, got %d orders and %d left", database.orders, database.stock[1])
+		}
+	})
+}
+
+func TestFulfilmentStatus(t *testing.T) {
+	t.Run("should take lines from stock while nothing is on backorder", func(t *testing.T) {
+		product := types.Product{ID: 1, Quantity: 2, StockPolicy: types.StockBackorder, Backordered: 0}
+		if status, err := fulfilmentStatus(product, 2); err != nil || status != types.FulfilmentAllocated {
+			t.Errorf("expected the line allocated, got %s and %v", status, err)
+		}
+
+		// stock that comes in goes to the backorders first
+		product.Backordered = 1
+		if status, err := fulfilmentStatus(product, 1); err != nil || status != types.FulfilmentBackordered {
+			t.Errorf("expected the line backordered behind the others, got %s and %v", status, err)
+		}
+	})
+
+	t.Run("should backorder only as far as the product allows", func(t *testing.T) {
+		if _, err := fulfilmentStatus(types.Product{ID: 1, Quantity: 1, StockPolicy: types.StockDeny}, 2); err == nil {
+			t.Error("expected a product that cannot be backordered to refuse the line")
+		}
+
+		product := types.Product{ID: 1, StockPolicy: types.StockPreorder, BackorderLimit: 5, Backordered: 4}
+		if _, err := fulfilmentStatus(product, 2); err == nil {
+			t.Error("expected the backorder limit to refuse the line")
+		}
+
+		if status, err := fulfilmentStatus(product, 1); err != nil || status != types.FulfilmentBackordered {
+			t.Errorf("expected the last unit under the limit backordered, got %s and %v", status, err)
+		}
+	})
+}
+
+func TestRevalidateCart(t *testing.T) {
+	availableAt := time.Date(2026, 12, 1, 0, 0, 0, 0, time.UTC)
+	products := &mockCartProductStore{products: map[int]types.Product{
+		1: {ID: 1, Price: money.New(1000, "USD"), Quantity: 0, StockPolicy: types.StockBackorder, BackorderLimit: 5},
+		2: {ID: 2, Price: money.New(1000, "USD"), Quantity: 0, StockPolicy: types.StockPreorder, AvailableAt: &availableAt},
+		3: {ID: 3, Price: money.New(1000, "USD"), Quantity: 0, StockPolicy: types.StockDeny},
+		4: {ID: 4, Price: money.New(1000, "USD"), Quantity: 2, StockPolicy: types.StockDeny},
+	}}
+	handler := &Handler{productStore: products, priceStore: &mockCheckoutPriceStore{}, currencyStore: &mockCheckoutCurrencyStore{}}
+
+	revalidate := func(lines ...types.CartLine) *types.CartView {
+		view, err := handler.revalidateCart(context.Background(), &types.Cart{ID: 1}, lines, "USD")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return view
+	}
+
+	cases := []struct {
+		name   string
+		line   types.CartLine
+		issues []string
+		ready  bool
+	}{
+		{"should take a line from stock at the price it was added at", types.CartLine{ProductID: 4, Quantity: 2, AddedPrice: money.New(1000, "USD")}, []string{}, true},
+		{"should flag a price change without holding the cart up", types.CartLine{ProductID: 4, Quantity: 1, AddedPrice: money.New(800, "USD")}, []string{types.CartIssuePriceChanged}, true},
+		{"should flag a line over the stock", types.CartLine{ProductID: 4, Quantity: 3, AddedPrice: money.New(1000, "USD")}, []string{types.CartIssueInsufficientStock}, false},
+		{"should flag a product that is out of stock", types.CartLine{ProductID: 3, Quantity: 1, AddedPrice: money.New(1000, "USD")}, []string{types.CartIssueUnavailable}, false},
+		{"should flag a product that is gone", types.CartLine{ProductID: 9, Quantity: 1, AddedPrice: money.New(1000, "USD")}, []string{types.CartIssueUnavailable}, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			view := revalidate(c.line)
+
+			if view.ReadyForCheckout != c.ready {
+				t.Errorf("expected ready for checkout %t, got %t", c.ready, view.ReadyForCheckout)
+			}
+
+			if issues := view.Items[0].Issues; !reflect.DeepEqual(issues, c.issues) {
+				t.Errorf("expected issues %v, got %v", c.issues, issues)
+			}
+		})
+	}
+
+	t.Run("should price the lines at their current price", func(t *testing.T) {
+		view := revalidate(types.CartLine{ProductID: 4, Quantity: 2, AddedPrice: money.New(800, "USD")})
+
+		if line := view.Items[0]; line.UnitPrice != money.New(1000, "USD") || line.LineTotal != money.New(2000, "USD") || view.Subtotal != money.New(2000, "USD") {
+			t.Errorf("expected two at 10.00, got %s for %s and a subtotal of %s", line.UnitPrice, line.LineTotal, view.Subtotal)
+		}
+	})
+
+	t.Run("should not be ready to check out an empty cart", func(t *testing.T) {
+		if view := revalidate(); view.ReadyForCheckout || len(view.Items) != 0 {
+			t.Errorf("expected an empty cart not ready for checkout, got %+v", view)
+		}
+	})
+
+	t.Run("should show lines the stock policy allows as backordered", func(t *testing.T) {
+		view := revalidate(types.CartLine{ProductID: 1, Quantity: 2}, types.CartLine{ProductID: 2, Quantity: 1})
+
+		if !view.ReadyForCheckout {
+			t.Errorf("expected the cart ready for checkout, got %+v", view.Items)
+		}
+
+		for _, line := range view.Items {
+			if line.FulfilmentStatus != types.FulfilmentBackordered || len(line.Issues) != 0 {
+				t.Errorf("expected product %d backordered without issues, got %s and %v", line.ProductID, line.FulfilmentStatus, line.Issues)
+			}
+		}
+
+		if expected := view.Items[1].ExpectedAt; expected == nil || !expected.Equal(availableAt) {
+			t.Errorf("expected the pre-order at %s, got %v", availableAt, expected)
+		}
+	})
+
+	t.Run("should hold up lines past what the stock policy allows", func(t *testing.T) {
+		view := revalidate(types.CartLine{ProductID: 1, Quantity: 6}, types.CartLine{ProductID: 3, Quantity: 1})
+
+		if view.ReadyForCheckout {
+			t.Error("expected the cart not to be ready for checkout")
+		}
+
+		if issues := view.Items[0].Issues; len(issues) != 1 || issues[0] != types.CartIssueUnavailable {
+			t.Errorf("expected the line over the backorder limit unavailable, got %v", issues)
+		}
+
+		if issues := view.Items[1].Issues; len(issues) != 1 || issues[0] != types.CartIssueUnavailable {
+			t.Errorf("expected the product that cannot be backordered unavailable, got %v", issues)
+		}
+	})
+}
+
+// fakeDatabase stands in for MySQL's transactions and row locks: writes made
+// in a transaction are applied when it commits, and a locked product stays
+// locked until the transaction holding it ends.
+type fakeDatabase struct {
+	mu         sync.Mutex
+	stock      map[int]int
+	locks      map[int]*sync.Mutex
+	orders     int
+	failOrders bool
+}
+
+func (d *fakeDatabase) quantity(productId int) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.stock[productId]
+}
+
+type fakeTx struct {
+	types.DB
+
+	held   []*sync.Mutex
+	stock  map[int]int
+	orders int
+}
+
+type fakeUnitOfWork struct {
+	database *fakeDatabase
+}
+
+func (u *fakeUnitOfWork) Do(ctx context.Context, fn func(tx types.DB) error) error {
+	tx := &fakeTx{stock: map[int]int{}}
+	defer func() {
+		for _, lock := range tx.held {
+			lock.Unlock()
+		}
+	}()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	u.database.mu.Lock()
+	defer u.database.mu.Unlock()
+
+	for id, quantity := range tx.stock {
+		u.database.stock[id] = quantity
+	}
+	u.database.orders += tx.orders
+
+	return nil
+}
+
+type fakeProductStore struct {
+	types.ProductStore
+
+	database *fakeDatabase
+	tx       *fakeTx
+}
+
+func (s *fakeProductStore) WithTx(tx types.DB) types.ProductStore {
+	return &fakeProductStore{database: s.database, tx: tx.(*fakeTx)}
+}
+
+func (s *fakeProductStore) GetProductsByID(ctx context.Context, ids []int) ([]types.Product, error) {
+	products := []types.Product{}
+	for _, id := range ids {
+		products = append(products, types.Product{ID: id, Price: money.New(1000, "USD"), Quantity: s.database.quantity(id)})
+	}
+
+	return products, nil
+}
+
+func (s *fakeProductStore) LockProductsByID(ctx context.Context, ids []int) ([]types.Product, error) {
+	sorted := append([]int{}, ids...)
+	sort.Ints(sorted)
+	for _, id := range sorted {
+		s.database.locks[id].Lock()
+		s.tx.held = append(s.tx.held, s.database.locks[id])
+	}
+
+	return s.GetProductsByID(ctx, ids)
+}
+
+func (s *fakeProductStore) UpdateProductBatch(ctx context.Context, products map[int]types.Product) error {
+	// give concurrent checkouts the chance to read stale stock
+	time.Sleep(time.Millisecond)
+
+	for id, product := range products {
+		s.tx.stock[id] = product.Quantity
+	}
+
+	return nil
+}
+
+// mockCartProductStore returns the products it holds as they are, stock
+// policy included.
+type mockCartProductStore struct {
+	types.ProductStore
+
+	products map[int]types.Product
+}
+
+func (m *mockCartProductStore) GetProductsByID(ctx context.Context, ids []int) ([]types.Product, error) {
+	products := []types.Product{}
+	for _, id := range ids {
+		if product, ok := m.products[id]; ok {
+			products = append(products, product)
+		}
+	}
+
+	return products, nil
+}
+
+type fakeOrderStore struct {
+	types.OrderStore
+
+	database *fakeDatabase
+	tx       *fakeTx
+}
+
+func (s *fakeOrderStore) WithTx(tx types.DB) types.OrderStore {
+	return &fakeOrderStore{database: s.database, tx: tx.(*fakeTx)}
+}
+
+func (s *fakeOrderStore) CreateOrder(ctx context.Context, order types.Order) (int, error) {
+	if s.database.failOrders {
+		return 0, fmt.Errorf("database unavailable")
+	}
+
+	s.tx.orders++
+
+	return s.tx.orders, nil
+}
+
+func (s *fakeOrderStore) CreateOrderItem(ctx context.Context, item types.OrderItem) (int, error) {
+	return 1, nil
+}
+
+type mockCheckoutUserStore struct {
+	types.UserStore
+
+	address string
+}
+
+func (m *mockCheckoutUserStore) GetUserAddressesByUserId(ctx context.Context, id int) ([]types.UserAddresses, error) {
+	return []types.UserAddresses{{UserId: id, Address: m.address, AddressType: types.First}}, nil
+}
+
+func (m *mockCheckoutUserStore) WithTx(tx types.DB) types.UserStore {
+	return m
+}
+
+type mockCheckoutPriceStore struct {
+	types.PriceStore
+}
+
+func (m *mockCheckoutPriceStore) GetEffectivePrices(ctx context.Context, ids []int, at time.Time) (map[int]types.ProductPrice, error) {
+	return map[int]types.ProductPrice{}, nil
+}
+
+type mockCheckoutCurrencyStore struct {
+	types.CurrencyStore
+}
+
+func (m *mockCheckoutCurrencyStore) GetExchangeRates(ctx context.Context) ([]types.ExchangeRate, error) {
+	return []types.ExchangeRate{}, nil
+}
+
+type mockCheckoutPromotionStore struct {
+	types.PromotionStore
+}
+
+func (m *mockCheckoutPromotionStore) GetApplicablePromotions(ctx context.Context, codes []string, at time.Time) ([]types.Promotion, error) {
+	return []types.Promotion{}, nil
+}
+
+func (m *mockCheckoutPromotionStore) GetUsage(ctx context.Context, promotionIDs []int, userId int) (map[int]int, map[int]int, error) {
+	return map[int]int{}, map[int]int{}, nil
+}
+
+func (m *mockCheckoutPromotionStore) RecordRedemptions(ctx context.Context, redemptions []types.PromotionRedemption, discounts []types.OrderItemDiscount) error {
+	return nil
+}
+
+func (m *mockCheckoutPromotionStore) WithTx(tx types.DB) types.PromotionStore {
+	return m
+}
+
+type mockCheckoutTaxStore struct {
+	types.TaxStore
+}
+
+func (m *mockCheckoutTaxStore) RecordOrderItemTaxes(ctx context.Context, taxes []types.OrderItemTax) error {
+	return nil
+}
+
+func (m *mockCheckoutTaxStore) WithTx(tx types.DB) types.TaxStore {
+	return m
+}
+
+type mockPayments struct {
+	types.PaymentProcessor
+
+	status  types.PaymentStatus
+	err     error
+	charges int
+}
+
+func (m *mockPayments) Charge(ctx context.Context, order types.Order, source string) (*types.Payment, error) {
+	m.charges++
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	if m.status == "" {
+		return nil, nil
+	}
+
+	return &types.Payment{OrderID: order.ID, Status: m.status, Amount: order.Total}, nil
+}
+
+type mockCartStore struct {
+	types.CartStore
+
+	lines    []types.CartLine
+	linesErr error
+	clearErr error
+	cleared  bool
+}
+
+func (m *mockCartStore) GetOrCreateCartByUserId(ctx context.Context, userId int) (*types.Cart, error) {
+	return &types.Cart{ID: 1}, nil
+}
+
+func (m *mockCartStore) GetCartLines(ctx context.Context, cartId int) ([]types.CartLine, error) {
+	return m.lines, m.linesErr
+}
+
+func (m *mockCartStore) ClearCart(ctx context.Context, cartId int) error {
+	if m.clearErr != nil {
+		return m.clearErr
+	}
+
+	m.cleared = true
+	return nil
+}
+
+type mockCheckoutIdempotencyStore struct {
+	types.IdempotencyStore
+
+	records map[string]types.IdempotencyRecord
+}
+
+func (m *mockCheckoutIdempotencyStore) ClaimKey(ctx context.Context, record types.IdempotencyRecord) (*types.IdempotencyRecord, error) {
+	if existing, ok := m.records[record.Scope+record.Key]; ok {
+		return &existing, nil
+	}
+	m.records[record.Scope+record.Key] = record
+
+	return nil, nil
+}
+
+func (m *mockCheckoutIdempotencyStore) SaveResponse(ctx context.Context, record types.IdempotencyRecord) error {
+	m.records[record.Scope+record.Key] = record
+	return nil
+}
+
+func (m *mockCheckoutIdempotencyStore) ReleaseKey(ctx context.Context, scope string, key string) error {
+	delete(m.records, scope+key)
+	return nil
+}
+
+func TestRedeem(t *testing.T) {
+	expired := time.Now().Add(-time.Hour)
+	setup := func() (*Handler, *mockCheckoutGiftCardStore, *mockCheckoutPaymentStore, *mockCheckoutLoyaltyStore) {
+		giftCards := &mockCheckoutGiftCardStore{
+			cards: map[string]*types.GiftCard{
+				giftcard.HashCode("AAAA-AAAA-AAAA-AAAA"): {ID: 1, Last4: "AAAA", Balance: money.New(3000, "USD"), Status: types.GiftCardActive},
+				giftcard.HashCode("BBBB-BBBB-BBBB-BBBB"): {ID: 2, Last4: "BBBB", Balance: money.New(4000, "USD"), Status: types.GiftCardActive},
+				giftcard.HashCode("CCCC-CCCC-CCCC-CCCC"): {ID: 3, Last4: "CCCC", Balance: money.New(4000, "USD"), Status: types.GiftCardActive, ExpiresAt: &expired},
+			},
+			wallet: money.New(10000, "USD"),
+		}
+		payments := &mockCheckoutPaymentStore{}
+		loyalty := &mockCheckoutLoyaltyStore{balance: 1500}
+
+		return &Handler{giftCardStore: giftCards, paymentStore: payments, loyaltyStore: loyalty}, giftCards, payments, loyalty
+	}
+
+	t.Run("should spend the gift cards in turn before store credit", func(t *testing.T) {
+		handler, giftCards, payments, _ := setup()
+
+		redeemed, err := handler.redeem(context.Background(), 1, 5, money.New(5000, "USD"), types.CartCheckoutPayload{
+			GiftCards:      []string{"aaaa aaaa aaaa aaaa", "BBBB-BBBB-BBBB-BBBB"},
+			UseStoreCredit: true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if left := giftCards.cards[giftcard.HashCode("BBBB-BBBB-BBBB-BBBB")].Balance; redeemed != money.New(5000, "USD") || left != money.New(2000, "USD") {
+			t.Fatalf("expected 50.00 redeemed leaving 20.00 on the second card, got %s and %s", redeemed, left)
+		}
+
+		if len(payments.payments) != 2 || payments.payments[0].Amount != money.New(3000, "USD") || payments.payments[1].Amount != money.New(2000, "USD") {
+			t.Errorf("expected 30.00 and 20.00 paid with gift cards, got %+v", payments.payments)
+		}
+
+		if giftCards.wallet != money.New(10000, "USD") {
+			t.Errorf("expected the store credit left alone, got %s", giftCards.wallet)
+		}
+	})
+
+	t.Run("should pay the rest with store credit", func(t *testing.T) {
+		handler, giftCards, payments, _ := setup()
+
+		redeemed, err := handler.redeem(context.Background(), 1, 5, money.New(5000, "USD"), types.CartCheckoutPayload{
+			GiftCards:      []string{"AAAA-AAAA-AAAA-AAAA"},
+			UseStoreCredit: true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if redeemed != money.New(5000, "USD") || giftCards.wallet != money.New(8000, "USD") || payments.payments[1].Provider != types.TenderStoreCredit {
+			t.Errorf("expected 20.00 paid with store credit, got %s redeemed and %s left", redeemed, giftCards.wallet)
+		}
+	})
+
+	t.Run("should refuse an expired gift card", func(t *testing.T) {
+		handler, _, payments, _ := setup()
+
+		_, err := handler.redeem(context.Background(), 1, 5, money.New(5000, "USD"), types.CartCheckoutPayload{GiftCards: []string{"CCCC-CCCC-CCCC-CCCC"}})
+		if checkoutStatus(err) != http.StatusBadRequest || len(payments.payments) != 0 {
+			t.Errorf("expected the expired gift card refused, got %v", err)
+		}
+	})
+
+	t.Run("should refuse a gift card spent elsewhere at the same time", func(t *testing.T) {
+		handler, giftCards, payments, _ := setup()
+		giftCards.spentElsewhere = true
+
+		_, err := handler.redeem(context.Background(), 1, 5, money.New(5000, "USD"), types.CartCheckoutPayload{GiftCards: []string{"AAAA-AAAA-AAAA-AAAA"}})
+		if checkoutStatus(err) != http.StatusBadRequest || len(payments.payments) != 0 {
+			t.Errorf("expected the spent gift card refused, got %v", err)
+		}
+	})
+
+	t.Run("should spend loyalty points before store credit", func(t *testing.T) {
+		handler, giftCards, payments, loyalty := setup()
+
+		redeemed, err := handler.redeem(context.Background(), 1, 5, money.New(5000, "USD"), types.CartCheckoutPayload{
+			GiftCards:      []string{"AAAA-AAAA-AAAA-AAAA"},
+			LoyaltyPoints:  1200,
+			UseStoreCredit: true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// a point is worth a cent
+		if redeemed != money.New(5000, "USD") || loyalty.balance != 300 || giftCards.wallet != money.New(9200, "USD") {
+			t.Errorf("expected 12.00 paid with points and 8.00 with store credit, got %d points and %s of store credit left", loyalty.balance, giftCards.wallet)
+		}
+
+		if len(payments.payments) != 3 || payments.payments[1].Provider != types.TenderLoyaltyPoints {
+			t.Errorf("expected a payment with loyalty points, got %+v", payments.payments)
+		}
+	})
+
+	t.Run("should refuse points spent elsewhere at the same time", func(t *testing.T) {
+		handler, _, payments, points := setup()
+		points.spentElsewhere = true
+
+		_, err := handler.redeem(context.Background(), 1, 5, money.New(5000, "USD"), types.CartCheckoutPayload{LoyaltyPoints: 1200})
+		if checkoutStatus(err) != http.StatusBadRequest || len(payments.payments) != 0 {
+			t.Errorf("expected the spent points refused, got %v", err)
+		}
+	})
+
+	t.Run("should refuse more points than the customer has", func(t *testing.T) {
+		handler, _, _, _ := setup()
+
+		_, err := handler.redeem(context.Background(), 1, 5, money.New(5000, "USD"), types.CartCheckoutPayload{LoyaltyPoints: 2000})
+		if checkoutStatus(err) != http.StatusBadRequest {
+			t.Errorf("expected the points refused, got %v", err)
+		}
+	})
+}
+
+// mockCheckoutGiftCardStore keeps the cards by the hash of their code and a
+// single customer's store credit.
+type mockCheckoutGiftCardStore struct {
+	types.GiftCardStore
+
+	cards  map[string]*types.GiftCard
+	wallet money.Money
+	// spentElsewhere has the store find the balance gone when it is spent
+	spentElsewhere bool
+}
+
+func (m *mockCheckoutGiftCardStore) GetGiftCardByCode(ctx context.Context, codeHash string) (*types.GiftCard, error) {
+	card, ok := m.cards[codeHash]
+	if !ok {
+		return nil, giftcard.ErrNotFound
+	}
+
+	copied := *card
+	return &copied, nil
+}
+
+func (m *mockCheckoutGiftCardStore) AddToGiftCard(ctx context.Context, id int, kind types.LedgerEntryKind, amount money.Money, orderId *int) (int, error) {
+	if m.spentElsewhere && amount.IsNegative() {
+		return 0, fmt.Errorf("%w: gift card %d does not have %s left to spend", giftcard.ErrInsufficientBalance, id, amount.Neg())
+	}
+
+	for _, card := range m.cards {
+		if card.ID == id {
+			card.Balance = card.Balance.Add(amount)
+		}
+	}
+
+	return id, nil
+}
+
+func (m *mockCheckoutGiftCardStore) GetWallet(ctx context.Context, userId int, currency string) (*types.Wallet, error) {
+	return &types.Wallet{UserID: userId, Balance: m.wallet}, nil
+}
+
+func (m *mockCheckoutGiftCardStore) AddToWallet(ctx context.Context, userId int, kind types.LedgerEntryKind, amount money.Money, orderId *int) (int, error) {
+	m.wallet = m.wallet.Add(amount)
+	return userId, nil
+}
+
+func (m *mockCheckoutGiftCardStore) WithTx(tx types.DB) types.GiftCardStore {
+	return m
+}
+
+type mockCheckoutPaymentStore struct {
+	types.PaymentStore
+
+	payments []types.Payment
+}
+
+func (m *mockCheckoutPaymentStore) CreatePayment(ctx context.Context, payment types.Payment) (int, error) {
+	m.payments = append(m.payments, payment)
+	return len(m.payments), nil
+}
+
+func (m *mockCheckoutPaymentStore) WithTx(tx types.DB) types.PaymentStore {
+	return m
+}
+
+type mockCheckoutLoyaltyStore struct {
+	types.LoyaltyStore
+
+	balance int
+	// spentElsewhere has the store find the points gone when they are spent
+	spentElsewhere bool
+}
+
+func (m *mockCheckoutLoyaltyStore) GetLoyaltyAccount(ctx context.Context, userId int) (*types.LoyaltyAccount, error) {
+	return &types.LoyaltyAccount{UserID: userId, Balance: m.balance}, nil
+}
+
+func (m *mockCheckoutLoyaltyStore) AddPoints(ctx context.Context, userId int, kind types.LoyaltyEntryKind, points int, orderId *int) (int, error) {
+	if m.spentElsewhere && points < 0 {
+		return 0, fmt.Errorf("%w: there are not %d loyalty points left to spend", loyalty.ErrInsufficientPoints, -points)
+	}
+
+	m.balance += points
+	return 1, nil
+}
+
+func (m *mockCheckoutLoyaltyStore) WithTx(tx types.DB) types.LoyaltyStore {
+	return m
+}
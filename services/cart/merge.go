@@ -0,0 +1,135 @@
+package cart
+
+import (
+	"context"
+	"github.com/xelathan/golang_backend/config"
+	"github.com/xelathan/golang_backend/services/auth"
+	"github.com/xelathan/golang_backend/types"
+)
+
+// Merger moves guest carts into user carts. It is handed to the user
+// handler so logging in or registering with a cart token keeps the cart.
+type Merger struct {
+	store        types.CartStore
+	productStore types.ProductStore
+}
+
+func NewMerger(store types.CartStore, productStore types.ProductStore) *Merger {
+	return &Merger{store: store, productStore: productStore}
+}
+
+// MergeGuestCart merges the guest cart named by cartToken into the user's
+// cart and deletes it. A token for a cart that no longer exists, for example
+// one that was already merged, merges nothing.
+func (m *Merger) MergeGuestCart(ctx context.Context, cartToken string, userId int) ([]types.CartAdjustment, error) {
+	guestToken, err := auth.ParseCartToken([]byte(config.Envs.CartTokenSecret), cartToken)
+	if err != nil {
+		return nil, err
+	}
+
+	guestCart, err := m.store.GetCartByGuestToken(ctx, guestToken)
+	if err != nil {
+		return []types.CartAdjustment{}, nil
+	}
+
+	userCart, err := m.store.GetOrCreateCartByUserId(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	guestLines, err := m.store.GetCartLines(ctx, guestCart.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	userLines, err := m.store.GetCartLines(ctx, userCart.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	productMap := map[int]types.Product{}
+	if len(guestLines) > 0 {
+		ids := make([]int, len(guestLines))
+		for i, line := range guestLines {
+			ids[i] = line.ProductID
+		}
+
+		products, err := m.productStore.GetProductsByID(ctx, ids)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, product := range products {
+			productMap[product.ID] = product
+		}
+	}
+
+	merged, adjustments := mergeLines(guestLines, userLines, productMap)
+	if err := m.store.MergeCart(ctx, guestCart.ID, userCart.ID, merged); err != nil {
+		return nil, err
+	}
+
+	return adjustments, nil
+}
+
+// mergeLines applies the merge rule to the guest lines in cart order:
+//   - a product in both carts gets the sum of both quantities
+//   - the resulting quantity is capped at the product's stock, unless the
+//     product's stock policy lets the customer backorder all of it
+//   - a product that is out of stock and cannot be backordered, or gone, is
+//     dropped from the guest side and the user's own line for it, if any, is
+//     left alone
+//
+// It returns the lines to write into the user's cart and an adjustment for
+// every guest line that did not carry over unchanged.
+func mergeLines(guest []types.CartLine, user []types.CartLine, products map[int]types.Product) ([]types.CartLine, []types.CartAdjustment) {
+	existing := make(map[int]int, len(user))
+	for _, line := range user {
+		existing[line.ProductID] = line.Quantity
+	}
+
+	merged := []types.CartLine{}
+	adjustments := []types.CartAdjustment{}
+	for _, line := range guest {
+		requested := line.Quantity + existing[line.ProductID]
+
+		product, ok := products[line.ProductID]
+		available := product.Quantity
+		if _, err := fulfilmentStatus(product, requested); ok && err == nil {
+			available = max(available, requested)
+		}
+
+		if !ok || available <= 0 {
+			adjustments = append(adjustments, types.CartAdjustment{
+				ProductID: line.ProductID,
+				Requested: requested,
+				Quantity:  existing[line.ProductID],
+				Reason:    types.CartAdjustmentRemoved,
+			})
+			continue
+		}
+
+		quantity := min(requested, available)
+		switch {
+		case quantity < requested:
+			adjustments = append(adjustments, types.CartAdjustment{
+				ProductID: line.ProductID,
+				Requested: requested,
+				Quantity:  quantity,
+				Reason:    types.CartAdjustmentCapped,
+			})
+		case existing[line.ProductID] > 0:
+			adjustments = append(adjustments, types.CartAdjustment{
+				ProductID: line.ProductID,
+				Requested: requested,
+				Quantity:  quantity,
+				Reason:    types.CartAdjustmentMerged,
+			})
+		}
+
+		line.Quantity = quantity
+		merged = append(merged, line)
+	}
+
+	return merged, adjustments
+}
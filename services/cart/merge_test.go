@@ -0,0 +1,92 @@
+package cart
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/xelathan/golang_backend/types"
+)
+
+func TestMergeLines(t *testing.T) {
+	products := map[int]types.Product{
+		1: {ID: 1, Quantity: 10},
+		2: {ID: 2, Quantity: 3},
+		3: {ID: 3, Quantity: 0},
+		5: {ID: 5, Quantity: 0, StockPolicy: types.StockBackorder, BackorderLimit: 4},
+	}
+
+	t.Run("should carry over guest lines the user does not have", func(t *testing.T) {
+		merged, adjustments := mergeLines(
+			[]types.CartLine{{ProductID: 1, Quantity: 2}},
+			nil,
+			products,
+		)
+
+		if len(merged) != 1 || merged[0].Quantity != 2 {
+			t.Errorf("expected one line of 2, got %v", merged)
+		}
+
+		if len(adjustments) != 0 {
+			t.Errorf("expected no adjustments, got %v", adjustments)
+		}
+	})
+
+	t.Run("should sum duplicate lines and cap them at the stock", func(t *testing.T) {
+		merged, adjustments := mergeLines(
+			[]types.CartLine{{ProductID: 1, Quantity: 2}, {ProductID: 2, Quantity: 2}},
+			[]types.CartLine{{ProductID: 1, Quantity: 3}, {ProductID: 2, Quantity: 2}},
+			products,
+		)
+
+		if len(merged) != 2 || merged[0].Quantity != 5 || merged[1].Quantity != 3 {
+			t.Errorf("expected quantities 5 and 3, got %v", merged)
+		}
+
+		expected := []types.CartAdjustment{
+			{ProductID: 1, Requested: 5, Quantity: 5, Reason: types.CartAdjustmentMerged},
+			{ProductID: 2, Requested: 4, Quantity: 3, Reason: types.CartAdjustmentCapped},
+		}
+		if !reflect.DeepEqual(adjustments, expected) {
+			t.Errorf("expected %v, got %v", expected, adjustments)
+		}
+	})
+
+	t.Run("should drop unavailable products and keep the user's line", func(t *testing.T) {
+		merged, adjustments := mergeLines(
+			[]types.CartLine{{ProductID: 3, Quantity: 1}, {ProductID: 4, Quantity: 1}},
+			[]types.CartLine{{ProductID: 3, Quantity: 2}},
+			products,
+		)
+
+		if len(merged) != 0 {
+			t.Errorf("expected nothing to merge, got %v", merged)
+		}
+
+		expected := []types.CartAdjustment{
+			{ProductID: 3, Requested: 3, Quantity: 2, Reason: types.CartAdjustmentRemoved},
+			{ProductID: 4, Requested: 1, Quantity: 0, Reason: types.CartAdjustmentRemoved},
+		}
+		if !reflect.DeepEqual(adjustments, expected) {
+			t.Errorf("expected %v, got %v", expected, adjustments)
+		}
+	})
+	t.Run("should carry over what the stock policy lets the user backorder", func(t *testing.T) {
+		merged, adjustments := mergeLines(
+			[]types.CartLine{{ProductID: 5, Quantity: 3}},
+			nil,
+			products,
+		)
+
+		if len(merged) != 1 || merged[0].Quantity != 3 {
+			t.Errorf("expected one line of 3, got %v", merged)
+		}
+
+		if len(adjustments) != 0 {
+			t.Errorf("expected no adjustments, got %v", adjustments)
+		}
+
+		if merged, _ := mergeLines([]types.CartLine{{ProductID: 5, Quantity: 6}}, nil, products); len(merged) != 0 {
+			t.Errorf("expected a line over the backorder limit to be dropped, got %v", merged)
+		}
+	})
+}
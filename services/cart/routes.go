@@ -1,36 +1,266 @@
 package cart
 
 import (
-	"database/sql"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"net/http"
+	"strconv"
 
+	"github.com/go-playground/validator/v10"
 	"github.com/gorilla/mux"
+	"github.com/xelathan/golang_backend/config"
+	"github.com/xelathan/golang_backend/money"
 	"github.com/xelathan/golang_backend/services/auth"
+	"github.com/xelathan/golang_backend/services/currency"
+	"github.com/xelathan/golang_backend/services/idempotency"
+	"github.com/xelathan/golang_backend/services/tax"
 	"github.com/xelathan/golang_backend/types"
 	"github.com/xelathan/golang_backend/utils"
 )
 
 type Handler struct {
-	orderStore   types.OrderStore
-	productStore types.ProductStore
-	userStore    types.UserStore
-	db           *sql.DB
+	store          types.CartStore
+	orderStore     types.OrderStore
+	productStore   types.ProductStore
+	userStore      types.UserStore
+	priceStore     types.PriceStore
+	currencyStore  types.CurrencyStore
+	promotionStore types.PromotionStore
+	taxCalculator  types.TaxCalculator
+	taxStore       types.TaxStore
+	shippingStore  types.ShippingStore
+	giftCardStore  types.GiftCardStore
+	paymentStore   types.PaymentStore
+	loyaltyStore   types.LoyaltyStore
+	payments       types.PaymentProcessor
+	keys           *idempotency.Keys
+	uow            types.UnitOfWork
 }
 
-func NewHandler(orderStore types.OrderStore, productStore types.ProductStore, userStore types.UserStore, db *sql.DB) *Handler {
+func NewHandler(store types.CartStore, orderStore types.OrderStore, productStore types.ProductStore, userStore types.UserStore, priceStore types.PriceStore, currencyStore types.CurrencyStore, promotionStore types.PromotionStore, taxCalculator types.TaxCalculator, taxStore types.TaxStore, shippingStore types.ShippingStore, giftCardStore types.GiftCardStore, paymentStore types.PaymentStore, loyaltyStore types.LoyaltyStore, payments types.PaymentProcessor, keys *idempotency.Keys, uow types.UnitOfWork) *Handler {
 	return &Handler{
-		orderStore:   orderStore,
-		productStore: productStore,
-		userStore:    userStore,
-		db:           db,
+		store:          store,
+		orderStore:     orderStore,
+		productStore:   productStore,
+		userStore:      userStore,
+		priceStore:     priceStore,
+		currencyStore:  currencyStore,
+		promotionStore: promotionStore,
+		taxCalculator:  taxCalculator,
+		taxStore:       taxStore,
+		shippingStore:  shippingStore,
+		giftCardStore:  giftCardStore,
+		paymentStore:   paymentStore,
+		loyaltyStore:   loyaltyStore,
+		payments:       payments,
+		keys:           keys,
+		uow:            uow,
 	}
 }
 
 func (h *Handler) RegisterRoutes(router *mux.Router) {
-	router.HandleFunc("/cart/checkout", auth.WithJWTAuth(h.handleCheckout, h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/cart", auth.WithOptionalJWTAuth(h.handleGetCart, h.userStore)).Methods(http.MethodGet)
+	router.HandleFunc("/cart/items", auth.WithOptionalJWTAuth(h.handleAddItem, h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/cart/items/{id}", auth.WithOptionalJWTAuth(h.handleUpdateItem, h.userStore)).Methods(http.MethodPatch)
+	router.HandleFunc("/cart/items/{id}", auth.WithOptionalJWTAuth(h.handleDeleteItem, h.userStore)).Methods(http.MethodDelete)
+	router.HandleFunc("/cart/quote", auth.WithOptionalJWTAuth(h.handleQuote, h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/cart/shipping_quotes", auth.WithOptionalJWTAuth(h.handleShippingQuotes, h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/cart/checkout", auth.WithJWTAuth(h.keys.Wrap(h.handleCheckout), h.userStore)).Methods(http.MethodPost)
 }
 
+func (h *Handler) handleGetCart(w http.ResponseWriter, r *http.Request) {
+	currencyCode, err := currency.FromRequest(r, config.Envs.BaseCurrency)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	cart, status, err := h.resolveCart(w, r, false)
+	if err != nil {
+		utils.WriteError(w, status, err)
+		return
+	}
+
+	h.writeCart(w, r, http.StatusOK, cart, currencyCode)
+}
+
+func (h *Handler) handleAddItem(w http.ResponseWriter, r *http.Request) {
+	payload := types.AddCartItemPayload{}
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	currencyCode, err := currency.FromRequest(r, config.Envs.BaseCurrency)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	cart, status, err := h.resolveCart(w, r, true)
+	if err != nil {
+		utils.WriteError(w, status, err)
+		return
+	}
+
+	lines, err := h.store.GetCartLines(r.Context(), cart.ID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// the stock check covers what is already in the cart for the product
+	quantity := payload.Quantity
+	for _, line := range lines {
+		if line.ProductID == payload.ProductID {
+			quantity += line.Quantity
+		}
+	}
+
+	product, status, err := h.getProductWithStock(r.Context(), payload.ProductID, quantity)
+	if err != nil {
+		utils.WriteError(w, status, err)
+		return
+	}
+
+	price, err := h.getCurrentPrice(r.Context(), *product, currencyCode)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := h.store.AddCartLine(r.Context(), types.CartLine{
+		CartID:     cart.ID,
+		ProductID:  product.ID,
+		Quantity:   payload.Quantity,
+		AddedPrice: price,
+	}); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.writeCart(w, r, http.StatusCreated, cart, currencyCode)
+}
+
+func (h *Handler) handleUpdateItem(w http.ResponseWriter, r *http.Request) {
+	lineId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid cart item id"))
+		return
+	}
+
+	payload := types.UpdateCartItemPayload{}
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	currencyCode, err := currency.FromRequest(r, config.Envs.BaseCurrency)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	cart, status, err := h.resolveCart(w, r, false)
+	if err != nil {
+		utils.WriteError(w, status, err)
+		return
+	}
+
+	if cart == nil {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("cart item not found"))
+		return
+	}
+
+	lines, err := h.store.GetCartLines(r.Context(), cart.ID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	line, ok := findLine(lines, lineId)
+	if !ok {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("cart item not found"))
+		return
+	}
+
+	if _, status, err := h.getProductWithStock(r.Context(), line.ProductID, payload.Quantity); err != nil {
+		utils.WriteError(w, status, err)
+		return
+	}
+
+	if err := h.store.UpdateCartLineQuantity(r.Context(), cart.ID, line.ID, payload.Quantity); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.writeCart(w, r, http.StatusOK, cart, currencyCode)
+}
+
+func (h *Handler) handleDeleteItem(w http.ResponseWriter, r *http.Request) {
+	lineId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid cart item id"))
+		return
+	}
+
+	currencyCode, err := currency.FromRequest(r, config.Envs.BaseCurrency)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	cart, status, err := h.resolveCart(w, r, false)
+	if err != nil {
+		utils.WriteError(w, status, err)
+		return
+	}
+
+	if cart == nil {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("cart item not found"))
+		return
+	}
+
+	lines, err := h.store.GetCartLines(r.Context(), cart.ID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if _, ok := findLine(lines, lineId); !ok {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("cart item not found"))
+		return
+	}
+
+	if err := h.store.DeleteCartLine(r.Context(), cart.ID, lineId); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.writeCart(w, r, http.StatusOK, cart, currencyCode)
+}
+
+// handleCheckout checks out the items in the body, or the stored cart when
+// the body is empty or has no items, and takes payment. The stored cart is
+// emptied once the order is placed and paid for, or its payment waits on
+// the customer, which is answered with 202. Gift cards, loyalty points and
+// store credit are taken along with the order and only the rest is charged. Guests have to
+// log in first, which merges their cart.
 func (h *Handler) handleCheckout(w http.ResponseWriter, r *http.Request) {
 	userId := auth.GetUserIdFromContext(r.Context())
 	if userId == -1 {
@@ -40,7 +270,7 @@ func (h *Handler) handleCheckout(w http.ResponseWriter, r *http.Request) {
 
 	cart_payload := types.CartCheckoutPayload{}
 
-	if err := utils.ParseJSON(r, &cart_payload); err != nil {
+	if err := utils.ParseJSON(r, &cart_payload); err != nil && !errors.Is(err, io.EOF) {
 		utils.WriteError(w, http.StatusBadRequest, err)
 		return
 	}
@@ -50,37 +280,387 @@ func (h *Handler) handleCheckout(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ids, err := getCartItemsIDs(cart_payload.Items)
+	var storedCart *types.Cart
+	items := cart_payload.Items
+	if len(items) == 0 {
+		cart, err := h.store.GetOrCreateCartByUserId(r.Context(), userId)
+		if err != nil {
+			idempotency.Abandon(r)
+			utils.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		lines, err := h.store.GetCartLines(r.Context(), cart.ID)
+		if err != nil {
+			idempotency.Abandon(r)
+			utils.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		storedCart, items = cart, cartItemsFromLines(lines)
+	}
+
+	ids, err := getCartItemsIDs(items)
 	if err != nil {
 		utils.WriteError(w, http.StatusBadRequest, err)
 		return
 	}
 
-	tx, err := h.db.Begin()
+	if len(ids) == 0 {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("cart is empty"))
+		return
+	}
+
+	currencyCode, err := currency.FromRequest(r, config.Envs.BaseCurrency)
 	if err != nil {
-		utils.WriteError(w, http.StatusInternalServerError, err)
+		utils.WriteError(w, http.StatusBadRequest, err)
 		return
 	}
-	// get products
-	products, err := h.productStore.GetProductsByID(ids)
+
+	// the stock is taken, the gift cards spent and the order placed
+	// together, or not at all
+	destination := normalizeDestination(cart_payload.Destination)
+	var orderId int
+	var priced *pricedCheckout
+	var redeemed money.Money
+	err = h.uow.Do(r.Context(), func(tx types.DB) error {
+		bound := h.withTx(tx)
+
+		var err error
+		orderId, priced, err = bound.createOrder(r.Context(), ids, items, userId, currencyCode, cart_payload.Codes, destination, cart_payload.ShippingMethodID, 0)
+		if err != nil {
+			return err
+		}
+
+		redeemed, err = bound.redeem(r.Context(), orderId, userId, priced.Total(), cart_payload)
+		return err
+	})
+	if err != nil {
+		// nothing was placed, so a retry can run again
+		idempotency.Abandon(r)
+		utils.WriteError(w, checkoutStatus(err), err)
+		return
+	}
+
+	// a declined payment cancels the order, gives back what was redeemed and
+	// leaves the cart as it was. From here on the order is placed, so errors
+	// are replayed to retries instead of placing it again.
+	payment, err := h.payments.Charge(r.Context(), types.Order{ID: orderId, UserId: userId, Total: priced.Total().Sub(redeemed)}, cart_payload.PaymentSource)
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, err)
-		tx.Rollback()
 		return
 	}
 
-	orderId, totalPrice, err := h.createOrder(products, cart_payload.Items, userId)
+	status := http.StatusOK
+	if payment != nil {
+		switch payment.Status {
+		case types.PaymentDeclined, types.PaymentFailed:
+			utils.WriteError(w, http.StatusPaymentRequired, fmt.Errorf("payment %s: %s", payment.Status, payment.FailureReason))
+			return
+		case types.PaymentRequiresAction:
+			status = http.StatusAccepted
+		}
+	}
+
+	if storedCart != nil {
+		// the order is paid for either way, so a cart that cannot be emptied
+		// is left for the customer to clear rather than failing the checkout
+		if err := h.store.ClearCart(r.Context(), storedCart.ID); err != nil {
+			log.Printf("cart: clearing cart %d failed: %v", storedCart.ID, err)
+		}
+	}
+
+	response := checkoutResponse(priced)
+	response["orderId"] = orderId
+	response["redeemed"] = redeemed
+	response["payment"] = payment
+	utils.WriteJSON(w, status, response)
+}
+
+// handleQuote prices the items in the body, or the caller's stored cart, the
+// way checkout would without placing an order. Guests can ask for quotes.
+func (h *Handler) handleQuote(w http.ResponseWriter, r *http.Request) {
+	payload := types.CartCheckoutPayload{}
+	if err := utils.ParseJSON(r, &payload); err != nil && !errors.Is(err, io.EOF) {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	currencyCode, err := currency.FromRequest(r, config.Envs.BaseCurrency)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	items, productMap, status, err := h.quoteItems(w, r, payload.Items)
+	if err != nil {
+		utils.WriteError(w, status, err)
+		return
+	}
+
+	userId := auth.GetUserIdFromContext(r.Context())
+	priced, err := h.priceCheckout(r.Context(), productMap, items, userId, currencyCode, payload.Codes, normalizeDestination(payload.Destination), payload.ShippingMethodID, 0)
+	if err != nil {
+		utils.WriteError(w, checkoutStatus(err), err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, checkoutResponse(priced))
+}
+
+// handleShippingQuotes lists the shipping methods available for the items in
+// the body, or the caller's stored cart, at the given address. Costs take
+// the promotion codes into account, so free shipping shows up here.
+func (h *Handler) handleShippingQuotes(w http.ResponseWriter, r *http.Request) {
+	payload := types.ShippingQuotePayload{}
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	currencyCode, err := currency.FromRequest(r, config.Envs.BaseCurrency)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	items, productMap, status, err := h.quoteItems(w, r, payload.Items)
+	if err != nil {
+		utils.WriteError(w, status, err)
+		return
+	}
+
+	userId := auth.GetUserIdFromContext(r.Context())
+	priced, err := h.priceCheckout(r.Context(), productMap, items, userId, currencyCode, payload.Codes, nil, 0, 0)
+	if err != nil {
+		utils.WriteError(w, checkoutStatus(err), err)
+		return
+	}
+
+	quotes, err := h.shippingQuotes(r.Context(), productMap, items, priced, tax.NormalizeDestination(payload.Destination), currencyCode)
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, err)
-		tx.Rollback()
 		return
 	}
 
-	err = tx.Commit()
+	utils.WriteJSON(w, http.StatusOK, map[string]any{
+		"freeShipping": priced.Promotions.FreeShipping,
+		"methods":      quotes,
+	})
+}
+
+// quoteItems returns the items to quote, falling back to the caller's stored
+// cart, with their products, checking every product can cover its quantity.
+// The status code to respond with is returned alongside any error.
+func (h *Handler) quoteItems(w http.ResponseWriter, r *http.Request, items []types.CartItem) ([]types.CartItem, map[int]types.Product, int, error) {
+	if len(items) == 0 {
+		cart, status, err := h.resolveCart(w, r, false)
+		if err != nil {
+			return nil, nil, status, err
+		}
+
+		if cart != nil {
+			lines, err := h.store.GetCartLines(r.Context(), cart.ID)
+			if err != nil {
+				return nil, nil, http.StatusInternalServerError, err
+			}
+
+			items = cartItemsFromLines(lines)
+		}
+	}
+
+	ids, err := getCartItemsIDs(items)
+	if err != nil {
+		return nil, nil, http.StatusBadRequest, err
+	}
+
+	if len(ids) == 0 {
+		return nil, nil, http.StatusBadRequest, fmt.Errorf("cart is empty")
+	}
+
+	products, err := h.productStore.GetProductsByID(r.Context(), ids)
+	if err != nil {
+		return nil, nil, http.StatusInternalServerError, err
+	}
+
+	productMap := make(map[int]types.Product, len(products))
+	for _, product := range products {
+		productMap[product.ID] = product
+	}
+
+	if err := checkIfCartIsInStock(items, productMap); err != nil {
+		return nil, nil, http.StatusBadRequest, err
+	}
+
+	return items, productMap, http.StatusOK, nil
+}
+
+// checkoutStatus is the status code for an error from pricing or placing an
+// order: problems with the request are bad requests.
+func checkoutStatus(err error) int {
+	var checkoutErr *checkoutError
+	if errors.As(err, &checkoutErr) {
+		return http.StatusBadRequest
+	}
+
+	return http.StatusInternalServerError
+}
+
+func checkoutResponse(priced *pricedCheckout) map[string]any {
+	return map[string]any{
+		"subtotal":     priced.Promotions.Subtotal,
+		"discount":     priced.Promotions.Discount,
+		"tax":          priced.Tax(),
+		"includedTax":  priced.IncludedTax,
+		"totalPrice":   priced.Total(),
+		"freeShipping": priced.Promotions.FreeShipping,
+		"promotions":   priced.Promotions.Applied,
+		"taxLines":     priced.TaxLines,
+		"shipping":     priced.Shipping,
+		"shippingCost": priced.ShippingCost,
+	}
+}
+
+func normalizeDestination(destination *types.Destination) *types.Destination {
+	if destination == nil {
+		return nil
+	}
+
+	normalized := tax.NormalizeDestination(*destination)
+	return &normalized
+}
+
+// resolveCart returns the signed-in user's cart, or the guest cart named by
+// the cart token header. When create is set a guest without a cart gets a
+// new one; its token is sent back in the same header. Without create a guest
+// without a cart gets a nil cart. The status code to respond with is
+// returned alongside any error.
+func (h *Handler) resolveCart(w http.ResponseWriter, r *http.Request, create bool) (*types.Cart, int, error) {
+	userId := auth.GetUserIdFromContext(r.Context())
+	if userId != -1 {
+		cart, err := h.store.GetOrCreateCartByUserId(r.Context(), userId)
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+
+		return cart, http.StatusOK, nil
+	}
+
+	secret := []byte(config.Envs.CartTokenSecret)
+	if token := r.Header.Get(auth.CartTokenHeader); token != "" {
+		guestToken, err := auth.ParseCartToken(secret, token)
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+
+		// the cart is gone once it has been merged into a user's cart
+		cart, err := h.store.GetCartByGuestToken(r.Context(), guestToken)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return nil, http.StatusInternalServerError, err
+		}
+
+		if cart != nil {
+			w.Header().Set(auth.CartTokenHeader, token)
+			return cart, http.StatusOK, nil
+		}
+	}
+
+	if !create {
+		return nil, http.StatusOK, nil
+	}
+
+	token, guestToken, err := auth.CreateCartToken(secret)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	cart, err := h.store.CreateGuestCart(r.Context(), guestToken)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	w.Header().Set(auth.CartTokenHeader, token)
+
+	return cart, http.StatusOK, nil
+}
+
+// writeCart responds with the cart revalidated in the given currency. A nil
+// cart is written as an empty one.
+func (h *Handler) writeCart(w http.ResponseWriter, r *http.Request, status int, cart *types.Cart, currencyCode string) {
+	if cart == nil {
+		cart = &types.Cart{}
+	}
+
+	lines := []types.CartLine{}
+	if cart.ID != 0 {
+		var err error
+		lines, err = h.store.GetCartLines(r.Context(), cart.ID)
+		if err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	view, err := h.revalidateCart(r.Context(), cart, lines, currencyCode)
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	utils.WriteJSON(w, http.StatusOK, map[string]any{"orderId": orderId, "totalPrice": totalPrice})
+	utils.WriteJSON(w, status, view)
+}
+
+// getProductWithStock loads a product and checks the quantity can be
+// ordered, from stock or on backorder when the product allows it, returning
+// the status code to respond with when it cannot.
+func (h *Handler) getProductWithStock(ctx context.Context, productId int, quantity int) (*types.Product, int, error) {
+	products, err := h.productStore.GetProductsByID(ctx, []int{productId})
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	if len(products) == 0 {
+		return nil, http.StatusNotFound, fmt.Errorf("product not found")
+	}
+
+	if _, err := fulfilmentStatus(products[0], quantity); err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	return &products[0], http.StatusOK, nil
+}
+
+func (h *Handler) getCurrentPrice(ctx context.Context, product types.Product, currencyCode string) (money.Money, error) {
+	converter, err := currency.LoadConverter(ctx, h.currencyStore, config.Envs.BaseCurrency)
+	if err != nil {
+		return money.Money{}, err
+	}
+
+	prices, err := h.getEffectivePrices(ctx, []types.Product{product}, converter, currencyCode)
+	if err != nil {
+		return money.Money{}, err
+	}
+
+	return prices[product.ID], nil
+}
+
+func findLine(lines []types.CartLine, lineId int) (types.CartLine, bool) {
+	for _, line := range lines {
+		if line.ID == lineId {
+			return line, true
+		}
+	}
+
+	return types.CartLine{}, false
 }
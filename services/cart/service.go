@@ -1,10 +1,21 @@
 package cart
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"strconv"
+	"time"
 
 	"github.com/xelathan/golang_backend/config"
+	"github.com/xelathan/golang_backend/money"
 	"github.com/xelathan/golang_backend/services/auth"
+	"github.com/xelathan/golang_backend/services/currency"
+	"github.com/xelathan/golang_backend/services/giftcard"
+	"github.com/xelathan/golang_backend/services/loyalty"
+	"github.com/xelathan/golang_backend/services/promotion"
+	"github.com/xelathan/golang_backend/services/shipping"
+	"github.com/xelathan/golang_backend/services/tax"
 	"github.com/xelathan/golang_backend/types"
 )
 
@@ -21,13 +32,187 @@ func getCartItemsIDs(items []types.CartItem) ([]int, error) {
 	return productIDs, nil
 }
 
-func (h *Handler) createOrder(products []types.Product, items []types.CartItem, userID int) (int, float64, error) {
+// pricedCheckout is a cart priced for checkout in the order currency: the
+// unit prices, the promotions that apply, the tax on what is left and the
+// shipping method chosen, if any.
+type pricedCheckout struct {
+	ExchangeRate string
+	Prices       map[int]money.Money
+	Promotions   promotion.Result
+	TaxLines     []types.TaxLine
+	AddedTax     money.Money
+	IncludedTax  money.Money
+	Shipping     *types.ShippingQuote
+	ShippingCost money.Money
+
+	converter *currency.Converter
+}
+
+func (c *pricedCheckout) Tax() money.Money {
+	return c.AddedTax.Add(c.IncludedTax)
+}
+
+// Total is the discounted subtotal plus the tax not already in the prices
+// and the shipping cost.
+func (c *pricedCheckout) Total() money.Money {
+	return c.Promotions.Total().Add(c.AddedTax).Add(c.ShippingCost)
+}
+
+// priceCheckout prices the items without changing anything, which is all a
+// quote needs and the first step of placing an order. Without a destination
+// no tax is calculated, and a shipping method can only be chosen with one.
+// percentOff lowers every unit price before promotions, for subscribers.
+// Errors caused by the request are *checkoutError.
+func (h *Handler) priceCheckout(ctx context.Context, productMap map[int]types.Product, items []types.CartItem, userID int, currencyCode string, codes []string, destination *types.Destination, shippingMethodID int, percentOff int) (*pricedCheckout, error) {
+	products := make([]types.Product, 0, len(productMap))
+	for _, product := range productMap {
+		products = append(products, product)
+	}
+
+	// prices are resolved once so the total and the order items agree
+	converter, err := currency.LoadConverter(ctx, h.currencyStore, config.Envs.BaseCurrency)
+	if err != nil {
+		return nil, err
+	}
+
+	exchangeRate, err := converter.Rate(currencyCode)
+	if err != nil {
+		return nil, err
+	}
+
+	prices, err := h.getEffectivePrices(ctx, products, converter, currencyCode)
+	if err != nil {
+		return nil, err
+	}
+
+	if percentOff > 0 {
+		for id, price := range prices {
+			prices[id] = price.Sub(price.MulRatio(int64(percentOff), 100, money.HalfUp))
+		}
+	}
+
+	if _, err := calculateTotalPrice(items, prices); err != nil {
+		return nil, err
+	}
+
+	lines := make([]promotion.Line, len(items))
+	for i, item := range items {
+		lines[i] = promotion.Line{
+			ProductID: item.ProductID,
+			Category:  productMap[item.ProductID].Category,
+			Quantity:  item.Quantity,
+			UnitPrice: prices[item.ProductID],
+		}
+	}
+
+	now := time.Now()
+	quote, err := promotion.Quote(ctx, h.promotionStore, converter, userID, codes, lines, currencyCode, now)
+	if err != nil {
+		return nil, &checkoutError{err}
+	}
+
+	priced := &pricedCheckout{
+		ExchangeRate: exchangeRate,
+		Prices:       prices,
+		Promotions:   quote,
+		TaxLines:     []types.TaxLine{},
+		AddedTax:     money.Zero(currencyCode),
+		IncludedTax:  money.Zero(currencyCode),
+		ShippingCost: money.Zero(currencyCode),
+		converter:    converter,
+	}
+
+	if destination == nil {
+		if shippingMethodID != 0 {
+			return nil, &checkoutError{fmt.Errorf("a destination is needed to choose a shipping method")}
+		}
+
+		return priced, nil
+	}
+
+	// tax is due on what the customer pays for each line, after discounts
+	discounts := make([]money.Money, len(lines))
+	for _, applied := range quote.Applied {
+		for _, line := range applied.Lines {
+			discounts[line.Line] = discounts[line.Line].Add(line.Amount)
+		}
+	}
+
+	taxable := make([]types.TaxableLine, len(lines))
+	for i, line := range lines {
+		taxable[i] = types.TaxableLine{
+			ProductID: line.ProductID,
+			TaxClass:  productMap[line.ProductID].TaxClass,
+			Amount:    line.Total().Sub(discounts[i]),
+		}
+	}
+
+	priced.TaxLines, err = h.taxCalculator.Calculate(ctx, taxable, *destination, now)
+	if err != nil {
+		return nil, err
+	}
+
+	priced.AddedTax, priced.IncludedTax = tax.Totals(priced.TaxLines, currencyCode)
+
+	if shippingMethodID == 0 {
+		return priced, nil
+	}
+
+	quotes, err := h.shippingQuotes(ctx, productMap, items, priced, *destination, currencyCode)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, quote := range quotes {
+		if quote.MethodID == shippingMethodID {
+			priced.Shipping = &quote
+			priced.ShippingCost = quote.Cost
+			return priced, nil
+		}
+	}
+
+	return nil, &checkoutError{fmt.Errorf("shipping method %d is not available for this destination", shippingMethodID)}
+}
+
+// shippingQuotes lists the methods that can ship the priced items to the
+// destination. Price tiers are measured on the discounted subtotal.
+func (h *Handler) shippingQuotes(ctx context.Context, productMap map[int]types.Product, items []types.CartItem, priced *pricedCheckout, destination types.Destination, currencyCode string) ([]types.ShippingQuote, error) {
+	parcel := shipping.ParcelFor(productMap, items, priced.Promotions.Total())
+
+	return shipping.Quote(ctx, h.shippingStore, parcel, destination, priced.Promotions.FreeShipping, priced.converter, currencyCode)
+}
+
+// withTx returns a copy of the handler with the stores an order is written
+// to bound to tx.
+func (h *Handler) withTx(tx types.DB) *Handler {
+	bound := *h
+	bound.orderStore = h.orderStore.WithTx(tx)
+	bound.productStore = h.productStore.WithTx(tx)
+	bound.userStore = h.userStore.WithTx(tx)
+	bound.promotionStore = h.promotionStore.WithTx(tx)
+	bound.taxStore = h.taxStore.WithTx(tx)
+	bound.giftCardStore = h.giftCardStore.WithTx(tx)
+	bound.paymentStore = h.paymentStore.WithTx(tx)
+	bound.loyaltyStore = h.loyaltyStore.WithTx(tx)
+
+	return &bound
+}
+
+// createOrder places an order for the products and returns its id together
+// with the priced checkout. It is meant to run on a handler bound to a
+// transaction, which holds the products' rows until the order is in.
+func (h *Handler) createOrder(ctx context.Context, productIDs []int, items []types.CartItem, userID int, currencyCode string, codes []string, destination *types.Destination, shippingMethodID int, percentOff int) (int, *pricedCheckout, error) {
+	// lock the products so concurrent checkouts cannot sell the same stock
 	// check if all products in stock
-	// if in stock calculate total price
-	// calculate total price
-	// reduce quantity of product
+	// price the cart: promotions, then tax, then shipping
+	// take the items from stock or put them on backorder
 	// create the order
-	// create the order items
+	// create the order items with their discounts and tax
+
+	products, err := h.productStore.LockProductsByID(ctx, productIDs)
+	if err != nil {
+		return 0, nil, err
+	}
 
 	productMap := make(map[int]types.Product)
 	for _, product := range products {
@@ -35,57 +220,262 @@ func (h *Handler) createOrder(products []types.Product, items []types.CartItem,
 	}
 
 	if err := checkIfCartIsInStock(items, productMap); err != nil {
-		return 0, 0, err
+		return 0, nil, &checkoutError{err}
 	}
 
-	totalPrice := calculateTotalPrice(items, productMap)
+	priced, err := h.priceCheckout(ctx, productMap, items, userID, currencyCode, codes, destination, shippingMethodID, percentOff)
+	if err != nil {
+		return 0, nil, err
+	}
 
-	for _, item := range items {
+	statuses := make([]types.FulfilmentStatus, len(items))
+	for i, item := range items {
 		product := productMap[item.ProductID]
-		product.Quantity -= item.Quantity
+		if statuses[i], err = fulfilmentStatus(product, item.Quantity); err != nil {
+			return 0, nil, &checkoutError{err}
+		}
+
+		if statuses[i] == types.FulfilmentAllocated {
+			product.Quantity -= item.Quantity
+		} else {
+			product.Backordered += item.Quantity
+		}
 		productMap[item.ProductID] = product
 	}
 
-	if err := h.productStore.UpdateProductBatch(productMap); err != nil {
-		return 0, 0, err
+	if err := h.productStore.UpdateProductBatch(ctx, productMap); err != nil {
+		return 0, nil, err
 	}
 
 	// query for user address
-	userAddresses, err := h.userStore.GetUserAddressesByUserId(userID)
+	userAddresses, err := h.userStore.GetUserAddressesByUserId(ctx, userID)
 	if err != nil {
-		return 0, 0, err
+		return 0, nil, err
 	}
 
 	addressToUse, err := getAddressToUse(userAddresses)
 	if err != nil {
-		return 0, 0, err
+		return 0, nil, err
 	}
 
-	orderId, err := h.orderStore.CreateOrder(types.Order{
-		UserId:  userID,
-		Total:   totalPrice,
-		Status:  types.Pending,
-		Address: addressToUse,
-	})
+	order := types.Order{
+		UserId:       userID,
+		Total:        priced.Total(),
+		Discount:     priced.Promotions.Discount,
+		Tax:          priced.Tax(),
+		ShippingCost: priced.ShippingCost,
+		ExchangeRate: priced.ExchangeRate,
+		Status:       types.Pending,
+		Address:      addressToUse,
+	}
+
+	if priced.Shipping != nil {
+		order.ShippingMethodID = priced.Shipping.MethodID
+		order.ShippingMethod = priced.Shipping.Name
+	}
+
+	orderId, err := h.orderStore.CreateOrder(ctx, order)
 
 	if err != nil {
-		return 0, 0, err
+		return 0, nil, err
 	}
 
-	for _, item := range items {
-		if err := h.orderStore.CreateOrderItem(types.OrderItem{
+	orderItemIDs := make([]int, len(items))
+	for i, item := range items {
+		orderItemIDs[i], err = h.orderStore.CreateOrderItem(ctx, types.OrderItem{
+			OrderID:          orderId,
+			ProductID:        item.ProductID,
+			ProductName:      productMap[item.ProductID].Name,
+			ProductImage:     productMap[item.ProductID].Image,
+			Quantity:         item.Quantity,
+			Price:            priced.Prices[item.ProductID],
+			FulfilmentStatus: statuses[i],
+			ExpectedAt:       expectedAt(productMap[item.ProductID], statuses[i]),
+		})
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+
+	redemptions, discounts := promotion.Redemptions(priced.Promotions, orderId, userID, orderItemIDs)
+	if err := h.promotionStore.RecordRedemptions(ctx, redemptions, discounts); err != nil {
+		return 0, nil, err
+	}
+
+	taxes := make([]types.OrderItemTax, len(priced.TaxLines))
+	for i, line := range priced.TaxLines {
+		taxes[i] = types.OrderItemTax{OrderID: orderId, OrderItemID: orderItemIDs[line.Line], TaxLine: line}
+	}
+
+	if err := h.taxStore.RecordOrderItemTaxes(ctx, taxes); err != nil {
+		return 0, nil, err
+	}
+
+	return orderId, priced, nil
+}
+
+// PlaceOrder places an order the way checkout does, for orders placed on a
+// customer's behalf such as their subscriptions. It takes no payment and
+// returns the pending order.
+func (h *Handler) PlaceOrder(ctx context.Context, request types.PlaceOrderRequest) (*types.Order, error) {
+	ids, err := getCartItemsIDs(request.Items)
+	if err != nil {
+		return nil, err
+	}
+
+	var order types.Order
+	err = h.uow.Do(ctx, func(tx types.DB) error {
+		orderId, priced, err := h.withTx(tx).createOrder(ctx, ids, request.Items, request.UserID, request.Currency, nil, normalizeDestination(request.Destination), request.ShippingMethodID, request.PercentOff)
+		if err != nil {
+			return err
+		}
+
+		order = types.Order{ID: orderId, UserId: request.UserID, Total: priced.Total(), Status: types.Pending}
+		if request.Placed != nil {
+			return request.Placed(tx, order)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &order, nil
+}
+
+// redeem pays what it can of the order's total with the gift cards, the
+// loyalty points and then the customer's store credit asked for, and
+// returns how much was paid. Each amount
+// taken is recorded as a captured payment of its tender so it is given back
+// like any other payment. It is meant to run in the transaction the order is
+// placed in, so a card that cannot be spent leaves nothing behind.
+func (h *Handler) redeem(ctx context.Context, orderId int, userID int, total money.Money, payload types.CartCheckoutPayload) (money.Money, error) {
+	redeemed := money.Zero(total.Currency)
+	pay := func(tender types.Tender, account string, amount money.Money) error {
+		reference, err := tender.Debit(ctx, account, amount, orderId)
+		if errors.Is(err, giftcard.ErrInsufficientBalance) || errors.Is(err, loyalty.ErrInsufficientPoints) {
+			// spent elsewhere since the balance was read
+			return &checkoutError{err}
+		}
+		if err != nil {
+			return err
+		}
+
+		if _, err := h.paymentStore.CreatePayment(ctx, types.Payment{
 			OrderID:   orderId,
-			ProductID: item.ProductID,
-			Quantity:  item.Quantity,
-			Price:     productMap[item.ProductID].Price,
+			Provider:  tender.Name(),
+			Reference: reference,
+			Status:    types.PaymentCaptured,
+			Amount:    amount,
+			Refunded:  money.Zero(amount.Currency),
 		}); err != nil {
-			return 0, 0, err
+			return err
+		}
+
+		redeemed = redeemed.Add(amount)
+		return nil
+	}
+
+	now := time.Now()
+	seen := map[int]bool{}
+	for _, code := range payload.GiftCards {
+		card, err := h.giftCardStore.GetGiftCardByCode(ctx, giftcard.HashCode(code))
+		if err != nil {
+			if errors.Is(err, giftcard.ErrNotFound) {
+				return money.Money{}, &checkoutError{fmt.Errorf("gift card %q not found", code)}
+			}
+			return money.Money{}, err
+		}
+
+		if seen[card.ID] {
+			return money.Money{}, &checkoutError{fmt.Errorf("gift card ending %s is listed more than once", card.Last4)}
+		}
+		seen[card.ID] = true
+
+		if err := giftcard.Spendable(card, total.Currency, now); err != nil {
+			return money.Money{}, &checkoutError{err}
+		}
+
+		// cards past what the order needs are left untouched
+		if amount := money.Min(card.Balance, total.Sub(redeemed)); amount.IsPositive() {
+			if err := pay(giftcard.NewGiftCards(h.giftCardStore), strconv.Itoa(card.ID), amount); err != nil {
+				return money.Money{}, err
+			}
 		}
 	}
 
-	return orderId, totalPrice, nil
+	if payload.LoyaltyPoints > 0 && total.Sub(redeemed).IsPositive() {
+		if total.Currency != config.Envs.BaseCurrency {
+			return money.Money{}, &checkoutError{fmt.Errorf("loyalty points can only be spent on orders in %s", config.Envs.BaseCurrency)}
+		}
+
+		account, err := h.loyaltyStore.GetLoyaltyAccount(ctx, userID)
+		if err != nil {
+			return money.Money{}, err
+		}
+
+		if account.Balance < payload.LoyaltyPoints {
+			return money.Money{}, &checkoutError{fmt.Errorf("only %d loyalty points can be spent", account.Balance)}
+		}
+
+		// points past what the order needs are not spent
+		points := loyalty.NewPointsFromConfig(h.loyaltyStore)
+		if spent := min(payload.LoyaltyPoints, points.PointsFor(total.Sub(redeemed))); spent > 0 {
+			if err := pay(points, strconv.Itoa(userID), points.Worth(spent)); err != nil {
+				return money.Money{}, err
+			}
+		}
+	}
+
+	if !payload.UseStoreCredit || !total.Sub(redeemed).IsPositive() {
+		return redeemed, nil
+	}
+
+	wallet, err := h.giftCardStore.GetWallet(ctx, userID, total.Currency)
+	if err != nil {
+		return money.Money{}, err
+	}
+
+	if !wallet.Balance.IsPositive() {
+		return redeemed, nil
+	}
+
+	if wallet.Balance.Currency != total.Currency {
+		return money.Money{}, &checkoutError{fmt.Errorf("store credit is held in %s and cannot pay for an order in %s", wallet.Balance.Currency, total.Currency)}
+	}
+
+	if amount := money.Min(wallet.Balance, total.Sub(redeemed)); amount.IsPositive() {
+		if err := pay(giftcard.NewStoreCredit(h.giftCardStore), strconv.Itoa(userID), amount); err != nil {
+			return money.Money{}, err
+		}
+	}
+
+	return redeemed, nil
+}
+
+// expectedAt is when a backordered pre-order line is expected to be
+// available. Backorders of products that are out already have no date.
+func expectedAt(product types.Product, status types.FulfilmentStatus) *time.Time {
+	if status != types.FulfilmentBackordered || product.StockPolicy != types.StockPreorder {
+		return nil
+	}
+
+	return product.AvailableAt
 }
 
+// checkoutError is a checkout failure caused by what the customer asked for
+// rather than by the server, such as a promotion code that does not apply.
+type checkoutError struct {
+	err error
+}
+
+func (e *checkoutError) Error() string {
+	return e.err.Error()
+}
+
+// checkIfCartIsInStock checks every line can be ordered, from stock or on
+// backorder when the product allows it.
 func checkIfCartIsInStock(items []types.CartItem, productsMap map[int]types.Product) error {
 	if len(items) == 0 {
 		return fmt.Errorf("cart is empty")
@@ -97,21 +487,159 @@ func checkIfCartIsInStock(items []types.CartItem, productsMap map[int]types.Prod
 			return fmt.Errorf("product %d is not available", item.ProductID)
 		}
 
-		if product.Quantity < item.Quantity {
-			return fmt.Errorf("product %d is not available for the requested quantity", item.ProductID)
+		if _, err := fulfilmentStatus(product, item.Quantity); err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
-func calculateTotalPrice(items []types.CartItem, productMap map[int]types.Product) float64 {
-	totalPrice := 0.0
+// fulfilmentStatus is how a line of quantity units of the product is
+// fulfilled. Lines are taken from stock whole or backordered whole, and
+// stock goes to backorders first as it comes in, so a product with units on
+// backorder backorders every new line too.
+func fulfilmentStatus(product types.Product, quantity int) (types.FulfilmentStatus, error) {
+	if product.Backordered == 0 && product.Quantity >= quantity {
+		return types.FulfilmentAllocated, nil
+	}
+
+	if product.StockPolicy != types.StockBackorder && product.StockPolicy != types.StockPreorder {
+		return "", fmt.Errorf("product %d is not available for the requested quantity", product.ID)
+	}
+
+	if product.BackorderLimit > 0 && product.Backordered+quantity > product.BackorderLimit {
+		return "", fmt.Errorf("only %d of product %d can be backordered", max(product.BackorderLimit-product.Backordered, 0), product.ID)
+	}
+
+	return types.FulfilmentBackordered, nil
+}
+
+// getEffectivePrices returns the unit price of every product at checkout
+// time in the order currency
+func (h *Handler) getEffectivePrices(ctx context.Context, products []types.Product, converter *currency.Converter, currencyCode string) (map[int]money.Money, error) {
+	resolved, err := currency.ResolvePrices(ctx, h.priceStore, h.currencyStore, converter, products, currencyCode, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	prices := make(map[int]money.Money, len(resolved))
+	for id, price := range resolved {
+		prices[id] = price.Price
+	}
+
+	return prices, nil
+}
+
+// calculateTotalPrice sums the lines exactly in minor units. Every product in
+// a cart must be priced in the same currency.
+func calculateTotalPrice(items []types.CartItem, prices map[int]money.Money) (money.Money, error) {
+	totalPrice := money.Money{}
 	for _, item := range items {
-		totalPrice += productMap[item.ProductID].Price * float64(item.Quantity)
+		price := prices[item.ProductID]
+		if !totalPrice.SameCurrency(price) {
+			return money.Money{}, fmt.Errorf("cart mixes %s and %s prices", totalPrice.Currency, price.Currency)
+		}
+
+		totalPrice = totalPrice.Add(price.Mul(item.Quantity))
+	}
+
+	return totalPrice, nil
+}
+
+// revalidateCart prices every stored line at its current price in the given
+// currency and checks it against the product's stock and stock policy. Lines
+// are never changed here; problems are reported per line and keep the cart
+// from being ready for checkout, except for price changes which are
+// informational.
+func (h *Handler) revalidateCart(ctx context.Context, cart *types.Cart, lines []types.CartLine, currencyCode string) (*types.CartView, error) {
+	view := &types.CartView{
+		ID:               cart.ID,
+		Items:            []types.CartLineView{},
+		Subtotal:         money.Zero(currencyCode),
+		ReadyForCheckout: len(lines) > 0,
+	}
+
+	if len(lines) == 0 {
+		return view, nil
+	}
+
+	ids := make([]int, len(lines))
+	for i, line := range lines {
+		ids[i] = line.ProductID
+	}
+
+	products, err := h.productStore.GetProductsByID(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	productMap := make(map[int]types.Product, len(products))
+	for _, product := range products {
+		productMap[product.ID] = product
+	}
+
+	converter, err := currency.LoadConverter(ctx, h.currencyStore, config.Envs.BaseCurrency)
+	if err != nil {
+		return nil, err
+	}
+
+	prices, err := h.getEffectivePrices(ctx, products, converter, currencyCode)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, line := range lines {
+		lineView := types.CartLineView{CartLine: line, Issues: []string{}}
+
+		product, ok := productMap[line.ProductID]
+		if !ok {
+			lineView.Issues = append(lineView.Issues, types.CartIssueUnavailable)
+			view.ReadyForCheckout = false
+			view.Items = append(view.Items, lineView)
+			continue
+		}
+
+		price := prices[product.ID]
+		lineView.Name = product.Name
+		lineView.Image = product.Image
+		lineView.Available = product.Quantity
+		lineView.UnitPrice = price
+		lineView.LineTotal = price.Mul(line.Quantity)
+
+		// lines the product lets customers backorder are shown as such
+		// rather than held up
+		status, err := fulfilmentStatus(product, line.Quantity)
+		switch {
+		case err == nil:
+			lineView.FulfilmentStatus, lineView.ExpectedAt = status, expectedAt(product, status)
+		case product.Quantity <= 0:
+			lineView.Issues = append(lineView.Issues, types.CartIssueUnavailable)
+			view.ReadyForCheckout = false
+		default:
+			lineView.Issues = append(lineView.Issues, types.CartIssueInsufficientStock)
+			view.ReadyForCheckout = false
+		}
+
+		if line.AddedPrice.Currency == price.Currency && line.AddedPrice != price {
+			lineView.Issues = append(lineView.Issues, types.CartIssuePriceChanged)
+		}
+
+		view.Subtotal = view.Subtotal.Add(lineView.LineTotal)
+		view.Items = append(view.Items, lineView)
+	}
+
+	return view, nil
+}
+
+// cartItemsFromLines turns stored cart lines into the items checkout expects.
+func cartItemsFromLines(lines []types.CartLine) []types.CartItem {
+	items := make([]types.CartItem, len(lines))
+	for i, line := range lines {
+		items[i] = types.CartItem{ProductID: line.ProductID, Quantity: line.Quantity}
 	}
 
-	return totalPrice
+	return items
 }
 
 func getAddressToUse(addresses []types.UserAddresses) (string, error) {
@@ -0,0 +1,188 @@
+package cart
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/xelathan/golang_backend/types"
+)
+
+var ErrNotFound = errors.New("cart not found")
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// GetOrCreateCartByUserId returns the user's cart, creating an empty one on
+// first use. Every user has at most one cart.
+func (s *Store) GetOrCreateCartByUserId(ctx context.Context, userId int) (*types.Cart, error) {
+	_, err := s.db.ExecContext(ctx, "INSERT IGNORE INTO carts (userId) VALUES (?)", userId)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.getCart(ctx, "userId = ?", userId)
+}
+
+func (s *Store) CreateGuestCart(ctx context.Context, guestToken string) (*types.Cart, error) {
+	_, err := s.db.ExecContext(ctx, "INSERT INTO carts (guestToken) VALUES (?)", guestToken)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.getCart(ctx, "guestToken = ?", guestToken)
+}
+
+func (s *Store) GetCartByGuestToken(ctx context.Context, guestToken string) (*types.Cart, error) {
+	return s.getCart(ctx, "guestToken = ?", guestToken)
+}
+
+func (s *Store) getCart(ctx context.Context, where string, arg any) (*types.Cart, error) {
+	cart := &types.Cart{}
+	var userId sql.NullInt64
+	var guestToken sql.NullString
+	err := s.db.QueryRowContext(ctx, "SELECT id, userId, guestToken, createdAt, updatedAt FROM carts WHERE "+where, arg).Scan(
+		&cart.ID,
+		&userId,
+		&guestToken,
+		&cart.CreatedAt,
+		&cart.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	cart.UserID = int(userId.Int64)
+	cart.GuestToken = guestToken.String
+
+	return cart, nil
+}
+
+func (s *Store) GetCartLines(ctx context.Context, cartId int) ([]types.CartLine, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT id, cartId, productId, quantity, addedPrice, addedCurrency, createdAt FROM cart_items WHERE cartId = ? ORDER BY id", cartId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lines := []types.CartLine{}
+	for rows.Next() {
+		line := types.CartLine{}
+		if err := rows.Scan(
+			&line.ID,
+			&line.CartID,
+			&line.ProductID,
+			&line.Quantity,
+			&line.AddedPrice.Amount,
+			&line.AddedPrice.Currency,
+			&line.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+
+		lines = append(lines, line)
+	}
+
+	return lines, rows.Err()
+}
+
+// AddCartLine adds the line's quantity to the cart, merging it into an
+// existing line for the same product. The added price is refreshed either way.
+func (s *Store) AddCartLine(ctx context.Context, line types.CartLine) error {
+	_, err := s.db.ExecContext(ctx,
+		"INSERT INTO cart_items (cartId, productId, quantity, addedPrice, addedCurrency) VALUES (?,?,?,?,?) "+
+			"ON DUPLICATE KEY UPDATE quantity = quantity + VALUES(quantity), addedPrice = VALUES(addedPrice), addedCurrency = VALUES(addedCurrency)",
+		line.CartID, line.ProductID, line.Quantity, line.AddedPrice.Amount, line.AddedPrice.Currency,
+	)
+	if err != nil {
+		return err
+	}
+
+	return s.touchCart(ctx, line.CartID)
+}
+
+// MergeCart writes the merged lines into the user's cart, replacing the
+// quantity of lines it already has, and deletes the guest cart.
+func (s *Store) MergeCart(ctx context.Context, guestCartId int, userCartId int, lines []types.CartLine) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, line := range lines {
+		_, err := tx.ExecContext(ctx,
+			"INSERT INTO cart_items (cartId, productId, quantity, addedPrice, addedCurrency) VALUES (?,?,?,?,?) "+
+				"ON DUPLICATE KEY UPDATE quantity = VALUES(quantity), addedPrice = VALUES(addedPrice), addedCurrency = VALUES(addedCurrency)",
+			userCartId, line.ProductID, line.Quantity, line.AddedPrice.Amount, line.AddedPrice.Currency,
+		)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM carts WHERE id = ?", guestCartId); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE carts SET updatedAt = CURRENT_TIMESTAMP WHERE id = ?", userCartId); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *Store) UpdateCartLineQuantity(ctx context.Context, cartId int, lineId int, quantity int) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE cart_items SET quantity = ? WHERE id = ? AND cartId = ?", quantity, lineId, cartId)
+	if err != nil {
+		return err
+	}
+
+	return s.touchCart(ctx, cartId)
+}
+
+func (s *Store) DeleteCartLine(ctx context.Context, cartId int, lineId int) error {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM cart_items WHERE id = ? AND cartId = ?", lineId, cartId)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return fmt.Errorf("cart item not found")
+	}
+
+	return s.touchCart(ctx, cartId)
+}
+
+func (s *Store) ClearCart(ctx context.Context, cartId int) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM cart_items WHERE cartId = ?", cartId)
+	if err != nil {
+		return err
+	}
+
+	return s.touchCart(ctx, cartId)
+}
+
+// touchCart bumps updatedAt, which MySQL only does on its own when a column
+// of the cart row itself changes.
+func (s *Store) touchCart(ctx context.Context, cartId int) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE carts SET updatedAt = CURRENT_TIMESTAMP WHERE id = ?", cartId)
+	return err
+}
@@ -0,0 +1,257 @@
+package catalog
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/xelathan/golang_backend/money"
+	"github.com/xelathan/golang_backend/types"
+)
+
+const (
+	FormatCSV   = "csv"
+	FormatJSONL = "jsonl"
+)
+
+var csvColumns = []string{"sku", "name", "description", "image", "price", "quantity", "reorderThreshold", "currency", "category", "taxClass", "weightGrams", "lengthMm", "widthMm", "heightMm"}
+
+// rowReader yields one catalog row at a time and io.EOF once the input is
+// exhausted. A row that cannot be decoded is returned as a *decodeError so the
+// import can report it and move on; any other error means the rest of the
+// input is unreadable.
+type rowReader interface {
+	Next() (types.CreateProductPayload, error)
+}
+
+type decodeError struct {
+	msg string
+}
+
+func (e *decodeError) Error() string {
+	return e.msg
+}
+
+func newRowReader(r io.Reader, format string) (rowReader, error) {
+	switch format {
+	case FormatCSV:
+		return newCSVRowReader(r)
+	case FormatJSONL:
+		return &jsonlRowReader{scanner: newLineScanner(r)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported format %q", format)
+	}
+}
+
+type csvRowReader struct {
+	reader  *csv.Reader
+	columns map[string]int
+}
+
+func newCSVRowReader(r io.Reader) (*csvRowReader, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading csv header: %w", err)
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+
+	for _, required := range csvColumns[:5] {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("csv header is missing column %q", required)
+		}
+	}
+
+	return &csvRowReader{reader: reader, columns: columns}, nil
+}
+
+func (c *csvRowReader) Next() (types.CreateProductPayload, error) {
+	payload := types.CreateProductPayload{}
+
+	record, err := c.reader.Read()
+	if err == io.EOF {
+		return payload, io.EOF
+	}
+	if err != nil {
+		if _, ok := err.(*csv.ParseError); ok {
+			return payload, &decodeError{msg: err.Error()}
+		}
+		return payload, err
+	}
+
+	field := func(name string) string {
+		i, ok := c.columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	payload.SKU = field("sku")
+	payload.Name = field("name")
+	payload.Description = field("description")
+	payload.Image = field("image")
+	payload.Category = field("category")
+	payload.TaxClass = field("taxClass")
+
+	currency := strings.ToUpper(field("currency"))
+	if currency == "" {
+		currency = money.DefaultCurrency
+	}
+
+	problems := []string{}
+	if payload.Price, err = money.Parse(field("price"), currency); err != nil {
+		problems = append(problems, fmt.Sprintf("price: %v", err))
+	}
+	if payload.Quantity, err = parseOptionalInt(field("quantity")); err != nil {
+		problems = append(problems, "quantity is not an integer")
+	}
+	if payload.ReorderThreshold, err = parseOptionalInt(field("reorderThreshold")); err != nil {
+		problems = append(problems, "reorderThreshold is not an integer")
+	}
+	if payload.WeightGrams, err = parseOptionalInt(field("weightGrams")); err != nil {
+		problems = append(problems, "weightGrams is not an integer")
+	}
+	if payload.LengthMm, err = parseOptionalInt(field("lengthMm")); err != nil {
+		problems = append(problems, "lengthMm is not an integer")
+	}
+	if payload.WidthMm, err = parseOptionalInt(field("widthMm")); err != nil {
+		problems = append(problems, "widthMm is not an integer")
+	}
+	if payload.HeightMm, err = parseOptionalInt(field("heightMm")); err != nil {
+		problems = append(problems, "heightMm is not an integer")
+	}
+
+	if len(problems) > 0 {
+		return payload, &decodeError{msg: strings.Join(problems, "; ")}
+	}
+
+	return payload, nil
+}
+
+func parseOptionalInt(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	return strconv.Atoi(value)
+}
+
+type jsonlRowReader struct {
+	scanner *bufio.Scanner
+}
+
+func newLineScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	return scanner
+}
+
+func (j *jsonlRowReader) Next() (types.CreateProductPayload, error) {
+	payload := types.CreateProductPayload{}
+
+	for j.scanner.Scan() {
+		line := strings.TrimSpace(j.scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if err := json.Unmarshal([]byte(line), &payload); err != nil {
+			return payload, &decodeError{msg: err.Error()}
+		}
+
+		return payload, nil
+	}
+
+	if err := j.scanner.Err(); err != nil {
+		return payload, err
+	}
+
+	return payload, io.EOF
+}
+
+// rowWriter encodes products for export. Flush must be called once at the end.
+type rowWriter interface {
+	Write(types.Product) error
+	Flush() error
+}
+
+func newRowWriter(w io.Writer, format string) (rowWriter, error) {
+	switch format {
+	case FormatCSV:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(csvColumns); err != nil {
+			return nil, err
+		}
+		return &csvRowWriter{writer: writer}, nil
+	case FormatJSONL:
+		return &jsonlRowWriter{encoder: json.NewEncoder(w)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported format %q", format)
+	}
+}
+
+type csvRowWriter struct {
+	writer *csv.Writer
+}
+
+func (c *csvRowWriter) Write(p types.Product) error {
+	return c.writer.Write([]string{
+		p.SKU,
+		p.Name,
+		p.Description,
+		p.Image,
+		p.Price.Decimal(),
+		strconv.Itoa(p.Quantity),
+		strconv.Itoa(p.ReorderThreshold),
+		p.Price.Currency,
+		p.Category,
+		p.TaxClass,
+		strconv.Itoa(p.WeightGrams),
+		strconv.Itoa(p.LengthMm),
+		strconv.Itoa(p.WidthMm),
+		strconv.Itoa(p.HeightMm),
+	})
+}
+
+func (c *csvRowWriter) Flush() error {
+	c.writer.Flush()
+	return c.writer.Error()
+}
+
+type jsonlRowWriter struct {
+	encoder *json.Encoder
+}
+
+func (j *jsonlRowWriter) Write(p types.Product) error {
+	return j.encoder.Encode(types.CreateProductPayload{
+		SKU:              p.SKU,
+		Name:             p.Name,
+		Description:      p.Description,
+		Image:            p.Image,
+		Price:            p.Price,
+		Quantity:         p.Quantity,
+		ReorderThreshold: p.ReorderThreshold,
+		Category:         p.Category,
+		TaxClass:         p.TaxClass,
+		WeightGrams:      p.WeightGrams,
+		LengthMm:         p.LengthMm,
+		WidthMm:          p.WidthMm,
+		HeightMm:         p.HeightMm,
+	})
+}
+
+func (j *jsonlRowWriter) Flush() error {
+	return nil
+}
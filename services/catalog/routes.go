@@ -0,0 +1,87 @@
+package catalog
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/xelathan/golang_backend/services/auth"
+	"github.com/xelathan/golang_backend/types"
+	"github.com/xelathan/golang_backend/utils"
+)
+
+type Handler struct {
+	productStore types.ProductStore
+	userStore    types.UserStore
+}
+
+func NewHandler(productStore types.ProductStore, userStore types.UserStore) *Handler {
+	return &Handler{productStore: productStore, userStore: userStore}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/admin/catalog/import", auth.WithAdminAuth(h.handleImport, h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/admin/catalog/export", auth.WithAdminAuth(h.handleExport, h.userStore)).Methods(http.MethodGet)
+}
+
+// handleImport takes the file as the raw request body, e.g.
+// POST /admin/catalog/import?format=csv&dry_run=true&batch_size=200
+func (h *Handler) handleImport(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	opts := ImportOptions{Format: query.Get("format"), DryRun: query.Get("dry_run") == "true"}
+	if opts.Format == "" {
+		opts.Format = FormatCSV
+	}
+
+	if batchSize := query.Get("batch_size"); batchSize != "" {
+		size, err := strconv.Atoi(batchSize)
+		if err != nil || size <= 0 {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid batch_size"))
+			return
+		}
+		opts.BatchSize = size
+	}
+
+	if r.Body == nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("missing request body"))
+		return
+	}
+
+	report, err := Import(r.Context(), h.productStore, r.Body, opts)
+	if err != nil {
+		// earlier batches may already be committed, so return the report too
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error(), "report": report})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, report)
+}
+
+func (h *Handler) handleExport(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = FormatCSV
+	}
+
+	contentType := "text/csv"
+	switch format {
+	case FormatCSV:
+	case FormatJSONL:
+		contentType = "application/x-ndjson"
+	default:
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("unsupported format %q", format))
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=catalog.%s", format))
+	w.WriteHeader(http.StatusOK)
+
+	// headers are already sent, an error here can only be logged
+	if err := Export(r.Context(), h.productStore, w, format); err != nil {
+		log.Printf("catalog: export failed: %v", err)
+	}
+}
@@ -0,0 +1,168 @@
+package catalog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/xelathan/golang_backend/config"
+	"github.com/xelathan/golang_backend/types"
+	"github.com/xelathan/golang_backend/utils"
+)
+
+const DefaultBatchSize = 500
+
+type ImportOptions struct {
+	Format    string
+	DryRun    bool
+	BatchSize int
+}
+
+// Import reads products from r and upserts them by SKU. Every row is
+// validated with the same rules as CreateProductPayload; invalid rows are
+// listed in the report and skipped, valid rows are committed in batches of
+// opts.BatchSize. In dry-run mode nothing is written but the report still
+// tells which rows would be created or updated.
+func Import(ctx context.Context, store types.ProductStore, r io.Reader, opts ImportOptions) (*types.ImportReport, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+
+	reader, err := newRowReader(r, opts.Format)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &types.ImportReport{DryRun: opts.DryRun, Errors: []types.ImportRowError{}}
+	seen := map[string]int{}
+	batch := []types.Product{}
+
+	for row := 1; ; row++ {
+		payload, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+
+		var decodeErr *decodeError
+		if err != nil && !errors.As(err, &decodeErr) {
+			return report, fmt.Errorf("row %d: %w", row, err)
+		}
+
+		report.Rows++
+
+		problems := []string{}
+		if decodeErr != nil {
+			problems = append(problems, decodeErr.Error())
+		} else {
+			problems = append(problems, validateRow(payload)...)
+		}
+
+		if first, ok := seen[payload.SKU]; ok && payload.SKU != "" {
+			problems = append(problems, fmt.Sprintf("duplicate sku, first seen on row %d", first))
+		} else if payload.SKU != "" {
+			seen[payload.SKU] = row
+		}
+
+		if len(problems) > 0 {
+			report.Failed++
+			report.Errors = append(report.Errors, types.ImportRowError{Row: row, SKU: payload.SKU, Errors: problems})
+			continue
+		}
+
+		batch = append(batch, types.Product{
+			SKU:              payload.SKU,
+			Name:             payload.Name,
+			Description:      payload.Description,
+			Image:            payload.Image,
+			Price:            payload.Price,
+			Quantity:         payload.Quantity,
+			ReorderThreshold: payload.ReorderThreshold,
+			Category:         payload.Category,
+			TaxClass:         payload.TaxClass,
+			WeightGrams:      payload.WeightGrams,
+			LengthMm:         payload.LengthMm,
+			WidthMm:          payload.WidthMm,
+			HeightMm:         payload.HeightMm,
+		})
+
+		if len(batch) == opts.BatchSize {
+			if err := commitBatch(ctx, store, batch, report); err != nil {
+				return report, err
+			}
+			batch = batch[:0]
+		}
+	}
+
+	if err := commitBatch(ctx, store, batch, report); err != nil {
+		return report, err
+	}
+
+	return report, nil
+}
+
+func validateRow(payload types.CreateProductPayload) []string {
+	problems := []string{}
+	if payload.SKU == "" {
+		problems = append(problems, "sku is required for import")
+	}
+
+	if payload.Price.Currency != config.Envs.BaseCurrency {
+		problems = append(problems, fmt.Sprintf("price must be in the base currency %s", config.Envs.BaseCurrency))
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		var validationErrors validator.ValidationErrors
+		if !errors.As(err, &validationErrors) {
+			return append(problems, err.Error())
+		}
+
+		for _, fe := range validationErrors {
+			problems = append(problems, fmt.Sprintf("%s failed on the '%s' rule", fe.Field(), fe.Tag()))
+		}
+	}
+
+	return problems
+}
+
+func commitBatch(ctx context.Context, store types.ProductStore, batch []types.Product, report *types.ImportReport) error {
+	if len(batch) == 0 {
+		return nil
+	}
+
+	skus := make([]string, len(batch))
+	for i, p := range batch {
+		skus[i] = p.SKU
+	}
+
+	existing, err := store.GetProductsBySKU(ctx, skus)
+	if err != nil {
+		return err
+	}
+
+	if !report.DryRun {
+		if err := store.UpsertProductsBySKU(ctx, batch); err != nil {
+			return err
+		}
+	}
+
+	report.Updated += len(existing)
+	report.Created += len(batch) - len(existing)
+
+	return nil
+}
+
+// Export streams the whole catalog to w in the given format.
+func Export(ctx context.Context, store types.ProductStore, w io.Writer, format string) error {
+	writer, err := newRowWriter(w, format)
+	if err != nil {
+		return err
+	}
+
+	if err := store.StreamProducts(ctx, writer.Write); err != nil {
+		return err
+	}
+
+	return writer.Flush()
+}
@@ -0,0 +1,192 @@
+package catalog
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/xelathan/golang_backend/money"
+	"github.com/xelathan/golang_backend/types"
+)
+
+func TestRowReader(t *testing.T) {
+	cases := []struct {
+		name    string
+		format  string
+		input   string
+		price   money.Money
+		invalid bool
+	}{
+		{"should decode a csv row", FormatCSV, "sku,name,description,image,price\nA-1,Mug,Blue mug,mug.png,12.50\n", money.New(1250, "USD"), false},
+		{"should decode a csv row in its currency", FormatCSV, "sku,name,description,image,price,currency\nA-1,Mug,Blue mug,mug.png,12,eur\n", money.New(1200, "EUR"), false},
+		{"should refuse a csv price with more than two decimals", FormatCSV, "sku,name,description,image,price\nA-1,Mug,Blue mug,mug.png,12.505\n", money.Money{}, true},
+		{"should refuse a csv quantity that is not an integer", FormatCSV, "sku,name,description,image,price,quantity\nA-1,Mug,Blue mug,mug.png,12,many\n", money.New(1200, "USD"), true},
+		{"should decode a jsonl row", FormatJSONL, "\n{\"sku\":\"A-1\",\"name\":\"Mug\",\"price\":{\"amount\":\"12.50\",\"currency\":\"USD\"}}\n", money.New(1250, "USD"), false},
+		{"should refuse a jsonl price with more than two decimals", FormatJSONL, "{\"sku\":\"A-1\",\"price\":\"12.505\"}\n", money.Money{}, true},
+		{"should refuse a line that is not json", FormatJSONL, "{\"sku\":\n", money.Money{}, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			reader, err := newRowReader(strings.NewReader(c.input), c.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			payload, err := reader.Next()
+
+			var decodeErr *decodeError
+			if c.invalid {
+				if !errors.As(err, &decodeErr) {
+					t.Errorf("expected a decode error, got %v", err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if payload.SKU != "A-1" || payload.Price != c.price {
+				t.Errorf("expected A-1 at %s, got %s at %s", c.price, payload.SKU, payload.Price)
+			}
+
+			if _, err := reader.Next(); err != io.EOF {
+				t.Errorf("expected the input to end, got %v", err)
+			}
+		})
+	}
+
+	t.Run("should refuse a csv header missing a required column", func(t *testing.T) {
+		if _, err := newRowReader(strings.NewReader("sku,name,description,image\n"), FormatCSV); err == nil {
+			t.Error("expected an error for the missing price column")
+		}
+	})
+
+	t.Run("should refuse an unknown format", func(t *testing.T) {
+		if _, err := newRowReader(strings.NewReader(""), "xml"); err == nil {
+			t.Error("expected an error for the xml format")
+		}
+	})
+}
+
+func TestImport(t *testing.T) {
+	header := "sku,name,description,image,price,currency\n"
+	row := func(sku string, price string, currency string) string {
+		return sku + ",Mug,Blue mug,mug.png," + price + "," + currency + "\n"
+	}
+
+	cases := []struct {
+		name      string
+		input     string
+		existing  []string
+		batchSize int
+		dryRun    bool
+		report    types.ImportReport
+		batches   []int
+		problem   string
+	}{
+		{
+			name:      "should count created and updated products across batches",
+			input:     header + row("A-1", "10", "USD") + row("A-2", "10", "USD") + row("A-3", "10", "USD") + row("A-4", "10", "USD") + row("A-5", "10", "USD"),
+			existing:  []string{"A-2", "A-5"},
+			batchSize: 2,
+			report:    types.ImportReport{Rows: 5, Created: 3, Updated: 2},
+			batches:   []int{2, 2, 1},
+		},
+		{
+			name:      "should report what would change without writing in a dry run",
+			input:     header + row("A-1", "10", "USD") + row("A-2", "10", "USD") + row("A-3", "10", "USD"),
+			existing:  []string{"A-1"},
+			batchSize: 2,
+			dryRun:    true,
+			report:    types.ImportReport{Rows: 3, Created: 2, Updated: 1, DryRun: true},
+		},
+		{
+			name:    "should skip a sku seen on an earlier row",
+			input:   header + row("A-1", "10", "USD") + row("A-1", "12", "USD"),
+			report:  types.ImportReport{Rows: 2, Created: 1, Failed: 1},
+			batches: []int{1},
+			problem: "duplicate sku, first seen on row 1",
+		},
+		{
+			name:    "should skip a price outside the base currency",
+			input:   header + row("A-1", "10", "EUR") + row("A-2", "10", "USD"),
+			report:  types.ImportReport{Rows: 2, Created: 1, Failed: 1},
+			batches: []int{1},
+			problem: "price must be in the base currency USD",
+		},
+		{
+			name:    "should skip a price with more than two decimals",
+			input:   header + row("A-1", "10.999", "USD"),
+			report:  types.ImportReport{Rows: 1, Failed: 1},
+			problem: "more than 2 decimal places",
+		},
+		{
+			name:    "should skip a row without a sku",
+			input:   header + row("", "10", "USD"),
+			report:  types.ImportReport{Rows: 1, Failed: 1},
+			problem: "sku is required for import",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			store := &mockProductStore{existing: map[string]bool{}}
+			for _, sku := range c.existing {
+				store.existing[sku] = true
+			}
+
+			report, err := Import(context.Background(), store, strings.NewReader(c.input), ImportOptions{Format: FormatCSV, DryRun: c.dryRun, BatchSize: c.batchSize})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if report.Rows != c.report.Rows || report.Created != c.report.Created || report.Updated != c.report.Updated || report.Failed != c.report.Failed || report.DryRun != c.report.DryRun {
+				t.Errorf("expected %+v, got %+v", c.report, *report)
+			}
+
+			if len(store.batches) != len(c.batches) {
+				t.Fatalf("expected %d batches written, got %d", len(c.batches), len(store.batches))
+			}
+			for i, size := range c.batches {
+				if store.batches[i] != size {
+					t.Errorf("expected batch %d to hold %d products, got %d", i, size, store.batches[i])
+				}
+			}
+
+			if c.problem != "" && (len(report.Errors) != 1 || !strings.Contains(strings.Join(report.Errors[0].Errors, "; "), c.problem)) {
+				t.Errorf("expected a row error containing %q, got %+v", c.problem, report.Errors)
+			}
+		})
+	}
+}
+
+type mockProductStore struct {
+	types.ProductStore
+
+	existing map[string]bool
+	batches  []int
+}
+
+func (m *mockProductStore) GetProductsBySKU(ctx context.Context, skus []string) ([]types.Product, error) {
+	products := []types.Product{}
+	for _, sku := range skus {
+		if m.existing[sku] {
+			products = append(products, types.Product{SKU: sku})
+		}
+	}
+
+	return products, nil
+}
+
+func (m *mockProductStore) UpsertProductsBySKU(ctx context.Context, products []types.Product) error {
+	m.batches = append(m.batches, len(products))
+	for _, p := range products {
+		m.existing[p.SKU] = true
+	}
+
+	return nil
+}
@@ -0,0 +1,148 @@
+package currency
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+
+	"github.com/xelathan/golang_backend/money"
+	"github.com/xelathan/golang_backend/types"
+)
+
+// FromRequest returns the currency the client asked for, taken from the
+// currency query parameter or else the Accept-Currency header, and the base
+// currency when neither is set.
+func FromRequest(r *http.Request, base string) (string, error) {
+	currency := r.URL.Query().Get("currency")
+	if currency == "" {
+		currency = r.Header.Get("Accept-Currency")
+	}
+
+	currency = strings.ToUpper(strings.TrimSpace(currency))
+	if currency == "" {
+		return base, nil
+	}
+
+	if !money.IsValidCurrency(currency) {
+		return "", fmt.Errorf("unsupported currency %s", currency)
+	}
+
+	return currency, nil
+}
+
+// Converter converts between currencies using a snapshot of the stored
+// exchange rates. Rates are relative to the base currency, whose rate is 1.
+type Converter struct {
+	base  string
+	rates map[string]*big.Rat
+}
+
+func NewConverter(base string, rates []types.ExchangeRate) (*Converter, error) {
+	c := &Converter{base: base, rates: map[string]*big.Rat{base: big.NewRat(1, 1)}}
+
+	for _, rate := range rates {
+		parsed, err := ParseRate(rate.Rate)
+		if err != nil {
+			return nil, fmt.Errorf("rate for %s: %w", rate.Currency, err)
+		}
+
+		if rate.Currency != base {
+			c.rates[rate.Currency] = parsed
+		}
+	}
+
+	return c, nil
+}
+
+// LoadConverter builds a Converter from the rates currently in the store.
+func LoadConverter(ctx context.Context, store types.CurrencyStore, base string) (*Converter, error) {
+	rates, err := store.GetExchangeRates(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewConverter(base, rates)
+}
+
+// ParseRate reads a positive decimal exchange rate exactly.
+func ParseRate(rate string) (*big.Rat, error) {
+	parsed, ok := new(big.Rat).SetString(rate)
+	if !ok || parsed.Sign() <= 0 {
+		return nil, fmt.Errorf("invalid exchange rate %q", rate)
+	}
+
+	return parsed, nil
+}
+
+func (c *Converter) Base() string {
+	return c.base
+}
+
+// Rate returns the base to currency rate as a decimal string, the form
+// snapshotted on orders.
+func (c *Converter) Rate(currency string) (string, error) {
+	rate, ok := c.rates[currency]
+	if !ok {
+		return "", fmt.Errorf("no exchange rate for %s", currency)
+	}
+
+	return strings.TrimRight(strings.TrimRight(rate.FloatString(8), "0"), "."), nil
+}
+
+// Convert expresses m in the target currency, rounding half up to the
+// target's minor unit.
+func (c *Converter) Convert(m money.Money, target string) (money.Money, error) {
+	if m.Currency == target {
+		return m, nil
+	}
+
+	from, ok := c.rates[m.Currency]
+	if !ok {
+		return money.Money{}, fmt.Errorf("no exchange rate for %s", m.Currency)
+	}
+
+	to, ok := c.rates[target]
+	if !ok {
+		return money.Money{}, fmt.Errorf("no exchange rate for %s", target)
+	}
+
+	// minor(target) = minor(source) * to / from * 10^exp(target) / 10^exp(source)
+	factor := new(big.Rat).Quo(to, from)
+	factor.Mul(factor, new(big.Rat).SetFrac(pow10(money.Exponent(target)), pow10(money.Exponent(m.Currency))))
+
+	converted := m.MulRat(factor, money.HalfUp)
+	converted.Currency = target
+
+	return converted, nil
+}
+
+// Localize prices a product in the target currency. A per-currency override
+// replaces the regular price, but an active sale is always converted so the
+// discount applies in every market.
+func (c *Converter) Localize(price money.Money, compareAt *money.Money, override *money.Money, target string) (money.Money, *money.Money, error) {
+	if compareAt == nil && override != nil {
+		return *override, nil, nil
+	}
+
+	localized, err := c.Convert(price, target)
+	if err != nil {
+		return money.Money{}, nil, err
+	}
+
+	if compareAt == nil {
+		return localized, nil, nil
+	}
+
+	localizedCompareAt, err := c.Convert(*compareAt, target)
+	if err != nil {
+		return money.Money{}, nil, err
+	}
+
+	return localized, &localizedCompareAt, nil
+}
+
+func pow10(exp int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(exp)), nil)
+}
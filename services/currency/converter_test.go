@@ -0,0 +1,62 @@
+package currency
+
+import (
+	"testing"
+
+	"github.com/xelathan/golang_backend/money"
+	"github.com/xelathan/golang_backend/types"
+)
+
+func TestConverter(t *testing.T) {
+	converter, err := NewConverter("USD", []types.ExchangeRate{
+		{Currency: "EUR", Rate: "0.92"},
+		{Currency: "JPY", Rate: "149.5"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("should convert between minor units of different exponents", func(t *testing.T) {
+		converted, err := converter.Convert(money.New(1999, "USD"), "JPY")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// 19.99 * 149.5 = 2988.505, rounded half up to whole yen
+		if converted != money.New(2989, "JPY") {
+			t.Errorf("expected 2989 JPY, got %s", converted)
+		}
+	})
+
+	t.Run("should convert through the base currency", func(t *testing.T) {
+		converted, err := converter.Convert(money.New(1000, "EUR"), "JPY")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// 10.00 / 0.92 * 149.5 = 1625
+		if converted != money.New(1625, "JPY") {
+			t.Errorf("expected 1625 JPY, got %s", converted)
+		}
+	})
+
+	t.Run("should prefer overrides unless a sale is active", func(t *testing.T) {
+		override := money.New(1500, "EUR")
+		price, _, err := converter.Localize(money.New(2000, "USD"), nil, &override, "EUR")
+		if err != nil || price != override {
+			t.Errorf("expected override, got %s %v", price, err)
+		}
+
+		compareAt := money.New(2500, "USD")
+		price, localizedCompareAt, err := converter.Localize(money.New(2000, "USD"), &compareAt, &override, "EUR")
+		if err != nil || price != money.New(1840, "EUR") || *localizedCompareAt != money.New(2300, "EUR") {
+			t.Errorf("expected converted sale price, got %s %v %v", price, localizedCompareAt, err)
+		}
+	})
+
+	t.Run("should fail for currencies without a rate", func(t *testing.T) {
+		if _, err := converter.Convert(money.New(100, "USD"), "GBP"); err == nil {
+			t.Errorf("expected an error for a missing rate")
+		}
+	})
+}
@@ -0,0 +1,154 @@
+package currency
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"github.com/xelathan/golang_backend/config"
+	"github.com/xelathan/golang_backend/money"
+	"github.com/xelathan/golang_backend/services/auth"
+	"github.com/xelathan/golang_backend/types"
+	"github.com/xelathan/golang_backend/utils"
+)
+
+type Handler struct {
+	store        types.CurrencyStore
+	productStore types.ProductStore
+	userStore    types.UserStore
+}
+
+func NewHandler(store types.CurrencyStore, productStore types.ProductStore, userStore types.UserStore) *Handler {
+	return &Handler{store: store, productStore: productStore, userStore: userStore}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/admin/exchange_rates", auth.WithAdminAuth(h.handleGetExchangeRates, h.userStore)).Methods(http.MethodGet)
+	router.HandleFunc("/admin/exchange_rates", auth.WithAdminAuth(h.handleSetExchangeRates, h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/admin/products/{id}/currency_prices", auth.WithAdminAuth(h.handleSetCurrencyPrice, h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/admin/products/{id}/currency_prices/{currency}", auth.WithAdminAuth(h.handleDeleteCurrencyPrice, h.userStore)).Methods(http.MethodDelete)
+}
+
+func (h *Handler) handleGetExchangeRates(w http.ResponseWriter, r *http.Request) {
+	rates, err := h.store.GetExchangeRates(r.Context())
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]any{"base": config.Envs.BaseCurrency, "rates": rates})
+}
+
+func (h *Handler) handleSetExchangeRates(w http.ResponseWriter, r *http.Request) {
+	payload := types.SetExchangeRatesPayload{}
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	rates, err := ValidateRates(payload.Rates, config.Envs.BaseCurrency)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.store.UpsertExchangeRates(r.Context(), rates); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]int{"updated": len(rates)})
+}
+
+// ValidateRates normalizes currency codes and rejects unknown currencies,
+// the base currency and rates that are not positive decimals.
+func ValidateRates(rates []types.ExchangeRate, base string) ([]types.ExchangeRate, error) {
+	normalized := make([]types.ExchangeRate, len(rates))
+	for i, rate := range rates {
+		rate.Currency = strings.ToUpper(strings.TrimSpace(rate.Currency))
+
+		if !money.IsValidCurrency(rate.Currency) {
+			return nil, fmt.Errorf("unsupported currency %s", rate.Currency)
+		}
+
+		if rate.Currency == base {
+			return nil, fmt.Errorf("the base currency %s always has a rate of 1", base)
+		}
+
+		if _, err := ParseRate(rate.Rate); err != nil {
+			return nil, err
+		}
+
+		normalized[i] = rate
+	}
+
+	return normalized, nil
+}
+
+func (h *Handler) handleSetCurrencyPrice(w http.ResponseWriter, r *http.Request) {
+	productId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid product id"))
+		return
+	}
+
+	payload := types.SetCurrencyPricePayload{}
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	if payload.Price.Currency == config.Envs.BaseCurrency {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("use the product price history to change the base price"))
+		return
+	}
+
+	products, err := h.productStore.GetProductsByID(r.Context(), []int{productId})
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if len(products) == 0 {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("product not found"))
+		return
+	}
+
+	if err := h.store.SetCurrencyPrice(r.Context(), productId, payload.Price); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]any{"productId": productId, "price": payload.Price})
+}
+
+func (h *Handler) handleDeleteCurrencyPrice(w http.ResponseWriter, r *http.Request) {
+	productId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid product id"))
+		return
+	}
+
+	currency := strings.ToUpper(mux.Vars(r)["currency"])
+	if err := h.store.DeleteCurrencyPrice(r.Context(), productId, currency); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
@@ -0,0 +1,55 @@
+package currency
+
+import (
+	"context"
+	"time"
+
+	"github.com/xelathan/golang_backend/money"
+	"github.com/xelathan/golang_backend/types"
+)
+
+// ResolvePrices returns the price in effect at the given time of every
+// product, expressed in the target currency. Products without price history
+// fall back to their stored price. The returned ProductPrice carries the
+// localized price and compare-at price only.
+func ResolvePrices(ctx context.Context, priceStore types.PriceStore, currencyStore types.CurrencyStore, converter *Converter, products []types.Product, target string, at time.Time) (map[int]types.ProductPrice, error) {
+	ids := make([]int, len(products))
+	for i, p := range products {
+		ids[i] = p.ID
+	}
+
+	effective, err := priceStore.GetEffectivePrices(ctx, ids, at)
+	if err != nil {
+		return nil, err
+	}
+
+	overrides := map[int]money.Money{}
+	if target != converter.Base() {
+		overrides, err = currencyStore.GetCurrencyPrices(ctx, ids, target)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	prices := make(map[int]types.ProductPrice, len(products))
+	for _, p := range products {
+		price, compareAt := p.Price, (*money.Money)(nil)
+		if e, ok := effective[p.ID]; ok {
+			price, compareAt = e.Price, e.CompareAtPrice
+		}
+
+		var override *money.Money
+		if o, ok := overrides[p.ID]; ok {
+			override = &o
+		}
+
+		localized, localizedCompareAt, err := converter.Localize(price, compareAt, override, target)
+		if err != nil {
+			return nil, err
+		}
+
+		prices[p.ID] = types.ProductPrice{ProductID: p.ID, Price: localized, CompareAtPrice: localizedCompareAt, EffectiveFrom: at}
+	}
+
+	return prices, nil
+}
@@ -0,0 +1,110 @@
+package currency
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/xelathan/golang_backend/money"
+	"github.com/xelathan/golang_backend/types"
+)
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+func (s *Store) GetExchangeRates(ctx context.Context) ([]types.ExchangeRate, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT currency, rate, updatedAt FROM exchange_rates ORDER BY currency")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rates := []types.ExchangeRate{}
+	for rows.Next() {
+		rate := types.ExchangeRate{}
+		if err := rows.Scan(&rate.Currency, &rate.Rate, &rate.UpdatedAt); err != nil {
+			return nil, err
+		}
+
+		rates = append(rates, rate)
+	}
+
+	return rates, nil
+}
+
+// UpsertExchangeRates replaces the given rates in one transaction so a
+// partially loaded rate sheet is never visible to checkout.
+func (s *Store) UpsertExchangeRates(ctx context.Context, rates []types.ExchangeRate) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, rate := range rates {
+		_, err := tx.ExecContext(ctx, "INSERT INTO exchange_rates (currency, rate) VALUES (?,?) ON DUPLICATE KEY UPDATE rate = VALUES(rate)", rate.Currency, rate.Rate)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *Store) GetCurrencyPrices(ctx context.Context, productIDs []int, currency string) (map[int]money.Money, error) {
+	prices := map[int]money.Money{}
+	if len(productIDs) == 0 {
+		return prices, nil
+	}
+
+	placeholders := strings.Repeat(",?", len(productIDs)-1)
+	query := fmt.Sprintf("SELECT productId, price FROM product_currency_prices WHERE currency = ? AND productId IN (?%s)", placeholders)
+
+	args := make([]interface{}, 0, len(productIDs)+1)
+	args = append(args, currency)
+	for _, v := range productIDs {
+		args = append(args, v)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var productId int
+		price := money.Zero(currency)
+		if err := rows.Scan(&productId, &price.Amount); err != nil {
+			return nil, err
+		}
+
+		prices[productId] = price
+	}
+
+	return prices, nil
+}
+
+func (s *Store) SetCurrencyPrice(ctx context.Context, productId int, price money.Money) error {
+	_, err := s.db.ExecContext(ctx, "INSERT INTO product_currency_prices (productId, currency, price) VALUES (?,?,?) ON DUPLICATE KEY UPDATE price = VALUES(price)", productId, price.Currency, price.Amount)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *Store) DeleteCurrencyPrice(ctx context.Context, productId int, currency string) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM product_currency_prices WHERE productId = ? AND currency = ?", productId, currency)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
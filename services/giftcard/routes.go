@@ -0,0 +1,165 @@
+package giftcard
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"github.com/xelathan/golang_backend/config"
+	"github.com/xelathan/golang_backend/services/auth"
+	"github.com/xelathan/golang_backend/services/idempotency"
+	"github.com/xelathan/golang_backend/types"
+	"github.com/xelathan/golang_backend/utils"
+)
+
+type Handler struct {
+	issuer    *Issuer
+	store     types.GiftCardStore
+	userStore types.UserStore
+	keys      *idempotency.Keys
+}
+
+func NewHandler(issuer *Issuer, store types.GiftCardStore, userStore types.UserStore, keys *idempotency.Keys) *Handler {
+	return &Handler{issuer: issuer, store: store, userStore: userStore, keys: keys}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/gift_cards/balance", auth.WithJWTAuth(h.handleGetBalance, h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/me/store_credit", auth.WithJWTAuth(h.handleGetStoreCredit, h.userStore)).Methods(http.MethodGet)
+
+	router.HandleFunc("/admin/gift_cards", auth.WithAdminAuth(h.keys.Wrap(h.handleIssue), h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/admin/gift_cards/{id}", auth.WithAdminAuth(h.handleGetGiftCard, h.userStore)).Methods(http.MethodGet)
+	router.HandleFunc("/admin/gift_cards/{id}/disable", auth.WithAdminAuth(h.handleDisable, h.userStore)).Methods(http.MethodPost)
+}
+
+// handleGetBalance looks a card up by its code. The code is sent in the body
+// so it does not end up in access logs.
+func (h *Handler) handleGetBalance(w http.ResponseWriter, r *http.Request) {
+	payload := types.GiftCardBalancePayload{}
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	card, err := h.store.GetGiftCardByCode(r.Context(), HashCode(payload.Code))
+	if err != nil {
+		utils.WriteError(w, giftCardStatus(err), err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]any{
+		"last4":     card.Last4,
+		"balance":   card.Balance,
+		"status":    card.Status,
+		"expiresAt": card.ExpiresAt,
+	})
+}
+
+func (h *Handler) handleGetStoreCredit(w http.ResponseWriter, r *http.Request) {
+	userId := auth.GetUserIdFromContext(r.Context())
+	if userId == -1 {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid userId"))
+		return
+	}
+
+	wallet, err := h.store.GetWallet(r.Context(), userId, config.Envs.BaseCurrency)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	wallet.Transactions, err = h.store.GetWalletTransactions(r.Context(), userId)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, wallet)
+}
+
+// handleIssue answers with the card's code, which cannot be looked up again
+// afterwards.
+func (h *Handler) handleIssue(w http.ResponseWriter, r *http.Request) {
+	payload := types.IssueGiftCardPayload{}
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	if payload.ExpiresAt != nil && !payload.ExpiresAt.After(time.Now()) {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("expiresAt must be in the future"))
+		return
+	}
+
+	staffId := auth.GetUserIdFromContext(r.Context())
+	card, code, err := h.issuer.Issue(r.Context(), payload, &staffId)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, map[string]any{"giftCard": card, "code": code})
+}
+
+func (h *Handler) handleGetGiftCard(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid gift card id"))
+		return
+	}
+
+	card, err := h.store.GetGiftCardById(r.Context(), id)
+	if err != nil {
+		utils.WriteError(w, giftCardStatus(err), err)
+		return
+	}
+
+	card.Transactions, err = h.store.GetGiftCardTransactions(r.Context(), id)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, card)
+}
+
+// handleDisable stops a card from being spent, such as one whose code was
+// lost or leaked. Its balance stays on it.
+func (h *Handler) handleDisable(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid gift card id"))
+		return
+	}
+
+	if err := h.store.SetGiftCardStatus(r.Context(), id, types.GiftCardDisabled); err != nil {
+		utils.WriteError(w, giftCardStatus(err), err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]any{"id": id, "status": types.GiftCardDisabled})
+}
+
+func giftCardStatus(err error) int {
+	if errors.Is(err, ErrNotFound) {
+		return http.StatusNotFound
+	}
+
+	return http.StatusInternalServerError
+}
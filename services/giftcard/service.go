@@ -0,0 +1,191 @@
+package giftcard
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/xelathan/golang_backend/types"
+)
+
+const EventGiftCardIssued = "gift_card.issued"
+
+// codeAlphabet leaves out the letters and digits that are easily mistaken
+// for one another. Its 32 symbols take 5 bits of a random byte each, so
+// codes are not biased.
+const codeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// NewCode makes a gift card code of 16 symbols in groups of four, about 80
+// bits of randomness.
+func NewCode() (string, error) {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+
+	var code strings.Builder
+	for i, b := range random {
+		if i > 0 && i%4 == 0 {
+			code.WriteByte('-')
+		}
+		code.WriteByte(codeAlphabet[int(b)%len(codeAlphabet)])
+	}
+
+	return code.String(), nil
+}
+
+// HashCode is what a code is stored and looked up by. Codes are matched
+// without their dashes or spaces and in any case.
+func HashCode(code string) string {
+	hash := sha256.Sum256([]byte(normalize(code)))
+	return hex.EncodeToString(hash[:])
+}
+
+func normalize(code string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+func last4(code string) string {
+	code = normalize(code)
+	return code[max(len(code)-4, 0):]
+}
+
+// Spendable checks the card can pay for an order in currency at the time.
+func Spendable(card *types.GiftCard, currency string, at time.Time) error {
+	switch {
+	case card.Status != types.GiftCardActive:
+		return fmt.Errorf("gift card ending %s is %s", card.Last4, card.Status)
+	case card.ExpiresAt != nil && !at.Before(*card.ExpiresAt):
+		return fmt.Errorf("gift card ending %s expired on %s", card.Last4, card.ExpiresAt.Format(time.DateOnly))
+	case card.Balance.Currency != currency:
+		return fmt.Errorf("gift card ending %s is in %s", card.Last4, card.Balance.Currency)
+	case !card.Balance.IsPositive():
+		return fmt.Errorf("gift card ending %s has nothing left to spend", card.Last4)
+	}
+
+	return nil
+}
+
+// Issuer issues gift cards, by hand for staff and for the gift cards bought
+// in the shop once their order is paid for, and expires them. Codes are only
+// known when a card is issued: they are sent to the card's recipient and
+// only their hash is kept.
+type Issuer struct {
+	store    types.GiftCardStore
+	notifier types.Notifier
+	validity time.Duration
+}
+
+// NewIssuer issues cards that expire after validity, or never when it is 0.
+func NewIssuer(store types.GiftCardStore, notifier types.Notifier, validity time.Duration) *Issuer {
+	return &Issuer{store: store, notifier: notifier, validity: validity}
+}
+
+// Start issues the gift cards bought and expires cards every interval.
+func (i *Issuer) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := i.Sweep(context.Background()); err != nil {
+				log.Printf("giftcard: sweep failed: %v", err)
+			}
+		}
+	}()
+}
+
+// Issue issues a card worth amount and returns it with its code. IssuedBy
+// is the staff member issuing it. ExpiresAt defaults to the issuer's
+// validity.
+func (i *Issuer) Issue(ctx context.Context, payload types.IssueGiftCardPayload, issuedBy *int) (*types.GiftCard, string, error) {
+	card := types.GiftCard{InitialAmount: payload.Amount, Recipient: payload.Recipient, IssuedBy: issuedBy, ExpiresAt: payload.ExpiresAt}
+	if card.ExpiresAt == nil {
+		card.ExpiresAt = i.expiry(time.Now())
+	}
+
+	return i.issue(ctx, card)
+}
+
+// Sweep expires the cards past their date and issues the cards bought on
+// paid orders, one for every unit, worth what the unit was sold for.
+func (i *Issuer) Sweep(ctx context.Context) error {
+	if _, err := i.store.ExpireGiftCards(ctx, time.Now()); err != nil {
+		return err
+	}
+
+	purchases, err := i.store.GetUnissuedGiftCards(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, purchase := range purchases {
+		for n := purchase.Issued; n < purchase.OrderItem.Quantity; n++ {
+			orderItemId := purchase.OrderItem.ID
+			card := types.GiftCard{
+				InitialAmount: purchase.OrderItem.Price,
+				Recipient:     purchase.Email,
+				OrderItemID:   &orderItemId,
+				ExpiresAt:     i.expiry(time.Now()),
+			}
+
+			if _, _, err := i.issue(ctx, card); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (i *Issuer) issue(ctx context.Context, card types.GiftCard) (*types.GiftCard, string, error) {
+	code, err := NewCode()
+	if err != nil {
+		return nil, "", err
+	}
+
+	card.CodeHash, card.Last4 = HashCode(code), last4(code)
+	card.Balance, card.Status = card.InitialAmount, types.GiftCardActive
+
+	card.ID, err = i.store.CreateGiftCard(ctx, card)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// the card is issued either way; one whose code never arrived can be
+	// disabled and issued again by staff
+	if card.Recipient != "" {
+		if err := i.notifier.Notify(types.Notification{
+			Event:     EventGiftCardIssued,
+			Recipient: card.Recipient,
+			Subject:   fmt.Sprintf("Your %s gift card", card.InitialAmount),
+			Message:   fmt.Sprintf("Your gift card code is %s.%s", code, expiryNote(card.ExpiresAt)),
+		}); err != nil {
+			log.Printf("giftcard: failed to send gift card %d to %q: %v", card.ID, card.Recipient, err)
+		}
+	}
+
+	return &card, code, nil
+}
+
+func (i *Issuer) expiry(from time.Time) *time.Time {
+	if i.validity <= 0 {
+		return nil
+	}
+
+	expiresAt := from.Add(i.validity)
+	return &expiresAt
+}
+
+func expiryNote(expiresAt *time.Time) string {
+	if expiresAt == nil {
+		return ""
+	}
+
+	return fmt.Sprintf(" It can be spent until %s.", expiresAt.Format(time.DateOnly))
+}
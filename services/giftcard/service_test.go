@@ -0,0 +1,128 @@
+package giftcard
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xelathan/golang_backend/money"
+	"github.com/xelathan/golang_backend/types"
+)
+
+func TestCode(t *testing.T) {
+	t.Run("should make codes of four groups of four", func(t *testing.T) {
+		code, err := NewCode()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		groups := strings.Split(code, "-")
+		if len(groups) != 4 || len(normalize(code)) != 16 || strings.Trim(normalize(code), codeAlphabet) != "" {
+			t.Errorf("expected a code like XXXX-XXXX-XXXX-XXXX, got %q", code)
+		}
+	})
+
+	t.Run("should match codes without dashes or case", func(t *testing.T) {
+		if HashCode("abcd efgh-jkmn-PQRS") != HashCode("ABCD-EFGH-JKMN-PQRS") {
+			t.Error("expected the same hash for the same code written differently")
+		}
+
+		if last4("abcd-efgh-jkmn-pqrs") != "PQRS" {
+			t.Errorf("expected the last four symbols, got %q", last4("abcd-efgh-jkmn-pqrs"))
+		}
+	})
+}
+
+func TestSpendable(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Hour)
+
+	cases := []struct {
+		name  string
+		card  types.GiftCard
+		valid bool
+	}{
+		{"active card", types.GiftCard{Status: types.GiftCardActive, Balance: money.New(500, "USD")}, true},
+		{"disabled card", types.GiftCard{Status: types.GiftCardDisabled, Balance: money.New(500, "USD")}, false},
+		{"expired card", types.GiftCard{Status: types.GiftCardActive, Balance: money.New(500, "USD"), ExpiresAt: &past}, false},
+		{"other currency", types.GiftCard{Status: types.GiftCardActive, Balance: money.New(500, "EUR")}, false},
+		{"empty card", types.GiftCard{Status: types.GiftCardActive, Balance: money.Zero("USD")}, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if err := Spendable(&c.card, "USD", now); (err == nil) != c.valid {
+				t.Errorf("expected spendable to be %v, got %v", c.valid, err)
+			}
+		})
+	}
+}
+
+func TestSweep(t *testing.T) {
+	t.Run("should issue a card for every unit not issued yet", func(t *testing.T) {
+		store := &mockGiftCardStore{purchases: []types.GiftCardPurchase{
+			{OrderItem: types.OrderItem{ID: 7, Quantity: 3, Price: money.New(2500, "USD")}, Email: "buyer@example.com", Issued: 1},
+		}}
+		notifier := &mockNotifier{}
+
+		if err := NewIssuer(store, notifier, 24*time.Hour).Sweep(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(store.cards) != 2 || len(notifier.sent) != 2 {
+			t.Fatalf("expected 2 cards issued and sent, got %d and %d", len(store.cards), len(notifier.sent))
+		}
+
+		card := store.cards[0]
+		if card.Balance != money.New(2500, "USD") || *card.OrderItemID != 7 || card.ExpiresAt == nil || card.Recipient != "buyer@example.com" {
+			t.Errorf("expected a 25.00 card for the order item sent to the buyer, got %+v", card)
+		}
+
+		if strings.Contains(notifier.sent[0].Message, card.CodeHash) {
+			t.Error("expected the code sent rather than its hash")
+		}
+	})
+
+	t.Run("should issue cards that never expire without a validity", func(t *testing.T) {
+		store := &mockGiftCardStore{}
+
+		card, code, err := NewIssuer(store, &mockNotifier{}, 0).Issue(context.Background(), types.IssueGiftCardPayload{Amount: money.New(1000, "USD")}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if card.ExpiresAt != nil || card.CodeHash != HashCode(code) || card.Last4 != last4(code) {
+			t.Errorf("expected a card without an expiry stored by its code's hash, got %+v", card)
+		}
+	})
+}
+
+type mockGiftCardStore struct {
+	types.GiftCardStore
+
+	purchases []types.GiftCardPurchase
+	cards     []types.GiftCard
+}
+
+func (m *mockGiftCardStore) CreateGiftCard(ctx context.Context, card types.GiftCard) (int, error) {
+	m.cards = append(m.cards, card)
+	return len(m.cards), nil
+}
+
+func (m *mockGiftCardStore) ExpireGiftCards(ctx context.Context, at time.Time) (int, error) {
+	return 0, nil
+}
+
+func (m *mockGiftCardStore) GetUnissuedGiftCards(ctx context.Context) ([]types.GiftCardPurchase, error) {
+	return m.purchases, nil
+}
+
+type mockNotifier struct {
+	sent []types.Notification
+}
+
+func (m *mockNotifier) Notify(notification types.Notification) error {
+	m.sent = append(m.sent, notification)
+	return nil
+}
@@ -0,0 +1,408 @@
+package giftcard
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/xelathan/golang_backend/db"
+	"github.com/xelathan/golang_backend/money"
+	"github.com/xelathan/golang_backend/types"
+)
+
+var (
+	ErrNotFound         = errors.New("gift card not found")
+	ErrCurrencyMismatch = errors.New("gift card is held in another currency")
+	// ErrInsufficientBalance is returned when a gift card or store credit
+	// no longer has what is being spent, usually because it was spent
+	// elsewhere at the same time.
+	ErrInsufficientBalance = errors.New("not enough left to spend")
+)
+
+const selectGiftCards = "SELECT id, codeHash, last4, initialAmount, balance, currency, status, recipient, orderItemId, issuedBy, expiresAt, createdAt FROM gift_cards"
+
+type Store struct {
+	db types.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+func (s *Store) WithTx(tx types.DB) types.GiftCardStore {
+	return &Store{db: tx}
+}
+
+func (s *Store) CreateGiftCard(ctx context.Context, card types.GiftCard) (int, error) {
+	var id int64
+	err := db.InTxContext(ctx, s.db, func(tx types.DB) error {
+		res, err := tx.ExecContext(ctx,
+			"INSERT INTO gift_cards (codeHash, last4, initialAmount, balance, currency, status, recipient, orderItemId, issuedBy, expiresAt) VALUES (?,?,?,?,?,?,?,?,?,?)",
+			card.CodeHash, card.Last4, card.InitialAmount.Amount, card.InitialAmount.Amount, card.InitialAmount.Currency, types.GiftCardActive,
+			card.Recipient, card.OrderItemID, card.IssuedBy, card.ExpiresAt,
+		)
+		if err != nil {
+			return err
+		}
+
+		id, err = res.LastInsertId()
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO gift_card_transactions (giftCardId, kind, amount) VALUES (?,?,?)",
+			id, types.LedgerIssue, card.InitialAmount.Amount,
+		)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+func (s *Store) GetGiftCardById(ctx context.Context, id int) (*types.GiftCard, error) {
+	return s.getGiftCard(ctx, " WHERE id = ?", id)
+}
+
+func (s *Store) GetGiftCardByCode(ctx context.Context, codeHash string) (*types.GiftCard, error) {
+	return s.getGiftCard(ctx, " WHERE codeHash = ?", codeHash)
+}
+
+func (s *Store) getGiftCard(ctx context.Context, suffix string, args ...any) (*types.GiftCard, error) {
+	rows, err := s.db.QueryContext(ctx, selectGiftCards+suffix, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, ErrNotFound
+	}
+
+	card := types.GiftCard{}
+	var orderItemId, issuedBy sql.NullInt64
+	var expiresAt sql.NullTime
+	err = rows.Scan(
+		&card.ID, &card.CodeHash, &card.Last4, &card.InitialAmount.Amount, &card.Balance.Amount, &card.InitialAmount.Currency,
+		&card.Status, &card.Recipient, &orderItemId, &issuedBy, &expiresAt, &card.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	card.Balance.Currency = card.InitialAmount.Currency
+	card.OrderItemID = nullableInt(orderItemId)
+	card.IssuedBy = nullableInt(issuedBy)
+	if expiresAt.Valid {
+		card.ExpiresAt = &expiresAt.Time
+	}
+
+	return &card, nil
+}
+
+func (s *Store) GetGiftCardTransactions(ctx context.Context, id int) ([]types.GiftCardTransaction, error) {
+	return s.getGiftCardTransactions(ctx, " WHERE t.giftCardId = ? ORDER BY t.id", id)
+}
+
+func (s *Store) GetGiftCardTransaction(ctx context.Context, transactionId int) (*types.GiftCardTransaction, error) {
+	transactions, err := s.getGiftCardTransactions(ctx, " WHERE t.id = ?", transactionId)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(transactions) == 0 {
+		return nil, fmt.Errorf("gift card transaction not found")
+	}
+
+	return &transactions[0], nil
+}
+
+func (s *Store) getGiftCardTransactions(ctx context.Context, suffix string, args ...any) ([]types.GiftCardTransaction, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT t.id, t.giftCardId, t.kind, t.amount, g.currency, t.orderId, t.createdAt FROM gift_card_transactions t JOIN gift_cards g ON g.id = t.giftCardId"+suffix,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transactions := []types.GiftCardTransaction{}
+	for rows.Next() {
+		t := types.GiftCardTransaction{}
+		var orderId sql.NullInt64
+		if err := rows.Scan(&t.ID, &t.GiftCardID, &t.Kind, &t.Amount.Amount, &t.Amount.Currency, &orderId, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		t.OrderID = nullableInt(orderId)
+
+		transactions = append(transactions, t)
+	}
+
+	return transactions, rows.Err()
+}
+
+// AddToGiftCard guards what is taken off in the update itself, so two
+// orders paying with the same card cannot both spend what is left on it.
+func (s *Store) AddToGiftCard(ctx context.Context, id int, kind types.LedgerEntryKind, amount money.Money, orderId *int) (int, error) {
+	var transactionId int64
+	err := db.InTxContext(ctx, s.db, func(tx types.DB) error {
+		query, args := "UPDATE gift_cards SET balance = balance + ? WHERE id = ? AND currency = ?", []any{amount.Amount, id, amount.Currency}
+		if amount.IsNegative() {
+			query += " AND status = ? AND (expiresAt IS NULL OR expiresAt > ?) AND balance >= ?"
+			args = append(args, types.GiftCardActive, time.Now(), -amount.Amount)
+		}
+
+		res, err := tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if affected == 0 {
+			// tell apart why nothing was updated
+			currency := ""
+			err := tx.QueryRowContext(ctx, "SELECT currency FROM gift_cards WHERE id = ?", id).Scan(&currency)
+			switch {
+			case err == sql.ErrNoRows:
+				return ErrNotFound
+			case err != nil:
+				return err
+			case currency != amount.Currency:
+				return fmt.Errorf("%w: gift card %d is held in %s, not %s", ErrCurrencyMismatch, id, currency, amount.Currency)
+			}
+
+			return fmt.Errorf("%w: gift card %d does not have %s left to spend", ErrInsufficientBalance, id, amount.Neg())
+		}
+
+		res, err = tx.ExecContext(ctx,
+			"INSERT INTO gift_card_transactions (giftCardId, kind, amount, orderId) VALUES (?,?,?,?)",
+			id, kind, amount.Amount, orderId,
+		)
+		if err != nil {
+			return err
+		}
+
+		transactionId, err = res.LastInsertId()
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return int(transactionId), nil
+}
+
+// ExpireGiftCards records what each card lost in its ledger before
+// clearing its balance.
+func (s *Store) ExpireGiftCards(ctx context.Context, at time.Time) (int, error) {
+	var expired int64
+	err := db.InTxContext(ctx, s.db, func(tx types.DB) error {
+		_, err := tx.ExecContext(ctx,
+			"INSERT INTO gift_card_transactions (giftCardId, kind, amount) SELECT id, ?, -balance FROM gift_cards WHERE status = ? AND expiresAt <= ? AND balance > 0",
+			types.LedgerExpire, types.GiftCardActive, at,
+		)
+		if err != nil {
+			return err
+		}
+
+		res, err := tx.ExecContext(ctx,
+			"UPDATE gift_cards SET balance = 0, status = ? WHERE status = ? AND expiresAt <= ?",
+			types.GiftCardExpired, types.GiftCardActive, at,
+		)
+		if err != nil {
+			return err
+		}
+
+		expired, err = res.RowsAffected()
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return int(expired), nil
+}
+
+func (s *Store) SetGiftCardStatus(ctx context.Context, id int, status types.GiftCardStatus) error {
+	res, err := s.db.ExecContext(ctx, "UPDATE gift_cards SET status = ? WHERE id = ?", status, id)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// GetUnissuedGiftCards only looks at orders that have been paid for and
+// not cancelled or refunded since.
+func (s *Store) GetUnissuedGiftCards(ctx context.Context) ([]types.GiftCardPurchase, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT oi.id, oi.orderId, oi.productId, oi.quantity, oi.price, o.currency, u.email, COUNT(g.id) "+
+			"FROM order_items oi "+
+			"JOIN products p ON p.id = oi.productId "+
+			"JOIN orders o ON o.id = oi.orderId "+
+			"JOIN users u ON u.id = o.userId "+
+			"LEFT JOIN gift_cards g ON g.orderItemId = oi.id "+
+			"WHERE p.giftCard = TRUE AND o.status IN (?,?,?,?) "+
+			"GROUP BY oi.id, oi.orderId, oi.productId, oi.quantity, oi.price, o.currency, u.email "+
+			"HAVING COUNT(g.id) < oi.quantity ORDER BY oi.id",
+		types.Paid, types.Processing, types.Shipped, types.Delivered,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	purchases := []types.GiftCardPurchase{}
+	for rows.Next() {
+		p := types.GiftCardPurchase{}
+		err := rows.Scan(
+			&p.OrderItem.ID, &p.OrderItem.OrderID, &p.OrderItem.ProductID, &p.OrderItem.Quantity,
+			&p.OrderItem.Price.Amount, &p.OrderItem.Price.Currency, &p.Email, &p.Issued,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		purchases = append(purchases, p)
+	}
+
+	return purchases, rows.Err()
+}
+
+func (s *Store) GetWallet(ctx context.Context, userId int, currency string) (*types.Wallet, error) {
+	wallet := types.Wallet{UserID: userId, Balance: money.Zero(currency)}
+	err := s.db.QueryRowContext(ctx, "SELECT balance, currency, updatedAt FROM wallets WHERE userId = ?", userId).
+		Scan(&wallet.Balance.Amount, &wallet.Balance.Currency, &wallet.UpdatedAt)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	return &wallet, nil
+}
+
+func (s *Store) GetWalletTransactions(ctx context.Context, userId int) ([]types.WalletTransaction, error) {
+	return s.getWalletTransactions(ctx, " WHERE t.userId = ? ORDER BY t.id DESC", userId)
+}
+
+func (s *Store) GetWalletTransaction(ctx context.Context, transactionId int) (*types.WalletTransaction, error) {
+	transactions, err := s.getWalletTransactions(ctx, " WHERE t.id = ?", transactionId)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(transactions) == 0 {
+		return nil, fmt.Errorf("wallet transaction not found")
+	}
+
+	return &transactions[0], nil
+}
+
+func (s *Store) getWalletTransactions(ctx context.Context, suffix string, args ...any) ([]types.WalletTransaction, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT t.id, t.userId, t.kind, t.amount, w.currency, t.orderId, t.createdAt FROM wallet_transactions t JOIN wallets w ON w.userId = t.userId"+suffix,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transactions := []types.WalletTransaction{}
+	for rows.Next() {
+		t := types.WalletTransaction{}
+		var orderId sql.NullInt64
+		if err := rows.Scan(&t.ID, &t.UserID, &t.Kind, &t.Amount.Amount, &t.Amount.Currency, &orderId, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		t.OrderID = nullableInt(orderId)
+
+		transactions = append(transactions, t)
+	}
+
+	return transactions, rows.Err()
+}
+
+// AddToWallet opens the wallet in the currency of the first amount added to
+// it. Amounts in another currency are refused.
+func (s *Store) AddToWallet(ctx context.Context, userId int, kind types.LedgerEntryKind, amount money.Money, orderId *int) (int, error) {
+	var transactionId int64
+	err := db.InTxContext(ctx, s.db, func(tx types.DB) error {
+		if !amount.IsNegative() {
+			_, err := tx.ExecContext(ctx, "INSERT IGNORE INTO wallets (userId, balance, currency) VALUES (?,0,?)", userId, amount.Currency)
+			if err != nil {
+				return err
+			}
+		}
+
+		query, args := "UPDATE wallets SET balance = balance + ? WHERE userId = ? AND currency = ?", []any{amount.Amount, userId, amount.Currency}
+		if amount.IsNegative() {
+			query += " AND balance >= ?"
+			args = append(args, -amount.Amount)
+		}
+
+		res, err := tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if affected == 0 && amount.IsNegative() {
+			return fmt.Errorf("%w: store credit does not have %s left to spend", ErrInsufficientBalance, amount.Neg())
+		}
+
+		if affected == 0 {
+			return fmt.Errorf("store credit is not held in %s", amount.Currency)
+		}
+
+		res, err = tx.ExecContext(ctx,
+			"INSERT INTO wallet_transactions (userId, kind, amount, orderId) VALUES (?,?,?,?)",
+			userId, kind, amount.Amount, orderId,
+		)
+		if err != nil {
+			return err
+		}
+
+		transactionId, err = res.LastInsertId()
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return int(transactionId), nil
+}
+
+func nullableInt(value sql.NullInt64) *int {
+	if !value.Valid {
+		return nil
+	}
+
+	v := int(value.Int64)
+	return &v
+}
@@ -0,0 +1,134 @@
+package giftcard
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/xelathan/golang_backend/money"
+	"github.com/xelathan/golang_backend/types"
+)
+
+// GiftCards is the tender of gift cards. Accounts are gift card ids and
+// references are the ids of ledger entries.
+type GiftCards struct {
+	store types.GiftCardStore
+}
+
+func NewGiftCards(store types.GiftCardStore) *GiftCards {
+	return &GiftCards{store: store}
+}
+
+func (t *GiftCards) Name() string {
+	return types.TenderGiftCard
+}
+
+func (t *GiftCards) WithTx(tx types.DB) types.Tender {
+	return &GiftCards{store: t.store.WithTx(tx)}
+}
+
+func (t *GiftCards) Debit(ctx context.Context, account string, amount money.Money, orderId int) (string, error) {
+	id, err := strconv.Atoi(account)
+	if err != nil {
+		return "", fmt.Errorf("invalid gift card %q", account)
+	}
+
+	transactionId, err := t.store.AddToGiftCard(ctx, id, types.LedgerDebit, amount.Neg(), &orderId)
+	if err != nil {
+		return "", err
+	}
+
+	return strconv.Itoa(transactionId), nil
+}
+
+func (t *GiftCards) Credit(ctx context.Context, account string, amount money.Money, orderId int) error {
+	id, err := strconv.Atoi(account)
+	if err != nil {
+		return fmt.Errorf("invalid gift card %q", account)
+	}
+
+	_, err = t.store.AddToGiftCard(ctx, id, types.LedgerCredit, amount, &orderId)
+	return err
+}
+
+// Reverse puts the amount back on the card even if it has expired or been
+// disabled since, in which case it cannot be spent.
+func (t *GiftCards) Reverse(ctx context.Context, reference string, amount money.Money) error {
+	transactionId, err := strconv.Atoi(reference)
+	if err != nil {
+		return fmt.Errorf("invalid gift card reference %q", reference)
+	}
+
+	debit, err := t.store.GetGiftCardTransaction(ctx, transactionId)
+	if err != nil {
+		return err
+	}
+
+	if debit.Kind != types.LedgerDebit {
+		return fmt.Errorf("gift card transaction %d is not a debit", transactionId)
+	}
+
+	_, err = t.store.AddToGiftCard(ctx, debit.GiftCardID, types.LedgerReversal, amount, debit.OrderID)
+	return err
+}
+
+// StoreCredit is the tender of customers' store credit. Accounts are user
+// ids and references are the ids of ledger entries.
+type StoreCredit struct {
+	store types.GiftCardStore
+}
+
+func NewStoreCredit(store types.GiftCardStore) *StoreCredit {
+	return &StoreCredit{store: store}
+}
+
+func (t *StoreCredit) Name() string {
+	return types.TenderStoreCredit
+}
+
+func (t *StoreCredit) WithTx(tx types.DB) types.Tender {
+	return &StoreCredit{store: t.store.WithTx(tx)}
+}
+
+func (t *StoreCredit) Debit(ctx context.Context, account string, amount money.Money, orderId int) (string, error) {
+	userId, err := strconv.Atoi(account)
+	if err != nil {
+		return "", fmt.Errorf("invalid wallet %q", account)
+	}
+
+	transactionId, err := t.store.AddToWallet(ctx, userId, types.LedgerDebit, amount.Neg(), &orderId)
+	if err != nil {
+		return "", err
+	}
+
+	return strconv.Itoa(transactionId), nil
+}
+
+func (t *StoreCredit) Credit(ctx context.Context, account string, amount money.Money, orderId int) error {
+	userId, err := strconv.Atoi(account)
+	if err != nil {
+		return fmt.Errorf("invalid wallet %q", account)
+	}
+
+	_, err = t.store.AddToWallet(ctx, userId, types.LedgerCredit, amount, &orderId)
+	return err
+}
+
+func (t *StoreCredit) Reverse(ctx context.Context, reference string, amount money.Money) error {
+	transactionId, err := strconv.Atoi(reference)
+	if err != nil {
+		return fmt.Errorf("invalid store credit reference %q", reference)
+	}
+
+	debit, err := t.store.GetWalletTransaction(ctx, transactionId)
+	if err != nil {
+		return err
+	}
+
+	if debit.Kind != types.LedgerDebit {
+		return fmt.Errorf("wallet transaction %d is not a debit", transactionId)
+	}
+
+	_, err = t.store.AddToWallet(ctx, debit.UserID, types.LedgerReversal, amount, debit.OrderID)
+	return err
+}
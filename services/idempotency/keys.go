@@ -0,0 +1,261 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/xelathan/golang_backend/config"
+	"github.com/xelathan/golang_backend/services/auth"
+	"github.com/xelathan/golang_backend/types"
+	"github.com/xelathan/golang_backend/utils"
+)
+
+const (
+	KeyHeader      = "Idempotency-Key"
+	ReplayedHeader = "Idempotent-Replayed"
+
+	maxKeyLength = 255
+	maxBodyBytes = 1 << 20
+)
+
+// Keys makes endpoints safe to retry. A request sent with an Idempotency-Key
+// runs once: repeats of it within the ttl get the first response back
+// without running again, and reusing the key for a different request is
+// refused. Duplicates arriving while the first is still running wait for it
+// on this instance, and are told to retry on any other.
+//
+// Errors are kept and replayed like any other response, since a handler can
+// fail after part of its work is committed. Handlers that fail before
+// changing anything call Abandon, which gives the key back so a retry runs.
+type Keys struct {
+	store    types.IdempotencyStore
+	ttl      time.Duration
+	interval time.Duration
+
+	mu       sync.Mutex
+	inFlight map[string]*keyLock
+}
+
+type keyLock struct {
+	mu      sync.Mutex
+	holders int
+}
+
+func NewKeys(store types.IdempotencyStore, ttl time.Duration, interval time.Duration) *Keys {
+	return &Keys{store: store, ttl: ttl, interval: interval, inFlight: map[string]*keyLock{}}
+}
+
+// Start removes expired keys periodically in its own goroutine.
+func (k *Keys) Start() {
+	go func() {
+		ticker := time.NewTicker(k.interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if _, err := k.store.DeleteExpiredKeys(context.Background()); err != nil {
+				log.Printf("idempotency: sweep failed: %v", err)
+			}
+		}
+	}()
+}
+
+type abandonedKey struct{}
+
+// Abandon marks the request as having changed nothing, so its key is given
+// back for a retry instead of keeping the response. It does nothing for
+// requests without a key.
+func Abandon(r *http.Request) {
+	if abandoned, ok := r.Context().Value(abandonedKey{}).(*bool); ok {
+		*abandoned = true
+	}
+}
+
+// Wrap applies the key to fn. Requests without the header run as usual.
+// Keys are scoped to the client, so fn should be wrapped in the auth
+// middleware rather than the other way around.
+func (k *Keys) Wrap(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(KeyHeader)
+		if key == "" {
+			fn(w, r)
+			return
+		}
+
+		if len(key) > maxKeyLength {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("%s must be at most %d characters", KeyHeader, maxKeyLength))
+			return
+		}
+
+		scope, ok := scopeOf(r)
+		if !ok {
+			fn(w, r)
+			return
+		}
+
+		body := []byte{}
+		if r.Body != nil {
+			var err error
+			body, err = io.ReadAll(io.LimitReader(r.Body, maxBodyBytes+1))
+			if err != nil {
+				utils.WriteError(w, http.StatusBadRequest, err)
+				return
+			}
+			if len(body) > maxBodyBytes {
+				utils.WriteError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("request body too large"))
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		unlock := k.lock(scope + " " + key)
+		defer unlock()
+
+		record := types.IdempotencyRecord{
+			Scope:       scope,
+			Key:         key,
+			Fingerprint: fingerprint(r, body),
+			ExpiresAt:   time.Now().Add(k.ttl),
+		}
+
+		existing, err := k.store.ClaimKey(r.Context(), record)
+		if err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		if existing != nil {
+			switch {
+			case existing.Fingerprint != record.Fingerprint:
+				utils.WriteError(w, http.StatusUnprocessableEntity, fmt.Errorf("%s was already used for a different request", KeyHeader))
+			case existing.Status == 0:
+				utils.WriteError(w, http.StatusConflict, fmt.Errorf("a request with this %s is still in progress", KeyHeader))
+			default:
+				replay(w, existing)
+			}
+			return
+		}
+
+		// what happens to the key is settled even when the request's own
+		// context has run out
+		ctx := context.WithoutCancel(r.Context())
+
+		// a handler that panics leaves nothing to replay, so its key is
+		// given back for the retry
+		saved := false
+		defer func() {
+			if !saved {
+				if err := k.store.ReleaseKey(ctx, scope, key); err != nil {
+					log.Printf("idempotency: releasing key failed: %v", err)
+				}
+			}
+		}()
+
+		abandoned := false
+		r = r.WithContext(context.WithValue(r.Context(), abandonedKey{}, &abandoned))
+
+		recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		fn(recorder, r)
+
+		if abandoned {
+			return
+		}
+
+		record.Status = recorder.status
+		record.ContentType = recorder.Header().Get("Content-Type")
+		record.Body = recorder.body.Bytes()
+
+		// the request has run, so the key stays claimed even if the response
+		// cannot be kept: retries are refused rather than run twice
+		if err := k.store.SaveResponse(ctx, record); err != nil {
+			log.Printf("idempotency: saving response failed: %v", err)
+		}
+		saved = true
+	}
+}
+
+// lock serializes the requests for a key on this instance.
+func (k *Keys) lock(name string) func() {
+	k.mu.Lock()
+	held, ok := k.inFlight[name]
+	if !ok {
+		held = &keyLock{}
+		k.inFlight[name] = held
+	}
+	held.holders++
+	k.mu.Unlock()
+
+	held.mu.Lock()
+
+	return func() {
+		held.mu.Unlock()
+
+		k.mu.Lock()
+		held.holders--
+		if held.holders == 0 {
+			delete(k.inFlight, name)
+		}
+		k.mu.Unlock()
+	}
+}
+
+// scopeOf names the client making the request: the signed in user, or the
+// guest cart for guests. Requests from neither cannot be told apart.
+func scopeOf(r *http.Request) (string, bool) {
+	if userId := auth.GetUserIdFromContext(r.Context()); userId > 0 {
+		return fmt.Sprintf("user:%d", userId), true
+	}
+
+	if cartId, err := auth.ParseCartToken([]byte(config.Envs.CartTokenSecret), r.Header.Get(auth.CartTokenHeader)); err == nil {
+		return "cart:" + cartId, true
+	}
+
+	return "", false
+}
+
+// fingerprint identifies a request by its method, URL and body.
+func fingerprint(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s %s\n", r.Method, r.URL.RequestURI())
+	hash.Write(body)
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func replay(w http.ResponseWriter, record *types.IdempotencyRecord) {
+	if record.ContentType != "" {
+		w.Header().Set("Content-Type", record.ContentType)
+	}
+	w.Header().Set(ReplayedHeader, "true")
+	w.WriteHeader(record.Status)
+	w.Write(record.Body)
+}
+
+// responseRecorder passes a response through while keeping a copy of it.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status, r.wroteHeader = status, true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	r.body.Write(b)
+
+	return r.ResponseWriter.Write(b)
+}
@@ -0,0 +1,195 @@
+package idempotency
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/xelathan/golang_backend/services/auth"
+	"github.com/xelathan/golang_backend/types"
+	"github.com/xelathan/golang_backend/utils"
+)
+
+func TestKeys(t *testing.T) {
+	setup := func() (http.HandlerFunc, *int32) {
+		keys := NewKeys(&mockIdempotencyStore{records: map[string]types.IdempotencyRecord{}}, time.Hour, time.Hour)
+
+		var calls int32
+		handler := keys.Wrap(func(w http.ResponseWriter, r *http.Request) {
+			n := atomic.AddInt32(&calls, 1)
+			// long enough for concurrent duplicates to pile up
+			time.Sleep(10 * time.Millisecond)
+			utils.WriteJSON(w, http.StatusCreated, map[string]int32{"order": n})
+		})
+
+		return handler, &calls
+	}
+
+	send := func(handler http.HandlerFunc, key string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/cart/checkout", strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserKey, 7))
+		if key != "" {
+			req.Header.Set(KeyHeader, key)
+		}
+
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+
+		return rr
+	}
+
+	t.Run("should replay the first response to a repeat", func(t *testing.T) {
+		handler, calls := setup()
+
+		first := send(handler, "abc", `{"currency":"USD"}`)
+		second := send(handler, "abc", `{"currency":"USD"}`)
+
+		if *calls != 1 {
+			t.Fatalf("expected the handler to run once, ran %d times", *calls)
+		}
+
+		if second.Code != http.StatusCreated || second.Body.String() != first.Body.String() || second.Header().Get(ReplayedHeader) != "true" {
+			t.Errorf("expected the first response replayed, got %d %s", second.Code, second.Body.String())
+		}
+	})
+
+	t.Run("should refuse a key reused for a different request", func(t *testing.T) {
+		handler, calls := setup()
+
+		send(handler, "abc", `{"currency":"USD"}`)
+		if rr := send(handler, "abc", `{"currency":"EUR"}`); rr.Code != http.StatusUnprocessableEntity {
+			t.Errorf("expected status code %d, got %d", http.StatusUnprocessableEntity, rr.Code)
+		}
+
+		if *calls != 1 {
+			t.Errorf("expected the handler to run once, ran %d times", *calls)
+		}
+	})
+
+	t.Run("should run concurrent duplicates once", func(t *testing.T) {
+		handler, calls := setup()
+
+		var wg sync.WaitGroup
+		codes := make([]int, 5)
+		for i := range codes {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				codes[i] = send(handler, "abc", `{}`).Code
+			}(i)
+		}
+		wg.Wait()
+
+		if *calls != 1 {
+			t.Errorf("expected the handler to run once, ran %d times", *calls)
+		}
+
+		for _, code := range codes {
+			if code != http.StatusCreated {
+				t.Errorf("expected every duplicate to get status code %d, got %d", http.StatusCreated, code)
+			}
+		}
+	})
+
+	t.Run("should run a request again after an error it abandoned", func(t *testing.T) {
+		keys := NewKeys(&mockIdempotencyStore{records: map[string]types.IdempotencyRecord{}}, time.Hour, time.Hour)
+
+		calls := 0
+		handler := keys.Wrap(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			if calls == 1 {
+				Abandon(r)
+				utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("database unavailable"))
+				return
+			}
+			utils.WriteJSON(w, http.StatusCreated, map[string]int{"order": calls})
+		})
+
+		if rr := send(handler, "abc", `{}`); rr.Code != http.StatusInternalServerError {
+			t.Fatalf("expected status code %d, got %d", http.StatusInternalServerError, rr.Code)
+		}
+
+		if rr := send(handler, "abc", `{}`); rr.Code != http.StatusCreated || rr.Header().Get(ReplayedHeader) != "" {
+			t.Errorf("expected the retry to run, got %d %s", rr.Code, rr.Body.String())
+		}
+
+		if calls != 2 {
+			t.Errorf("expected the handler to run twice, ran %d times", calls)
+		}
+	})
+
+	t.Run("should replay a server error that may have committed work", func(t *testing.T) {
+		keys := NewKeys(&mockIdempotencyStore{records: map[string]types.IdempotencyRecord{}}, time.Hour, time.Hour)
+
+		calls := 0
+		handler := keys.Wrap(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("payment provider unavailable"))
+		})
+
+		send(handler, "abc", `{}`)
+		if rr := send(handler, "abc", `{}`); rr.Code != http.StatusInternalServerError || rr.Header().Get(ReplayedHeader) != "true" {
+			t.Errorf("expected the error replayed, got %d %s", rr.Code, rr.Body.String())
+		}
+
+		if calls != 1 {
+			t.Errorf("expected the handler to run once, ran %d times", calls)
+		}
+	})
+
+	t.Run("should run requests without a key every time", func(t *testing.T) {
+		handler, calls := setup()
+
+		send(handler, "", `{}`)
+		send(handler, "", `{}`)
+
+		if *calls != 2 {
+			t.Errorf("expected the handler to run twice, ran %d times", *calls)
+		}
+	})
+}
+
+type mockIdempotencyStore struct {
+	mu      sync.Mutex
+	records map[string]types.IdempotencyRecord
+}
+
+func (m *mockIdempotencyStore) ClaimKey(ctx context.Context, record types.IdempotencyRecord) (*types.IdempotencyRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if existing, ok := m.records[record.Scope+record.Key]; ok {
+		return &existing, nil
+	}
+	m.records[record.Scope+record.Key] = record
+
+	return nil, nil
+}
+
+func (m *mockIdempotencyStore) SaveResponse(ctx context.Context, record types.IdempotencyRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.records[record.Scope+record.Key] = record
+
+	return nil
+}
+
+func (m *mockIdempotencyStore) ReleaseKey(ctx context.Context, scope string, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.records, scope+key)
+
+	return nil
+}
+
+func (m *mockIdempotencyStore) DeleteExpiredKeys(ctx context.Context) (int64, error) {
+	return 0, nil
+}
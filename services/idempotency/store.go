@@ -0,0 +1,97 @@
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/xelathan/golang_backend/types"
+)
+
+// maxClaimAttempts bounds how often a claim is retried when the key it
+// lost to is released before it can be read.
+const maxClaimAttempts = 3
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+func (s *Store) ClaimKey(ctx context.Context, record types.IdempotencyRecord) (*types.IdempotencyRecord, error) {
+	// the key can be released between the insert and the select, in which
+	// case the claim is tried again
+	for range maxClaimAttempts {
+		existing, err := s.claimKey(ctx, record)
+		if err != sql.ErrNoRows {
+			return existing, err
+		}
+	}
+
+	return nil, fmt.Errorf("could not claim %s after %d attempts", record.Key, maxClaimAttempts)
+}
+
+func (s *Store) claimKey(ctx context.Context, record types.IdempotencyRecord) (*types.IdempotencyRecord, error) {
+	// an expired key is free to be used again
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE scope = ? AND `key` = ? AND expiresAt <= ?", record.Scope, record.Key, time.Now()); err != nil {
+		return nil, err
+	}
+
+	// of concurrent claims for the same key only one insert goes through
+	res, err := s.db.ExecContext(ctx,
+		"INSERT IGNORE INTO idempotency_keys (scope, `key`, fingerprint, expiresAt) VALUES (?,?,?,?)",
+		record.Scope, record.Key, record.Fingerprint, record.ExpiresAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if affected == 1 {
+		return nil, nil
+	}
+
+	existing := types.IdempotencyRecord{}
+	var body []byte
+	err = s.db.QueryRowContext(ctx,
+		"SELECT scope, `key`, fingerprint, status, contentType, body, createdAt, expiresAt FROM idempotency_keys WHERE scope = ? AND `key` = ?",
+		record.Scope, record.Key,
+	).Scan(&existing.Scope, &existing.Key, &existing.Fingerprint, &existing.Status, &existing.ContentType, &body, &existing.CreatedAt, &existing.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	existing.Body = body
+
+	return &existing, nil
+}
+
+func (s *Store) SaveResponse(ctx context.Context, record types.IdempotencyRecord) error {
+	_, err := s.db.ExecContext(ctx,
+		"UPDATE idempotency_keys SET status = ?, contentType = ?, body = ? WHERE scope = ? AND `key` = ?",
+		record.Status, record.ContentType, record.Body, record.Scope, record.Key,
+	)
+
+	return err
+}
+
+func (s *Store) ReleaseKey(ctx context.Context, scope string, key string) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE scope = ? AND `key` = ? AND status = 0", scope, key)
+
+	return err
+}
+
+func (s *Store) DeleteExpiredKeys(ctx context.Context) (int64, error) {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE expiresAt <= ?", time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
@@ -0,0 +1,76 @@
+package inventory
+
+import (
+	"context"
+
+	"github.com/xelathan/golang_backend/types"
+)
+
+// Allocator hands stock that comes in to the backordered order items of its
+// product, oldest first. A line is allocated whole or not at all, and the
+// lines after one that does not fit wait behind it so nobody is overtaken by
+// a smaller order.
+type Allocator struct {
+	uow          types.UnitOfWork
+	productStore types.ProductStore
+	orderStore   types.OrderStore
+}
+
+func NewAllocator(uow types.UnitOfWork, productStore types.ProductStore, orderStore types.OrderStore) *Allocator {
+	return &Allocator{uow: uow, productStore: productStore, orderStore: orderStore}
+}
+
+// Allocate allocates what the products have in stock to their backorders,
+// one product at a time.
+func (a *Allocator) Allocate(ctx context.Context, productIDs []int) error {
+	for _, productID := range productIDs {
+		err := a.uow.Do(ctx, func(tx types.DB) error {
+			productStore := a.productStore.WithTx(tx)
+			orderStore := a.orderStore.WithTx(tx)
+
+			products, err := productStore.LockProductsByID(ctx, []int{productID})
+			if err != nil || len(products) == 0 {
+				return err
+			}
+
+			items, err := orderStore.GetBackorderedItems(ctx, productID)
+			if err != nil {
+				return err
+			}
+
+			product, allocated := allocate(products[0], items)
+			if len(allocated) == 0 {
+				return nil
+			}
+
+			if err := orderStore.AllocateOrderItems(ctx, allocated); err != nil {
+				return err
+			}
+
+			return productStore.UpdateProductBatch(ctx, map[int]types.Product{product.ID: product})
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// allocate takes the backordered items in order from the product's stock
+// until one does not fit, and returns the product left and the ids of the
+// items allocated.
+func allocate(product types.Product, items []types.OrderItem) (types.Product, []int) {
+	allocated := []int{}
+	for _, item := range items {
+		if item.Quantity > product.Quantity {
+			break
+		}
+
+		product.Quantity -= item.Quantity
+		product.Backordered = max(product.Backordered-item.Quantity, 0)
+		allocated = append(allocated, item.ID)
+	}
+
+	return product, allocated
+}
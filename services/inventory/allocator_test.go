@@ -0,0 +1,73 @@
+package inventory
+
+import (
+	"context"
+	"testing"
+
+	"github.com/xelathan/golang_backend/types"
+)
+
+func TestAllocator(t *testing.T) {
+	t.Run("should allocate backorders oldest first until one does not fit", func(t *testing.T) {
+		productStore := &mockProductStore{products: []types.Product{{ID: 1, Quantity: 4, Backordered: 6}}}
+		orderStore := &mockAllocatorOrderStore{items: []types.OrderItem{
+			{ID: 10, ProductID: 1, Quantity: 1},
+			{ID: 11, ProductID: 1, Quantity: 2},
+			{ID: 12, ProductID: 1, Quantity: 2},
+			{ID: 13, ProductID: 1, Quantity: 1},
+		}}
+		allocator := NewAllocator(&mockUnitOfWork{}, productStore, orderStore)
+
+		if err := allocator.Allocate(context.Background(), []int{1}); err != nil {
+			t.Fatal(err)
+		}
+
+		// the last item would fit in what is left but waits behind the third
+		if len(orderStore.allocated) != 2 || orderStore.allocated[0] != 10 || orderStore.allocated[1] != 11 {
+			t.Errorf("expected the first two items allocated, got %v", orderStore.allocated)
+		}
+
+		if product := productStore.products[0]; product.Quantity != 1 || product.Backordered != 3 {
+			t.Errorf("expected 1 unit left and 3 on backorder, got %d and %d", product.Quantity, product.Backordered)
+		}
+	})
+
+	t.Run("should leave the product alone when nothing fits", func(t *testing.T) {
+		productStore := &mockProductStore{products: []types.Product{{ID: 1, Quantity: 1, Backordered: 2}}}
+		orderStore := &mockAllocatorOrderStore{items: []types.OrderItem{{ID: 10, ProductID: 1, Quantity: 2}}}
+
+		if err := NewAllocator(&mockUnitOfWork{}, productStore, orderStore).Allocate(context.Background(), []int{1}); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(orderStore.allocated) != 0 || productStore.updates != 0 {
+			t.Errorf("expected nothing allocated, got %v", orderStore.allocated)
+		}
+	})
+}
+
+type mockUnitOfWork struct{}
+
+func (m *mockUnitOfWork) Do(ctx context.Context, fn func(tx types.DB) error) error {
+	return fn(nil)
+}
+
+type mockAllocatorOrderStore struct {
+	types.OrderStore
+
+	items     []types.OrderItem
+	allocated []int
+}
+
+func (m *mockAllocatorOrderStore) WithTx(tx types.DB) types.OrderStore {
+	return m
+}
+
+func (m *mockAllocatorOrderStore) GetBackorderedItems(ctx context.Context, productId int) ([]types.OrderItem, error) {
+	return m.items, nil
+}
+
+func (m *mockAllocatorOrderStore) AllocateOrderItems(ctx context.Context, ids []int) error {
+	m.allocated = append(m.allocated, ids...)
+	return nil
+}
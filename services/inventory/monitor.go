@@ -0,0 +1,252 @@
+package inventory
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/xelathan/golang_backend/types"
+)
+
+const (
+	EventLowStock    = "stock.low"
+	EventOutOfStock  = "stock.out"
+	EventBackInStock = "stock.back_in_stock"
+)
+
+// Monitor watches product quantities and notifies staff when a product drops
+// to its reorder threshold, and subscribed customers when an out of stock
+// product is replenished. Checks run in the background: product ids are queued
+// after every batch update and a periodic sweep catches changes made outside
+// of the API. Products with stock and units on backorder are handed to the
+// allocator, when one is set.
+type Monitor struct {
+	productStore   types.ProductStore
+	inventoryStore types.InventoryStore
+	notifier       types.Notifier
+	staff          []string
+	interval       time.Duration
+	queue          chan []int
+	allocator      *Allocator
+}
+
+func NewMonitor(productStore types.ProductStore, inventoryStore types.InventoryStore, notifier types.Notifier, staff []string, interval time.Duration) *Monitor {
+	return &Monitor{
+		productStore:   productStore,
+		inventoryStore: inventoryStore,
+		notifier:       notifier,
+		staff:          staff,
+		interval:       interval,
+		queue:          make(chan []int, 100),
+	}
+}
+
+// SetAllocator has the monitor allocate stock to backorders as it comes in.
+// The allocator updates products through the store the monitor watches, so
+// it is set once both exist.
+func (m *Monitor) SetAllocator(allocator *Allocator) {
+	m.allocator = allocator
+}
+
+// Start runs the monitor loop in its own goroutine.
+func (m *Monitor) Start() {
+	go m.run()
+}
+
+func (m *Monitor) run() {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case ids := <-m.queue:
+			if err := m.CheckProducts(context.Background(), ids); err != nil {
+				log.Printf("inventory: check failed: %v", err)
+			}
+		case <-ticker.C:
+			if err := m.Sweep(context.Background()); err != nil {
+				log.Printf("inventory: sweep failed: %v", err)
+			}
+		}
+	}
+}
+
+// Enqueue schedules a check for the given products. It never blocks the
+// caller; if the queue is full the ids are dropped and left to the next sweep.
+func (m *Monitor) Enqueue(productIDs []int) {
+	if len(productIDs) == 0 {
+		return
+	}
+
+	select {
+	case m.queue <- productIDs:
+	default:
+		log.Printf("inventory: queue full, deferring %d products to next sweep", len(productIDs))
+	}
+}
+
+func (m *Monitor) Sweep(ctx context.Context) error {
+	products, err := m.productStore.GetProducts(ctx)
+	if err != nil {
+		return err
+	}
+
+	return m.check(ctx, products)
+}
+
+func (m *Monitor) CheckProducts(ctx context.Context, productIDs []int) error {
+	products, err := m.productStore.GetProductsByID(ctx, productIDs)
+	if err != nil {
+		return err
+	}
+
+	return m.check(ctx, products)
+}
+
+func (m *Monitor) check(ctx context.Context, products []types.Product) error {
+	if len(products) == 0 {
+		return nil
+	}
+
+	ids := make([]int, len(products))
+	backordered := []int{}
+	for i, product := range products {
+		ids[i] = product.ID
+		if product.Quantity > 0 && product.Backordered > 0 {
+			backordered = append(backordered, product.ID)
+		}
+	}
+
+	// the allocation updates the products again, which queues another check
+	// with the quantities left
+	if m.allocator != nil && len(backordered) > 0 {
+		if err := m.allocator.Allocate(ctx, backordered); err != nil {
+			log.Printf("inventory: allocating backorders failed: %v", err)
+		}
+	}
+
+	levels, err := m.inventoryStore.GetStockLevels(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	for _, product := range products {
+		below := product.Quantity <= product.ReorderThreshold
+
+		// a product seen for the first time has no previous level: treat its
+		// current quantity as the previous one so only the low stock alert fires
+		previous, seen := levels[product.ID]
+		if !seen {
+			previous = types.StockLevel{ProductID: product.ID, LastQuantity: product.Quantity}
+		}
+
+		if below && !previous.BelowThreshold {
+			m.notifyStaff(product)
+		}
+
+		if previous.LastQuantity == 0 && product.Quantity > 0 {
+			if err := m.notifySubscribers(ctx, product); err != nil {
+				return err
+			}
+		}
+
+		if seen && previous.LastQuantity == product.Quantity && previous.BelowThreshold == below {
+			continue
+		}
+
+		if err := m.inventoryStore.UpsertStockLevel(ctx, types.StockLevel{
+			ProductID:      product.ID,
+			LastQuantity:   product.Quantity,
+			BelowThreshold: below,
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *Monitor) notifyStaff(product types.Product) {
+	event, subject := EventLowStock, fmt.Sprintf("Low stock: %s", product.Name)
+	if product.Quantity == 0 {
+		event, subject = EventOutOfStock, fmt.Sprintf("Out of stock: %s", product.Name)
+	}
+
+	message := fmt.Sprintf("Product %d (%s) has %d units left, reorder threshold is %d.", product.ID, product.Name, product.Quantity, product.ReorderThreshold)
+
+	// with no staff configured the alert is still sent once without a
+	// recipient, so log and webhook notifiers can pick it up
+	recipients := m.staff
+	if len(recipients) == 0 {
+		recipients = []string{""}
+	}
+
+	for _, recipient := range recipients {
+		if err := m.notifier.Notify(types.Notification{
+			Event:     event,
+			Recipient: recipient,
+			Subject:   subject,
+			Message:   message,
+		}); err != nil {
+			log.Printf("inventory: failed to notify staff %q: %v", recipient, err)
+		}
+	}
+}
+
+func (m *Monitor) notifySubscribers(ctx context.Context, product types.Product) error {
+	subscriptions, err := m.inventoryStore.GetPendingStockSubscriptions(ctx, product.ID)
+	if err != nil {
+		return err
+	}
+
+	for _, subscription := range subscriptions {
+		if err := m.notifier.Notify(types.Notification{
+			Event:     EventBackInStock,
+			Recipient: subscription.Email,
+			Subject:   fmt.Sprintf("%s is back in stock", product.Name),
+			Message:   fmt.Sprintf("Good news! %s is available again.", product.Name),
+		}); err != nil {
+			// leave the subscription pending so the next crossing retries it
+			log.Printf("inventory: failed to notify subscriber %d: %v", subscription.ID, err)
+			continue
+		}
+
+		if err := m.inventoryStore.MarkStockSubscriptionNotified(ctx, subscription.ID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MonitoredProductStore wraps a ProductStore and queues a stock check for
+// every product touched by UpdateProductBatch.
+type MonitoredProductStore struct {
+	types.ProductStore
+	monitor *Monitor
+}
+
+func NewMonitoredProductStore(store types.ProductStore, monitor *Monitor) *MonitoredProductStore {
+	return &MonitoredProductStore{ProductStore: store, monitor: monitor}
+}
+
+// WithTx binds the wrapped store. Checks queued in a transaction can run
+// before it commits, which the periodic sweep makes up for.
+func (s *MonitoredProductStore) WithTx(tx types.DB) types.ProductStore {
+	return &MonitoredProductStore{ProductStore: s.ProductStore.WithTx(tx), monitor: s.monitor}
+}
+
+func (s *MonitoredProductStore) UpdateProductBatch(ctx context.Context, products map[int]types.Product) error {
+	if err := s.ProductStore.UpdateProductBatch(ctx, products); err != nil {
+		return err
+	}
+
+	ids := make([]int, 0, len(products))
+	for id := range products {
+		ids = append(ids, id)
+	}
+	s.monitor.Enqueue(ids)
+
+	return nil
+}
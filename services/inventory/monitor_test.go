@@ -0,0 +1,156 @@
+package inventory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/xelathan/golang_backend/types"
+)
+
+func TestMonitor(t *testing.T) {
+	t.Run("should notify staff once when a product crosses its threshold", func(t *testing.T) {
+		productStore := &mockProductStore{products: []types.Product{{ID: 1, Name: "mug", Quantity: 10, ReorderThreshold: 5}}}
+		inventoryStore := newMockInventoryStore()
+		notifier := &mockNotifier{}
+		monitor := NewMonitor(productStore, inventoryStore, notifier, []string{"staff@example.com"}, time.Minute)
+
+		if err := monitor.CheckProducts(context.Background(), []int{1}); err != nil {
+			t.Fatal(err)
+		}
+		if len(notifier.sent) != 0 {
+			t.Fatalf("expected no notifications above threshold, got %d", len(notifier.sent))
+		}
+
+		productStore.products[0].Quantity = 4
+		if err := monitor.CheckProducts(context.Background(), []int{1}); err != nil {
+			t.Fatal(err)
+		}
+		productStore.products[0].Quantity = 3
+		if err := monitor.CheckProducts(context.Background(), []int{1}); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(notifier.sent) != 1 || notifier.sent[0].Event != EventLowStock {
+			t.Errorf("expected a single low stock notification, got %v", notifier.sent)
+		}
+	})
+
+	t.Run("should notify subscribers when a product comes back in stock", func(t *testing.T) {
+		productStore := &mockProductStore{products: []types.Product{{ID: 1, Name: "mug", Quantity: 0}}}
+		inventoryStore := newMockInventoryStore()
+		inventoryStore.subscriptions = []types.StockSubscription{{ID: 7, ProductID: 1, UserID: 2, Email: "customer@example.com"}}
+		notifier := &mockNotifier{}
+		monitor := NewMonitor(productStore, inventoryStore, notifier, nil, time.Minute)
+
+		if err := monitor.CheckProducts(context.Background(), []int{1}); err != nil {
+			t.Fatal(err)
+		}
+
+		productStore.products[0].Quantity = 5
+		if err := monitor.CheckProducts(context.Background(), []int{1}); err != nil {
+			t.Fatal(err)
+		}
+
+		last := notifier.sent[len(notifier.sent)-1]
+		if last.Event != EventBackInStock || last.Recipient != "customer@example.com" {
+			t.Errorf("expected back in stock notification to subscriber, got %v", last)
+		}
+
+		if inventoryStore.subscriptions[0].NotifiedAt == nil {
+			t.Errorf("expected subscription to be marked as notified")
+		}
+	})
+}
+
+type mockProductStore struct {
+	types.ProductStore
+	products []types.Product
+	updates  int
+}
+
+func (m *mockProductStore) WithTx(tx types.DB) types.ProductStore {
+	return m
+}
+
+func (m *mockProductStore) LockProductsByID(ctx context.Context, productIDs []int) ([]types.Product, error) {
+	return m.products, nil
+}
+
+func (m *mockProductStore) UpdateProductBatch(ctx context.Context, products map[int]types.Product) error {
+	m.updates++
+	for i, product := range m.products {
+		if updated, ok := products[product.ID]; ok {
+			m.products[i] = updated
+		}
+	}
+	return nil
+}
+
+func (m *mockProductStore) GetProducts(ctx context.Context) ([]types.Product, error) {
+	return m.products, nil
+}
+
+func (m *mockProductStore) GetProductsByID(ctx context.Context, productIDs []int) ([]types.Product, error) {
+	return m.products, nil
+}
+
+type mockInventoryStore struct {
+	levels        map[int]types.StockLevel
+	subscriptions []types.StockSubscription
+}
+
+func newMockInventoryStore() *mockInventoryStore {
+	return &mockInventoryStore{levels: map[int]types.StockLevel{}}
+}
+
+func (m *mockInventoryStore) GetStockLevels(ctx context.Context, productIDs []int) (map[int]types.StockLevel, error) {
+	levels := map[int]types.StockLevel{}
+	for k, v := range m.levels {
+		levels[k] = v
+	}
+	return levels, nil
+}
+
+func (m *mockInventoryStore) UpsertStockLevel(ctx context.Context, level types.StockLevel) error {
+	m.levels[level.ProductID] = level
+	return nil
+}
+
+func (m *mockInventoryStore) CreateStockSubscription(ctx context.Context, subscription types.StockSubscription) error {
+	m.subscriptions = append(m.subscriptions, subscription)
+	return nil
+}
+
+func (m *mockInventoryStore) DeleteStockSubscription(ctx context.Context, productId int, userId int) error {
+	return nil
+}
+
+func (m *mockInventoryStore) GetPendingStockSubscriptions(ctx context.Context, productId int) ([]types.StockSubscription, error) {
+	pending := []types.StockSubscription{}
+	for _, s := range m.subscriptions {
+		if s.ProductID == productId && s.NotifiedAt == nil {
+			pending = append(pending, s)
+		}
+	}
+	return pending, nil
+}
+
+func (m *mockInventoryStore) MarkStockSubscriptionNotified(ctx context.Context, id int) error {
+	now := time.Now()
+	for i := range m.subscriptions {
+		if m.subscriptions[i].ID == id {
+			m.subscriptions[i].NotifiedAt = &now
+		}
+	}
+	return nil
+}
+
+type mockNotifier struct {
+	sent []types.Notification
+}
+
+func (m *mockNotifier) Notify(notification types.Notification) error {
+	m.sent = append(m.sent, notification)
+	return nil
+}
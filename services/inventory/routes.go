@@ -0,0 +1,95 @@
+package inventory
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/xelathan/golang_backend/services/auth"
+	"github.com/xelathan/golang_backend/types"
+	"github.com/xelathan/golang_backend/utils"
+)
+
+type Handler struct {
+	store        types.InventoryStore
+	productStore types.ProductStore
+	userStore    types.UserStore
+}
+
+func NewHandler(store types.InventoryStore, productStore types.ProductStore, userStore types.UserStore) *Handler {
+	return &Handler{store: store, productStore: productStore, userStore: userStore}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/products/{id}/notify_me", auth.WithJWTAuth(h.handleSubscribe, h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/products/{id}/notify_me", auth.WithJWTAuth(h.handleUnsubscribe, h.userStore)).Methods(http.MethodDelete)
+}
+
+func (h *Handler) handleSubscribe(w http.ResponseWriter, r *http.Request) {
+	userId := auth.GetUserIdFromContext(r.Context())
+	if userId == -1 {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid userId"))
+		return
+	}
+
+	productId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid product id"))
+		return
+	}
+
+	products, err := h.productStore.GetProductsByID(r.Context(), []int{productId})
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if len(products) == 0 {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("product not found"))
+		return
+	}
+
+	if products[0].Quantity > 0 {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("product is in stock"))
+		return
+	}
+
+	user, err := h.userStore.GetUserById(r.Context(), userId)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := h.store.CreateStockSubscription(r.Context(), types.StockSubscription{
+		ProductID: productId,
+		UserID:    userId,
+		Email:     user.Email,
+	}); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, map[string]string{"status": "subscribed"})
+}
+
+func (h *Handler) handleUnsubscribe(w http.ResponseWriter, r *http.Request) {
+	userId := auth.GetUserIdFromContext(r.Context())
+	if userId == -1 {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid userId"))
+		return
+	}
+
+	productId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid product id"))
+		return
+	}
+
+	if err := h.store.DeleteStockSubscription(r.Context(), productId, userId); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]string{"status": "unsubscribed"})
+}
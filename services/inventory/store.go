@@ -0,0 +1,129 @@
+package inventory
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/xelathan/golang_backend/types"
+)
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+func (s *Store) GetStockLevels(ctx context.Context, productIDs []int) (map[int]types.StockLevel, error) {
+	levels := map[int]types.StockLevel{}
+	if len(productIDs) == 0 {
+		return levels, nil
+	}
+
+	placeholders := strings.Repeat(",?", len(productIDs)-1)
+	query := fmt.Sprintf("SELECT productId, lastQuantity, belowThreshold, updatedAt FROM product_stock_levels WHERE productId IN (?%s)", placeholders)
+
+	args := make([]interface{}, len(productIDs))
+	for i, v := range productIDs {
+		args[i] = v
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		level := types.StockLevel{}
+		err := rows.Scan(&level.ProductID, &level.LastQuantity, &level.BelowThreshold, &level.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		levels[level.ProductID] = level
+	}
+
+	return levels, rows.Err()
+}
+
+func (s *Store) UpsertStockLevel(ctx context.Context, level types.StockLevel) error {
+	query := "INSERT INTO product_stock_levels (productId, lastQuantity, belowThreshold) VALUES (?,?,?) ON DUPLICATE KEY UPDATE lastQuantity = VALUES(lastQuantity), belowThreshold = VALUES(belowThreshold)"
+	_, err := s.db.ExecContext(ctx, query, level.ProductID, level.LastQuantity, level.BelowThreshold)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *Store) CreateStockSubscription(ctx context.Context, subscription types.StockSubscription) error {
+	pending, err := s.GetPendingStockSubscriptions(ctx, subscription.ProductID)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range pending {
+		if p.UserID == subscription.UserID {
+			return nil
+		}
+	}
+
+	_, err = s.db.ExecContext(ctx, "INSERT INTO stock_subscriptions (productId, userId, email) VALUES (?,?,?)", subscription.ProductID, subscription.UserID, subscription.Email)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *Store) DeleteStockSubscription(ctx context.Context, productId int, userId int) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM stock_subscriptions WHERE productId = ? AND userId = ? AND notifiedAt IS NULL", productId, userId)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *Store) GetPendingStockSubscriptions(ctx context.Context, productId int) ([]types.StockSubscription, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT * FROM stock_subscriptions WHERE productId = ? AND notifiedAt IS NULL ORDER BY createdAt", productId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subscriptions := []types.StockSubscription{}
+	for rows.Next() {
+		subscription, err := scanRowIntoStockSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		subscriptions = append(subscriptions, *subscription)
+	}
+
+	return subscriptions, rows.Err()
+}
+
+func scanRowIntoStockSubscription(rows *sql.Rows) (*types.StockSubscription, error) {
+	subscription := new(types.StockSubscription)
+	err := rows.Scan(&subscription.ID, &subscription.ProductID, &subscription.UserID, &subscription.Email, &subscription.CreatedAt, &subscription.NotifiedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return subscription, nil
+}
+
+func (s *Store) MarkStockSubscriptionNotified(ctx context.Context, id int) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE stock_subscriptions SET notifiedAt = CURRENT_TIMESTAMP WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,171 @@
+package loyalty
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"github.com/xelathan/golang_backend/services/auth"
+	"github.com/xelathan/golang_backend/types"
+	"github.com/xelathan/golang_backend/utils"
+)
+
+type Handler struct {
+	store          types.LoyaltyStore
+	promotionStore types.PromotionStore
+	userStore      types.UserStore
+	points         *Points
+}
+
+func NewHandler(store types.LoyaltyStore, promotionStore types.PromotionStore, userStore types.UserStore, points *Points) *Handler {
+	return &Handler{store: store, promotionStore: promotionStore, userStore: userStore, points: points}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/me/loyalty", auth.WithJWTAuth(h.handleGetLoyalty, h.userStore)).Methods(http.MethodGet)
+
+	router.HandleFunc("/admin/loyalty/rules", auth.WithAdminAuth(h.handleGetRules, h.userStore)).Methods(http.MethodGet)
+	router.HandleFunc("/admin/loyalty/rules", auth.WithAdminAuth(h.handleCreateRule, h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/admin/loyalty/rules/{id}/active", auth.WithAdminAuth(h.handleSetActive, h.userStore)).Methods(http.MethodPost)
+}
+
+// handleGetLoyalty answers with the caller's points, what their balance is
+// worth at checkout and their history, newest first.
+func (h *Handler) handleGetLoyalty(w http.ResponseWriter, r *http.Request) {
+	userId := auth.GetUserIdFromContext(r.Context())
+	if userId == -1 {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid userId"))
+		return
+	}
+
+	account, err := h.store.GetLoyaltyAccount(r.Context(), userId)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	account.Transactions, err = h.store.GetLoyaltyTransactions(r.Context(), userId)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]any{
+		"balance":        account.Balance,
+		"pending":        account.Pending,
+		"value":          h.points.Worth(account.Balance),
+		"lastActivityAt": account.LastActivityAt,
+		"transactions":   account.Transactions,
+	})
+}
+
+func (h *Handler) handleGetRules(w http.ResponseWriter, r *http.Request) {
+	rules, err := h.store.GetLoyaltyRules(r.Context())
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, rules)
+}
+
+func (h *Handler) handleCreateRule(w http.ResponseWriter, r *http.Request) {
+	payload := types.CreateLoyaltyRulePayload{}
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	rule, err := ruleFromPayload(payload)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if rule.PromotionID != nil {
+		promotions, err := h.promotionStore.GetPromotions(r.Context())
+		if err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		if !hasPromotion(promotions, *rule.PromotionID) {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("promotion %d not found", *rule.PromotionID))
+			return
+		}
+	}
+
+	rule.ID, err = h.store.CreateLoyaltyRule(r.Context(), rule)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, rule)
+}
+
+// ruleFromPayload checks the fields each kind of rule needs and leaves out
+// the ones it does not use.
+func ruleFromPayload(payload types.CreateLoyaltyRulePayload) (types.LoyaltyRule, error) {
+	rule := types.LoyaltyRule{Name: payload.Name, Kind: payload.Kind, Points: payload.Points, Active: true}
+
+	switch payload.Kind {
+	case types.LoyaltyCategory:
+		rule.Category = strings.TrimSpace(payload.Category)
+		if rule.Category == "" {
+			return rule, fmt.Errorf("category is required for category rules")
+		}
+	case types.LoyaltyPromotion:
+		if payload.PromotionID == nil {
+			return rule, fmt.Errorf("promotionId is required for promotion rules")
+		}
+		rule.PromotionID = payload.PromotionID
+	}
+
+	return rule, nil
+}
+
+func hasPromotion(promotions []types.Promotion, id int) bool {
+	for _, promotion := range promotions {
+		if promotion.ID == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (h *Handler) handleSetActive(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid loyalty rule id"))
+		return
+	}
+
+	payload := types.SetLoyaltyRuleActivePayload{}
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.store.SetLoyaltyRuleActive(r.Context(), id, payload.Active); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			utils.WriteError(w, http.StatusNotFound, err)
+			return
+		}
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]any{"id": id, "active": payload.Active})
+}
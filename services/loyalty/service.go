@@ -0,0 +1,224 @@
+package loyalty
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/xelathan/golang_backend/money"
+	"github.com/xelathan/golang_backend/services/currency"
+	"github.com/xelathan/golang_backend/types"
+)
+
+// Program runs the loyalty points program. Delivered orders earn points
+// under the active rules, which stay pending until the order's return
+// window closes. Refunds take back the points earned on what they refund,
+// and balances expire when a customer has neither earned nor spent points
+// for long enough. Points are spent at checkout through Points.
+type Program struct {
+	store          types.LoyaltyStore
+	orderStore     types.OrderStore
+	productStore   types.ProductStore
+	promotionStore types.PromotionStore
+	base           string
+	window         time.Duration
+	inactivity     time.Duration
+}
+
+// NewProgram makes points pending for the return window. Balances never
+// expire when inactivity is 0.
+func NewProgram(store types.LoyaltyStore, orderStore types.OrderStore, productStore types.ProductStore, promotionStore types.PromotionStore, base string, window time.Duration, inactivity time.Duration) *Program {
+	return &Program{
+		store:          store,
+		orderStore:     orderStore,
+		productStore:   productStore,
+		promotionStore: promotionStore,
+		base:           base,
+		window:         window,
+		inactivity:     inactivity,
+	}
+}
+
+// Start sweeps every interval.
+func (p *Program) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := p.Sweep(context.Background()); err != nil {
+				log.Printf("loyalty: sweep failed: %v", err)
+			}
+		}
+	}()
+}
+
+// Sweep earns points on the orders delivered since the last sweep, releases
+// the points whose return window closed, takes points back for new refunds
+// and expires inactive balances.
+func (p *Program) Sweep(ctx context.Context) error {
+	now := time.Now()
+	if err := p.earn(ctx, now); err != nil {
+		return err
+	}
+
+	if _, err := p.store.ReleasePoints(ctx, now); err != nil {
+		return err
+	}
+
+	refunds, err := p.store.GetUnreversedRefunds(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, refund := range refunds {
+		if _, err := p.store.ReversePoints(ctx, refund, Reversal(refund)); err != nil {
+			return err
+		}
+	}
+
+	if p.inactivity > 0 {
+		if _, err := p.store.ExpirePoints(ctx, now.Add(-p.inactivity)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// earn only looks back over the return window, or a day without one, so
+// orders delivered before the program started do not earn points.
+func (p *Program) earn(ctx context.Context, now time.Time) error {
+	orders, err := p.store.GetUnearnedOrders(ctx, now.Add(-max(p.window, 24*time.Hour)))
+	if err != nil || len(orders) == 0 {
+		return err
+	}
+
+	rules, err := p.store.GetLoyaltyRules(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, order := range orders {
+		items, err := p.orderStore.GetOrderItems(ctx, order.Order.ID)
+		if err != nil {
+			return err
+		}
+
+		discounts, err := p.promotionStore.GetOrderItemDiscounts(ctx, order.Order.ID)
+		if err != nil {
+			return err
+		}
+
+		ids := make([]int, len(items))
+		for i, item := range items {
+			ids[i] = item.ProductID
+		}
+
+		products, err := p.productStore.GetProductsByID(ctx, ids)
+		if err != nil {
+			return err
+		}
+
+		categories := map[int]string{}
+		for _, product := range products {
+			categories[product.ID] = product.Category
+		}
+
+		points, err := Earned(rules, order, items, discounts, categories, p.base)
+		if err != nil {
+			return err
+		}
+
+		// orders that earn nothing are looked at again until they leave the
+		// window, in case a rule is added meanwhile
+		if points == 0 {
+			continue
+		}
+
+		if err := p.store.EarnPoints(ctx, order.Order.UserId, order.Order.ID, points, order.DeliveredAt.Add(p.window)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Earned is the points the order earns under the active rules. Points are
+// earned on what the items cost after their discounts, converted to the base
+// currency at the order's exchange rate, in whole units. Tax and shipping do
+// not earn points.
+func Earned(rules []types.LoyaltyRule, order types.LoyaltyOrder, items []types.OrderItem, discounts []types.OrderItemDiscount, categories map[int]string, base string) (int, error) {
+	converter, err := currency.NewConverter(base, []types.ExchangeRate{{Currency: order.Order.Total.Currency, Rate: order.Order.ExchangeRate}})
+	if err != nil {
+		return 0, err
+	}
+
+	spent, byCategory := money.Zero(base), map[string]money.Money{}
+	for _, item := range items {
+		amount := item.Price.Mul(item.Quantity)
+		for _, d := range discounts {
+			if d.OrderItemID == item.ID {
+				amount = amount.Sub(d.Amount)
+			}
+		}
+
+		amount, err = converter.Convert(money.Max(amount, money.Zero(amount.Currency)), base)
+		if err != nil {
+			return 0, err
+		}
+
+		spent = spent.Add(amount)
+		byCategory[categories[item.ProductID]] = byCategory[categories[item.ProductID]].Add(amount)
+	}
+
+	promotions := map[int]bool{}
+	for _, id := range order.PromotionIDs {
+		promotions[id] = true
+	}
+
+	points := 0
+	for _, rule := range rules {
+		if !rule.Active {
+			continue
+		}
+
+		switch rule.Kind {
+		case types.LoyaltyPerUnit:
+			points += rule.Points * units(spent)
+		case types.LoyaltyCategory:
+			points += rule.Points * units(byCategory[rule.Category])
+		case types.LoyaltyPromotion:
+			if rule.PromotionID != nil && promotions[*rule.PromotionID] {
+				points += rule.Points
+			}
+		}
+	}
+
+	return points, nil
+}
+
+// Reversal is the points a refund takes back: the share of the points
+// earned that the refund is of the order's total, rounded half up, out of
+// those not taken back yet.
+func Reversal(refund types.EarnedRefund) int {
+	left := refund.Earned - refund.Reversed
+	if !refund.OrderTotal.IsPositive() {
+		return max(left, 0)
+	}
+
+	total := refund.OrderTotal.Amount
+	points := (2*int64(refund.Earned)*refund.Refund.Amount.Amount + total) / (2 * total)
+
+	return max(min(int(points), left), 0)
+}
+
+// units is the whole units of the amount's currency in it.
+func units(m money.Money) int {
+	amount := m.Amount
+	for i := 0; i < money.Exponent(m.Currency); i++ {
+		amount /= 10
+	}
+
+	return int(amount)
+}
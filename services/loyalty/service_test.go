@@ -0,0 +1,145 @@
+package loyalty
+
+import (
+	"context"
+	"testing"
+
+	"github.com/xelathan/golang_backend/money"
+	"github.com/xelathan/golang_backend/types"
+)
+
+func TestEarned(t *testing.T) {
+	promotionId := 3
+	rules := []types.LoyaltyRule{
+		{Kind: types.LoyaltyPerUnit, Points: 1, Active: true},
+		{Kind: types.LoyaltyCategory, Points: 2, Category: "coffee", Active: true},
+		{Kind: types.LoyaltyPromotion, Points: 50, PromotionID: &promotionId, Active: true},
+		{Kind: types.LoyaltyPerUnit, Points: 10, Active: false},
+	}
+	items := []types.OrderItem{
+		{ID: 1, ProductID: 1, Quantity: 2, Price: money.New(1250, "USD")},
+		{ID: 2, ProductID: 2, Quantity: 1, Price: money.New(999, "USD")},
+	}
+	categories := map[int]string{1: "coffee", 2: "mugs"}
+
+	t.Run("should earn on what the items cost after discounts", func(t *testing.T) {
+		order := types.LoyaltyOrder{Order: types.Order{Total: money.New(3700, "USD"), ExchangeRate: "1"}}
+		discounts := []types.OrderItemDiscount{{OrderItemID: 1, Amount: money.New(500, "USD")}}
+
+		// 20.00 of coffee and 9.99 of mugs
+		points, err := Earned(rules, order, items, discounts, categories, "USD")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if points != 29+2*20 {
+			t.Errorf("expected 69 points, got %d", points)
+		}
+	})
+
+	t.Run("should earn the promotion's bonus", func(t *testing.T) {
+		order := types.LoyaltyOrder{Order: types.Order{Total: money.New(3499, "USD"), ExchangeRate: "1"}, PromotionIDs: []int{promotionId}}
+
+		points, err := Earned(rules, order, items, nil, categories, "USD")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if points != 34+2*25+50 {
+			t.Errorf("expected 134 points, got %d", points)
+		}
+	})
+
+	t.Run("should earn in the base currency", func(t *testing.T) {
+		order := types.LoyaltyOrder{Order: types.Order{Total: money.New(4000, "EUR"), ExchangeRate: "0.8"}}
+		euros := []types.OrderItem{{ID: 1, ProductID: 2, Quantity: 1, Price: money.New(4000, "EUR")}}
+
+		points, err := Earned(rules, order, euros, nil, categories, "USD")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if points != 50 {
+			t.Errorf("expected 50 points for 50.00 USD, got %d", points)
+		}
+	})
+}
+
+func TestReversal(t *testing.T) {
+	cases := []struct {
+		name     string
+		refund   money.Money
+		reversed int
+		points   int
+	}{
+		{"share of the total", money.New(2500, "USD"), 0, 25},
+		{"rounded half up", money.New(1050, "USD"), 0, 11},
+		{"no more than left", money.New(10000, "USD"), 90, 10},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			points := Reversal(types.EarnedRefund{
+				Refund:     types.Refund{Amount: c.refund},
+				OrderTotal: money.New(10000, "USD"),
+				Earned:     100,
+				Reversed:   c.reversed,
+			})
+
+			if points != c.points {
+				t.Errorf("expected %d points, got %d", c.points, points)
+			}
+		})
+	}
+}
+
+func TestPoints(t *testing.T) {
+	store := &mockLoyaltyStore{balance: 1000, transactions: map[int]types.LoyaltyTransaction{}}
+	points := NewPoints(store, money.New(5, "USD"))
+
+	t.Run("should spend whole points only", func(t *testing.T) {
+		if _, err := points.Debit(context.Background(), "7", money.New(12, "USD"), 1); err == nil {
+			t.Error("expected 0.12 USD refused at 0.05 a point")
+		}
+
+		if _, err := points.Debit(context.Background(), "7", money.New(500, "USD"), 1); err != nil || store.balance != 900 {
+			t.Errorf("expected 100 points spent, got %d left and %v", store.balance, err)
+		}
+	})
+
+	t.Run("should give back no more than was spent", func(t *testing.T) {
+		reference, err := points.Debit(context.Background(), "7", money.New(100, "USD"), 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := points.Reverse(context.Background(), reference, money.New(104, "USD")); err != nil {
+			t.Fatal(err)
+		}
+
+		if store.balance != 900 {
+			t.Errorf("expected the 20 points given back, got %d left", store.balance)
+		}
+	})
+}
+
+type mockLoyaltyStore struct {
+	types.LoyaltyStore
+
+	balance      int
+	transactions map[int]types.LoyaltyTransaction
+}
+
+func (m *mockLoyaltyStore) AddPoints(ctx context.Context, userId int, kind types.LoyaltyEntryKind, points int, orderId *int) (int, error) {
+	m.balance += points
+
+	id := len(m.transactions) + 1
+	m.transactions[id] = types.LoyaltyTransaction{ID: id, UserID: userId, Kind: kind, Points: points, OrderID: orderId}
+
+	return id, nil
+}
+
+func (m *mockLoyaltyStore) GetLoyaltyTransaction(ctx context.Context, transactionId int) (*types.LoyaltyTransaction, error) {
+	transaction := m.transactions[transactionId]
+	return &transaction, nil
+}
@@ -0,0 +1,468 @@
+package loyalty
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/xelathan/golang_backend/db"
+	"github.com/xelathan/golang_backend/types"
+)
+
+var (
+	ErrNotFound            = errors.New("loyalty rule not found")
+	ErrTransactionNotFound = errors.New("loyalty transaction not found")
+	// ErrInsufficientPoints is returned when the points being spent are no
+	// longer there, usually because they were spent elsewhere at the same
+	// time.
+	ErrInsufficientPoints = errors.New("not enough loyalty points left to spend")
+)
+
+type Store struct {
+	db types.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+func (s *Store) WithTx(tx types.DB) types.LoyaltyStore {
+	return &Store{db: tx}
+}
+
+func (s *Store) CreateLoyaltyRule(ctx context.Context, rule types.LoyaltyRule) (int, error) {
+	res, err := s.db.ExecContext(ctx,
+		"INSERT INTO loyalty_rules (name, kind, points, category, promotionId, active) VALUES (?,?,?,?,?,?)",
+		rule.Name, rule.Kind, rule.Points, rule.Category, rule.PromotionID, rule.Active,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+func (s *Store) GetLoyaltyRules(ctx context.Context) ([]types.LoyaltyRule, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT id, name, kind, points, category, promotionId, active, createdAt FROM loyalty_rules ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []types.LoyaltyRule{}
+	for rows.Next() {
+		rule := types.LoyaltyRule{}
+		var promotionId sql.NullInt64
+		if err := rows.Scan(&rule.ID, &rule.Name, &rule.Kind, &rule.Points, &rule.Category, &promotionId, &rule.Active, &rule.CreatedAt); err != nil {
+			return nil, err
+		}
+		rule.PromotionID = nullableInt(promotionId)
+
+		rules = append(rules, rule)
+	}
+
+	return rules, rows.Err()
+}
+
+func (s *Store) SetLoyaltyRuleActive(ctx context.Context, id int, active bool) error {
+	res, err := s.db.ExecContext(ctx, "UPDATE loyalty_rules SET active = ? WHERE id = ?", active, id)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		var exists bool
+		if err := s.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM loyalty_rules WHERE id = ?)", id).Scan(&exists); err != nil {
+			return err
+		}
+
+		if !exists {
+			return ErrNotFound
+		}
+	}
+
+	return nil
+}
+
+func (s *Store) GetLoyaltyAccount(ctx context.Context, userId int) (*types.LoyaltyAccount, error) {
+	account := types.LoyaltyAccount{UserID: userId}
+	var lastActivityAt sql.NullTime
+	err := s.db.QueryRowContext(ctx, "SELECT balance, pending, lastActivityAt FROM loyalty_accounts WHERE userId = ?", userId).
+		Scan(&account.Balance, &account.Pending, &lastActivityAt)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	if lastActivityAt.Valid {
+		account.LastActivityAt = &lastActivityAt.Time
+	}
+
+	return &account, nil
+}
+
+func (s *Store) GetLoyaltyTransactions(ctx context.Context, userId int) ([]types.LoyaltyTransaction, error) {
+	return s.getLoyaltyTransactions(ctx, " WHERE userId = ? ORDER BY id DESC", userId)
+}
+
+func (s *Store) GetLoyaltyTransaction(ctx context.Context, transactionId int) (*types.LoyaltyTransaction, error) {
+	transactions, err := s.getLoyaltyTransactions(ctx, " WHERE id = ?", transactionId)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(transactions) == 0 {
+		return nil, ErrTransactionNotFound
+	}
+
+	return &transactions[0], nil
+}
+
+func (s *Store) getLoyaltyTransactions(ctx context.Context, suffix string, args ...any) ([]types.LoyaltyTransaction, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT id, userId, kind, points, orderId, refundId, pending, availableAt, createdAt FROM loyalty_transactions"+suffix,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transactions := []types.LoyaltyTransaction{}
+	for rows.Next() {
+		t := types.LoyaltyTransaction{}
+		var orderId, refundId sql.NullInt64
+		var availableAt sql.NullTime
+		if err := rows.Scan(&t.ID, &t.UserID, &t.Kind, &t.Points, &orderId, &refundId, &t.Pending, &availableAt, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		t.OrderID, t.RefundID = nullableInt(orderId), nullableInt(refundId)
+		if availableAt.Valid {
+			t.AvailableAt = &availableAt.Time
+		}
+
+		transactions = append(transactions, t)
+	}
+
+	return transactions, rows.Err()
+}
+
+// AddPoints guards what is taken off in the update itself, so two orders
+// cannot both spend the same points.
+func (s *Store) AddPoints(ctx context.Context, userId int, kind types.LoyaltyEntryKind, points int, orderId *int) (int, error) {
+	var transactionId int64
+	err := db.InTxContext(ctx, s.db, func(tx types.DB) error {
+		if points >= 0 {
+			_, err := tx.ExecContext(ctx, "INSERT IGNORE INTO loyalty_accounts (userId) VALUES (?)", userId)
+			if err != nil {
+				return err
+			}
+		}
+
+		query, args := "UPDATE loyalty_accounts SET balance = balance + ?", []any{points}
+		if kind == types.LoyaltyEarn || kind == types.LoyaltyRedeem {
+			query += ", lastActivityAt = CURRENT_TIMESTAMP"
+		}
+		query += " WHERE userId = ?"
+		args = append(args, userId)
+		if points < 0 {
+			query += " AND balance >= ?"
+			args = append(args, -points)
+		}
+
+		res, err := tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if affected == 0 {
+			return fmt.Errorf("%w: there are not %d loyalty points left to spend", ErrInsufficientPoints, -points)
+		}
+
+		res, err = tx.ExecContext(ctx,
+			"INSERT INTO loyalty_transactions (userId, kind, points, orderId) VALUES (?,?,?,?)",
+			userId, kind, points, orderId,
+		)
+		if err != nil {
+			return err
+		}
+
+		transactionId, err = res.LastInsertId()
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return int(transactionId), nil
+}
+
+// GetUnearnedOrders only looks at orders that are still delivered, or have
+// been partly refunded since.
+func (s *Store) GetUnearnedOrders(ctx context.Context, deliveredSince time.Time) ([]types.LoyaltyOrder, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT o.id, o.userId, o.total, o.currency, o.exchangeRate, o.status, MAX(h.createdAt) "+
+			"FROM orders o "+
+			"JOIN order_status_history h ON h.orderId = o.id AND h.toStatus = ? "+
+			"WHERE o.status IN (?,?) "+
+			"AND NOT EXISTS (SELECT 1 FROM loyalty_transactions t WHERE t.orderId = o.id AND t.kind = ?) "+
+			"GROUP BY o.id, o.userId, o.total, o.currency, o.exchangeRate, o.status "+
+			"HAVING MAX(h.createdAt) >= ? ORDER BY o.id",
+		types.Delivered, types.Delivered, types.PartiallyRefunded, types.LoyaltyEarn, deliveredSince,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orders := []types.LoyaltyOrder{}
+	for rows.Next() {
+		o := types.LoyaltyOrder{}
+		err := rows.Scan(&o.Order.ID, &o.Order.UserId, &o.Order.Total.Amount, &o.Order.Total.Currency, &o.Order.ExchangeRate, &o.Order.Status, &o.DeliveredAt)
+		if err != nil {
+			return nil, err
+		}
+
+		orders = append(orders, o)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range orders {
+		orders[i].PromotionIDs, err = s.getOrderPromotionIDs(ctx, orders[i].Order.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return orders, nil
+}
+
+func (s *Store) getOrderPromotionIDs(ctx context.Context, orderId int) ([]int, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT DISTINCT promotionId FROM promotion_redemptions WHERE orderId = ? AND reversedAt IS NULL", orderId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+func (s *Store) EarnPoints(ctx context.Context, userId int, orderId int, points int, availableAt time.Time) error {
+	return db.InTxContext(ctx, s.db, func(tx types.DB) error {
+		_, err := tx.ExecContext(ctx, "INSERT IGNORE INTO loyalty_accounts (userId) VALUES (?)", userId)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx,
+			"UPDATE loyalty_accounts SET pending = pending + ?, lastActivityAt = CURRENT_TIMESTAMP WHERE userId = ?",
+			points, userId,
+		)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO loyalty_transactions (userId, kind, points, orderId, pending, availableAt) VALUES (?,?,?,?,TRUE,?)",
+			userId, types.LoyaltyEarn, points, orderId, availableAt,
+		)
+		return err
+	})
+}
+
+// ReleasePoints locks the entries it releases, so points reversed while it
+// runs are released with them or after.
+func (s *Store) ReleasePoints(ctx context.Context, at time.Time) (int, error) {
+	var released int64
+	err := db.InTxContext(ctx, s.db, func(tx types.DB) error {
+		rows, err := tx.QueryContext(ctx,
+			"SELECT userId, SUM(points) FROM loyalty_transactions WHERE pending = TRUE AND availableAt <= ? GROUP BY userId FOR UPDATE",
+			at,
+		)
+		if err != nil {
+			return err
+		}
+
+		points := map[int]int{}
+		for rows.Next() {
+			var userId, sum int
+			if err := rows.Scan(&userId, &sum); err != nil {
+				rows.Close()
+				return err
+			}
+			points[userId] = sum
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for userId, sum := range points {
+			_, err := tx.ExecContext(ctx,
+				"UPDATE loyalty_accounts SET pending = pending - ?, balance = balance + ? WHERE userId = ?",
+				sum, sum, userId,
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		res, err := tx.ExecContext(ctx, "UPDATE loyalty_transactions SET pending = FALSE WHERE pending = TRUE AND availableAt <= ?", at)
+		if err != nil {
+			return err
+		}
+
+		released, err = res.RowsAffected()
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return int(released), nil
+}
+
+func (s *Store) GetUnreversedRefunds(ctx context.Context) ([]types.EarnedRefund, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT r.id, r.orderId, r.amount, r.currency, r.createdAt, e.userId, o.total, o.currency, e.points, "+
+			"(SELECT COALESCE(-SUM(x.points), 0) FROM loyalty_transactions x WHERE x.orderId = r.orderId AND x.kind = ?) "+
+			"FROM refunds r "+
+			"JOIN orders o ON o.id = r.orderId "+
+			"JOIN loyalty_transactions e ON e.orderId = r.orderId AND e.kind = ? "+
+			"WHERE NOT EXISTS (SELECT 1 FROM loyalty_transactions t WHERE t.refundId = r.id) "+
+			"ORDER BY r.id",
+		types.LoyaltyReversal, types.LoyaltyEarn,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	refunds := []types.EarnedRefund{}
+	for rows.Next() {
+		r := types.EarnedRefund{}
+		err := rows.Scan(
+			&r.Refund.ID, &r.Refund.OrderID, &r.Refund.Amount.Amount, &r.Refund.Amount.Currency, &r.Refund.CreatedAt,
+			&r.UserID, &r.OrderTotal.Amount, &r.OrderTotal.Currency, &r.Earned, &r.Reversed,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		refunds = append(refunds, r)
+	}
+
+	return refunds, rows.Err()
+}
+
+// ReversePoints records the refund even when no points are taken back, so
+// it is not looked at again.
+func (s *Store) ReversePoints(ctx context.Context, refund types.EarnedRefund, points int) (int, error) {
+	var taken int
+	err := db.InTxContext(ctx, s.db, func(tx types.DB) error {
+		var pending bool
+		var availableAt sql.NullTime
+		err := tx.QueryRowContext(ctx,
+			"SELECT pending, availableAt FROM loyalty_transactions WHERE orderId = ? AND kind = ? FOR UPDATE",
+			refund.Refund.OrderID, types.LoyaltyEarn,
+		).Scan(&pending, &availableAt)
+		if err != nil {
+			return err
+		}
+
+		var balance int
+		err = tx.QueryRowContext(ctx, "SELECT balance FROM loyalty_accounts WHERE userId = ? FOR UPDATE", refund.UserID).Scan(&balance)
+		if err != nil {
+			return err
+		}
+
+		// points already spent cannot be taken back
+		taken = points
+		column := "pending"
+		if !pending {
+			taken, column = min(points, max(balance, 0)), "balance"
+		}
+
+		_, err = tx.ExecContext(ctx, "UPDATE loyalty_accounts SET "+column+" = "+column+" - ? WHERE userId = ?", taken, refund.UserID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO loyalty_transactions (userId, kind, points, orderId, refundId, pending, availableAt) VALUES (?,?,?,?,?,?,?)",
+			refund.UserID, types.LoyaltyReversal, -taken, refund.Refund.OrderID, refund.Refund.ID, pending, availableAt,
+		)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return taken, nil
+}
+
+// ExpirePoints records what each account lost in its ledger before clearing
+// its balance. Pending points are left alone.
+func (s *Store) ExpirePoints(ctx context.Context, inactiveSince time.Time) (int, error) {
+	var expired int64
+	err := db.InTxContext(ctx, s.db, func(tx types.DB) error {
+		_, err := tx.ExecContext(ctx,
+			"INSERT INTO loyalty_transactions (userId, kind, points) SELECT userId, ?, -balance FROM loyalty_accounts WHERE balance > 0 AND lastActivityAt < ?",
+			types.LoyaltyExpire, inactiveSince,
+		)
+		if err != nil {
+			return err
+		}
+
+		res, err := tx.ExecContext(ctx, "UPDATE loyalty_accounts SET balance = 0 WHERE balance > 0 AND lastActivityAt < ?", inactiveSince)
+		if err != nil {
+			return err
+		}
+
+		expired, err = res.RowsAffected()
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return int(expired), nil
+}
+
+func nullableInt(value sql.NullInt64) *int {
+	if !value.Valid {
+		return nil
+	}
+
+	v := int(value.Int64)
+	return &v
+}
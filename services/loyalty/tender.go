@@ -0,0 +1,103 @@
+package loyalty
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/xelathan/golang_backend/config"
+	"github.com/xelathan/golang_backend/money"
+	"github.com/xelathan/golang_backend/types"
+)
+
+// Points is the tender of loyalty points, each worth value. Accounts are
+// user ids and references are the ids of ledger entries. Points only pay
+// for orders in the currency of their value.
+type Points struct {
+	store types.LoyaltyStore
+	value money.Money
+}
+
+func NewPoints(store types.LoyaltyStore, value money.Money) *Points {
+	return &Points{store: store, value: value}
+}
+
+func (t *Points) Name() string {
+	return types.TenderLoyaltyPoints
+}
+
+func (t *Points) WithTx(tx types.DB) types.Tender {
+	return &Points{store: t.store.WithTx(tx), value: t.value}
+}
+
+// Worth is what the points pay for.
+func (t *Points) Worth(points int) money.Money {
+	return t.value.Mul(points)
+}
+
+// PointsFor is the most points amount covers, 0 in another currency.
+func (t *Points) PointsFor(amount money.Money) int {
+	if amount.Currency != t.value.Currency || !t.value.IsPositive() || amount.IsNegative() {
+		return 0
+	}
+
+	return int(amount.Amount / t.value.Amount)
+}
+
+// Debit spends the points worth amount, which has to be a whole number of
+// points.
+func (t *Points) Debit(ctx context.Context, account string, amount money.Money, orderId int) (string, error) {
+	userId, err := strconv.Atoi(account)
+	if err != nil {
+		return "", fmt.Errorf("invalid loyalty account %q", account)
+	}
+
+	points := t.PointsFor(amount)
+	if points == 0 || t.Worth(points) != amount {
+		return "", fmt.Errorf("%s is not a whole number of loyalty points", amount)
+	}
+
+	transactionId, err := t.store.AddPoints(ctx, userId, types.LoyaltyRedeem, -points, &orderId)
+	if err != nil {
+		return "", err
+	}
+
+	return strconv.Itoa(transactionId), nil
+}
+
+// Credit gives the points amount is worth, rounded down.
+func (t *Points) Credit(ctx context.Context, account string, amount money.Money, orderId int) error {
+	userId, err := strconv.Atoi(account)
+	if err != nil {
+		return fmt.Errorf("invalid loyalty account %q", account)
+	}
+
+	_, err = t.store.AddPoints(ctx, userId, types.LoyaltyRefund, t.PointsFor(amount), &orderId)
+	return err
+}
+
+// Reverse gives back the points amount is worth, rounded down, and never
+// more than were spent.
+func (t *Points) Reverse(ctx context.Context, reference string, amount money.Money) error {
+	transactionId, err := strconv.Atoi(reference)
+	if err != nil {
+		return fmt.Errorf("invalid loyalty reference %q", reference)
+	}
+
+	redeemed, err := t.store.GetLoyaltyTransaction(ctx, transactionId)
+	if err != nil {
+		return err
+	}
+
+	if redeemed.Kind != types.LoyaltyRedeem {
+		return fmt.Errorf("loyalty transaction %d is not a redemption", transactionId)
+	}
+
+	_, err = t.store.AddPoints(ctx, redeemed.UserID, types.LoyaltyRefund, min(t.PointsFor(amount), -redeemed.Points), redeemed.OrderID)
+	return err
+}
+
+// NewPointsFromConfig values points as configured, in the base currency.
+func NewPointsFromConfig(store types.LoyaltyStore) *Points {
+	return NewPoints(store, money.New(config.Envs.LoyaltyPointValueInMinorUnits, config.Envs.BaseCurrency))
+}
@@ -0,0 +1,110 @@
+package notification
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/xelathan/golang_backend/config"
+	"github.com/xelathan/golang_backend/types"
+)
+
+// NewNotifierFromConfig picks the notifier named by the NOTIFIER env var,
+// falling back to the log notifier when the name is unknown.
+func NewNotifierFromConfig() types.Notifier {
+	switch config.Envs.Notifier {
+	case "email":
+		return NewEmailNotifier(
+			fmt.Sprintf("%s:%s", config.Envs.SMTPHost, config.Envs.SMTPPort),
+			config.Envs.SMTPUser,
+			config.Envs.SMTPPassword,
+			config.Envs.SMTPFrom,
+		)
+	case "webhook":
+		return NewWebhookNotifier(config.Envs.NotifierWebhookURL)
+	default:
+		return &LogNotifier{}
+	}
+}
+
+// StaffRecipients returns the configured staff email addresses.
+func StaffRecipients() []string {
+	recipients := []string{}
+	for _, email := range strings.Split(config.Envs.StaffNotificationEmails, ",") {
+		if email = strings.TrimSpace(email); email != "" {
+			recipients = append(recipients, email)
+		}
+	}
+
+	return recipients
+}
+
+type LogNotifier struct{}
+
+func (n *LogNotifier) Notify(notification types.Notification) error {
+	log.Printf("notification [%s] to %s: %s - %s", notification.Event, notification.Recipient, notification.Subject, notification.Message)
+	return nil
+}
+
+type WebhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	return &WebhookNotifier{url: url, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (n *WebhookNotifier) Notify(notification types.Notification) error {
+	if n.url == "" {
+		return fmt.Errorf("webhook url not configured")
+	}
+
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+
+	res, err := n.client.Post(n.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with status %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+type EmailNotifier struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+func NewEmailNotifier(addr string, user string, password string, from string) *EmailNotifier {
+	var auth smtp.Auth
+	if user != "" {
+		host := strings.Split(addr, ":")[0]
+		auth = smtp.PlainAuth("", user, password, host)
+	}
+
+	return &EmailNotifier{addr: addr, auth: auth, from: from}
+}
+
+func (n *EmailNotifier) Notify(notification types.Notification) error {
+	if notification.Recipient == "" {
+		return fmt.Errorf("notification has no recipient")
+	}
+
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s\r\n", n.from, notification.Recipient, notification.Subject, notification.Message)
+
+	return smtp.SendMail(n.addr, n.auth, n.from, []string{notification.Recipient}, []byte(msg))
+}
@@ -0,0 +1,623 @@
+package order
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/xelathan/golang_backend/config"
+	"github.com/xelathan/golang_backend/money"
+	"github.com/xelathan/golang_backend/services/currency"
+	"github.com/xelathan/golang_backend/services/promotion"
+	"github.com/xelathan/golang_backend/services/shipping"
+	"github.com/xelathan/golang_backend/types"
+)
+
+// editError is an edit refused for the state of the order or what was
+// asked, as opposed to a failure to make it.
+type editError struct {
+	err error
+}
+
+func (e *editError) Error() string {
+	return e.err.Error()
+}
+
+// editable lists the statuses an order can be edited in: paid for but not
+// shipped yet. Pending orders are paid for or cancelled instead.
+var editable = []types.OrderStatus{types.Paid, types.Processing}
+
+// Editor edits orders that have not shipped yet. Items can be taken off the
+// order or their quantities lowered, down to the units already shipped, and
+// the address changed. The order is priced again with what is left of it,
+// the units taken off are put back in stock and, when the order was paid
+// for, the difference is refunded. Every edit is recorded as a revision of
+// the order.
+//
+// An edit is worked out and saved with its order locked, together with the
+// refund it owes. The refund is made once the edit is in and, should that
+// fail, retried by Start until the revision is settled.
+type Editor struct {
+	uow            types.UnitOfWork
+	orderStore     types.OrderStore
+	productStore   types.ProductStore
+	promotionStore types.PromotionStore
+	taxStore       types.TaxStore
+	shippingStore  types.ShippingStore
+	currencyStore  types.CurrencyStore
+	refundStore    types.RefundStore
+	shipmentStore  types.ShipmentStore
+	paymentStore   types.PaymentStore
+	refunder       types.Refunder
+}
+
+func NewEditor(uow types.UnitOfWork, orderStore types.OrderStore, productStore types.ProductStore, promotionStore types.PromotionStore, taxStore types.TaxStore, shippingStore types.ShippingStore, currencyStore types.CurrencyStore, refundStore types.RefundStore, shipmentStore types.ShipmentStore, paymentStore types.PaymentStore, refunder types.Refunder) *Editor {
+	return &Editor{
+		uow:            uow,
+		orderStore:     orderStore,
+		productStore:   productStore,
+		promotionStore: promotionStore,
+		taxStore:       taxStore,
+		shippingStore:  shippingStore,
+		currencyStore:  currencyStore,
+		refundStore:    refundStore,
+		shipmentStore:  shipmentStore,
+		paymentStore:   paymentStore,
+		refunder:       refunder,
+	}
+}
+
+// Start settles, every interval, the revisions whose refund failed when the
+// order was edited.
+func (e *Editor) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := e.Settle(context.Background()); err != nil {
+				log.Printf("order: settling revisions failed: %v", err)
+			}
+		}
+	}()
+}
+
+// Edit applies the edit to the order and returns the revision recording it.
+// ChangedBy is the customer or staff member making the edit.
+func (e *Editor) Edit(ctx context.Context, orderId int, payload types.EditOrderPayload, changedBy *int) (*types.OrderRevision, error) {
+	var revised *edit
+	err := e.uow.Do(ctx, func(tx types.DB) error {
+		orderStore, promotionStore := e.orderStore.WithTx(tx), e.promotionStore.WithTx(tx)
+
+		order, err := orderStore.LockOrderById(ctx, orderId)
+		if err != nil {
+			return err
+		}
+
+		history, err := orderStore.GetOrderStatusHistory(ctx, orderId)
+		if err != nil {
+			return err
+		}
+
+		if status := FulfilmentStatus(order, history); !isEditable(status) {
+			return &editError{fmt.Errorf("cannot edit an order that is %s", status)}
+		}
+
+		items, err := orderStore.GetOrderItems(ctx, orderId)
+		if err != nil {
+			return err
+		}
+
+		refunds, err := e.refundStore.WithTx(tx).GetRefundsByOrderId(ctx, orderId)
+		if err != nil {
+			return err
+		}
+
+		shipments, err := e.shipmentStore.WithTx(tx).GetShipmentsByOrderId(ctx, orderId)
+		if err != nil {
+			return err
+		}
+
+		discounts, err := promotionStore.GetOrderItemDiscounts(ctx, orderId)
+		if err != nil {
+			return err
+		}
+
+		taxes, err := e.taxStore.WithTx(tx).GetOrderItemTaxes(ctx, orderId)
+		if err != nil {
+			return err
+		}
+
+		prices, err := e.pricing(ctx, tx, order, items)
+		if err != nil {
+			return err
+		}
+
+		revised, err = revise(order, items, refunds, shipments, discounts, taxes, prices, payload)
+		if err != nil {
+			return err
+		}
+		revised.revision.ChangedBy = changedBy
+
+		payments, err := e.paymentStore.WithTx(tx).GetPaymentsByOrderId(ctx, orderId)
+		if err != nil {
+			return err
+		}
+
+		// refunds given before the edit may already cover some of it
+		captured, refunded := PaymentTotals(order, payments)
+		difference := revised.revision.PreviousTotal.Sub(revised.revision.Total)
+		revised.revision.RefundDue = money.Max(money.Min(difference, captured.Sub(refunded)), money.Zero(difference.Currency))
+
+		revised.revision.ID, err = orderStore.ReviseOrder(ctx, revised.order, revised.revision)
+		if err != nil {
+			return err
+		}
+
+		if err := promotionStore.UpdateOrderItemDiscounts(ctx, revised.discounts); err != nil {
+			return err
+		}
+
+		if len(revised.revision.Items) > 0 {
+			if err := promotionStore.ReviseRedemptions(ctx, orderId, revised.redemptions); err != nil {
+				return err
+			}
+		}
+
+		if err := e.taxStore.WithTx(tx).UpdateOrderItemTaxes(ctx, revised.taxes); err != nil {
+			return err
+		}
+
+		return release(ctx, e.productStore.WithTx(tx), revised.restock, revised.backordered)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	revision := revised.revision
+	if !revision.RefundDue.IsPositive() {
+		return &revision, nil
+	}
+
+	// the edit is in whatever happens to the refund, which Start retries
+	// when it cannot be made now
+	refundId, err := e.settle(context.WithoutCancel(ctx), revision.ID)
+	if err != nil {
+		log.Printf("order: refunding revision %d failed: %v", revision.ID, err)
+		return &revision, nil
+	}
+	revision.RefundDue, revision.RefundID = money.Zero(revision.RefundDue.Currency), refundId
+
+	return &revision, nil
+}
+
+// Settle makes the refunds that revisions still owe.
+func (e *Editor) Settle(ctx context.Context) error {
+	revisions, err := e.orderStore.GetUnsettledOrderRevisions(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, revision := range revisions {
+		if _, err := e.settle(ctx, revision.ID); err != nil {
+			log.Printf("order: refunding revision %d failed: %v", revision.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// settle refunds what the revision owes and returns the refund, nil when
+// there was nothing left to refund. The revision stays locked until it is
+// settled, and a refund made for it by an attempt that did not get to
+// record it is picked up instead of refunding again.
+func (e *Editor) settle(ctx context.Context, revisionId int) (*int, error) {
+	var refundId *int
+	err := e.uow.Do(ctx, func(tx types.DB) error {
+		orderStore := e.orderStore.WithTx(tx)
+
+		revision, err := orderStore.LockOrderRevisionById(ctx, revisionId)
+		if err != nil {
+			return err
+		}
+
+		refundId = revision.RefundID
+		if !revision.RefundDue.IsPositive() {
+			return nil
+		}
+
+		refunds, err := e.refundStore.WithTx(tx).GetRefundsByOrderId(ctx, revision.OrderID)
+		if err != nil {
+			return err
+		}
+
+		note := fmt.Sprintf("order revision %d", revision.ID)
+		for _, refund := range refunds {
+			if refund.Reason == types.RefundOrderEdited && refund.Note == note && refund.Status != types.RefundFailed {
+				refundId = &refund.ID
+				return orderStore.SettleOrderRevision(ctx, revision.ID, refundId)
+			}
+		}
+
+		order, err := orderStore.GetOrderById(ctx, revision.OrderID)
+		if err != nil {
+			return err
+		}
+
+		payments, err := e.paymentStore.WithTx(tx).GetPaymentsByOrderId(ctx, revision.OrderID)
+		if err != nil {
+			return err
+		}
+
+		// refunds given since the edit may have covered some of it
+		captured, refunded := PaymentTotals(order, payments)
+		if amount := money.Min(revision.RefundDue, captured.Sub(refunded)); amount.IsPositive() {
+			refund, err := e.refunder.Refund(ctx, revision.OrderID, types.RefundOrderPayload{
+				Amount: &amount,
+				Reason: types.RefundOrderEdited,
+				Note:   note,
+			}, revision.ChangedBy)
+			if err != nil {
+				return err
+			}
+			refundId = &refund.ID
+		}
+
+		return orderStore.SettleOrderRevision(ctx, revision.ID, refundId)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return refundId, nil
+}
+
+// pricing loads what the order is priced again with. The order currency
+// keeps the rate the order was placed at, so the promotions' minimums and
+// the shipping tiers are measured the way they were at checkout.
+func (e *Editor) pricing(ctx context.Context, tx types.DB, order *types.Order, items []types.OrderItem) (pricing, error) {
+	productIds := make([]int, len(items))
+	for i, item := range items {
+		productIds[i] = item.ProductID
+	}
+
+	products, err := e.productStore.WithTx(tx).GetProductsByID(ctx, productIds)
+	if err != nil {
+		return pricing{}, err
+	}
+
+	p := pricing{products: map[int]types.Product{}}
+	for _, product := range products {
+		p.products[product.ID] = product
+	}
+
+	rates, err := e.currencyStore.GetExchangeRates(ctx)
+	if err != nil {
+		return pricing{}, err
+	}
+
+	currencyCode := order.Total.Currency
+	if currencyCode != config.Envs.BaseCurrency {
+		pinned := []types.ExchangeRate{{Currency: currencyCode, Rate: order.ExchangeRate}}
+		for _, rate := range rates {
+			if rate.Currency != currencyCode {
+				pinned = append(pinned, rate)
+			}
+		}
+		rates = pinned
+	}
+
+	if p.converter, err = currency.NewConverter(config.Envs.BaseCurrency, rates); err != nil {
+		return pricing{}, err
+	}
+
+	promotions, err := e.promotionStore.WithTx(tx).GetOrderPromotions(ctx, order.ID)
+	if err != nil {
+		return pricing{}, err
+	}
+
+	if p.promotions, err = promotion.Localize(promotions, p.converter, currencyCode); err != nil {
+		return pricing{}, err
+	}
+
+	if order.ShippingMethodID == 0 {
+		return p, nil
+	}
+
+	// the method the order was charged for, even if it has been retired
+	methods, err := e.shippingStore.GetMethods(ctx)
+	if err != nil {
+		return pricing{}, err
+	}
+
+	for _, method := range methods {
+		if method.ID == order.ShippingMethodID {
+			p.method = &method
+		}
+	}
+
+	return p, nil
+}
+
+// FulfilmentStatus is how far the order has got towards the customer.
+// Refunds move an order to partially refunded wherever it is, so for those
+// the status it had before is looked up in its history.
+func FulfilmentStatus(order *types.Order, history []types.OrderStatusChange) types.OrderStatus {
+	status := order.Status
+	for i := len(history) - 1; i >= 0 && status == types.PartiallyRefunded; i-- {
+		status = history[i].FromStatus
+	}
+
+	return status
+}
+
+func isEditable(status types.OrderStatus) bool {
+	for _, s := range editable {
+		if s == status {
+			return true
+		}
+	}
+
+	return false
+}
+
+// edit is an order as revised along with the lines of its discounts and tax
+// that changed, its promotions' redemptions, the quantities to put back in
+// stock and the ones to take off backorder, keyed by product id.
+type edit struct {
+	order       types.Order
+	revision    types.OrderRevision
+	discounts   []types.OrderItemDiscount
+	taxes       []types.OrderItemTax
+	redemptions []types.PromotionRedemption
+	restock     map[int]int
+	backordered map[int]int
+}
+
+// pricing is what an edit prices the order again with: the promotions it
+// redeemed, in the order currency, its products and the shipping method it
+// was charged for, nil when it has none.
+type pricing struct {
+	promotions []types.Promotion
+	products   map[int]types.Product
+	method     *types.ShippingMethod
+	converter  *currency.Converter
+}
+
+// revise works out the order after the edit. Each item keeps its price and
+// the order is priced again with what is left of it, see reprice. Only the
+// address changes when no item does.
+func revise(order *types.Order, items []types.OrderItem, refunds []types.Refund, shipments []types.Shipment, discounts []types.OrderItemDiscount, taxes []types.OrderItemTax, prices pricing, payload types.EditOrderPayload) (*edit, error) {
+	lines := map[int]types.OrderItem{}
+	for _, item := range items {
+		lines[item.ID] = item
+	}
+
+	refundedUnits := map[int]int{}
+	for _, refund := range refunds {
+		if refund.Status == types.RefundFailed {
+			continue
+		}
+		for _, item := range refund.Items {
+			refundedUnits[item.OrderItemID] += item.Quantity
+		}
+	}
+
+	shippedUnits := map[int]int{}
+	for _, shipment := range shipments {
+		for _, item := range shipment.Items {
+			shippedUnits[item.OrderItemID] += item.Quantity
+		}
+	}
+
+	e := &edit{
+		order: *order,
+		revision: types.OrderRevision{
+			OrderID:       order.ID,
+			Reason:        payload.Reason,
+			PreviousTotal: order.Total,
+			Total:         order.Total,
+			Items:         []types.OrderRevisionItem{},
+		},
+		discounts:   []types.OrderItemDiscount{},
+		taxes:       []types.OrderItemTax{},
+		redemptions: []types.PromotionRedemption{},
+		restock:     map[int]int{},
+		backordered: map[int]int{},
+	}
+
+	// quantities are what each item will be left with
+	quantities := map[int]int{}
+	for _, item := range items {
+		quantities[item.ID] = item.Quantity
+	}
+
+	edited := map[int]bool{}
+	for _, a := range payload.Items {
+		item, ok := lines[a.OrderItemID]
+		if !ok {
+			return nil, &editError{fmt.Errorf("order item %d is not part of the order", a.OrderItemID)}
+		}
+
+		if edited[item.ID] {
+			return nil, &editError{fmt.Errorf("order item %d is listed more than once", item.ID)}
+		}
+		edited[item.ID] = true
+
+		if a.Quantity > item.Quantity {
+			return nil, &editError{fmt.Errorf("order item %d can only be lowered from %d", item.ID, item.Quantity)}
+		}
+
+		// refunded units have already been given back
+		if a.Quantity < refundedUnits[item.ID] {
+			return nil, &editError{fmt.Errorf("%d of order item %d have been refunded and cannot be taken off the order", refundedUnits[item.ID], item.ID)}
+		}
+
+		if a.Quantity < shippedUnits[item.ID] {
+			return nil, &editError{fmt.Errorf("%d of order item %d have shipped and cannot be taken off the order", shippedUnits[item.ID], item.ID)}
+		}
+
+		quantities[item.ID] = a.Quantity
+	}
+
+	left := 0
+	for _, item := range items {
+		left += quantities[item.ID]
+
+		quantity := quantities[item.ID]
+		if quantity == item.Quantity {
+			continue
+		}
+
+		e.revision.Items = append(e.revision.Items, types.OrderRevisionItem{OrderItemID: item.ID, FromQuantity: item.Quantity, ToQuantity: quantity})
+		if item.FulfilmentStatus == types.FulfilmentBackordered {
+			e.backordered[item.ProductID] += item.Quantity - quantity
+		} else {
+			e.restock[item.ProductID] += item.Quantity - quantity
+		}
+	}
+
+	if left == 0 {
+		return nil, &editError{fmt.Errorf("cannot take every item off the order, cancel it instead")}
+	}
+
+	if len(e.revision.Items) > 0 {
+		if err := e.reprice(order, items, quantities, discounts, taxes, prices); err != nil {
+			return nil, err
+		}
+	}
+
+	if payload.Address != nil && *payload.Address != order.Address {
+		e.order.Address = *payload.Address
+		e.revision.PreviousAddress, e.revision.Address = order.Address, *payload.Address
+	}
+
+	if len(e.revision.Items) == 0 && e.revision.Address == "" {
+		return nil, &editError{fmt.Errorf("nothing to change")}
+	}
+
+	return e, nil
+}
+
+// reprice prices the order again with the quantities left. The promotions
+// it redeemed are applied again, which drops those it no longer qualifies
+// for, the tax of every item follows what the item now costs and the
+// shipping method is quoted for the lighter parcel. The order's amounts
+// change by what its items' did, which keeps whatever was not down to them
+// as it was, and its total never goes up: an edit does not charge the
+// customer more.
+func (e *edit) reprice(order *types.Order, items []types.OrderItem, quantities map[int]int, discounts []types.OrderItemDiscount, taxes []types.OrderItemTax, prices pricing) error {
+	currencyCode := order.Total.Currency
+	zero := money.Zero(currencyCode)
+
+	byId := map[int]types.OrderItem{}
+	lines := []promotion.Line{}
+	parcelItems := []types.CartItem{}
+	itemIds := []int{}
+	subtotal := zero
+	for _, item := range items {
+		byId[item.ID] = item
+		subtotal = subtotal.Add(item.Price.Mul(item.Quantity))
+		if quantities[item.ID] == 0 {
+			continue
+		}
+
+		lines = append(lines, promotion.Line{
+			ProductID: item.ProductID,
+			Category:  prices.products[item.ProductID].Category,
+			Quantity:  quantities[item.ID],
+			UnitPrice: item.Price,
+		})
+		parcelItems = append(parcelItems, types.CartItem{ProductID: item.ProductID, Quantity: quantities[item.ID]})
+		itemIds = append(itemIds, item.ID)
+	}
+
+	result := promotion.Apply(prices.promotions, lines, currencyCode)
+
+	var applied []types.OrderItemDiscount
+	e.redemptions, applied = promotion.Redemptions(result, order.ID, order.UserId, itemIds)
+
+	// what each promotion now takes off each item, and each item in all
+	type line struct{ orderItemId, promotionId int }
+	amounts := map[line]money.Money{}
+	itemDiscounts := map[int]money.Money{}
+	for _, d := range applied {
+		amounts[line{d.OrderItemID, d.PromotionID}] = d.Amount
+		itemDiscounts[d.OrderItemID] = itemDiscounts[d.OrderItemID].Add(d.Amount)
+	}
+
+	discount := zero
+	for _, d := range discounts {
+		discount = discount.Add(d.Amount)
+
+		amount, ok := amounts[line{d.OrderItemID, d.PromotionID}]
+		if !ok {
+			amount = zero
+		}
+		delete(amounts, line{d.OrderItemID, d.PromotionID})
+
+		if amount != d.Amount {
+			d.Amount = amount
+			e.discounts = append(e.discounts, d)
+		}
+	}
+
+	// a promotion reaches lines it did not before when one ahead of it is
+	// dropped
+	for _, d := range applied {
+		if _, ok := amounts[line{d.OrderItemID, d.PromotionID}]; ok {
+			e.discounts = append(e.discounts, d)
+		}
+	}
+
+	tax, addedTax, revisedTax, revisedAddedTax := zero, zero, zero, zero
+	for _, t := range taxes {
+		tax = tax.Add(t.Amount)
+		if !t.Inclusive {
+			addedTax = addedTax.Add(t.Amount)
+		}
+
+		item := byId[t.OrderItemID]
+		taxable := money.Max(item.Price.Mul(quantities[item.ID]).Sub(itemDiscounts[item.ID]), zero)
+		amount := zero
+		if t.Taxable.IsPositive() {
+			amount = t.Amount.MulRatio(taxable.Amount, t.Taxable.Amount, money.HalfUp)
+		}
+
+		revisedTax = revisedTax.Add(amount)
+		if !t.Inclusive {
+			revisedAddedTax = revisedAddedTax.Add(amount)
+		}
+
+		if taxable != t.Taxable || amount != t.Amount {
+			t.Taxable, t.Amount = taxable, amount
+			e.taxes = append(e.taxes, t)
+		}
+	}
+
+	shippingCost := order.ShippingCost
+	if prices.method != nil {
+		parcel := shipping.ParcelFor(prices.products, parcelItems, result.Total())
+		cost, ok, err := shipping.Cost(*prices.method, parcel, result.FreeShipping, prices.converter, currencyCode)
+		if err != nil {
+			return err
+		}
+
+		if ok {
+			shippingCost = money.Min(cost, order.ShippingCost)
+		}
+	}
+
+	total := order.Total.
+		Sub(subtotal).Add(discount).Sub(addedTax).Sub(order.ShippingCost).
+		Add(result.Subtotal).Sub(result.Discount).Add(revisedAddedTax).Add(shippingCost)
+
+	e.order.Total = money.Max(money.Min(total, order.Total), zero)
+	e.order.Discount = order.Discount.Sub(discount).Add(result.Discount)
+	e.order.Tax = order.Tax.Sub(tax).Add(revisedTax)
+	e.order.ShippingCost = shippingCost
+	e.revision.Total = e.order.Total
+
+	return nil
+}
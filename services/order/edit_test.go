@@ -0,0 +1,340 @@
+package order
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/xelathan/golang_backend/money"
+	"github.com/xelathan/golang_backend/services/currency"
+	"github.com/xelathan/golang_backend/types"
+)
+
+func TestRevise(t *testing.T) {
+	// 25.00 of items less a 2.00 discount on the first, 10% tax on top and
+	// 3.00 shipping
+	order := &types.Order{
+		ID:           1,
+		Status:       types.Paid,
+		Total:        money.New(2830, "USD"),
+		Discount:     money.New(200, "USD"),
+		Tax:          money.New(230, "USD"),
+		ShippingCost: money.New(300, "USD"),
+		Address:      "1 Main St",
+	}
+	items := []types.OrderItem{
+		{ID: 10, ProductID: 7, Quantity: 2, Price: money.New(1000, "USD")},
+		{ID: 11, ProductID: 8, Quantity: 1, Price: money.New(500, "USD")},
+	}
+	discounts := []types.OrderItemDiscount{{ID: 1, OrderItemID: 10, PromotionID: 1, Amount: money.New(200, "USD")}}
+	taxes := []types.OrderItemTax{
+		{ID: 1, OrderItemID: 10, TaxLine: types.TaxLine{Taxable: money.New(1800, "USD"), Amount: money.New(180, "USD")}},
+		{ID: 2, OrderItemID: 11, TaxLine: types.TaxLine{Taxable: money.New(500, "USD"), Amount: money.New(50, "USD")}},
+	}
+
+	converter, err := currency.NewConverter("USD", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 10% off the first product, which weighs 600g to the second's 300g
+	prices := pricing{
+		promotions: []types.Promotion{{ID: 1, Type: types.PromotionPercentage, PercentOff: 10, MinOrderValue: money.Zero("USD"), ProductIDs: []int{7}}},
+		products:   map[int]types.Product{7: {ID: 7, WeightGrams: 600}, 8: {ID: 8, WeightGrams: 300}},
+		converter:  converter,
+	}
+	takeOne := types.EditOrderPayload{Items: []types.EditOrderItemPayload{{OrderItemID: 10, Quantity: 1}}}
+
+	t.Run("should lower the discount and tax with the units taken off", func(t *testing.T) {
+		e, err := revise(order, items, nil, nil, discounts, taxes, prices, takeOne)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if e.order.Total != money.New(1840, "USD") || e.order.Discount != money.New(100, "USD") || e.order.Tax != money.New(140, "USD") {
+			t.Errorf("expected 18.40 with 1.00 off and 1.40 tax, got %s with %s off and %s tax", e.order.Total, e.order.Discount, e.order.Tax)
+		}
+
+		if len(e.discounts) != 1 || e.discounts[0].Amount != money.New(100, "USD") || len(e.taxes) != 1 || e.taxes[0].Taxable != money.New(900, "USD") {
+			t.Errorf("expected the first item's discount and tax halved, got %+v and %+v", e.discounts, e.taxes)
+		}
+
+		if e.restock[7] != 1 || e.revision.PreviousTotal != order.Total || e.revision.Items[0].ToQuantity != 1 {
+			t.Errorf("expected one unit restocked and the revision recorded, got %v and %+v", e.restock, e.revision)
+		}
+	})
+
+	t.Run("should drop a promotion the order no longer reaches the minimum of", func(t *testing.T) {
+		prices := prices
+		prices.promotions = []types.Promotion{{ID: 1, Type: types.PromotionPercentage, PercentOff: 10, MinOrderValue: money.New(2500, "USD"), ProductIDs: []int{7}}}
+
+		e, err := revise(order, items, nil, nil, discounts, taxes, prices, takeOne)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if e.order.Total != money.New(1950, "USD") || !e.order.Discount.IsZero() || e.order.Tax != money.New(150, "USD") {
+			t.Errorf("expected 19.50 without a discount and 1.50 tax, got %s with %s off and %s tax", e.order.Total, e.order.Discount, e.order.Tax)
+		}
+
+		if len(e.discounts) != 1 || !e.discounts[0].Amount.IsZero() || len(e.redemptions) != 0 {
+			t.Errorf("expected the discount cleared and the promotion no longer redeemed, got %+v and %+v", e.discounts, e.redemptions)
+		}
+	})
+
+	t.Run("should quote the shipping method for the lighter parcel", func(t *testing.T) {
+		order := *order
+		order.Total, order.ShippingCost = money.New(3030, "USD"), money.New(500, "USD")
+
+		upTo := int64(1000)
+		prices := prices
+		prices.method = &types.ShippingMethod{
+			ID:       1,
+			Kind:     types.ShippingStandard,
+			RateType: types.ShippingRateWeight,
+			FlatRate: money.Zero("USD"),
+			Tiers: []types.ShippingRateTier{
+				{UpTo: &upTo, Rate: money.New(300, "USD")},
+				{Rate: money.New(500, "USD")},
+			},
+		}
+
+		e, err := revise(&order, items, nil, nil, discounts, taxes, prices, takeOne)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if e.order.ShippingCost != money.New(300, "USD") || e.order.Total != money.New(1840, "USD") {
+			t.Errorf("expected 3.00 shipping and 18.40 in all, got %s and %s", e.order.ShippingCost, e.order.Total)
+		}
+	})
+
+	t.Run("should never charge more than the order was placed for", func(t *testing.T) {
+		// 10.00 off orders of 25.00 or more, spread over both items
+		order := &types.Order{ID: 2, Status: types.Paid, Total: money.New(1500, "USD"), Discount: money.New(1000, "USD"), Tax: money.Zero("USD"), ShippingCost: money.Zero("USD")}
+		discounts := []types.OrderItemDiscount{
+			{ID: 1, OrderItemID: 10, PromotionID: 2, Amount: money.New(800, "USD")},
+			{ID: 2, OrderItemID: 11, PromotionID: 2, Amount: money.New(200, "USD")},
+		}
+		prices := prices
+		prices.promotions = []types.Promotion{{ID: 2, Type: types.PromotionFixed, AmountOff: money.New(1000, "USD"), MinOrderValue: money.New(2500, "USD")}}
+
+		e, err := revise(order, items, nil, nil, discounts, nil, prices, types.EditOrderPayload{Items: []types.EditOrderItemPayload{{OrderItemID: 11, Quantity: 0}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if e.order.Total != order.Total || !e.order.Discount.IsZero() {
+			t.Errorf("expected the total kept at %s without the discount, got %s with %s off", order.Total, e.order.Total, e.order.Discount)
+		}
+	})
+
+	t.Run("should only change the address when no items are listed", func(t *testing.T) {
+		address := "2 Side St"
+		e, err := revise(order, items, nil, nil, discounts, taxes, prices, types.EditOrderPayload{Address: &address})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if e.order.Total != order.Total || e.order.Address != address || e.revision.PreviousAddress != order.Address {
+			t.Errorf("expected only the address to change, got %+v", e.revision)
+		}
+	})
+
+	t.Run("should refuse edits that cannot be made", func(t *testing.T) {
+		refunds := []types.Refund{{Items: []types.RefundItem{{OrderItemID: 11, Quantity: 1}}}}
+		shipments := []types.Shipment{{Items: []types.ShipmentItem{{OrderItemID: 10, Quantity: 1}}}}
+		cases := map[string]types.EditOrderPayload{
+			"raising a quantity":       {Items: []types.EditOrderItemPayload{{OrderItemID: 11, Quantity: 2}}},
+			"taking every item off":    {Items: []types.EditOrderItemPayload{{OrderItemID: 10, Quantity: 0}, {OrderItemID: 11, Quantity: 0}}},
+			"taking refunded units":    {Items: []types.EditOrderItemPayload{{OrderItemID: 11, Quantity: 0}}},
+			"taking shipped units":     {Items: []types.EditOrderItemPayload{{OrderItemID: 10, Quantity: 0}}},
+			"an item of another order": {Items: []types.EditOrderItemPayload{{OrderItemID: 99, Quantity: 0}}},
+			"changing nothing":         {Items: []types.EditOrderItemPayload{{OrderItemID: 10, Quantity: 2}}},
+		}
+
+		for name, payload := range cases {
+			_, err := revise(order, items, refunds, shipments, discounts, taxes, prices, payload)
+
+			var refused *editError
+			if !errors.As(err, &refused) {
+				t.Errorf("expected %s to be refused, got %v", name, err)
+			}
+		}
+	})
+}
+
+func TestEditorSettle(t *testing.T) {
+	setup := func(refunds ...types.Refund) (*Editor, *mockRevisionStore, *mockRefunder) {
+		orders := &mockRevisionStore{
+			order:    types.Order{ID: 1, Status: types.Paid, Total: money.New(2330, "USD")},
+			revision: types.OrderRevision{ID: 1, OrderID: 1, RefundDue: money.New(500, "USD")},
+		}
+		refundStore := &mockEditRefundStore{refunds: refunds}
+		payments := &mockEditPaymentStore{payments: []types.Payment{
+			{OrderID: 1, Status: types.PaymentCaptured, Amount: money.New(2830, "USD"), Refunded: money.Zero("USD")},
+		}}
+		refunder := &mockRefunder{store: refundStore}
+
+		editor := NewEditor(mockUnitOfWork{}, orders, nil, nil, nil, nil, nil, refundStore, nil, payments, refunder)
+		return editor, orders, refunder
+	}
+
+	t.Run("should retry a refund that failed until it is made once", func(t *testing.T) {
+		editor, orders, refunder := setup()
+		refunder.err = errors.New("provider unavailable")
+
+		if err := editor.Settle(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		if !orders.revision.RefundDue.IsPositive() {
+			t.Fatalf("expected the revision to still owe the refund, got %+v", orders.revision)
+		}
+
+		refunder.err = nil
+		for range 2 {
+			if err := editor.Settle(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if refunder.calls != 2 || len(refunder.store.refunds) != 1 || refunder.store.refunds[0].Amount != money.New(500, "USD") {
+			t.Errorf("expected one refund of 5.00 after the failed attempt, got %d calls and %+v", refunder.calls, refunder.store.refunds)
+		}
+
+		if !orders.revision.RefundDue.IsZero() || orders.revision.RefundID == nil || *orders.revision.RefundID != refunder.store.refunds[0].ID {
+			t.Errorf("expected the revision settled with the refund, got %+v", orders.revision)
+		}
+	})
+
+	t.Run("should pick up a refund made for the revision instead of refunding again", func(t *testing.T) {
+		editor, orders, refunder := setup(types.Refund{ID: 7, OrderID: 1, Reason: types.RefundOrderEdited, Note: "order revision 1", Status: types.RefundPending})
+
+		refundId, err := editor.settle(context.Background(), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if refunder.calls != 0 || refundId == nil || *refundId != 7 || !orders.revision.RefundDue.IsZero() {
+			t.Errorf("expected the pending refund recorded on the revision, got %d calls and %+v", refunder.calls, orders.revision)
+		}
+	})
+}
+
+func TestFulfilmentStatus(t *testing.T) {
+	t.Run("should look past refunds for how far the order has got", func(t *testing.T) {
+		order := &types.Order{Status: types.PartiallyRefunded}
+		processing := []types.OrderStatusChange{
+			{FromStatus: types.Pending, ToStatus: types.Paid},
+			{FromStatus: types.Paid, ToStatus: types.Processing},
+			{FromStatus: types.Processing, ToStatus: types.PartiallyRefunded},
+			{FromStatus: types.PartiallyRefunded, ToStatus: types.PartiallyRefunded},
+		}
+		delivered := []types.OrderStatusChange{
+			{FromStatus: types.Shipped, ToStatus: types.Delivered},
+			{FromStatus: types.Delivered, ToStatus: types.PartiallyRefunded},
+		}
+
+		if status := FulfilmentStatus(order, processing); !isEditable(status) {
+			t.Errorf("expected a refunded order still being processed to be editable, got %s", status)
+		}
+
+		if status := FulfilmentStatus(order, delivered); isEditable(status) {
+			t.Errorf("expected a delivered order not to be editable, got %s", status)
+		}
+	})
+}
+
+type mockRevisionStore struct {
+	types.OrderStore
+
+	order    types.Order
+	revision types.OrderRevision
+}
+
+func (m *mockRevisionStore) GetOrderById(ctx context.Context, orderId int) (*types.Order, error) {
+	order := m.order
+	return &order, nil
+}
+
+func (m *mockRevisionStore) LockOrderRevisionById(ctx context.Context, id int) (*types.OrderRevision, error) {
+	revision := m.revision
+	return &revision, nil
+}
+
+func (m *mockRevisionStore) GetUnsettledOrderRevisions(ctx context.Context) ([]types.OrderRevision, error) {
+	if !m.revision.RefundDue.IsPositive() {
+		return []types.OrderRevision{}, nil
+	}
+
+	return []types.OrderRevision{m.revision}, nil
+}
+
+func (m *mockRevisionStore) SettleOrderRevision(ctx context.Context, id int, refundId *int) error {
+	m.revision.RefundDue, m.revision.RefundID = money.Zero(m.revision.RefundDue.Currency), refundId
+	return nil
+}
+
+func (m *mockRevisionStore) WithTx(tx types.DB) types.OrderStore {
+	return m
+}
+
+type mockEditRefundStore struct {
+	types.RefundStore
+
+	refunds []types.Refund
+}
+
+func (m *mockEditRefundStore) GetRefundsByOrderId(ctx context.Context, orderId int) ([]types.Refund, error) {
+	return m.refunds, nil
+}
+
+func (m *mockEditRefundStore) WithTx(tx types.DB) types.RefundStore {
+	return m
+}
+
+type mockEditPaymentStore struct {
+	types.PaymentStore
+
+	payments []types.Payment
+}
+
+func (m *mockEditPaymentStore) GetPaymentsByOrderId(ctx context.Context, orderId int) ([]types.Payment, error) {
+	return m.payments, nil
+}
+
+func (m *mockEditPaymentStore) WithTx(tx types.DB) types.PaymentStore {
+	return m
+}
+
+type mockRefunder struct {
+	store *mockEditRefundStore
+	err   error
+	calls int
+}
+
+func (m *mockRefunder) Refund(ctx context.Context, orderId int, payload types.RefundOrderPayload, createdBy *int) (*types.Refund, error) {
+	m.calls++
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	refund := types.Refund{
+		ID:      len(m.store.refunds) + 1,
+		OrderID: orderId,
+		Amount:  *payload.Amount,
+		Reason:  payload.Reason,
+		Note:    payload.Note,
+		Status:  types.RefundCompleted,
+	}
+	m.store.refunds = append(m.store.refunds, refund)
+
+	return &refund, nil
+}
+
+type mockUnitOfWork struct{}
+
+func (mockUnitOfWork) Do(ctx context.Context, fn func(tx types.DB) error) error {
+	return fn(nil)
+}
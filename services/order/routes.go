@@ -1,29 +1,51 @@
 package order
 
 import (
-	"database/sql"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
+	"time"
 
+	"github.com/go-playground/validator/v10"
 	"github.com/gorilla/mux"
 	"github.com/xelathan/golang_backend/services/auth"
+	"github.com/xelathan/golang_backend/services/idempotency"
 	"github.com/xelathan/golang_backend/types"
 	"github.com/xelathan/golang_backend/utils"
 )
 
 type Handler struct {
-	userStore    types.UserStore
-	orderStore   types.OrderStore
-	productStore types.ProductStore
-	db           *sql.DB
+	userStore     types.UserStore
+	orderStore    types.OrderStore
+	refundStore   types.RefundStore
+	returnStore   types.ReturnStore
+	shipmentStore types.ShipmentStore
+	editor        *Editor
+	payments      types.PaymentProcessor
+	transitions   types.OrderTransitioner
+	keys          *idempotency.Keys
 }
 
-func NewHandler(userStore types.UserStore, orderStore types.OrderStore, productStore types.ProductStore, db *sql.DB) *Handler {
-	return &Handler{userStore: userStore, orderStore: orderStore, productStore: productStore, db: db}
+func NewHandler(userStore types.UserStore, orderStore types.OrderStore, refundStore types.RefundStore, returnStore types.ReturnStore, shipmentStore types.ShipmentStore, editor *Editor, payments types.PaymentProcessor, transitions types.OrderTransitioner, keys *idempotency.Keys) *Handler {
+	return &Handler{userStore: userStore, orderStore: orderStore, refundStore: refundStore, returnStore: returnStore, shipmentStore: shipmentStore, editor: editor, payments: payments, transitions: transitions, keys: keys}
 }
 
+// Order history pages hold defaultPageSize orders unless page_size asks for
+// more, up to maxPageSize.
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
 func (h *Handler) RegisterRoutes(router *mux.Router) {
-	router.HandleFunc("/cancel_order", auth.WithJWTAuth(h.handleCancelOrder, h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/orders", auth.WithJWTAuth(h.handleGetOrders, h.userStore)).Methods(http.MethodGet)
+	router.HandleFunc("/orders/{id}", auth.WithJWTAuth(h.handleGetOrder, h.userStore)).Methods(http.MethodGet)
+	router.HandleFunc("/cancel_order", auth.WithJWTAuth(h.keys.Wrap(h.handleCancelOrder), h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/orders/{id}/transitions", auth.WithAdminAuth(h.handleTransition, h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/orders/{id}/revisions", auth.WithJWTAuth(h.keys.Wrap(h.handleEditOrder), h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/admin/orders/{id}/revisions", auth.WithAdminAuth(h.keys.Wrap(h.handleAdminEditOrder), h.userStore)).Methods(http.MethodPost)
 }
 
 func (h *Handler) handleCancelOrder(w http.ResponseWriter, r *http.Request) {
@@ -41,95 +63,328 @@ func (h *Handler) handleCancelOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tx, err := h.db.Begin()
+	order, err := h.orderStore.GetOrderById(r.Context(), cancelOrderPayload.OrderId)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// other users' orders are reported the same as missing ones
+	if order == nil || order.UserId != userId {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid order id"))
+		return
+	}
+
+	// customers can only cancel orders they have not paid for yet
+	if order.Status != types.Pending {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("cannot cancel an order that is not pending"))
+		return
+	}
+
+	// a payment still waiting on the customer must not go through later
+	if err := h.payments.VoidOrderPayments(r.Context(), order.ID); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// cancelling restocks the items and gives back the promotions used
+	if _, err := h.transitions.Transition(r.Context(), order.ID, types.Cancelled, &userId, "cancelled by customer"); err != nil {
+		utils.WriteError(w, transitionStatus(err), err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]string{"status": "lets go baby"})
+}
+
+// handleGetOrders lists the user's orders, newest first, e.g.
+// GET /orders?status=shipped&from=2024-11-01&to=2024-11-30&page=2&page_size=10
+func (h *Handler) handleGetOrders(w http.ResponseWriter, r *http.Request) {
+	userId := auth.GetUserIdFromContext(r.Context())
+	if userId == -1 {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid userId in token claims"))
+		return
+	}
+
+	filter, page, err := parseHistoryQuery(r.URL.Query())
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	orders, total, err := h.orderStore.GetOrderHistoryByUserId(r.Context(), userId, filter)
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, err)
-		tx.Rollback()
 		return
 	}
 
-	// get history of orders from user
-	orderHistory, err := h.orderStore.GetOrderHistoryByUserId(userId)
+	utils.WriteJSON(w, http.StatusOK, map[string]any{
+		"orders":   orders,
+		"page":     page,
+		"pageSize": filter.Limit,
+		"total":    total,
+	})
+}
+
+func (h *Handler) handleGetOrder(w http.ResponseWriter, r *http.Request) {
+	userId := auth.GetUserIdFromContext(r.Context())
+	if userId == -1 {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid userId in token claims"))
+		return
+	}
+
+	orderId, err := strconv.Atoi(mux.Vars(r)["id"])
 	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid order id"))
+		return
+	}
+
+	order, err := h.orderStore.GetOrderById(r.Context(), orderId)
+	if err != nil && !errors.Is(err, ErrNotFound) {
 		utils.WriteError(w, http.StatusInternalServerError, err)
-		tx.Rollback()
 		return
 	}
 
-	if len(orderHistory) == 0 {
-		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("no orders to cancel"))
-		tx.Rollback()
+	// other users' orders are reported the same as missing ones
+	if order == nil || order.UserId != userId {
+		utils.WriteError(w, http.StatusNotFound, ErrNotFound)
 		return
 	}
 
-	// create map of orderId: orderHistoryItem
-	orderHistoryMap := map[int][]types.OrderHistory{}
-	for _, or := range orderHistory {
-		orderHistoryMap[or.OrderId] = append(orderHistoryMap[or.OrderId], or)
+	items, err := h.orderStore.GetOrderItems(r.Context(), order.ID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
 	}
 
-	// get orderItems of the orderId given in payload
-	orderItems := orderHistoryMap[cancelOrderPayload.OrderId]
-	if len(orderItems) == 0 {
-		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid order id"))
-		tx.Rollback()
+	history, err := h.orderStore.GetOrderStatusHistory(r.Context(), order.ID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	// if order is not pending then we cancel
-	if orderItems[0].Status != "pending" {
-		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("cannot cancel an order that is not pending"))
-		tx.Rollback()
+	refunds, err := h.refundStore.GetRefundsByOrderId(r.Context(), order.ID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	// create list of productIds to query products and map of new quantities
-	newItemQuantities := map[int]int{}
-	productIds := []int{}
-	for _, item := range orderItems {
-		newItemQuantities[item.ProductId] = item.Quantity
-		productIds = append(productIds, item.ProductId)
+	returns, err := h.returnStore.GetReturnsByOrderId(r.Context(), order.ID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
 	}
 
-	products, err := h.productStore.GetProductsByID(productIds)
+	shipments, err := h.shipmentStore.GetShipmentsByOrderId(r.Context(), order.ID)
 	if err != nil {
-		utils.WriteJSON(w, http.StatusInternalServerError, err)
-		tx.Rollback()
+		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	// update products based on new quantities: restock since order cancelled
-	productsMap := map[int]types.Product{}
-	for _, product := range products {
-		product.Quantity += newItemQuantities[product.ID]
-		productsMap[product.ID] = product
+	revisions, err := h.orderStore.GetOrderRevisions(r.Context(), order.ID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, types.OrderDetail{Order: *order, Items: items, History: history, Refunds: refunds, Returns: returns, Revisions: revisions, Shipments: shipments})
+}
+
+// handleEditOrder edits one of the user's orders that has not shipped yet.
+func (h *Handler) handleEditOrder(w http.ResponseWriter, r *http.Request) {
+	userId := auth.GetUserIdFromContext(r.Context())
+	if userId == -1 {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid userId in token claims"))
+		return
 	}
 
-	if err := h.productStore.UpdateProductBatch(productsMap); err != nil {
-		utils.WriteJSON(w, http.StatusInternalServerError, err)
-		tx.Rollback()
+	orderId, payload, err := parseEdit(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
 		return
 	}
 
-	// set order status to cancelled
-	if err := h.orderStore.UpdateOrder(types.Order{
-		ID:        orderItems[0].OrderId,
-		UserId:    userId,
-		Total:     orderItems[0].Total,
-		Status:    types.Cancelled,
-		Address:   orderItems[0].Address,
-		CreatedAt: orderItems[0].CreatedAt,
-	}); err != nil {
-		utils.WriteJSON(w, http.StatusInternalServerError, err)
-		tx.Rollback()
+	order, err := h.orderStore.GetOrderById(r.Context(), orderId)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	if err := tx.Commit(); err != nil {
-		utils.WriteJSON(w, http.StatusInternalServerError, err)
-		tx.Rollback()
+	// other users' orders are reported the same as missing ones
+	if order == nil || order.UserId != userId {
+		utils.WriteError(w, http.StatusNotFound, ErrNotFound)
 		return
 	}
 
-	utils.WriteJSON(w, http.StatusOK, map[string]string{"status": "lets go baby"})
+	revision, err := h.editor.Edit(r.Context(), orderId, payload, &userId)
+	if err != nil {
+		utils.WriteError(w, editStatus(err), err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, revision)
+}
+
+func (h *Handler) handleAdminEditOrder(w http.ResponseWriter, r *http.Request) {
+	orderId, payload, err := parseEdit(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	userId := auth.GetUserIdFromContext(r.Context())
+	revision, err := h.editor.Edit(r.Context(), orderId, payload, &userId)
+	if err != nil {
+		utils.WriteError(w, editStatus(err), err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, revision)
+}
+
+func parseEdit(r *http.Request) (int, types.EditOrderPayload, error) {
+	payload := types.EditOrderPayload{}
+	orderId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		return 0, payload, fmt.Errorf("invalid order id")
+	}
+
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		return 0, payload, err
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		return 0, payload, fmt.Errorf("invalid payload %v", errors)
+	}
+
+	return orderId, payload, nil
+}
+
+func (h *Handler) handleTransition(w http.ResponseWriter, r *http.Request) {
+	orderId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid order id"))
+		return
+	}
+
+	payload := types.OrderTransitionPayload{}
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	userId := auth.GetUserIdFromContext(r.Context())
+	order, err := h.transitions.Transition(r.Context(), orderId, payload.Status, &userId, payload.Reason)
+	if err != nil {
+		utils.WriteError(w, transitionStatus(err), err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, order)
+}
+
+// transitionStatus is the status code for an error from a transition: moves
+// the state machine refuses conflict with the order's current status.
+func transitionStatus(err error) int {
+	var refused *transitionError
+	switch {
+	case errors.As(err, &refused):
+		return http.StatusConflict
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	}
+
+	return http.StatusInternalServerError
+}
+
+// editStatus is the status code for an error from an edit: edits refused
+// for the order or what was asked are bad requests.
+func editStatus(err error) int {
+	var refused *editError
+	switch {
+	case errors.As(err, &refused):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	}
+
+	return http.StatusInternalServerError
+}
+
+// statuses are the values the order history can be filtered on.
+var statuses = []types.OrderStatus{
+	types.Pending, types.Paid, types.Processing, types.Shipped, types.Delivered,
+	types.Cancelled, types.Refunded, types.PartiallyRefunded, types.Returned,
+}
+
+// parseHistoryQuery reads the order history filter and the page asked for.
+// from and to are dates or RFC 3339 times; a date for to includes that day.
+func parseHistoryQuery(query url.Values) (types.OrderHistoryFilter, int, error) {
+	filter := types.OrderHistoryFilter{Limit: defaultPageSize}
+
+	if status := query.Get("status"); status != "" {
+		for _, known := range statuses {
+			if types.OrderStatus(status) == known {
+				filter.Status = known
+			}
+		}
+		if filter.Status == "" {
+			return filter, 0, fmt.Errorf("invalid status")
+		}
+	}
+
+	var err error
+	if from := query.Get("from"); from != "" {
+		if filter.From, _, err = parseDate(from); err != nil {
+			return filter, 0, fmt.Errorf("invalid from")
+		}
+	}
+
+	if to := query.Get("to"); to != "" {
+		var dateOnly bool
+		if filter.To, dateOnly, err = parseDate(to); err != nil {
+			return filter, 0, fmt.Errorf("invalid to")
+		}
+		if dateOnly {
+			filter.To = filter.To.AddDate(0, 0, 1)
+		}
+	}
+
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.From.Before(filter.To) {
+		return filter, 0, fmt.Errorf("from must be before to")
+	}
+
+	page := 1
+	if value := query.Get("page"); value != "" {
+		if page, err = strconv.Atoi(value); err != nil || page <= 0 {
+			return filter, 0, fmt.Errorf("invalid page")
+		}
+	}
+
+	if value := query.Get("page_size"); value != "" {
+		size, err := strconv.Atoi(value)
+		if err != nil || size <= 0 || size > maxPageSize {
+			return filter, 0, fmt.Errorf("invalid page_size, must be between 1 and %d", maxPageSize)
+		}
+		filter.Limit = size
+	}
+
+	filter.Offset = (page - 1) * filter.Limit
+
+	return filter, page, nil
+}
+
+func parseDate(value string) (time.Time, bool, error) {
+	if date, err := time.Parse(time.DateOnly, value); err == nil {
+		return date, true, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	return t, false, err
 }
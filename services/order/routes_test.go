@@ -0,0 +1,158 @@
+package order
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/xelathan/golang_backend/services/auth"
+	"github.com/xelathan/golang_backend/types"
+)
+
+func TestOrderHandlers(t *testing.T) {
+	orderStore := &mockOrderStore{orders: map[int]types.Order{
+		1: {ID: 1, UserId: 7, Status: types.Paid},
+		2: {ID: 2, UserId: 8, Status: types.Pending},
+	}}
+	handler := NewHandler(nil, orderStore, &mockRefundStore{}, &mockReturnStore{}, &mockShipmentStore{}, nil, nil, nil, nil)
+
+	get := func(path string, userId int) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(http.MethodGet, path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserKey, userId))
+
+		rr := httptest.NewRecorder()
+		router := mux.NewRouter()
+		router.HandleFunc("/orders", handler.handleGetOrders)
+		router.HandleFunc("/orders/{id}", handler.handleGetOrder)
+		router.ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	t.Run("should return the user's own order", func(t *testing.T) {
+		if rr := get("/orders/1", 7); rr.Code != http.StatusOK {
+			t.Errorf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+	})
+
+	t.Run("should not show another user's order", func(t *testing.T) {
+		if rr := get("/orders/2", 7); rr.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+	})
+
+	t.Run("should only list the user's orders", func(t *testing.T) {
+		if rr := get("/orders?status=paid&page=2&page_size=5", 7); rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		if orderStore.userId != 7 || orderStore.filter.Status != types.Paid || orderStore.filter.Offset != 5 {
+			t.Errorf("expected the second page of user 7's paid orders, got user %d and %+v", orderStore.userId, orderStore.filter)
+		}
+	})
+
+	t.Run("should fail on an invalid filter", func(t *testing.T) {
+		for _, query := range []string{"status=lost", "page=0", "page_size=1000", "from=yesterday", "from=2024-11-02&to=2024-11-01"} {
+			if rr := get("/orders?"+query, 7); rr.Code != http.StatusBadRequest {
+				t.Errorf("expected status code %d for %s, got %d", http.StatusBadRequest, query, rr.Code)
+			}
+		}
+	})
+}
+
+func TestParseHistoryQuery(t *testing.T) {
+	t.Run("should include the whole day a date ends on", func(t *testing.T) {
+		filter, _, err := parseHistoryQuery(url.Values{"from": {"2024-11-01"}, "to": {"2024-11-30"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !filter.To.Equal(time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC)) {
+			t.Errorf("expected orders up to the start of December, got %s", filter.To)
+		}
+	})
+
+	t.Run("should take times as they are", func(t *testing.T) {
+		filter, _, err := parseHistoryQuery(url.Values{"to": {"2024-11-30T12:00:00Z"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !filter.To.Equal(time.Date(2024, 11, 30, 12, 0, 0, 0, time.UTC)) {
+			t.Errorf("expected orders up to noon, got %s", filter.To)
+		}
+	})
+}
+
+type mockOrderStore struct {
+	types.OrderStore
+
+	orders map[int]types.Order
+	userId int
+	filter types.OrderHistoryFilter
+}
+
+func (m *mockOrderStore) GetOrderById(ctx context.Context, orderId int) (*types.Order, error) {
+	order, ok := m.orders[orderId]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return &order, nil
+}
+
+func (m *mockOrderStore) GetOrderItems(ctx context.Context, orderId int) ([]types.OrderItem, error) {
+	return []types.OrderItem{}, nil
+}
+
+func (m *mockOrderStore) GetOrderStatusHistory(ctx context.Context, orderId int) ([]types.OrderStatusChange, error) {
+	return []types.OrderStatusChange{}, nil
+}
+
+func (m *mockOrderStore) GetOrderRevisions(ctx context.Context, orderId int) ([]types.OrderRevision, error) {
+	return []types.OrderRevision{}, nil
+}
+
+func (m *mockOrderStore) GetOrderHistoryByUserId(ctx context.Context, userId int, filter types.OrderHistoryFilter) ([]types.Order, int, error) {
+	m.userId, m.filter = userId, filter
+
+	orders := []types.Order{}
+	for _, order := range m.orders {
+		if order.UserId == userId {
+			orders = append(orders, order)
+		}
+	}
+
+	return orders, len(orders), nil
+}
+
+type mockRefundStore struct {
+	types.RefundStore
+}
+
+func (m *mockRefundStore) GetRefundsByOrderId(ctx context.Context, orderId int) ([]types.Refund, error) {
+	return []types.Refund{}, nil
+}
+
+type mockReturnStore struct {
+	types.ReturnStore
+}
+
+func (m *mockReturnStore) GetReturnsByOrderId(ctx context.Context, orderId int) ([]types.Return, error) {
+	return []types.Return{}, nil
+}
+
+type mockShipmentStore struct {
+	types.ShipmentStore
+}
+
+func (m *mockShipmentStore) GetShipmentsByOrderId(ctx context.Context, orderId int) ([]types.Shipment, error) {
+	return []types.Shipment{}, nil
+}
@@ -0,0 +1,232 @@
+package order
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/xelathan/golang_backend/money"
+	"github.com/xelathan/golang_backend/types"
+)
+
+// transitions lists the statuses an order can move to from each status.
+// Cancelled and refunded orders are final.
+var transitions = map[types.OrderStatus][]types.OrderStatus{
+	types.Pending:           {types.Paid, types.Cancelled},
+	types.Paid:              {types.Processing, types.Cancelled, types.PartiallyRefunded, types.Refunded},
+	types.Processing:        {types.Shipped, types.Cancelled, types.PartiallyRefunded, types.Refunded},
+	types.Shipped:           {types.Delivered, types.Returned},
+	types.Delivered:         {types.Returned, types.PartiallyRefunded, types.Refunded},
+	types.Returned:          {types.PartiallyRefunded, types.Refunded},
+	types.PartiallyRefunded: {types.Processing, types.Shipped, types.Delivered, types.Returned, types.PartiallyRefunded, types.Refunded},
+}
+
+// guard checks an order may enter a status beyond the move being allowed.
+type guard func(order *types.Order, payments []types.Payment) error
+
+var guards = map[types.OrderStatus]guard{
+	types.Paid:              paidInFull,
+	types.Shipped:           hasAddress,
+	types.Cancelled:         nothingCaptured,
+	types.PartiallyRefunded: refundedInPart,
+	types.Refunded:          refundedInFull,
+}
+
+// CanTransition reports whether an order may move between the statuses.
+func CanTransition(from types.OrderStatus, to types.OrderStatus) bool {
+	for _, status := range transitions[from] {
+		if status == to {
+			return true
+		}
+	}
+
+	return false
+}
+
+// transitionError is a transition refused by the state machine or one of
+// its guards, as opposed to a failure to carry it out.
+type transitionError struct {
+	err error
+}
+
+func (e *transitionError) Error() string {
+	return e.err.Error()
+}
+
+// StateMachine moves orders between statuses. Entering cancelled puts the
+// order's items back in stock, or off backorder, and its promotions up for use again, in the
+// same transaction as the status change.
+type StateMachine struct {
+	uow            types.UnitOfWork
+	orderStore     types.OrderStore
+	paymentStore   types.PaymentStore
+	productStore   types.ProductStore
+	promotionStore types.PromotionStore
+}
+
+func NewStateMachine(uow types.UnitOfWork, orderStore types.OrderStore, paymentStore types.PaymentStore, productStore types.ProductStore, promotionStore types.PromotionStore) *StateMachine {
+	return &StateMachine{uow: uow, orderStore: orderStore, paymentStore: paymentStore, productStore: productStore, promotionStore: promotionStore}
+}
+
+func (m *StateMachine) Transition(ctx context.Context, orderId int, to types.OrderStatus, changedBy *int, reason string) (*types.Order, error) {
+	order, err := m.orderStore.GetOrderById(ctx, orderId)
+	if err != nil {
+		return nil, err
+	}
+
+	if !CanTransition(order.Status, to) {
+		return nil, &transitionError{fmt.Errorf("cannot move order from %s to %s", order.Status, to)}
+	}
+
+	if check, ok := guards[to]; ok {
+		payments, err := m.paymentStore.GetPaymentsByOrderId(ctx, orderId)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := check(order, payments); err != nil {
+			return nil, &transitionError{err}
+		}
+	}
+
+	// the status update only applies if the order is still where it was
+	// read, so concurrent transitions cannot both go through
+	change := types.OrderStatusChange{OrderID: orderId, FromStatus: order.Status, ToStatus: to, ChangedBy: changedBy, Reason: reason}
+	err = m.uow.Do(ctx, func(tx types.DB) error {
+		if err := m.orderStore.WithTx(tx).UpdateOrderStatus(ctx, change); err != nil {
+			return err
+		}
+
+		if to != types.Cancelled {
+			return nil
+		}
+
+		if err := restock(ctx, m.orderStore.WithTx(tx), m.productStore.WithTx(tx), orderId); err != nil {
+			return err
+		}
+
+		return m.promotionStore.WithTx(tx).ReverseRedemptions(ctx, orderId)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	order.Status = to
+
+	return order, nil
+}
+
+func restock(ctx context.Context, orderStore types.OrderStore, productStore types.ProductStore, orderId int) error {
+	items, err := orderStore.GetOrderItems(ctx, orderId)
+	if err != nil {
+		return err
+	}
+
+	quantities, backordered := map[int]int{}, map[int]int{}
+	for _, item := range items {
+		if item.FulfilmentStatus == types.FulfilmentBackordered {
+			backordered[item.ProductID] += item.Quantity
+		} else {
+			quantities[item.ProductID] += item.Quantity
+		}
+	}
+
+	return release(ctx, productStore, quantities, backordered)
+}
+
+// Restock puts quantities, keyed by product id, back in stock. The products
+// are locked first, so productStore should be bound to a transaction.
+func Restock(ctx context.Context, productStore types.ProductStore, quantities map[int]int) error {
+	return release(ctx, productStore, quantities, nil)
+}
+
+// release gives back what order items held of their products: allocated
+// units go back in stock and backordered ones come off the backorder. Both
+// are keyed by product id.
+func release(ctx context.Context, productStore types.ProductStore, quantities map[int]int, backordered map[int]int) error {
+	productIds := []int{}
+	for productId := range quantities {
+		productIds = append(productIds, productId)
+	}
+	for productId := range backordered {
+		if _, ok := quantities[productId]; !ok {
+			productIds = append(productIds, productId)
+		}
+	}
+
+	if len(productIds) == 0 {
+		return nil
+	}
+
+	products, err := productStore.LockProductsByID(ctx, productIds)
+	if err != nil {
+		return err
+	}
+
+	productsMap := map[int]types.Product{}
+	for _, product := range products {
+		product.Quantity += quantities[product.ID]
+		product.Backordered = max(product.Backordered-backordered[product.ID], 0)
+		productsMap[product.ID] = product
+	}
+
+	return productStore.UpdateProductBatch(ctx, productsMap)
+}
+
+// PaymentTotals adds up what the order's payments have captured and what
+// has been refunded of that.
+func PaymentTotals(order *types.Order, payments []types.Payment) (captured money.Money, refunded money.Money) {
+	captured, refunded = money.Zero(order.Total.Currency), money.Zero(order.Total.Currency)
+	for _, payment := range payments {
+		switch payment.Status {
+		case types.PaymentCaptured, types.PaymentPartiallyRefunded, types.PaymentRefunded:
+			captured = captured.Add(payment.Amount)
+			refunded = refunded.Add(payment.Refunded)
+		}
+	}
+
+	return captured, refunded
+}
+
+func paidInFull(order *types.Order, payments []types.Payment) error {
+	captured, _ := PaymentTotals(order, payments)
+	if captured.Cmp(order.Total) < 0 {
+		return fmt.Errorf("order total %s is not paid, %s captured", order.Total, captured)
+	}
+
+	return nil
+}
+
+func hasAddress(order *types.Order, payments []types.Payment) error {
+	if order.Address == "" {
+		return fmt.Errorf("order has no address to ship to")
+	}
+
+	return nil
+}
+
+func nothingCaptured(order *types.Order, payments []types.Payment) error {
+	captured, refunded := PaymentTotals(order, payments)
+	if captured.Cmp(refunded) > 0 {
+		return fmt.Errorf("%s captured for the order has to be refunded before it is cancelled", captured.Sub(refunded))
+	}
+
+	return nil
+}
+
+func refundedInPart(order *types.Order, payments []types.Payment) error {
+	captured, refunded := PaymentTotals(order, payments)
+	if !refunded.IsPositive() || refunded.Cmp(captured) >= 0 {
+		return fmt.Errorf("order has %s refunded of %s captured, which is not a partial refund", refunded, captured)
+	}
+
+	return nil
+}
+
+func refundedInFull(order *types.Order, payments []types.Payment) error {
+	captured, refunded := PaymentTotals(order, payments)
+	if !captured.IsPositive() || refunded.Cmp(captured) < 0 {
+		return fmt.Errorf("order has %s refunded of %s captured", refunded, captured)
+	}
+
+	return nil
+}
@@ -0,0 +1,58 @@
+package order
+
+import (
+	"testing"
+
+	"github.com/xelathan/golang_backend/money"
+	"github.com/xelathan/golang_backend/types"
+)
+
+func TestStateMachine(t *testing.T) {
+	t.Run("should only allow the defined transitions", func(t *testing.T) {
+		cases := []struct {
+			from, to types.OrderStatus
+			allowed  bool
+		}{
+			{types.Pending, types.Paid, true},
+			{types.Pending, types.Shipped, false},
+			{types.Paid, types.Processing, true},
+			{types.Shipped, types.Delivered, true},
+			{types.Shipped, types.Cancelled, false},
+			{types.Delivered, types.Returned, true},
+			{types.Cancelled, types.Pending, false},
+			{types.Refunded, types.Processing, false},
+		}
+
+		for _, c := range cases {
+			if CanTransition(c.from, c.to) != c.allowed {
+				t.Errorf("expected %s -> %s allowed to be %v", c.from, c.to, c.allowed)
+			}
+		}
+	})
+
+	t.Run("should guard statuses on the order's payments", func(t *testing.T) {
+		order := &types.Order{Total: money.New(5000, "USD"), Address: "1 Main St"}
+		captured := []types.Payment{{Status: types.PaymentCaptured, Amount: money.New(5000, "USD"), Refunded: money.Zero("USD")}}
+		partly := []types.Payment{{Status: types.PaymentPartiallyRefunded, Amount: money.New(5000, "USD"), Refunded: money.New(1000, "USD")}}
+
+		if err := paidInFull(order, nil); err == nil {
+			t.Error("expected an unpaid order not to be paid")
+		}
+
+		if err := paidInFull(order, captured); err != nil {
+			t.Errorf("expected a captured order to be paid, got %v", err)
+		}
+
+		if err := nothingCaptured(order, captured); err == nil {
+			t.Error("expected a captured order not to be cancellable")
+		}
+
+		if err := refundedInPart(order, partly); err != nil {
+			t.Errorf("expected a partial refund, got %v", err)
+		}
+
+		if err := refundedInFull(order, partly); err == nil {
+			t.Error("expected a partial refund not to count as a full one")
+		}
+	})
+}
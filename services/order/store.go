@@ -1,22 +1,42 @@
 package order
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"strings"
 
+	"github.com/xelathan/golang_backend/db"
 	"github.com/xelathan/golang_backend/types"
 )
 
+var (
+	ErrNotFound         = errors.New("order not found")
+	ErrRevisionNotFound = errors.New("order revision not found")
+)
+
 type Store struct {
-	db *sql.DB
+	db types.DB
 }
 
 func NewStore(db *sql.DB) *Store {
 	return &Store{db: db}
 }
 
-func (s *Store) CreateOrder(order types.Order) (int, error) {
-	res, err := s.db.Exec("INSERT INTO orders (userId, total, status, address) VALUES (?,?,?,?)", order.UserId, order.Total, order.Status, order.Address)
+func (s *Store) WithTx(tx types.DB) types.OrderStore {
+	return &Store{db: tx}
+}
+
+func (s *Store) CreateOrder(ctx context.Context, order types.Order) (int, error) {
+	exchangeRate := order.ExchangeRate
+	if exchangeRate == "" {
+		exchangeRate = "1"
+	}
+
+	res, err := s.db.ExecContext(ctx, "INSERT INTO orders (userId, total, discount, tax, shippingCost, shippingMethodId, shippingMethod, currency, exchangeRate, status, address) VALUES (?,?,?,?,?,?,?,?,?,?,?)",
+		order.UserId, order.Total.Amount, order.Discount.Amount, order.Tax.Amount, order.ShippingCost.Amount,
+		sql.NullInt64{Int64: int64(order.ShippingMethodID), Valid: order.ShippingMethodID != 0}, sql.NullString{String: order.ShippingMethod, Valid: order.ShippingMethod != ""}, order.Total.Currency, exchangeRate, order.Status, order.Address)
 	if err != nil {
 		return 0, err
 	}
@@ -29,17 +49,27 @@ func (s *Store) CreateOrder(order types.Order) (int, error) {
 	return int(id), nil
 }
 
-func (s *Store) CreateOrderItem(orderItem types.OrderItem) error {
-	_, err := s.db.Exec("INSERT INTO order_items (orderId, productId, quantity, price) VALUES (?,?,?,?)", orderItem.OrderID, orderItem.ProductID, orderItem.Quantity, orderItem.Price)
+func (s *Store) CreateOrderItem(ctx context.Context, orderItem types.OrderItem) (int, error) {
+	status := orderItem.FulfilmentStatus
+	if status == "" {
+		status = types.FulfilmentAllocated
+	}
+
+	res, err := s.db.ExecContext(ctx, "INSERT INTO order_items (orderId, productId, productName, productImage, quantity, price, currency, fulfilmentStatus, expectedAt) VALUES (?,?,?,?,?,?,?,?,?)", orderItem.OrderID, orderItem.ProductID, orderItem.ProductName, orderItem.ProductImage, orderItem.Quantity, orderItem.Price.Amount, orderItem.Price.Currency, status, orderItem.ExpectedAt)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	return nil
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
 }
 
-func (s *Store) UpdateOrder(order types.Order) error {
-	_, err := s.db.Exec("UPDATE orders SET total = ?, status = ?, address = ? WHERE id = ?", order.Total, order.Status, order.Address, order.ID)
+func (s *Store) UpdateOrder(ctx context.Context, order types.Order) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE orders SET total = ?, currency = ?, status = ?, address = ? WHERE id = ?", order.Total.Amount, order.Total.Currency, order.Status, order.Address, order.ID)
 	if err != nil {
 		return err
 	}
@@ -47,42 +77,164 @@ func (s *Store) UpdateOrder(order types.Order) error {
 	return nil
 }
 
-func (s *Store) GetOrderHistoryByUserId(userId int) ([]types.OrderHistory, error) {
-	rows, err := s.db.Query("SELECT o.id, o.total, o.status, o.address, o.createdAt, oi.productId, oi.quantity, oi.price FROM orders o JOIN order_items oi ON o.id = oi.orderId WHERE o.userId = ? ORDER BY o.createdAt DESC", userId)
-	if err != nil {
-		return nil, err
+func (s *Store) GetOrderHistoryByUserId(ctx context.Context, userId int, filter types.OrderHistoryFilter) ([]types.Order, int, error) {
+	where := "WHERE userId = ?"
+	args := []any{userId}
+	if filter.Status != "" {
+		where += " AND status = ?"
+		args = append(args, filter.Status)
+	}
+	if !filter.From.IsZero() {
+		where += " AND createdAt >= ?"
+		args = append(args, filter.From)
+	}
+	if !filter.To.IsZero() {
+		where += " AND createdAt < ?"
+		args = append(args, filter.To)
 	}
 
-	orders := []types.OrderHistory{}
+	var total int
+	if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM orders "+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
 
+	rows, err := s.db.QueryContext(ctx, "SELECT * FROM orders "+where+" ORDER BY createdAt DESC, id DESC LIMIT ? OFFSET ?", append(args, filter.Limit, filter.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	orders := []types.Order{}
 	for rows.Next() {
-		order, err := scanRowsIntoOrderHistory(rows)
+		order, err := scanRowsIntoOrder(rows)
 		if err != nil {
-			return nil, err
+			return nil, 0, err
 		}
 
 		orders = append(orders, *order)
 	}
 
-	return orders, nil
+	return orders, total, rows.Err()
 }
 
-func scanRowsIntoOrderHistory(rows *sql.Rows) (*types.OrderHistory, error) {
-	orderHistoryRow := new(types.OrderHistory)
+func (s *Store) UpdateOrderStatus(ctx context.Context, change types.OrderStatusChange) error {
+	return db.InTxContext(ctx, s.db, func(tx types.DB) error {
+		res, err := tx.ExecContext(ctx, "UPDATE orders SET status = ? WHERE id = ? AND status = ?", change.ToStatus, change.OrderID, change.FromStatus)
+		if err != nil {
+			return err
+		}
+
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if affected == 0 {
+			return fmt.Errorf("order is no longer %s", change.FromStatus)
+		}
 
-	err := rows.Scan(&orderHistoryRow.OrderId, &orderHistoryRow.Total, &orderHistoryRow.Status, &orderHistoryRow.Address, &orderHistoryRow.CreatedAt, &orderHistoryRow.ProductId, &orderHistoryRow.Quantity, &orderHistoryRow.Price)
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO order_status_history (orderId, fromStatus, toStatus, changedBy, reason) VALUES (?,?,?,?,?)",
+			change.OrderID, change.FromStatus, change.ToStatus, change.ChangedBy, change.Reason,
+		)
+
+		return err
+	})
+}
+
+func (s *Store) GetOrderStatusHistory(ctx context.Context, orderId int) ([]types.OrderStatusChange, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT id, orderId, fromStatus, toStatus, changedBy, reason, createdAt FROM order_status_history WHERE orderId = ? ORDER BY id", orderId)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
+
+	history := []types.OrderStatusChange{}
+	for rows.Next() {
+		change := types.OrderStatusChange{}
+		var changedBy sql.NullInt64
+		if err := rows.Scan(&change.ID, &change.OrderID, &change.FromStatus, &change.ToStatus, &changedBy, &change.Reason, &change.CreatedAt); err != nil {
+			return nil, err
+		}
+
+		if changedBy.Valid {
+			userId := int(changedBy.Int64)
+			change.ChangedBy = &userId
+		}
 
-	return orderHistoryRow, nil
+		history = append(history, change)
+	}
+
+	return history, rows.Err()
+}
+
+const selectOrderItems = "SELECT oi.id, oi.orderId, oi.productId, oi.productName, oi.productImage, oi.quantity, oi.price, oi.currency, oi.fulfilmentStatus, oi.expectedAt FROM order_items oi"
+
+func (s *Store) GetOrderItems(ctx context.Context, orderId int) ([]types.OrderItem, error) {
+	return s.getOrderItems(ctx, " WHERE oi.orderId = ? ORDER BY oi.id", orderId)
+}
+
+func (s *Store) GetBackorderedItems(ctx context.Context, productId int) ([]types.OrderItem, error) {
+	return s.getOrderItems(ctx,
+		" JOIN orders o ON o.id = oi.orderId WHERE oi.productId = ? AND oi.fulfilmentStatus = ? AND o.status NOT IN (?,?) ORDER BY oi.id",
+		productId, types.FulfilmentBackordered, types.Cancelled, types.Refunded,
+	)
+}
+
+func (s *Store) AllocateOrderItems(ctx context.Context, ids []int) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	args := []any{types.FulfilmentAllocated}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+
+	_, err := s.db.ExecContext(ctx, "UPDATE order_items SET fulfilmentStatus = ?, expectedAt = NULL WHERE id IN (?"+strings.Repeat(",?", len(ids)-1)+")", args...)
+
+	return err
+}
+
+func (s *Store) getOrderItems(ctx context.Context, suffix string, args ...any) ([]types.OrderItem, error) {
+	rows, err := s.db.QueryContext(ctx, selectOrderItems+suffix, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []types.OrderItem{}
+	for rows.Next() {
+		item := types.OrderItem{}
+		var expectedAt sql.NullTime
+		if err := rows.Scan(&item.ID, &item.OrderID, &item.ProductID, &item.ProductName, &item.ProductImage, &item.Quantity, &item.Price.Amount, &item.Price.Currency, &item.FulfilmentStatus, &expectedAt); err != nil {
+			return nil, err
+		}
+
+		if expectedAt.Valid {
+			item.ExpectedAt = &expectedAt.Time
+		}
+
+		items = append(items, item)
+	}
+
+	return items, rows.Err()
+}
+
+func (s *Store) GetOrderById(ctx context.Context, orderId int) (*types.Order, error) {
+	return s.getOrderById(ctx, orderId, "")
+}
+
+func (s *Store) LockOrderById(ctx context.Context, orderId int) (*types.Order, error) {
+	return s.getOrderById(ctx, orderId, " FOR UPDATE")
 }
 
-func (s *Store) GetOrderById(orderId int) (*types.Order, error) {
-	rows, err := s.db.Query("SELECT * FROM orders WHERE orderId = ?", orderId)
+func (s *Store) getOrderById(ctx context.Context, orderId int, suffix string) (*types.Order, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT * FROM orders WHERE id = ?"+suffix, orderId)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	order := new(types.Order)
 	for rows.Next() {
@@ -93,7 +245,7 @@ func (s *Store) GetOrderById(orderId int) (*types.Order, error) {
 	}
 
 	if order.ID == 0 {
-		return nil, fmt.Errorf("order does not exist")
+		return nil, ErrNotFound
 	}
 
 	return order, nil
@@ -101,10 +253,227 @@ func (s *Store) GetOrderById(orderId int) (*types.Order, error) {
 
 func scanRowsIntoOrder(rows *sql.Rows) (*types.Order, error) {
 	order := new(types.Order)
-	err := rows.Scan(&order.ID, &order.UserId, &order.Total, &order.Status, &order.Address, &order.CreatedAt)
+	shippingMethodId, shippingMethod := sql.NullInt64{}, sql.NullString{}
+	err := rows.Scan(&order.ID, &order.UserId, &order.Total.Amount, &order.Discount.Amount, &order.Tax.Amount, &order.ShippingCost.Amount, &shippingMethodId, &shippingMethod, &order.Total.Currency, &order.ExchangeRate, &order.Status, &order.Address, &order.CreatedAt)
 	if err != nil {
 		return nil, err
 	}
+	order.Discount.Currency = order.Total.Currency
+	order.Tax.Currency = order.Total.Currency
+	order.ShippingCost.Currency = order.Total.Currency
+	order.ShippingMethodID = int(shippingMethodId.Int64)
+	order.ShippingMethod = shippingMethod.String
 
 	return order, nil
 }
+
+func (s *Store) HasCompletedOrderWithProduct(ctx context.Context, userId int, productId int) (bool, error) {
+	var exists bool
+	query := "SELECT EXISTS (SELECT 1 FROM orders o JOIN order_items oi ON o.id = oi.orderId WHERE o.userId = ? AND oi.productId = ? AND o.status = ?)"
+	if err := s.db.QueryRowContext(ctx, query, userId, productId, types.Delivered).Scan(&exists); err != nil {
+		return false, err
+	}
+
+	return exists, nil
+}
+
+func (s *Store) ReviseOrder(ctx context.Context, order types.Order, revision types.OrderRevision) (int, error) {
+	previousAddress := order.Address
+	if revision.Address != "" {
+		previousAddress = revision.PreviousAddress
+	}
+
+	var id int64
+	err := db.InTxContext(ctx, s.db, func(tx types.DB) error {
+		var status types.OrderStatus
+		var total int64
+		var address string
+		err := tx.QueryRowContext(ctx, "SELECT status, total, address FROM orders WHERE id = ? FOR UPDATE", order.ID).Scan(&status, &total, &address)
+		if err != nil {
+			return err
+		}
+
+		if status != order.Status || total != revision.PreviousTotal.Amount || address != previousAddress {
+			return fmt.Errorf("order has changed since it was read")
+		}
+
+		_, err = tx.ExecContext(ctx, "UPDATE orders SET total = ?, discount = ?, tax = ?, shippingCost = ?, address = ? WHERE id = ?",
+			order.Total.Amount, order.Discount.Amount, order.Tax.Amount, order.ShippingCost.Amount, order.Address, order.ID,
+		)
+		if err != nil {
+			return err
+		}
+
+		for _, item := range revision.Items {
+			res, err := tx.ExecContext(ctx, "UPDATE order_items SET quantity = ? WHERE id = ? AND orderId = ? AND quantity = ?", item.ToQuantity, item.OrderItemID, order.ID, item.FromQuantity)
+			if err != nil {
+				return err
+			}
+
+			affected, err := res.RowsAffected()
+			if err != nil {
+				return err
+			}
+
+			if affected == 0 {
+				return fmt.Errorf("order item %d has changed since it was read", item.OrderItemID)
+			}
+		}
+
+		res, err := tx.ExecContext(ctx,
+			"INSERT INTO order_revisions (orderId, changedBy, reason, previousTotal, total, currency, refundDue, previousAddress, address) VALUES (?,?,?,?,?,?,?,?,?)",
+			order.ID, revision.ChangedBy, revision.Reason, revision.PreviousTotal.Amount, revision.Total.Amount, revision.Total.Currency, revision.RefundDue.Amount,
+			sql.NullString{String: revision.PreviousAddress, Valid: revision.Address != ""}, sql.NullString{String: revision.Address, Valid: revision.Address != ""},
+		)
+		if err != nil {
+			return err
+		}
+
+		id, err = res.LastInsertId()
+		if err != nil {
+			return err
+		}
+
+		for _, item := range revision.Items {
+			_, err := tx.ExecContext(ctx,
+				"INSERT INTO order_revision_items (revisionId, orderItemId, fromQuantity, toQuantity) VALUES (?,?,?,?)",
+				id, item.OrderItemID, item.FromQuantity, item.ToQuantity,
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+const selectRevisions = "SELECT id, orderId, changedBy, reason, previousTotal, total, currency, refundDue, refundId, previousAddress, address, createdAt FROM order_revisions"
+
+func (s *Store) GetOrderRevisions(ctx context.Context, orderId int) ([]types.OrderRevision, error) {
+	rows, err := s.db.QueryContext(ctx, selectRevisions+" WHERE orderId = ? ORDER BY id", orderId)
+	if err != nil {
+		return nil, err
+	}
+
+	revisions, err := scanRowsIntoRevisions(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(revisions) == 0 {
+		return revisions, nil
+	}
+
+	byId := map[int]int{}
+	for i, revision := range revisions {
+		byId[revision.ID] = i
+	}
+
+	itemRows, err := s.db.QueryContext(ctx,
+		"SELECT ri.id, ri.revisionId, ri.orderItemId, ri.fromQuantity, ri.toQuantity FROM order_revision_items ri JOIN order_revisions r ON r.id = ri.revisionId WHERE r.orderId = ? ORDER BY ri.id",
+		orderId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer itemRows.Close()
+
+	for itemRows.Next() {
+		item := types.OrderRevisionItem{}
+		if err := itemRows.Scan(&item.ID, &item.RevisionID, &item.OrderItemID, &item.FromQuantity, &item.ToQuantity); err != nil {
+			return nil, err
+		}
+
+		revision := &revisions[byId[item.RevisionID]]
+		revision.Items = append(revision.Items, item)
+	}
+
+	return revisions, itemRows.Err()
+}
+
+func (s *Store) LockOrderRevisionById(ctx context.Context, id int) (*types.OrderRevision, error) {
+	rows, err := s.db.QueryContext(ctx, selectRevisions+" WHERE id = ? FOR UPDATE", id)
+	if err != nil {
+		return nil, err
+	}
+
+	revisions, err := scanRowsIntoRevisions(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(revisions) == 0 {
+		return nil, ErrRevisionNotFound
+	}
+
+	return &revisions[0], nil
+}
+
+func (s *Store) GetUnsettledOrderRevisions(ctx context.Context) ([]types.OrderRevision, error) {
+	rows, err := s.db.QueryContext(ctx, selectRevisions+" WHERE refundDue > 0 ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+
+	return scanRowsIntoRevisions(rows)
+}
+
+func (s *Store) SettleOrderRevision(ctx context.Context, id int, refundId *int) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE order_revisions SET refundDue = 0, refundId = ? WHERE id = ?", refundId, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// scanRowsIntoRevisions reads revisions selected with selectRevisions,
+// leaving their items empty.
+func scanRowsIntoRevisions(rows *sql.Rows) ([]types.OrderRevision, error) {
+	defer rows.Close()
+
+	revisions := []types.OrderRevision{}
+	for rows.Next() {
+		revision := types.OrderRevision{Items: []types.OrderRevisionItem{}}
+		var changedBy, refundId sql.NullInt64
+		var previousAddress, address sql.NullString
+		err := rows.Scan(
+			&revision.ID,
+			&revision.OrderID,
+			&changedBy,
+			&revision.Reason,
+			&revision.PreviousTotal.Amount,
+			&revision.Total.Amount,
+			&revision.Total.Currency,
+			&revision.RefundDue.Amount,
+			&refundId,
+			&previousAddress,
+			&address,
+			&revision.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		revision.PreviousTotal.Currency, revision.RefundDue.Currency = revision.Total.Currency, revision.Total.Currency
+		revision.PreviousAddress, revision.Address = previousAddress.String, address.String
+		if changedBy.Valid {
+			userId := int(changedBy.Int64)
+			revision.ChangedBy = &userId
+		}
+		if refundId.Valid {
+			id := int(refundId.Int64)
+			revision.RefundID = &id
+		}
+
+		revisions = append(revisions, revision)
+	}
+
+	return revisions, rows.Err()
+}
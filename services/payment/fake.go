@@ -0,0 +1,225 @@
+package payment
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/xelathan/golang_backend/money"
+	"github.com/xelathan/golang_backend/types"
+)
+
+const FakeSignatureHeader = "X-Fake-Signature"
+
+// Payment sources the fake provider understands, modelled on the test cards
+// real gateways hand out. An empty source pays successfully.
+const (
+	FakeSourceSuccess    = "fake_success"
+	FakeSourceDecline    = "fake_decline"
+	FakeSource3DS        = "fake_3ds"
+	FakeSource3DSDecline = "fake_3ds_decline"
+)
+
+// FakeProvider is an in-memory gateway for running the payment flow offline.
+// 3-D Secure sources leave the payment waiting on the customer, who "passes"
+// or "fails" the challenge after the configured delay; the outcome arrives
+// by webhook like it would from a real gateway. Captures are confirmed by a
+// delayed webhook as well, which exercises duplicate deliveries.
+type FakeProvider struct {
+	secret []byte
+	delay  time.Duration
+
+	mu       sync.Mutex
+	next     int
+	payments map[string]*fakePayment
+	receiver func(header http.Header, body []byte)
+}
+
+type fakePayment struct {
+	status   types.PaymentStatus
+	amount   money.Money
+	captured money.Money
+	refunded money.Money
+}
+
+func NewFakeProvider(secret []byte, delay time.Duration) *FakeProvider {
+	return &FakeProvider{secret: secret, delay: delay, payments: map[string]*fakePayment{}}
+}
+
+// SetWebhookReceiver sets where webhooks are delivered. Without a receiver
+// they are dropped.
+func (p *FakeProvider) SetWebhookReceiver(receiver func(header http.Header, body []byte)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.receiver = receiver
+}
+
+func (p *FakeProvider) Name() string {
+	return "fake"
+}
+
+func (p *FakeProvider) Authorize(request types.PaymentRequest) (types.PaymentResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.next++
+	reference := fmt.Sprintf("fake_%d_%d", request.OrderID, p.next)
+	payment := &fakePayment{amount: request.Amount, captured: money.Zero(request.Amount.Currency), refunded: money.Zero(request.Amount.Currency)}
+	p.payments[reference] = payment
+
+	result := types.PaymentResult{Reference: reference}
+	switch request.Source {
+	case "", FakeSourceSuccess:
+		payment.status = types.PaymentAuthorized
+	case FakeSourceDecline:
+		payment.status = types.PaymentDeclined
+		result.FailureReason = "card_declined"
+	case FakeSource3DS, FakeSource3DSDecline:
+		payment.status = types.PaymentRequiresAction
+		result.NextAction = "complete the 3-D Secure challenge, the result follows by webhook"
+
+		outcome := types.PaymentEvent{Reference: reference, Status: types.PaymentAuthorized}
+		if request.Source == FakeSource3DSDecline {
+			outcome = types.PaymentEvent{Reference: reference, Status: types.PaymentDeclined, FailureReason: "authentication_failed"}
+		}
+		p.sendLater(outcome, types.PaymentRequiresAction)
+	default:
+		payment.status = types.PaymentDeclined
+		result.FailureReason = "invalid_source"
+	}
+
+	result.Status = payment.status
+
+	return result, nil
+}
+
+func (p *FakeProvider) Capture(reference string, amount money.Money) (types.PaymentResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	payment, err := p.payment(reference, types.PaymentAuthorized)
+	if err != nil {
+		return types.PaymentResult{}, err
+	}
+
+	if amount.Cmp(payment.amount) > 0 {
+		return types.PaymentResult{}, fmt.Errorf("cannot capture %s of an authorization of %s", amount, payment.amount)
+	}
+
+	payment.status = types.PaymentCaptured
+	payment.captured = amount
+	p.sendLater(types.PaymentEvent{Reference: reference, Status: types.PaymentCaptured}, types.PaymentCaptured)
+
+	return types.PaymentResult{Reference: reference, Status: payment.status}, nil
+}
+
+func (p *FakeProvider) Void(reference string) (types.PaymentResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	payment, err := p.payment(reference, types.PaymentAuthorized, types.PaymentRequiresAction)
+	if err != nil {
+		return types.PaymentResult{}, err
+	}
+
+	payment.status = types.PaymentVoided
+
+	return types.PaymentResult{Reference: reference, Status: payment.status}, nil
+}
+
+func (p *FakeProvider) Refund(reference string, amount money.Money) (types.PaymentResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	payment, err := p.payment(reference, types.PaymentCaptured, types.PaymentPartiallyRefunded)
+	if err != nil {
+		return types.PaymentResult{}, err
+	}
+
+	refunded := payment.refunded.Add(amount)
+	if !amount.IsPositive() || refunded.Cmp(payment.captured) > 0 {
+		return types.PaymentResult{}, fmt.Errorf("cannot refund %s of %s captured, %s already refunded", amount, payment.captured, payment.refunded)
+	}
+
+	payment.refunded = refunded
+	payment.status = types.PaymentPartiallyRefunded
+	if refunded == payment.captured {
+		payment.status = types.PaymentRefunded
+	}
+
+	return types.PaymentResult{Reference: reference, Status: payment.status}, nil
+}
+
+func (p *FakeProvider) VerifyWebhook(header http.Header, body []byte) (types.PaymentEvent, error) {
+	signature, err := hex.DecodeString(header.Get(FakeSignatureHeader))
+	if err != nil || !hmac.Equal(signature, p.sign(body)) {
+		return types.PaymentEvent{}, fmt.Errorf("invalid webhook signature")
+	}
+
+	event := types.PaymentEvent{}
+	if err := json.Unmarshal(body, &event); err != nil {
+		return types.PaymentEvent{}, err
+	}
+
+	return event, nil
+}
+
+// payment returns the payment if it is in one of the given statuses. The
+// caller holds the lock.
+func (p *FakeProvider) payment(reference string, statuses ...types.PaymentStatus) (*fakePayment, error) {
+	payment, ok := p.payments[reference]
+	if !ok {
+		return nil, fmt.Errorf("payment %s not found", reference)
+	}
+
+	for _, status := range statuses {
+		if payment.status == status {
+			return payment, nil
+		}
+	}
+
+	return nil, fmt.Errorf("payment %s is %s", reference, payment.status)
+}
+
+// sendLater delivers the event after the delay, as long as the payment is
+// still in the status it was in when the event was scheduled. The event's
+// status is applied to the payment first. The caller holds the lock.
+func (p *FakeProvider) sendLater(event types.PaymentEvent, from types.PaymentStatus) {
+	time.AfterFunc(p.delay, func() {
+		p.mu.Lock()
+		payment, receiver := p.payments[event.Reference], p.receiver
+		if payment.status != from {
+			p.mu.Unlock()
+			return
+		}
+		payment.status = event.Status
+		p.mu.Unlock()
+
+		if receiver == nil {
+			return
+		}
+
+		body, err := json.Marshal(event)
+		if err != nil {
+			return
+		}
+
+		header := http.Header{}
+		header.Set("Content-Type", "application/json")
+		header.Set(FakeSignatureHeader, hex.EncodeToString(p.sign(body)))
+		receiver(header, body)
+	})
+}
+
+func (p *FakeProvider) sign(body []byte) []byte {
+	mac := hmac.New(sha256.New, p.secret)
+	mac.Write(body)
+
+	return mac.Sum(nil)
+}
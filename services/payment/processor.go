@@ -0,0 +1,416 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/xelathan/golang_backend/config"
+	"github.com/xelathan/golang_backend/money"
+	"github.com/xelathan/golang_backend/types"
+)
+
+// NewProviderFromConfig picks the provider named by the PAYMENT_PROVIDER env
+// var. The fake provider is the only one built in, so it is also the
+// fallback.
+func NewProviderFromConfig() types.PaymentProvider {
+	switch config.Envs.PaymentProvider {
+	default:
+		return NewFakeProvider(
+			[]byte(config.Envs.PaymentWebhookSecret),
+			time.Duration(config.Envs.FakePaymentWebhookDelayInSeconds)*time.Second,
+		)
+	}
+}
+
+// next lists the statuses a payment can move to from each status. Events
+// for any other move are duplicates or arrived out of order and are ignored.
+var next = map[types.PaymentStatus][]types.PaymentStatus{
+	types.PaymentRequiresAction:    {types.PaymentAuthorized, types.PaymentCaptured, types.PaymentDeclined, types.PaymentFailed, types.PaymentVoided},
+	types.PaymentAuthorized:        {types.PaymentCaptured, types.PaymentFailed, types.PaymentVoided},
+	types.PaymentCaptured:          {types.PaymentPartiallyRefunded, types.PaymentRefunded},
+	types.PaymentPartiallyRefunded: {types.PaymentPartiallyRefunded, types.PaymentRefunded},
+}
+
+func canMove(from types.PaymentStatus, to types.PaymentStatus) bool {
+	for _, status := range next[from] {
+		if status == to {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ErrNotRefunded is returned by Refund and RefundTo when they fail before
+// any money was given back.
+var ErrNotRefunded = errors.New("nothing was refunded")
+
+// Processor takes payments through the provider and moves orders along with
+// them: a captured payment marks the order paid and a declined or failed one
+// cancels it. Payments are authorized and captured straight away. Once the
+// provider has been asked for money the order has to follow it, so from
+// then on payments are recorded and transitions made without the request's
+// context, and go through even if the client has gone away.
+//
+// Orders can also be paid in part with tenders, gift cards and store
+// credit, whose payments are taken at checkout. Refunding or releasing the
+// order gives those back to the tender they came from.
+//
+// A payment's row is locked while money moves on it, so webhooks, refunds
+// and voids on the same payments wait for each other. The rows are locked
+// before the provider is asked for anything, so a unit of work retried for
+// a deadlock is retried before any money moves. Orders follow their
+// payments once those are committed.
+type Processor struct {
+	uow         types.UnitOfWork
+	provider    types.PaymentProvider
+	store       types.PaymentStore
+	transitions types.OrderTransitioner
+	tenders     map[string]types.Tender
+}
+
+func NewProcessor(uow types.UnitOfWork, provider types.PaymentProvider, store types.PaymentStore, transitions types.OrderTransitioner, tenders ...types.Tender) *Processor {
+	p := &Processor{uow: uow, provider: provider, store: store, transitions: transitions, tenders: map[string]types.Tender{}}
+	for _, tender := range tenders {
+		p.tenders[tender.Name()] = tender
+	}
+
+	return p
+}
+
+func (p *Processor) Provider() types.PaymentProvider {
+	return p.provider
+}
+
+// Charge pays for a pending order. Orders with nothing to pay are marked
+// paid without a payment, in which case the payment returned is nil.
+func (p *Processor) Charge(ctx context.Context, order types.Order, source string) (*types.Payment, error) {
+	if !order.Total.IsPositive() {
+		_, err := p.transitions.Transition(ctx, order.ID, types.Paid, nil, "nothing to pay")
+		return nil, err
+	}
+
+	// the provider may take the money from here on, so the order follows
+	// it even if the client goes away
+	ctx = context.WithoutCancel(ctx)
+
+	// nothing was taken when the provider could not be reached, so the
+	// order is released the same as for a declined payment
+	result, err := p.provider.Authorize(types.PaymentRequest{OrderID: order.ID, Amount: order.Total, Source: source})
+	if err != nil {
+		if releaseErr := p.release(ctx, order.ID, "payment provider unavailable"); releaseErr != nil {
+			return nil, releaseErr
+		}
+		return nil, err
+	}
+
+	// the payment is created and settled in one transaction, so a webhook
+	// for it waits on the new row until it is
+	var payment types.Payment
+	err = p.uow.Do(ctx, func(tx types.DB) error {
+		store := p.store.WithTx(tx)
+
+		payment = types.Payment{
+			OrderID:       order.ID,
+			Provider:      p.provider.Name(),
+			Reference:     result.Reference,
+			Status:        result.Status,
+			Amount:        order.Total,
+			Refunded:      money.Zero(order.Total.Currency),
+			FailureReason: result.FailureReason,
+			NextAction:    result.NextAction,
+		}
+
+		var err error
+		payment.ID, err = store.CreatePayment(ctx, payment)
+		if err != nil {
+			return err
+		}
+
+		return p.settle(ctx, store, &payment)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.follow(ctx, payment); err != nil {
+		return nil, err
+	}
+
+	return &payment, nil
+}
+
+// HandleEvent applies a verified webhook event to its payment.
+func (p *Processor) HandleEvent(ctx context.Context, event types.PaymentEvent) error {
+	var payment *types.Payment
+	err := p.uow.Do(ctx, func(tx types.DB) error {
+		store := p.store.WithTx(tx)
+
+		var err error
+		payment, err = store.LockPaymentByReference(ctx, p.provider.Name(), event.Reference)
+		if err != nil {
+			return err
+		}
+
+		if !canMove(payment.Status, event.Status) {
+			payment = nil
+			return nil
+		}
+
+		if err := p.update(ctx, store, payment, types.PaymentResult{Status: event.Status, FailureReason: event.FailureReason}); err != nil {
+			return err
+		}
+
+		return p.settle(ctx, store, payment)
+	})
+	if err != nil || payment == nil {
+		return err
+	}
+
+	return p.follow(ctx, *payment)
+}
+
+// VoidOrderPayments also gives back what the order took from tenders, as
+// it is only used on orders that are about to be cancelled.
+func (p *Processor) VoidOrderPayments(ctx context.Context, orderId int) error {
+	return p.uow.Do(ctx, func(tx types.DB) error {
+		store := p.store.WithTx(tx)
+
+		payments, err := store.LockPaymentsByOrderId(ctx, orderId)
+		if err != nil {
+			return err
+		}
+
+		if err := p.reverseTenders(ctx, tx, store, payments); err != nil {
+			return err
+		}
+
+		for i := range payments {
+			if !canMove(payments[i].Status, types.PaymentVoided) {
+				continue
+			}
+
+			result, err := p.provider.Void(payments[i].Reference)
+			if err != nil {
+				return err
+			}
+
+			if err := p.update(ctx, store, &payments[i], result); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Refund takes amount from the order's payments in the order they were
+// made, each up to what it has left of what it captured.
+func (p *Processor) Refund(ctx context.Context, orderId int, amount money.Money) error {
+	return p.refund(ctx, orderId, amount, func(tx types.DB, payment types.Payment, part money.Money, first bool) (types.PaymentStatus, error) {
+		if tender, ok := p.tenders[payment.Provider]; ok {
+			if err := tender.WithTx(tx).Reverse(ctx, payment.Reference, part); err != nil {
+				return "", err
+			}
+
+			return refundedStatus(payment, part), nil
+		}
+
+		result, err := p.provider.Refund(payment.Reference, part)
+		if err != nil {
+			return "", err
+		}
+
+		return result.Status, nil
+	})
+}
+
+// RefundTo takes amount from the order's payments like Refund, then gives
+// it back as credit on the tender's account in one go.
+func (p *Processor) RefundTo(ctx context.Context, orderId int, amount money.Money, tender string, account string) error {
+	to, ok := p.tenders[tender]
+	if !ok {
+		return fmt.Errorf("%w: cannot refund to %s", ErrNotRefunded, tender)
+	}
+
+	return p.refund(ctx, orderId, amount, func(tx types.DB, payment types.Payment, part money.Money, first bool) (types.PaymentStatus, error) {
+		if first {
+			if err := to.WithTx(tx).Credit(ctx, account, amount, orderId); err != nil {
+				return "", err
+			}
+		}
+
+		return refundedStatus(payment, part), nil
+	})
+}
+
+// refund shares amount over the order's payments and gives each part back
+// through giveBack, which returns the payment's status afterwards. Tenders
+// are given back in tx, the transaction the payments are locked in, so
+// nothing is credited unless the payments are updated with it, and first is
+// set for the first part given back in it. A part failing keeps the ones
+// given back before it, and the error tells whether any were.
+func (p *Processor) refund(ctx context.Context, orderId int, amount money.Money, giveBack func(tx types.DB, payment types.Payment, part money.Money, first bool) (types.PaymentStatus, error)) error {
+	var refundErr error
+	err := p.uow.Do(ctx, func(tx types.DB) error {
+		store := p.store.WithTx(tx)
+		refundErr = nil
+
+		payments, err := store.LockPaymentsByOrderId(ctx, orderId)
+		if err != nil {
+			return err
+		}
+
+		parts := make([]money.Money, len(payments))
+		left := amount
+		for i, payment := range payments {
+			parts[i] = money.Zero(amount.Currency)
+			if !canMove(payment.Status, types.PaymentRefunded) || !left.IsPositive() {
+				continue
+			}
+
+			parts[i] = money.Min(left, payment.Amount.Sub(payment.Refunded))
+			left = left.Sub(parts[i])
+		}
+
+		// checked before anything is refunded so a refund never goes
+		// through in part for lack of money to give back
+		if left.IsPositive() {
+			return fmt.Errorf("%w: cannot refund %s, the order only has %s left to refund", ErrNotRefunded, amount, amount.Sub(left))
+		}
+
+		given := false
+		for i := range payments {
+			if !parts[i].IsPositive() {
+				continue
+			}
+
+			status, err := giveBack(tx, payments[i], parts[i], !given)
+			if err != nil {
+				refundErr = err
+				if !given {
+					refundErr = fmt.Errorf("%w: %w", ErrNotRefunded, err)
+				}
+				return nil
+			}
+			given = true
+
+			payments[i].Status = status
+			payments[i].Refunded = payments[i].Refunded.Add(parts[i])
+			if err := store.UpdatePayment(ctx, payments[i]); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return refundErr
+}
+
+// reverseTenders gives back all that is left of what the payments took from
+// tenders. The payments should be locked in tx, which store is bound to and
+// the tenders are given back in.
+func (p *Processor) reverseTenders(ctx context.Context, tx types.DB, store types.PaymentStore, payments []types.Payment) error {
+	for i, payment := range payments {
+		tender, ok := p.tenders[payment.Provider]
+		left := payment.Amount.Sub(payment.Refunded)
+		if !ok || !canMove(payment.Status, types.PaymentRefunded) || !left.IsPositive() {
+			continue
+		}
+
+		if err := tender.WithTx(tx).Reverse(ctx, payment.Reference, left); err != nil {
+			return err
+		}
+
+		payments[i].Status, payments[i].Refunded = types.PaymentRefunded, payment.Amount
+		if err := store.UpdatePayment(ctx, payments[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// release cancels an order whose payment did not go through, after giving
+// back what it took from tenders, which would otherwise keep it from being
+// cancelled.
+func (p *Processor) release(ctx context.Context, orderId int, reason string) error {
+	ctx = context.WithoutCancel(ctx)
+
+	err := p.uow.Do(ctx, func(tx types.DB) error {
+		store := p.store.WithTx(tx)
+
+		payments, err := store.LockPaymentsByOrderId(ctx, orderId)
+		if err != nil {
+			return err
+		}
+
+		return p.reverseTenders(ctx, tx, store, payments)
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = p.transitions.Transition(ctx, orderId, types.Cancelled, nil, reason)
+	return err
+}
+
+// refundedStatus is the status of a payment once part more of it has been
+// refunded.
+func refundedStatus(payment types.Payment, part money.Money) types.PaymentStatus {
+	if payment.Refunded.Add(part).Cmp(payment.Amount) >= 0 {
+		return types.PaymentRefunded
+	}
+
+	return types.PaymentPartiallyRefunded
+}
+
+// settle captures authorized payments. The payment should be locked in
+// store's transaction.
+func (p *Processor) settle(ctx context.Context, store types.PaymentStore, payment *types.Payment) error {
+	if payment.Status != types.PaymentAuthorized {
+		return nil
+	}
+
+	result, err := p.provider.Capture(payment.Reference, payment.Amount)
+	if err != nil {
+		return err
+	}
+
+	return p.update(ctx, store, payment, result)
+}
+
+// follow moves the order along with its settled payment: captured payments
+// mark the order paid and failed ones release it.
+func (p *Processor) follow(ctx context.Context, payment types.Payment) error {
+	ctx = context.WithoutCancel(ctx)
+
+	switch payment.Status {
+	case types.PaymentCaptured:
+		_, err := p.transitions.Transition(ctx, payment.OrderID, types.Paid, nil, "payment captured")
+		return err
+	case types.PaymentDeclined, types.PaymentFailed:
+		return p.release(ctx, payment.OrderID, fmt.Sprintf("payment %s: %s", payment.Status, payment.FailureReason))
+	}
+
+	return nil
+}
+
+func (p *Processor) update(ctx context.Context, store types.PaymentStore, payment *types.Payment, result types.PaymentResult) error {
+	if result.Status == payment.Status {
+		return nil
+	}
+
+	payment.Status = result.Status
+	if result.FailureReason != "" {
+		payment.FailureReason = result.FailureReason
+	}
+
+	return store.UpdatePayment(ctx, *payment)
+}
@@ -0,0 +1,506 @@
+package payment
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xelathan/golang_backend/money"
+	orderservice "github.com/xelathan/golang_backend/services/order"
+	"github.com/xelathan/golang_backend/types"
+)
+
+func TestProcessor(t *testing.T) {
+	order := types.Order{ID: 1, Total: money.New(2500, "USD"), Status: types.Pending}
+
+	giftCards := &mockTender{name: types.TenderGiftCard}
+	storeCredit := &mockTender{name: types.TenderStoreCredit}
+
+	setup := func() (*Processor, *FakeProvider, *mockPaymentStore, *mockOrderStore, *mockProductStore) {
+		// webhooks come in once the checkout has recorded the payment
+		provider := NewFakeProvider([]byte("secret"), 10*time.Millisecond)
+		payments := &mockPaymentStore{payments: map[int]*types.Payment{}}
+		orders := &mockOrderStore{order: order, items: []types.OrderItem{{OrderID: 1, ProductID: 7, Quantity: 2}}}
+		products := &mockProductStore{quantities: map[int]int{7: 3}}
+		giftCards.given, storeCredit.given = map[string]money.Money{}, map[string]money.Money{}
+
+		transitions := orderservice.NewStateMachine(mockUnitOfWork{}, orders, payments, products, &mockPromotionStore{})
+		processor := NewProcessor(&lockingUnitOfWork{}, provider, payments, transitions, giftCards, storeCredit)
+
+		return processor, provider, payments, orders, products
+	}
+
+	t.Run("should capture and mark the order paid", func(t *testing.T) {
+		processor, _, _, orders, _ := setup()
+
+		payment, err := processor.Charge(context.Background(), order, FakeSourceSuccess)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if payment.Status != types.PaymentCaptured || orders.status(1) != types.Paid {
+			t.Errorf("expected a captured payment and a paid order, got %s and %s", payment.Status, orders.status(1))
+		}
+	})
+
+	t.Run("should release the order when the payment is declined", func(t *testing.T) {
+		processor, _, _, orders, products := setup()
+
+		payment, err := processor.Charge(context.Background(), order, FakeSourceDecline)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if payment.Status != types.PaymentDeclined || orders.status(1) != types.Cancelled || products.quantities[7] != 5 {
+			t.Errorf("expected a declined payment, a cancelled order and restocked items, got %s, %s and %d", payment.Status, orders.status(1), products.quantities[7])
+		}
+	})
+
+	t.Run("should mark the order paid when the client goes away during the charge", func(t *testing.T) {
+		processor, _, _, orders, _ := setup()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		payment, err := processor.Charge(ctx, order, FakeSourceSuccess)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if payment.Status != types.PaymentCaptured || orders.status(1) != types.Paid {
+			t.Errorf("expected a captured payment and a paid order, got %s and %s", payment.Status, orders.status(1))
+		}
+	})
+
+	t.Run("should settle a 3-D Secure payment when its webhook arrives", func(t *testing.T) {
+		processor, provider, payments, orders, _ := setup()
+
+		delivered := make(chan struct{}, 2)
+		provider.SetWebhookReceiver(func(header http.Header, body []byte) {
+			event, err := provider.VerifyWebhook(header, body)
+			if err != nil {
+				t.Error(err)
+			} else if err := processor.HandleEvent(context.Background(), event); err != nil {
+				t.Error(err)
+			}
+			delivered <- struct{}{}
+		})
+
+		payment, err := processor.Charge(context.Background(), order, FakeSource3DS)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if payment.Status != types.PaymentRequiresAction || orders.status(1) != types.Pending {
+			t.Fatalf("expected the payment to wait on the customer, got %s", payment.Status)
+		}
+
+		// the challenge result, then the capture confirmation
+		for i := 0; i < 2; i++ {
+			select {
+			case <-delivered:
+			case <-time.After(time.Second):
+				t.Fatal("webhook was not delivered")
+			}
+		}
+
+		if stored := payments.get(payment.ID); stored.Status != types.PaymentCaptured || orders.status(1) != types.Paid {
+			t.Errorf("expected a captured payment and a paid order, got %s and %s", stored.Status, orders.status(1))
+		}
+	})
+
+	t.Run("should reject webhooks with a bad signature", func(t *testing.T) {
+		_, provider, _, _, _ := setup()
+
+		header := http.Header{}
+		header.Set(FakeSignatureHeader, "00")
+		if _, err := provider.VerifyWebhook(header, []byte(`{"reference":"fake_1_1","status":"captured"}`)); err == nil {
+			t.Error("expected the webhook to be rejected")
+		}
+	})
+
+	t.Run("should answer webhooks for unknown payments with not found", func(t *testing.T) {
+		processor, provider, _, _, _ := setup()
+
+		body := []byte(`{"reference":"fake_9_9","status":"captured"}`)
+		header := http.Header{}
+		header.Set(FakeSignatureHeader, hex.EncodeToString(provider.sign(body)))
+
+		if status, err := NewHandler(processor).receive(context.Background(), header, body); status != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d: %v", http.StatusNotFound, status, err)
+		}
+	})
+
+	t.Run("should ignore events that do not move the payment forward", func(t *testing.T) {
+		processor, _, payments, orders, _ := setup()
+
+		payment, err := processor.Charge(context.Background(), order, FakeSourceSuccess)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := processor.HandleEvent(context.Background(), types.PaymentEvent{Reference: payment.Reference, Status: types.PaymentAuthorized}); err != nil {
+			t.Fatal(err)
+		}
+
+		if stored := payments.get(payment.ID); stored.Status != types.PaymentCaptured || orders.status(1) != types.Paid {
+			t.Errorf("expected the payment to stay captured, got %s", stored.Status)
+		}
+	})
+
+	t.Run("should refund up to what was captured", func(t *testing.T) {
+		processor, _, payments, _, _ := setup()
+
+		payment, err := processor.Charge(context.Background(), order, FakeSourceSuccess)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := processor.Refund(context.Background(), 1, money.New(1000, "USD")); err != nil {
+			t.Fatal(err)
+		}
+
+		if stored := payments.get(payment.ID); stored.Status != types.PaymentPartiallyRefunded || stored.Refunded != money.New(1000, "USD") {
+			t.Fatalf("expected 10.00 refunded, got %s with %s refunded", stored.Status, stored.Refunded)
+		}
+
+		if err := processor.Refund(context.Background(), 1, money.New(2000, "USD")); !errors.Is(err, ErrNotRefunded) {
+			t.Errorf("expected a refund over what is left to fail without refunding anything, got %v", err)
+		}
+
+		if err := processor.Refund(context.Background(), 1, money.New(1500, "USD")); err != nil {
+			t.Fatal(err)
+		}
+
+		if stored := payments.get(payment.ID); stored.Status != types.PaymentRefunded || stored.Refunded != order.Total {
+			t.Errorf("expected the payment refunded in full, got %s with %s refunded", stored.Status, stored.Refunded)
+		}
+	})
+
+	t.Run("should give the gift card back when the rest is declined", func(t *testing.T) {
+		processor, _, payments, orders, _ := setup()
+
+		// 10.00 of the order was paid with a gift card at checkout
+		giftCard, _ := payments.CreatePayment(context.Background(), types.Payment{
+			OrderID:   1,
+			Provider:  types.TenderGiftCard,
+			Reference: "41",
+			Status:    types.PaymentCaptured,
+			Amount:    money.New(1000, "USD"),
+			Refunded:  money.Zero("USD"),
+		})
+
+		rest := order
+		rest.Total = money.New(1500, "USD")
+		if _, err := processor.Charge(context.Background(), rest, FakeSourceDecline); err != nil {
+			t.Fatal(err)
+		}
+
+		if stored := payments.get(giftCard); stored.Status != types.PaymentRefunded || giftCards.given["41"] != money.New(1000, "USD") || orders.status(1) != types.Cancelled {
+			t.Errorf("expected the gift card given back and the order cancelled, got %s, %v and %s", stored.Status, giftCards.given, orders.status(1))
+		}
+	})
+
+	t.Run("should give a gift card back once when saving the payment fails", func(t *testing.T) {
+		processor, _, payments, _, _ := setup()
+
+		giftCard, _ := payments.CreatePayment(context.Background(), types.Payment{
+			OrderID:   1,
+			Provider:  types.TenderGiftCard,
+			Reference: "41",
+			Status:    types.PaymentCaptured,
+			Amount:    money.New(1000, "USD"),
+			Refunded:  money.Zero("USD"),
+		})
+
+		payments.failUpdates = 1
+		if err := processor.VoidOrderPayments(context.Background(), 1); err == nil {
+			t.Fatal("expected the void to fail with the payment update")
+		}
+
+		if len(giftCards.given) != 0 {
+			t.Fatalf("expected nothing given back with the payment unchanged, got %v", giftCards.given)
+		}
+
+		if err := processor.VoidOrderPayments(context.Background(), 1); err != nil {
+			t.Fatal(err)
+		}
+
+		if stored := payments.get(giftCard); stored.Status != types.PaymentRefunded || giftCards.given["41"] != money.New(1000, "USD") {
+			t.Errorf("expected the gift card given back once, got %s and %v", stored.Status, giftCards.given)
+		}
+	})
+
+	t.Run("should refund as store credit", func(t *testing.T) {
+		processor, _, payments, _, _ := setup()
+
+		payment, err := processor.Charge(context.Background(), order, FakeSourceSuccess)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := processor.RefundTo(context.Background(), 1, money.New(1000, "USD"), types.TenderStoreCredit, "5"); err != nil {
+			t.Fatal(err)
+		}
+
+		if stored := payments.get(payment.ID); stored.Status != types.PaymentPartiallyRefunded || storeCredit.given["5"] != money.New(1000, "USD") {
+			t.Errorf("expected 10.00 of the payment refunded to store credit, got %s and %v", stored.Status, storeCredit.given)
+		}
+	})
+}
+
+// mockTender keeps what it was given back or credited by account, with
+// references standing for the accounts they were debited from. Bound to a
+// mockTx, it only keeps it once the transaction commits.
+type mockTender struct {
+	types.Tender
+
+	name  string
+	given map[string]money.Money
+	tx    *mockTx
+}
+
+func (m *mockTender) Name() string {
+	return m.name
+}
+
+func (m *mockTender) Credit(ctx context.Context, account string, amount money.Money, orderId int) error {
+	m.give(account, amount)
+	return nil
+}
+
+func (m *mockTender) Reverse(ctx context.Context, reference string, amount money.Money) error {
+	m.give(reference, amount)
+	return nil
+}
+
+func (m *mockTender) WithTx(tx types.DB) types.Tender {
+	bound, _ := tx.(*mockTx)
+	return &mockTender{name: m.name, given: m.given, tx: bound}
+}
+
+func (m *mockTender) give(account string, amount money.Money) {
+	if m.tx == nil {
+		m.given[account] = m.given[account].Add(amount)
+		return
+	}
+
+	m.tx.onCommit = append(m.tx.onCommit, func() {
+		m.given[account] = m.given[account].Add(amount)
+	})
+}
+
+type mockPaymentStore struct {
+	mu       sync.Mutex
+	payments map[int]*types.Payment
+	// failUpdates is how many updates fail before they go through again
+	failUpdates int
+}
+
+func (m *mockPaymentStore) CreatePayment(ctx context.Context, payment types.Payment) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	payment.ID = len(m.payments) + 1
+	m.payments[payment.ID] = &payment
+
+	return payment.ID, nil
+}
+
+func (m *mockPaymentStore) GetPaymentByReference(ctx context.Context, provider string, reference string) (*types.Payment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, payment := range m.payments {
+		if payment.Provider == provider && payment.Reference == reference {
+			found := *payment
+			return &found, nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+func (m *mockPaymentStore) GetPaymentsByOrderId(ctx context.Context, orderId int) ([]types.Payment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	payments := []types.Payment{}
+	for _, payment := range m.payments {
+		if payment.OrderID == orderId {
+			payments = append(payments, *payment)
+		}
+	}
+	sort.Slice(payments, func(i, j int) bool { return payments[i].ID < payments[j].ID })
+
+	return payments, nil
+}
+
+func (m *mockPaymentStore) LockPaymentByReference(ctx context.Context, provider string, reference string) (*types.Payment, error) {
+	return m.GetPaymentByReference(ctx, provider, reference)
+}
+
+func (m *mockPaymentStore) LockPaymentsByOrderId(ctx context.Context, orderId int) ([]types.Payment, error) {
+	return m.GetPaymentsByOrderId(ctx, orderId)
+}
+
+func (m *mockPaymentStore) UpdatePayment(ctx context.Context, payment types.Payment) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.failUpdates > 0 {
+		m.failUpdates--
+		return errors.New("connection lost")
+	}
+
+	m.payments[payment.ID] = &payment
+
+	return nil
+}
+
+func (m *mockPaymentStore) WithTx(tx types.DB) types.PaymentStore {
+	return m
+}
+
+func (m *mockPaymentStore) get(id int) types.Payment {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return *m.payments[id]
+}
+
+type mockOrderStore struct {
+	types.OrderStore
+
+	mu    sync.Mutex
+	order types.Order
+	items []types.OrderItem
+}
+
+func (m *mockOrderStore) GetOrderById(ctx context.Context, orderId int) (*types.Order, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	order := m.order
+	return &order, nil
+}
+
+func (m *mockOrderStore) UpdateOrderStatus(ctx context.Context, change types.OrderStatusChange) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.order.Status != change.FromStatus {
+		return fmt.Errorf("order is no longer %s", change.FromStatus)
+	}
+	m.order.Status = change.ToStatus
+
+	return nil
+}
+
+func (m *mockOrderStore) GetOrderItems(ctx context.Context, orderId int) ([]types.OrderItem, error) {
+	return m.items, nil
+}
+
+func (m *mockOrderStore) WithTx(tx types.DB) types.OrderStore {
+	return m
+}
+
+func (m *mockOrderStore) status(orderId int) types.OrderStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.order.Status
+}
+
+type mockProductStore struct {
+	types.ProductStore
+
+	quantities map[int]int
+}
+
+func (m *mockProductStore) GetProductsByID(ctx context.Context, ids []int) ([]types.Product, error) {
+	products := []types.Product{}
+	for _, id := range ids {
+		products = append(products, types.Product{ID: id, Quantity: m.quantities[id]})
+	}
+
+	return products, nil
+}
+
+func (m *mockProductStore) LockProductsByID(ctx context.Context, ids []int) ([]types.Product, error) {
+	return m.GetProductsByID(ctx, ids)
+}
+
+func (m *mockProductStore) WithTx(tx types.DB) types.ProductStore {
+	return m
+}
+
+func (m *mockProductStore) UpdateProductBatch(ctx context.Context, products map[int]types.Product) error {
+	for id, product := range products {
+		m.quantities[id] = product.Quantity
+	}
+
+	return nil
+}
+
+type mockPromotionStore struct {
+	types.PromotionStore
+}
+
+func (m *mockPromotionStore) ReverseRedemptions(ctx context.Context, orderId int) error {
+	return nil
+}
+
+func (m *mockPromotionStore) WithTx(tx types.DB) types.PromotionStore {
+	return m
+}
+
+type mockUnitOfWork struct{}
+
+func (mockUnitOfWork) Do(ctx context.Context, fn func(tx types.DB) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return fn(nil)
+}
+
+// lockingUnitOfWork runs one unit of work at a time, standing in for the
+// payment rows the processor locks in them, and commits what tenders bound
+// to its transaction did only when the work succeeds.
+type lockingUnitOfWork struct {
+	mu sync.Mutex
+}
+
+func (u *lockingUnitOfWork) Do(ctx context.Context, fn func(tx types.DB) error) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	tx := &mockTx{}
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	for _, commit := range tx.onCommit {
+		commit()
+	}
+
+	return nil
+}
+
+// mockTx is a transaction of lockingUnitOfWork.
+type mockTx struct {
+	types.DB
+
+	onCommit []func()
+}
@@ -0,0 +1,75 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/xelathan/golang_backend/utils"
+)
+
+// maxWebhookBytes bounds how much of a webhook body is read.
+const maxWebhookBytes = 1 << 20
+
+type Handler struct {
+	processor *Processor
+}
+
+func NewHandler(processor *Processor) *Handler {
+	return &Handler{processor: processor}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/payments/webhooks/{provider}", h.handleWebhook).Methods(http.MethodPost)
+}
+
+// handleWebhook takes status updates from the payment provider. Deliveries
+// are retried by providers until they get a 2xx, so duplicates are expected
+// and acknowledged without doing anything.
+func (h *Handler) handleWebhook(w http.ResponseWriter, r *http.Request) {
+	if mux.Vars(r)["provider"] != h.processor.Provider().Name() {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("unknown payment provider"))
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBytes))
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if status, err := h.receive(r.Context(), r.Header, body); err != nil {
+		utils.WriteError(w, status, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]string{"status": "received"})
+}
+
+// DeliverWebhook takes a webhook handed over in-process, which is how the
+// fake provider delivers its events.
+func (h *Handler) DeliverWebhook(header http.Header, body []byte) {
+	if _, err := h.receive(context.Background(), header, body); err != nil {
+		log.Printf("payment webhook: %v", err)
+	}
+}
+
+func (h *Handler) receive(ctx context.Context, header http.Header, body []byte) (int, error) {
+	event, err := h.processor.Provider().VerifyWebhook(header, body)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	if err := h.processor.HandleEvent(ctx, event); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return http.StatusNotFound, err
+		}
+		return http.StatusInternalServerError, err
+	}
+
+	return http.StatusOK, nil
+}
@@ -0,0 +1,128 @@
+package payment
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/xelathan/golang_backend/types"
+)
+
+var ErrNotFound = errors.New("payment not found")
+
+const selectPayments = "SELECT id, orderId, provider, reference, status, amount, currency, refunded, failureReason, createdAt, updatedAt FROM payments"
+
+type Store struct {
+	db types.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+func (s *Store) WithTx(tx types.DB) types.PaymentStore {
+	return &Store{db: tx}
+}
+
+func (s *Store) CreatePayment(ctx context.Context, payment types.Payment) (int, error) {
+	res, err := s.db.ExecContext(ctx,
+		"INSERT INTO payments (orderId, provider, reference, status, amount, currency, refunded, failureReason) VALUES (?,?,?,?,?,?,?,?)",
+		payment.OrderID, payment.Provider, payment.Reference, payment.Status, payment.Amount.Amount, payment.Amount.Currency,
+		payment.Refunded.Amount, sql.NullString{String: payment.FailureReason, Valid: payment.FailureReason != ""},
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+func (s *Store) GetPaymentByReference(ctx context.Context, provider string, reference string) (*types.Payment, error) {
+	return s.getPaymentByReference(ctx, provider, reference, "")
+}
+
+func (s *Store) LockPaymentByReference(ctx context.Context, provider string, reference string) (*types.Payment, error) {
+	return s.getPaymentByReference(ctx, provider, reference, " FOR UPDATE")
+}
+
+func (s *Store) getPaymentByReference(ctx context.Context, provider string, reference string, suffix string) (*types.Payment, error) {
+	rows, err := s.db.QueryContext(ctx, selectPayments+" WHERE provider = ? AND reference = ?"+suffix, provider, reference)
+	if err != nil {
+		return nil, err
+	}
+
+	payments, err := scanRowsIntoPayments(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(payments) == 0 {
+		return nil, ErrNotFound
+	}
+
+	return &payments[0], nil
+}
+
+func (s *Store) GetPaymentsByOrderId(ctx context.Context, orderId int) ([]types.Payment, error) {
+	return s.getPaymentsByOrderId(ctx, orderId, "")
+}
+
+func (s *Store) LockPaymentsByOrderId(ctx context.Context, orderId int) ([]types.Payment, error) {
+	return s.getPaymentsByOrderId(ctx, orderId, " FOR UPDATE")
+}
+
+func (s *Store) getPaymentsByOrderId(ctx context.Context, orderId int, suffix string) ([]types.Payment, error) {
+	rows, err := s.db.QueryContext(ctx, selectPayments+" WHERE orderId = ? ORDER BY id"+suffix, orderId)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanRowsIntoPayments(rows)
+}
+
+func (s *Store) UpdatePayment(ctx context.Context, payment types.Payment) error {
+	_, err := s.db.ExecContext(ctx,
+		"UPDATE payments SET status = ?, refunded = ?, failureReason = ? WHERE id = ?",
+		payment.Status, payment.Refunded.Amount, sql.NullString{String: payment.FailureReason, Valid: payment.FailureReason != ""}, payment.ID,
+	)
+
+	return err
+}
+
+func scanRowsIntoPayments(rows *sql.Rows) ([]types.Payment, error) {
+	defer rows.Close()
+
+	payments := []types.Payment{}
+	for rows.Next() {
+		payment := types.Payment{}
+		var failureReason sql.NullString
+		err := rows.Scan(
+			&payment.ID,
+			&payment.OrderID,
+			&payment.Provider,
+			&payment.Reference,
+			&payment.Status,
+			&payment.Amount.Amount,
+			&payment.Amount.Currency,
+			&payment.Refunded.Amount,
+			&failureReason,
+			&payment.CreatedAt,
+			&payment.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		payment.Refunded.Currency = payment.Amount.Currency
+		payment.FailureReason = failureReason.String
+
+		payments = append(payments, payment)
+	}
+
+	return payments, rows.Err()
+}
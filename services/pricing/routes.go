@@ -0,0 +1,120 @@
+package pricing
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"github.com/xelathan/golang_backend/services/auth"
+	"github.com/xelathan/golang_backend/types"
+	"github.com/xelathan/golang_backend/utils"
+)
+
+type Handler struct {
+	store        types.PriceStore
+	productStore types.ProductStore
+	userStore    types.UserStore
+}
+
+func NewHandler(store types.PriceStore, productStore types.ProductStore, userStore types.UserStore) *Handler {
+	return &Handler{store: store, productStore: productStore, userStore: userStore}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/products/{id}/prices", auth.WithAdminAuth(h.handleGetPriceHistory, h.userStore)).Methods(http.MethodGet)
+	router.HandleFunc("/products/{id}/prices", auth.WithAdminAuth(h.handleSchedulePrice, h.userStore)).Methods(http.MethodPost)
+}
+
+func (h *Handler) handleGetPriceHistory(w http.ResponseWriter, r *http.Request) {
+	productId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid product id"))
+		return
+	}
+
+	history, err := h.store.GetPriceHistory(r.Context(), productId)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, history)
+}
+
+func (h *Handler) handleSchedulePrice(w http.ResponseWriter, r *http.Request) {
+	productId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid product id"))
+		return
+	}
+
+	payload := types.SchedulePricePayload{}
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	from := time.Now()
+	if payload.EffectiveFrom != nil {
+		from = *payload.EffectiveFrom
+	}
+
+	if payload.CompareAtPrice != nil {
+		if !payload.CompareAtPrice.SameCurrency(payload.Price) || payload.CompareAtPrice.Cmp(payload.Price) <= 0 {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("compareAtPrice must be greater than price and in the same currency"))
+			return
+		}
+	}
+
+	if payload.EffectiveTo != nil {
+		if payload.CompareAtPrice == nil {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("effectiveTo is only allowed for sale prices"))
+			return
+		}
+
+		if !payload.EffectiveTo.After(from) {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("effectiveTo must be after effectiveFrom"))
+			return
+		}
+	}
+
+	products, err := h.productStore.GetProductsByID(r.Context(), []int{productId})
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if len(products) == 0 {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("product not found"))
+		return
+	}
+
+	if payload.Price.Currency != products[0].Price.Currency {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("price must be in the product currency %s", products[0].Price.Currency))
+		return
+	}
+
+	price := types.ProductPrice{
+		ProductID:      productId,
+		Price:          payload.Price,
+		CompareAtPrice: payload.CompareAtPrice,
+		EffectiveFrom:  from,
+		EffectiveTo:    payload.EffectiveTo,
+	}
+
+	if err := h.store.SchedulePrice(r.Context(), price); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, price)
+}
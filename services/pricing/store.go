@@ -0,0 +1,241 @@
+package pricing
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/xelathan/golang_backend/db"
+	"github.com/xelathan/golang_backend/money"
+	"github.com/xelathan/golang_backend/types"
+)
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+func (s *Store) GetPriceHistory(ctx context.Context, productId int) ([]types.ProductPrice, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT * FROM product_prices WHERE productId = ? ORDER BY effectiveFrom DESC, id DESC", productId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	prices := []types.ProductPrice{}
+	for rows.Next() {
+		price, err := scanRowIntoProductPrice(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		prices = append(prices, *price)
+	}
+
+	return prices, nil
+}
+
+func scanRowIntoProductPrice(rows *sql.Rows) (*types.ProductPrice, error) {
+	price := new(types.ProductPrice)
+	compareAt := sql.NullInt64{}
+	effectiveTo := sql.NullTime{}
+
+	err := rows.Scan(&price.ID, &price.ProductID, &price.Price.Amount, &compareAt, &price.Price.Currency, &price.EffectiveFrom, &effectiveTo, &price.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if compareAt.Valid {
+		compareAtPrice := money.New(compareAt.Int64, price.Price.Currency)
+		price.CompareAtPrice = &compareAtPrice
+	}
+	if effectiveTo.Valid {
+		price.EffectiveTo = &effectiveTo.Time
+	}
+
+	return price, nil
+}
+
+// SchedulePrice records a base price change or a time-boxed sale. Sales are
+// stored as is; base prices are chained so that every base row ends where the
+// next one begins.
+func (s *Store) SchedulePrice(ctx context.Context, price types.ProductPrice) error {
+	return db.InTxContext(ctx, s.db, func(tx types.DB) error {
+		if price.CompareAtPrice != nil {
+			_, err := tx.ExecContext(ctx, "INSERT INTO product_prices (productId, price, compareAtPrice, currency, effectiveFrom, effectiveTo) VALUES (?,?,?,?,?,?)", price.ProductID, price.Price.Amount, price.CompareAtPrice.Amount, price.Price.Currency, price.EffectiveFrom, price.EffectiveTo)
+			return err
+		}
+
+		return RecordBasePrice(ctx, tx, price.ProductID, price.Price, price.EffectiveFrom)
+	})
+}
+
+// RecordBasePrice inserts a base price effective from the given time inside
+// tx. The base row in effect at that time is closed, the new row is bounded
+// by the next scheduled base change if there is one, and products.price is
+// kept in step when the change is effective immediately. Changes scheduled
+// for later reach products.price through ApplyDueBasePrices.
+func RecordBasePrice(ctx context.Context, tx types.DB, productId int, price money.Money, from time.Time) error {
+	rows, err := tx.QueryContext(ctx, "SELECT * FROM product_prices WHERE productId = ? AND compareAtPrice IS NULL FOR UPDATE", productId)
+	if err != nil {
+		return err
+	}
+
+	history := []types.ProductPrice{}
+	for rows.Next() {
+		base, err := scanRowIntoProductPrice(rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+
+		history = append(history, *base)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	closing, until := scheduleBase(history, from)
+	for _, id := range closing {
+		if _, err := tx.ExecContext(ctx, "UPDATE product_prices SET effectiveTo = ? WHERE id = ?", from, id); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO product_prices (productId, price, currency, effectiveFrom, effectiveTo) VALUES (?,?,?,?,?)", productId, price.Amount, price.Currency, from, until)
+	if err != nil {
+		return err
+	}
+
+	if !from.After(time.Now()) {
+		if _, err := tx.ExecContext(ctx, "UPDATE products SET price = ?, currency = ? WHERE id = ?", price.Amount, price.Currency, productId); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ApplyDueBasePrices copies the base price in effect at the given time into
+// products.price wherever the two differ, and returns how many products
+// were updated. Sales are left out, since products.price is the base price.
+func (s *Store) ApplyDueBasePrices(ctx context.Context, at time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx,
+		"UPDATE products p JOIN product_prices pp ON pp.productId = p.id SET p.price = pp.price, p.currency = pp.currency "+
+			"WHERE pp.compareAtPrice IS NULL AND pp.effectiveFrom <= ? AND (pp.effectiveTo IS NULL OR pp.effectiveTo > ?) "+
+			"AND (p.price <> pp.price OR p.currency <> pp.currency)",
+		at, at,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// Start applies base prices as they come due periodically in its own
+// goroutine.
+func (s *Store) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if _, err := s.ApplyDueBasePrices(context.Background(), time.Now()); err != nil {
+				log.Printf("pricing: sweep failed: %v", err)
+			}
+		}
+	}()
+}
+
+// scheduleBase works out where a base price effective from the given time
+// goes in the product's base price history: the ids of the rows in effect
+// at that time, which end where it begins, and the start of the next
+// scheduled change, where it ends. Until is nil when nothing comes after.
+func scheduleBase(history []types.ProductPrice, from time.Time) (closing []int, until *time.Time) {
+	for _, base := range history {
+		if base.EffectiveFrom.After(from) {
+			if until == nil || base.EffectiveFrom.Before(*until) {
+				next := base.EffectiveFrom
+				until = &next
+			}
+			continue
+		}
+
+		if base.EffectiveTo == nil || base.EffectiveTo.After(from) {
+			closing = append(closing, base.ID)
+		}
+	}
+
+	return closing, until
+}
+
+// GetEffectivePrices resolves the price of each product at the given time.
+// An active sale wins over the base price; among several candidates of the
+// same kind the one that started last wins. Products with no price history
+// are left out of the map and callers fall back to products.price.
+func (s *Store) GetEffectivePrices(ctx context.Context, productIDs []int, at time.Time) (map[int]types.ProductPrice, error) {
+	if len(productIDs) == 0 {
+		return map[int]types.ProductPrice{}, nil
+	}
+
+	placeholders := strings.Repeat(",?", len(productIDs)-1)
+	query := fmt.Sprintf("SELECT * FROM product_prices WHERE productId IN (?%s) AND effectiveFrom <= ? AND (effectiveTo IS NULL OR effectiveTo > ?)", placeholders)
+
+	args := make([]interface{}, 0, len(productIDs)+2)
+	for _, v := range productIDs {
+		args = append(args, v)
+	}
+	args = append(args, at, at)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	candidates := []types.ProductPrice{}
+	for rows.Next() {
+		candidate, err := scanRowIntoProductPrice(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		candidates = append(candidates, *candidate)
+	}
+
+	return pickEffective(candidates), nil
+}
+
+// pickEffective picks the price of each product out of the ones in effect.
+func pickEffective(candidates []types.ProductPrice) map[int]types.ProductPrice {
+	prices := map[int]types.ProductPrice{}
+	for _, candidate := range candidates {
+		current, ok := prices[candidate.ProductID]
+		if !ok || takesPrecedence(candidate, current) {
+			prices[candidate.ProductID] = candidate
+		}
+	}
+
+	return prices
+}
+
+func takesPrecedence(candidate types.ProductPrice, current types.ProductPrice) bool {
+	candidateSale, currentSale := candidate.CompareAtPrice != nil, current.CompareAtPrice != nil
+	if candidateSale != currentSale {
+		return candidateSale
+	}
+
+	if !candidate.EffectiveFrom.Equal(current.EffectiveFrom) {
+		return candidate.EffectiveFrom.After(current.EffectiveFrom)
+	}
+
+	return candidate.ID > current.ID
+}
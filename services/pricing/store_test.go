@@ -0,0 +1,93 @@
+package pricing
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/xelathan/golang_backend/money"
+	"github.com/xelathan/golang_backend/types"
+)
+
+func TestPickEffective(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 10, d, 0, 0, 0, 0, time.UTC) }
+	compareAt := money.New(2000, "USD")
+	base := func(id int, amount int64, from time.Time) types.ProductPrice {
+		return types.ProductPrice{ID: id, ProductID: 1, Price: money.New(amount, "USD"), EffectiveFrom: from}
+	}
+	sale := func(id int, amount int64, from time.Time) types.ProductPrice {
+		price := base(id, amount, from)
+		price.CompareAtPrice = &compareAt
+		return price
+	}
+
+	cases := []struct {
+		name       string
+		candidates []types.ProductPrice
+		expected   int
+	}{
+		{"a sale over the base price", []types.ProductPrice{base(1, 2000, day(1)), sale(2, 1500, day(2))}, 2},
+		{"a sale over a base price that started later", []types.ProductPrice{sale(1, 1500, day(1)), base(2, 2000, day(5))}, 1},
+		{"the sale that started last", []types.ProductPrice{sale(1, 1500, day(3)), sale(2, 1200, day(1))}, 1},
+		{"the base price that started last", []types.ProductPrice{base(1, 1800, day(1)), base(2, 2000, day(4))}, 2},
+		{"the later row of two that started together", []types.ProductPrice{base(2, 2000, day(1)), base(1, 1800, day(1))}, 2},
+	}
+
+	for _, c := range cases {
+		t.Run("should pick "+c.name, func(t *testing.T) {
+			prices := pickEffective(c.candidates)
+			if got := prices[1]; got.ID != c.expected {
+				t.Errorf("expected price %d, got %d", c.expected, got.ID)
+			}
+		})
+	}
+
+	t.Run("should pick a price for each product", func(t *testing.T) {
+		other := base(3, 500, day(1))
+		other.ProductID = 2
+
+		prices := pickEffective([]types.ProductPrice{base(1, 2000, day(1)), other})
+		if len(prices) != 2 || prices[2].ID != 3 {
+			t.Errorf("expected a price for both products, got %+v", prices)
+		}
+	})
+}
+
+func TestScheduleBase(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 10, d, 0, 0, 0, 0, time.UTC) }
+	base := func(id int, from time.Time, to *time.Time) types.ProductPrice {
+		return types.ProductPrice{ID: id, ProductID: 1, Price: money.New(2000, "USD"), EffectiveFrom: from, EffectiveTo: to}
+	}
+	until := func(d int) *time.Time {
+		t := day(d)
+		return &t
+	}
+
+	cases := []struct {
+		name    string
+		history []types.ProductPrice
+		from    time.Time
+		closing []int
+		until   *time.Time
+	}{
+		{"should open the history of a new product", nil, day(1), nil, nil},
+		{"should close the open price", []types.ProductPrice{base(1, day(1), nil)}, day(5), []int{1}, nil},
+		{"should leave prices that already ended", []types.ProductPrice{base(1, day(1), until(3)), base(2, day(3), nil)}, day(5), []int{2}, nil},
+		{"should close a price that ends after it", []types.ProductPrice{base(1, day(1), until(10)), base(2, day(10), nil)}, day(5), []int{1}, until(10)},
+		{"should end where the next change begins", []types.ProductPrice{base(1, day(1), nil), base(2, day(12), nil), base(3, day(8), until(12))}, day(5), []int{1}, until(8)},
+		{"should replace a price starting at the same time", []types.ProductPrice{base(1, day(5), nil)}, day(5), []int{1}, nil},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			closing, until := scheduleBase(c.history, c.from)
+			if !reflect.DeepEqual(closing, c.closing) {
+				t.Errorf("expected to close %v, got %v", c.closing, closing)
+			}
+
+			if (until == nil) != (c.until == nil) || (until != nil && !until.Equal(*c.until)) {
+				t.Errorf("expected to end at %v, got %v", c.until, until)
+			}
+		})
+	}
+}
@@ -1,33 +1,61 @@
 package product
 
 import (
+	"context"
 	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/gorilla/mux"
+	"github.com/xelathan/golang_backend/config"
+	"github.com/xelathan/golang_backend/services/auth"
+	"github.com/xelathan/golang_backend/services/currency"
 	"github.com/xelathan/golang_backend/types"
 	"github.com/xelathan/golang_backend/utils"
 )
 
 type Handler struct {
-	store types.ProductStore
+	store         types.ProductStore
+	userStore     types.UserStore
+	reviewStore   types.ReviewStore
+	priceStore    types.PriceStore
+	currencyStore types.CurrencyStore
 }
 
-func NewHandler(store types.ProductStore) *Handler {
+func NewHandler(store types.ProductStore, userStore types.UserStore, reviewStore types.ReviewStore, priceStore types.PriceStore, currencyStore types.CurrencyStore) *Handler {
 	return &Handler{
-		store: store,
+		store:         store,
+		userStore:     userStore,
+		reviewStore:   reviewStore,
+		priceStore:    priceStore,
+		currencyStore: currencyStore,
 	}
 }
 
 func (h *Handler) RegisterRoutes(router *mux.Router) {
 	router.HandleFunc("/products", h.handleGetProducts).Methods(http.MethodGet)
+	router.HandleFunc("/products/{id}", h.handleGetProduct).Methods(http.MethodGet)
 	router.HandleFunc("/create_product", h.handleCreateProduct).Methods(http.MethodPost)
+	router.HandleFunc("/products/{id}/reorder_threshold", auth.WithAdminAuth(h.handleSetReorderThreshold, h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/products/{id}/stock_policy", auth.WithAdminAuth(h.handleSetStockPolicy, h.userStore)).Methods(http.MethodPost)
 }
 
 func (h *Handler) handleGetProducts(w http.ResponseWriter, r *http.Request) {
-	ps, err := h.store.GetProducts()
+	target, err := currency.FromRequest(r, config.Envs.BaseCurrency)
 	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	ps, err := h.store.GetProducts(r.Context())
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := h.attachDetails(r.Context(), ps, target); err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
 	}
@@ -35,6 +63,70 @@ func (h *Handler) handleGetProducts(w http.ResponseWriter, r *http.Request) {
 	utils.WriteJSON(w, http.StatusOK, ps)
 }
 
+func (h *Handler) handleGetProduct(w http.ResponseWriter, r *http.Request) {
+	productId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid product id"))
+		return
+	}
+
+	target, err := currency.FromRequest(r, config.Envs.BaseCurrency)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	ps, err := h.store.GetProductsByID(r.Context(), []int{productId})
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if len(ps) == 0 {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("product not found"))
+		return
+	}
+
+	if err := h.attachDetails(r.Context(), ps, target); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, ps[0])
+}
+
+// attachDetails replaces each product's stored price with the one in effect
+// right now in the target currency and fills in the approved review aggregate
+func (h *Handler) attachDetails(ctx context.Context, ps []types.Product, target string) error {
+	ids := make([]int, len(ps))
+	for i, p := range ps {
+		ids[i] = p.ID
+	}
+
+	converter, err := currency.LoadConverter(ctx, h.currencyStore, config.Envs.BaseCurrency)
+	if err != nil {
+		return err
+	}
+
+	prices, err := currency.ResolvePrices(ctx, h.priceStore, h.currencyStore, converter, ps, target, time.Now())
+	if err != nil {
+		return err
+	}
+
+	summaries, err := h.reviewStore.GetRatingSummaries(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	for i := range ps {
+		ps[i].Price = prices[ps[i].ID].Price
+		ps[i].CompareAtPrice = prices[ps[i].ID].CompareAtPrice
+		ps[i].Rating = summaries[ps[i].ID]
+	}
+
+	return nil
+}
+
 func (h *Handler) handleCreateProduct(w http.ResponseWriter, r *http.Request) {
 	// read in payload from request
 	payload := types.CreateProductPayload{}
@@ -44,23 +136,37 @@ func (h *Handler) handleCreateProduct(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// validate payload
-	utils.Validate.RegisterValidation("decimal2", utils.ValidateDecimalPlaces)
 	if err := utils.Validate.Struct(payload); err != nil {
 		errors := err.(validator.ValidationErrors)
 		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
 		return
 	}
 
+	// products are priced in the base currency, other markets use overrides
+	if payload.Price.Currency != config.Envs.BaseCurrency {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("price must be in the base currency %s", config.Envs.BaseCurrency))
+		return
+	}
+
 	created := types.Product{
-		Name:        payload.Name,
-		Description: payload.Description,
-		Image:       payload.Image,
-		Price:       payload.Price,
-		Quantity:    payload.Quantity,
+		SKU:              payload.SKU,
+		Name:             payload.Name,
+		Description:      payload.Description,
+		Image:            payload.Image,
+		Price:            payload.Price,
+		Quantity:         payload.Quantity,
+		ReorderThreshold: payload.ReorderThreshold,
+		Category:         payload.Category,
+		TaxClass:         payload.TaxClass,
+		WeightGrams:      payload.WeightGrams,
+		LengthMm:         payload.LengthMm,
+		WidthMm:          payload.WidthMm,
+		HeightMm:         payload.HeightMm,
+		GiftCard:         payload.GiftCard,
 	}
 
 	// create the product
-	err := h.store.CreateProduct(created)
+	err := h.store.CreateProduct(r.Context(), created)
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
@@ -72,3 +178,64 @@ func (h *Handler) handleCreateProduct(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+func (h *Handler) handleSetReorderThreshold(w http.ResponseWriter, r *http.Request) {
+	productId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid product id"))
+		return
+	}
+
+	payload := types.SetReorderThresholdPayload{}
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	if err := h.store.UpdateReorderThreshold(r.Context(), productId, payload.ReorderThreshold); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]int{"reorderThreshold": payload.ReorderThreshold})
+}
+
+// handleSetStockPolicy sets whether the product can be ordered beyond its
+// stock. Units already backordered stay on backorder whatever the policy.
+func (h *Handler) handleSetStockPolicy(w http.ResponseWriter, r *http.Request) {
+	productId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid product id"))
+		return
+	}
+
+	payload := types.SetStockPolicyPayload{}
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	// only pre-orders have a date to be available from
+	if payload.Policy != types.StockPreorder {
+		payload.AvailableAt = nil
+	}
+
+	if err := h.store.UpdateStockPolicy(r.Context(), productId, payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, payload)
+}
@@ -1,26 +1,36 @@
 package product
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"strings"
+	"time"
 
+	"github.com/xelathan/golang_backend/db"
+	"github.com/xelathan/golang_backend/money"
+	"github.com/xelathan/golang_backend/services/pricing"
 	"github.com/xelathan/golang_backend/types"
 )
 
 type Store struct {
-	db *sql.DB
+	db types.DB
 }
 
 func NewStore(db *sql.DB) *Store {
 	return &Store{db: db}
 }
 
-func (s *Store) GetProducts() ([]types.Product, error) {
-	rows, err := s.db.Query("SELECT * FROM products")
+func (s *Store) WithTx(tx types.DB) types.ProductStore {
+	return &Store{db: tx}
+}
+
+func (s *Store) GetProducts(ctx context.Context) ([]types.Product, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT * FROM products")
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	products := make([]types.Product, 0)
 
@@ -33,40 +43,70 @@ func (s *Store) GetProducts() ([]types.Product, error) {
 		products = append(products, *p)
 	}
 
-	return products, nil
+	return products, rows.Err()
 }
 
 func scanRowsIntoProduct(rows *sql.Rows) (*types.Product, error) {
 	product := new(types.Product)
-	err := rows.Scan(&product.ID, &product.Name, &product.Description, &product.Image, &product.Price, &product.Quantity, &product.CreatedAt)
+	sku, category := sql.NullString{}, sql.NullString{}
+	availableAt := sql.NullTime{}
+	err := rows.Scan(&product.ID, &product.Name, &product.Description, &product.Image, &product.Price.Amount, &product.Price.Currency, &product.Quantity, &product.CreatedAt, &product.ReorderThreshold, &sku, &category, &product.TaxClass, &product.WeightGrams, &product.LengthMm, &product.WidthMm, &product.HeightMm,
+		&product.StockPolicy, &product.BackorderLimit, &product.Backordered, &availableAt, &product.GiftCard)
 	if err != nil {
 		return nil, err
 	}
+	product.SKU = sku.String
+	product.Category = category.String
+	if availableAt.Valid {
+		product.AvailableAt = &availableAt.Time
+	}
 
 	return product, nil
 }
 
-func (s *Store) CreateProduct(product types.Product) error {
-	_, err := s.db.Exec("INSERT INTO products (name, description, image, price, quantity) VALUES (?,?,?,?,?)", product.Name, product.Description, product.Image, product.Price, product.Quantity)
-	if err != nil {
-		return err
-	}
-	return nil
+func (s *Store) CreateProduct(ctx context.Context, product types.Product) error {
+	return db.InTxContext(ctx, s.db, func(tx types.DB) error {
+		res, err := tx.ExecContext(ctx, "INSERT INTO products (name, description, image, price, currency, quantity, reorderThreshold, sku, category, taxClass, weightGrams, lengthMm, widthMm, heightMm, giftCard) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)",
+			product.Name, product.Description, product.Image, product.Price.Amount, product.Price.Currency, product.Quantity, product.ReorderThreshold,
+			nullableString(product.SKU), nullableString(product.Category), taxClassOrDefault(product.TaxClass), product.WeightGrams, product.LengthMm, product.WidthMm, product.HeightMm, product.GiftCard)
+		if err != nil {
+			return err
+		}
+
+		id, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+
+		// the initial price opens the product's price history
+		return pricing.RecordBasePrice(ctx, tx, int(id), product.Price, time.Now())
+	})
+}
+
+func (s *Store) GetProductsByID(ctx context.Context, productIDs []int) ([]types.Product, error) {
+	return s.getProductsByID(ctx, productIDs, "")
+}
+
+// LockProductsByID takes the locks in id order, so checkouts of overlapping
+// carts queue up behind each other instead of deadlocking.
+func (s *Store) LockProductsByID(ctx context.Context, productIDs []int) ([]types.Product, error) {
+	return s.getProductsByID(ctx, productIDs, " ORDER BY id FOR UPDATE")
 }
 
-func (s *Store) GetProductsByID(productIDs []int) ([]types.Product, error) {
+func (s *Store) getProductsByID(ctx context.Context, productIDs []int, suffix string) ([]types.Product, error) {
 	placeholders := strings.Repeat(",?", len(productIDs)-1)
-	query := fmt.Sprintf("SELECT * FROM products WHERE id IN (?%s)", placeholders)
+	query := fmt.Sprintf("SELECT * FROM products WHERE id IN (?%s)%s", placeholders, suffix)
 
 	args := make([]interface{}, len(productIDs))
 	for i, v := range productIDs {
 		args[i] = v
 	}
 
-	rows, err := s.db.Query(query, args...)
+	rows, err := s.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	products := []types.Product{}
 	for rows.Next() {
@@ -79,22 +119,24 @@ func (s *Store) GetProductsByID(productIDs []int) ([]types.Product, error) {
 		products = append(products, *p)
 	}
 
-	return products, nil
+	return products, rows.Err()
 }
 
-func (s *Store) UpdateProductBatch(products map[int]types.Product) error {
+func (s *Store) UpdateProductBatch(ctx context.Context, products map[int]types.Product) error {
 	if len(products) == 0 {
 		return nil
 	}
 
-	query := "UPDATE products SET quantity = CASE id"
+	quantities, backordered := "", ""
 	ids := []int{}
 
 	for _, product := range products {
-		query += fmt.Sprintf(" WHEN %d THEN %d", product.ID, product.Quantity)
+		quantities += fmt.Sprintf(" WHEN %d THEN %d", product.ID, product.Quantity)
+		backordered += fmt.Sprintf(" WHEN %d THEN %d", product.ID, product.Backordered)
 		ids = append(ids, product.ID)
 	}
-	query += " END WHERE id IN ("
+
+	query := "UPDATE products SET quantity = CASE id" + quantities + " END, backordered = CASE id" + backordered + " END WHERE id IN ("
 	for i := range len(products) {
 		if i > 0 {
 			query += ","
@@ -103,10 +145,165 @@ func (s *Store) UpdateProductBatch(products map[int]types.Product) error {
 	}
 	query += ");"
 
-	_, err := s.db.Exec(query)
+	_, err := s.db.ExecContext(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *Store) UpdateReorderThreshold(ctx context.Context, productId int, threshold int) error {
+	res, err := s.db.ExecContext(ctx, "UPDATE products SET reorderThreshold = ? WHERE id = ?", threshold, productId)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return fmt.Errorf("product not found")
+	}
+
+	return nil
+}
+
+func (s *Store) UpdateStockPolicy(ctx context.Context, productId int, payload types.SetStockPolicyPayload) error {
+	res, err := s.db.ExecContext(ctx, "UPDATE products SET stockPolicy = ?, backorderLimit = ?, availableAt = ? WHERE id = ?", payload.Policy, payload.BackorderLimit, payload.AvailableAt, productId)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
 	if err != nil {
 		return err
 	}
 
+	if affected == 0 {
+		return fmt.Errorf("product not found")
+	}
+
 	return nil
 }
+
+func (s *Store) GetProductsBySKU(ctx context.Context, skus []string) ([]types.Product, error) {
+	if len(skus) == 0 {
+		return []types.Product{}, nil
+	}
+
+	placeholders := strings.Repeat(",?", len(skus)-1)
+	query := fmt.Sprintf("SELECT * FROM products WHERE sku IN (?%s)", placeholders)
+
+	args := make([]interface{}, len(skus))
+	for i, v := range skus {
+		args[i] = v
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	products := []types.Product{}
+	for rows.Next() {
+		p, err := scanRowsIntoProduct(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		products = append(products, *p)
+	}
+
+	return products, rows.Err()
+}
+
+// UpsertProductsBySKU inserts or updates every product keyed on its SKU in a
+// single transaction, so a batch is either fully applied or not at all.
+func (s *Store) UpsertProductsBySKU(ctx context.Context, products []types.Product) error {
+	if len(products) == 0 {
+		return nil
+	}
+
+	query := "INSERT INTO products (sku, name, description, image, price, currency, quantity, reorderThreshold, category, taxClass, weightGrams, lengthMm, widthMm, heightMm) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?) " +
+		"ON DUPLICATE KEY UPDATE name = VALUES(name), description = VALUES(description), image = VALUES(image), price = VALUES(price), currency = VALUES(currency), quantity = VALUES(quantity), reorderThreshold = VALUES(reorderThreshold), category = VALUES(category), taxClass = VALUES(taxClass), " +
+		"weightGrams = VALUES(weightGrams), lengthMm = VALUES(lengthMm), widthMm = VALUES(widthMm), heightMm = VALUES(heightMm)"
+
+	now := time.Now()
+
+	return db.InTxContext(ctx, s.db, func(tx types.DB) error {
+		for _, p := range products {
+			if err := upsertProductBySKU(ctx, tx, query, p, now); err != nil {
+				return fmt.Errorf("sku %s: %w", p.SKU, err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// upsertProductBySKU writes one product and, when it is new or its price
+// changed, records the price in the product's price history
+func upsertProductBySKU(ctx context.Context, tx types.DB, query string, p types.Product, now time.Time) error {
+	var id int
+	currentPrice := money.Money{}
+	err := tx.QueryRowContext(ctx, "SELECT id, price, currency FROM products WHERE sku = ? FOR UPDATE", p.SKU).Scan(&id, &currentPrice.Amount, &currentPrice.Currency)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	res, err := tx.ExecContext(ctx, query, p.SKU, p.Name, p.Description, p.Image, p.Price.Amount, p.Price.Currency, p.Quantity, p.ReorderThreshold, nullableString(p.Category), taxClassOrDefault(p.TaxClass), p.WeightGrams, p.LengthMm, p.WidthMm, p.HeightMm)
+	if err != nil {
+		return err
+	}
+
+	if id == 0 {
+		insertedId, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		id = int(insertedId)
+	} else if currentPrice == p.Price {
+		return nil
+	}
+
+	return pricing.RecordBasePrice(ctx, tx, id, p.Price, now)
+}
+
+// StreamProducts calls fn for every product in id order without holding the
+// whole catalog in memory. Iteration stops at the first error fn returns.
+func (s *Store) StreamProducts(ctx context.Context, fn func(types.Product) error) error {
+	rows, err := s.db.QueryContext(ctx, "SELECT * FROM products ORDER BY id")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		p, err := scanRowsIntoProduct(rows)
+		if err != nil {
+			return err
+		}
+
+		if err := fn(*p); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+func taxClassOrDefault(taxClass string) string {
+	if taxClass == "" {
+		return types.DefaultTaxClass
+	}
+
+	return taxClass
+}
+
+func nullableString(value string) sql.NullString {
+	return sql.NullString{String: value, Valid: value != ""}
+}
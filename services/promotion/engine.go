@@ -0,0 +1,191 @@
+package promotion
+
+import (
+	"sort"
+
+	"github.com/xelathan/golang_backend/money"
+	"github.com/xelathan/golang_backend/types"
+)
+
+// Line is one cart line as the engine sees it, priced in the order currency.
+type Line struct {
+	ProductID int
+	Category  string
+	Quantity  int
+	UnitPrice money.Money
+}
+
+func (l Line) Total() money.Money {
+	return l.UnitPrice.Mul(l.Quantity)
+}
+
+// Result is the outcome of applying promotions to a cart. Skipped maps the
+// id of every candidate that did not apply to the reason why.
+type Result struct {
+	Subtotal     money.Money
+	Discount     money.Money
+	FreeShipping bool
+	Applied      []types.AppliedPromotion
+	Skipped      map[int]string
+}
+
+const (
+	SkipMinOrderValue = "the order does not reach the minimum value"
+	SkipNotEligible   = "no product in the cart is eligible"
+	SkipNotCombinable = "cannot be combined with the other promotions"
+)
+
+// Apply works out the discounts for a cart. Promotions must already be
+// expressed in the currency of the lines and be within their dates and usage
+// limits. The rules are:
+//   - promotions are tried by descending priority, then by id
+//   - the minimum order value is checked against the undiscounted subtotal
+//   - an exclusive promotion only applies when nothing else has, and once it
+//     has applied nothing else does
+//   - every promotion discounts what earlier promotions left of a line, so a
+//     line never goes below zero
+func Apply(promotions []types.Promotion, lines []Line, currency string) Result {
+	sorted := make([]types.Promotion, len(promotions))
+	copy(sorted, promotions)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Priority != sorted[j].Priority {
+			return sorted[i].Priority > sorted[j].Priority
+		}
+		return sorted[i].ID < sorted[j].ID
+	})
+
+	result := Result{
+		Subtotal: money.Zero(currency),
+		Discount: money.Zero(currency),
+		Applied:  []types.AppliedPromotion{},
+		Skipped:  map[int]string{},
+	}
+
+	remaining := make([]money.Money, len(lines))
+	for i, line := range lines {
+		remaining[i] = line.Total()
+		result.Subtotal = result.Subtotal.Add(remaining[i])
+	}
+
+	exclusiveApplied := false
+	for _, p := range sorted {
+		if exclusiveApplied || (p.Exclusive && len(result.Applied) > 0) {
+			result.Skipped[p.ID] = SkipNotCombinable
+			continue
+		}
+
+		if result.Subtotal.Cmp(p.MinOrderValue) < 0 {
+			result.Skipped[p.ID] = SkipMinOrderValue
+			continue
+		}
+
+		eligible := eligibleLines(p, lines)
+		if len(eligible) == 0 {
+			result.Skipped[p.ID] = SkipNotEligible
+			continue
+		}
+
+		applied := types.AppliedPromotion{
+			PromotionID: p.ID,
+			Name:        p.Name,
+			Code:        p.Code,
+			Amount:      money.Zero(currency),
+			Lines:       []types.LineDiscount{},
+		}
+
+		for i, amount := range discountLines(p, lines, remaining, eligible) {
+			if !amount.IsPositive() {
+				continue
+			}
+
+			remaining[i] = remaining[i].Sub(amount)
+			applied.Amount = applied.Amount.Add(amount)
+			applied.Lines = append(applied.Lines, types.LineDiscount{Line: i, ProductID: lines[i].ProductID, Amount: amount})
+		}
+
+		if p.Type == types.PromotionFreeShipping {
+			applied.FreeShipping = true
+			result.FreeShipping = true
+		} else if !applied.Amount.IsPositive() {
+			result.Skipped[p.ID] = SkipNotEligible
+			continue
+		}
+
+		result.Discount = result.Discount.Add(applied.Amount)
+		result.Applied = append(result.Applied, applied)
+		exclusiveApplied = p.Exclusive
+	}
+
+	return result
+}
+
+// eligibleLines returns the indexes of the lines the promotion targets.
+func eligibleLines(p types.Promotion, lines []Line) []int {
+	eligible := []int{}
+	for i, line := range lines {
+		if targets(p, line) {
+			eligible = append(eligible, i)
+		}
+	}
+
+	return eligible
+}
+
+func targets(p types.Promotion, line Line) bool {
+	if len(p.ProductIDs) == 0 && len(p.Categories) == 0 {
+		return true
+	}
+
+	for _, id := range p.ProductIDs {
+		if id == line.ProductID {
+			return true
+		}
+	}
+
+	for _, category := range p.Categories {
+		if category != "" && category == line.Category {
+			return true
+		}
+	}
+
+	return false
+}
+
+// discountLines returns the discount of the promotion on every eligible
+// line, keyed by line index and never more than what remains of the line.
+func discountLines(p types.Promotion, lines []Line, remaining []money.Money, eligible []int) map[int]money.Money {
+	discounts := map[int]money.Money{}
+
+	switch p.Type {
+	case types.PromotionPercentage:
+		for _, i := range eligible {
+			discounts[i] = remaining[i].MulRatio(int64(p.PercentOff), 100, money.HalfUp)
+		}
+
+	case types.PromotionFixed:
+		// the amount is spread over the eligible lines in proportion to what
+		// is left of them, so every line carries its share
+		weights := make([]int64, len(eligible))
+		total := money.Zero(p.AmountOff.Currency)
+		for j, i := range eligible {
+			weights[j] = remaining[i].Amount
+			total = total.Add(remaining[i])
+		}
+
+		for j, part := range money.Min(p.AmountOff, total).Allocate(weights) {
+			discounts[eligible[j]] = part
+		}
+
+	case types.PromotionBuyXGetY:
+		if p.BuyQuantity <= 0 || p.GetQuantity <= 0 {
+			break
+		}
+
+		for _, i := range eligible {
+			free := lines[i].Quantity / (p.BuyQuantity + p.GetQuantity) * p.GetQuantity
+			discounts[i] = money.Min(lines[i].UnitPrice.Mul(free), remaining[i])
+		}
+	}
+
+	return discounts
+}
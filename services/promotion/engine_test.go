@@ -0,0 +1,98 @@
+package promotion
+
+import (
+	"testing"
+
+	"github.com/xelathan/golang_backend/money"
+	"github.com/xelathan/golang_backend/types"
+)
+
+func TestApply(t *testing.T) {
+	lines := []Line{
+		{ProductID: 1, Category: "shirts", Quantity: 2, UnitPrice: money.New(1000, "USD")},
+		{ProductID: 2, Category: "hats", Quantity: 3, UnitPrice: money.New(500, "USD")},
+	}
+
+	t.Run("should take a percentage off the eligible lines only", func(t *testing.T) {
+		result := Apply([]types.Promotion{
+			{ID: 1, Type: types.PromotionPercentage, PercentOff: 15, Categories: []string{"hats"}},
+		}, lines, "USD")
+
+		// 15% of 15.00
+		if result.Discount != money.New(225, "USD") || result.Total() != money.New(3275, "USD") {
+			t.Errorf("expected 2.25 off 35.00, got %s off %s", result.Discount, result.Subtotal)
+		}
+
+		if len(result.Applied) != 1 || len(result.Applied[0].Lines) != 1 || result.Applied[0].Lines[0].Line != 1 {
+			t.Errorf("expected one discounted line, got %+v", result.Applied)
+		}
+	})
+
+	t.Run("should spread a fixed amount over the lines without losing a cent", func(t *testing.T) {
+		result := Apply([]types.Promotion{
+			{ID: 1, Type: types.PromotionFixed, AmountOff: money.New(1000, "USD")},
+		}, lines, "USD")
+
+		lineTotal := money.Zero("USD")
+		for _, line := range result.Applied[0].Lines {
+			lineTotal = lineTotal.Add(line.Amount)
+		}
+
+		if result.Discount != money.New(1000, "USD") || lineTotal != result.Discount {
+			t.Errorf("expected 10.00 spread over the lines, got %s with lines adding up to %s", result.Discount, lineTotal)
+		}
+	})
+
+	t.Run("should give every Y after X away", func(t *testing.T) {
+		result := Apply([]types.Promotion{
+			{ID: 1, Type: types.PromotionBuyXGetY, BuyQuantity: 2, GetQuantity: 1},
+		}, lines, "USD")
+
+		// only the hats line has a full set of three
+		if result.Discount != money.New(500, "USD") {
+			t.Errorf("expected 5.00 off, got %s", result.Discount)
+		}
+	})
+
+	t.Run("should skip promotions under their minimum order value", func(t *testing.T) {
+		result := Apply([]types.Promotion{
+			{ID: 1, Type: types.PromotionFreeShipping, MinOrderValue: money.New(5000, "USD")},
+		}, lines, "USD")
+
+		if result.FreeShipping || result.Skipped[1] != SkipMinOrderValue {
+			t.Errorf("expected free shipping to be skipped, got %+v", result)
+		}
+	})
+
+	t.Run("should not combine exclusive promotions", func(t *testing.T) {
+		result := Apply([]types.Promotion{
+			{ID: 1, Type: types.PromotionPercentage, PercentOff: 10, Priority: 1},
+			{ID: 2, Type: types.PromotionPercentage, PercentOff: 50, Exclusive: true},
+			{ID: 3, Type: types.PromotionFreeShipping},
+		}, lines, "USD")
+
+		if len(result.Applied) != 2 || result.Skipped[2] != SkipNotCombinable {
+			t.Errorf("expected the exclusive promotion to be skipped, got %+v", result)
+		}
+
+		result = Apply([]types.Promotion{
+			{ID: 1, Type: types.PromotionPercentage, PercentOff: 10},
+			{ID: 2, Type: types.PromotionPercentage, PercentOff: 50, Exclusive: true, Priority: 1},
+		}, lines, "USD")
+
+		if len(result.Applied) != 1 || result.Applied[0].PromotionID != 2 || result.Skipped[1] != SkipNotCombinable {
+			t.Errorf("expected only the exclusive promotion, got %+v", result)
+		}
+	})
+
+	t.Run("should never discount a line below zero", func(t *testing.T) {
+		result := Apply([]types.Promotion{
+			{ID: 1, Type: types.PromotionPercentage, PercentOff: 100},
+			{ID: 2, Type: types.PromotionFixed, AmountOff: money.New(1000, "USD")},
+		}, lines, "USD")
+
+		if result.Discount != result.Subtotal || !result.Total().IsZero() || result.Skipped[2] != SkipNotEligible {
+			t.Errorf("expected the whole subtotal off and the fixed amount skipped, got %+v", result)
+		}
+	})
+}
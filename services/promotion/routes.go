@@ -0,0 +1,182 @@
+package promotion
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"github.com/xelathan/golang_backend/config"
+	"github.com/xelathan/golang_backend/money"
+	"github.com/xelathan/golang_backend/services/auth"
+	"github.com/xelathan/golang_backend/types"
+	"github.com/xelathan/golang_backend/utils"
+)
+
+type Handler struct {
+	store        types.PromotionStore
+	productStore types.ProductStore
+	userStore    types.UserStore
+}
+
+func NewHandler(store types.PromotionStore, productStore types.ProductStore, userStore types.UserStore) *Handler {
+	return &Handler{store: store, productStore: productStore, userStore: userStore}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/admin/promotions", auth.WithAdminAuth(h.handleGetPromotions, h.userStore)).Methods(http.MethodGet)
+	router.HandleFunc("/admin/promotions", auth.WithAdminAuth(h.handleCreatePromotion, h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/admin/promotions/{id}/active", auth.WithAdminAuth(h.handleSetActive, h.userStore)).Methods(http.MethodPost)
+}
+
+func (h *Handler) handleGetPromotions(w http.ResponseWriter, r *http.Request) {
+	promotions, err := h.store.GetPromotions(r.Context())
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, promotions)
+}
+
+func (h *Handler) handleCreatePromotion(w http.ResponseWriter, r *http.Request) {
+	payload := types.CreatePromotionPayload{}
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	promotion, err := promotionFromPayload(payload, time.Now())
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if len(promotion.ProductIDs) > 0 {
+		products, err := h.productStore.GetProductsByID(r.Context(), promotion.ProductIDs)
+		if err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		if len(products) != len(promotion.ProductIDs) {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("productIds contains unknown products"))
+			return
+		}
+	}
+
+	id, err := h.store.CreatePromotion(r.Context(), promotion)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	promotion.ID = id
+	promotion.Active = true
+
+	utils.WriteJSON(w, http.StatusCreated, promotion)
+}
+
+// promotionFromPayload checks the fields each promotion type needs and
+// fills in the defaults. Amounts must be in the base currency.
+func promotionFromPayload(payload types.CreatePromotionPayload, now time.Time) (types.Promotion, error) {
+	base := config.Envs.BaseCurrency
+	promotion := types.Promotion{
+		Name:          payload.Name,
+		Code:          strings.ToUpper(strings.TrimSpace(payload.Code)),
+		Type:          payload.Type,
+		AmountOff:     money.Zero(base),
+		MinOrderValue: money.Zero(base),
+		StartsAt:      now,
+		EndsAt:        payload.EndsAt,
+		UsageLimit:    payload.UsageLimit,
+		PerUserLimit:  payload.PerUserLimit,
+		Exclusive:     payload.Exclusive,
+		Priority:      payload.Priority,
+		ProductIDs:    payload.ProductIDs,
+		Categories:    payload.Categories,
+	}
+
+	if promotion.ProductIDs == nil {
+		promotion.ProductIDs = []int{}
+	}
+
+	if promotion.Categories == nil {
+		promotion.Categories = []string{}
+	}
+
+	switch payload.Type {
+	case types.PromotionPercentage:
+		if payload.PercentOff == 0 {
+			return promotion, fmt.Errorf("percentOff is required for percentage promotions")
+		}
+		promotion.PercentOff = payload.PercentOff
+	case types.PromotionFixed:
+		if payload.AmountOff == nil {
+			return promotion, fmt.Errorf("amountOff is required for fixed promotions")
+		}
+		promotion.AmountOff = *payload.AmountOff
+	case types.PromotionBuyXGetY:
+		if payload.BuyQuantity == 0 || payload.GetQuantity == 0 {
+			return promotion, fmt.Errorf("buyQuantity and getQuantity are required for buy_x_get_y promotions")
+		}
+		promotion.BuyQuantity = payload.BuyQuantity
+		promotion.GetQuantity = payload.GetQuantity
+	}
+
+	if promotion.AmountOff.Currency != base {
+		return promotion, fmt.Errorf("amountOff must be in the base currency %s", base)
+	}
+
+	if payload.MinOrderValue != nil {
+		if payload.MinOrderValue.Currency != base {
+			return promotion, fmt.Errorf("minOrderValue must be in the base currency %s", base)
+		}
+		promotion.MinOrderValue = *payload.MinOrderValue
+	}
+
+	if payload.StartsAt != nil {
+		promotion.StartsAt = *payload.StartsAt
+	}
+
+	if promotion.EndsAt != nil && !promotion.EndsAt.After(promotion.StartsAt) {
+		return promotion, fmt.Errorf("endsAt must be after startsAt")
+	}
+
+	return promotion, nil
+}
+
+func (h *Handler) handleSetActive(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid promotion id"))
+		return
+	}
+
+	payload := types.SetPromotionActivePayload{}
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.store.SetPromotionActive(r.Context(), id, payload.Active); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			utils.WriteError(w, http.StatusNotFound, err)
+			return
+		}
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]any{"id": id, "active": payload.Active})
+}
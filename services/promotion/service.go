@@ -0,0 +1,156 @@
+package promotion
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/xelathan/golang_backend/money"
+	"github.com/xelathan/golang_backend/services/currency"
+	"github.com/xelathan/golang_backend/types"
+)
+
+// NormalizeCodes upper-cases and de-duplicates promotion codes, keeping the
+// order they were entered in.
+func NormalizeCodes(codes []string) []string {
+	seen := map[string]bool{}
+	normalized := []string{}
+	for _, code := range codes {
+		code = strings.ToUpper(strings.TrimSpace(code))
+		if code == "" || seen[code] {
+			continue
+		}
+
+		seen[code] = true
+		normalized = append(normalized, code)
+	}
+
+	return normalized
+}
+
+// Quote works out the promotions of a checkout: it loads the automatic
+// promotions and those with the entered codes, drops the ones that have
+// reached a usage limit, converts them to the order currency and applies
+// them to the lines. Every entered code has to apply, otherwise the error
+// tells the customer why it did not.
+func Quote(ctx context.Context, store types.PromotionStore, converter *currency.Converter, userId int, codes []string, lines []Line, currencyCode string, at time.Time) (Result, error) {
+	codes = NormalizeCodes(codes)
+
+	candidates, err := store.GetApplicablePromotions(ctx, codes, at)
+	if err != nil {
+		return Result{}, err
+	}
+
+	byCode := map[string]types.Promotion{}
+	ids := make([]int, len(candidates))
+	for i, p := range candidates {
+		ids[i] = p.ID
+		if p.Code != "" {
+			byCode[strings.ToUpper(p.Code)] = p
+		}
+	}
+
+	for _, code := range codes {
+		if _, ok := byCode[code]; !ok {
+			return Result{}, fmt.Errorf("promotion code %s is not valid", code)
+		}
+	}
+
+	total, byUser, err := store.GetUsage(ctx, ids, userId)
+	if err != nil {
+		return Result{}, err
+	}
+
+	usable := []types.Promotion{}
+	for _, p := range candidates {
+		if p.UsageLimit != nil && total[p.ID] >= *p.UsageLimit || p.PerUserLimit != nil && byUser[p.ID] >= *p.PerUserLimit {
+			if p.Code != "" {
+				return Result{}, fmt.Errorf("promotion code %s has reached its usage limit", p.Code)
+			}
+			continue
+		}
+
+		localized, err := localize(p, converter, currencyCode)
+		if err != nil {
+			return Result{}, err
+		}
+
+		usable = append(usable, localized)
+	}
+
+	result := Apply(usable, lines, currencyCode)
+
+	for _, code := range codes {
+		if reason, skipped := result.Skipped[byCode[code].ID]; skipped {
+			return Result{}, fmt.Errorf("promotion code %s cannot be applied: %s", code, reason)
+		}
+	}
+
+	return result, nil
+}
+
+// Localize converts the amounts of the promotions to the order currency.
+func Localize(promotions []types.Promotion, converter *currency.Converter, currencyCode string) ([]types.Promotion, error) {
+	localized := make([]types.Promotion, len(promotions))
+	for i, p := range promotions {
+		var err error
+		if localized[i], err = localize(p, converter, currencyCode); err != nil {
+			return nil, err
+		}
+	}
+
+	return localized, nil
+}
+
+// localize converts the amounts of a promotion to the order currency.
+func localize(p types.Promotion, converter *currency.Converter, currencyCode string) (types.Promotion, error) {
+	amountOff, err := converter.Convert(p.AmountOff, currencyCode)
+	if err != nil {
+		return p, err
+	}
+
+	minOrderValue, err := converter.Convert(p.MinOrderValue, currencyCode)
+	if err != nil {
+		return p, err
+	}
+
+	p.AmountOff, p.MinOrderValue = amountOff, minOrderValue
+
+	return p, nil
+}
+
+// Redemptions turns the promotions applied to an order into the records that
+// count towards usage limits and the line-level discount breakdown.
+// orderItemIDs holds the id of the order item created for every line.
+func Redemptions(result Result, orderId int, userId int, orderItemIDs []int) ([]types.PromotionRedemption, []types.OrderItemDiscount) {
+	redemptions := []types.PromotionRedemption{}
+	discounts := []types.OrderItemDiscount{}
+
+	for _, applied := range result.Applied {
+		redemptions = append(redemptions, types.PromotionRedemption{
+			PromotionID:  applied.PromotionID,
+			OrderID:      orderId,
+			UserID:       userId,
+			Code:         applied.Code,
+			Amount:       applied.Amount,
+			FreeShipping: applied.FreeShipping,
+		})
+
+		for _, line := range applied.Lines {
+			discounts = append(discounts, types.OrderItemDiscount{
+				OrderID:     orderId,
+				OrderItemID: orderItemIDs[line.Line],
+				PromotionID: applied.PromotionID,
+				Amount:      line.Amount,
+			})
+		}
+	}
+
+	return redemptions, discounts
+}
+
+// Total is the subtotal less the discount, never below zero.
+func (r Result) Total() money.Money {
+	return money.Max(r.Subtotal.Sub(r.Discount), money.Zero(r.Subtotal.Currency))
+}
@@ -0,0 +1,388 @@
+package promotion
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/xelathan/golang_backend/db"
+	"github.com/xelathan/golang_backend/types"
+)
+
+var ErrNotFound = errors.New("promotion not found")
+
+const selectPromotions = "SELECT id, name, code, type, percentOff, amountOff, buyQuantity, getQuantity, minOrderValue, currency, " +
+	"startsAt, endsAt, usageLimit, perUserLimit, exclusive, priority, active, createdAt FROM promotions"
+
+type Store struct {
+	db types.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+func (s *Store) WithTx(tx types.DB) types.PromotionStore {
+	return &Store{db: tx}
+}
+
+func (s *Store) CreatePromotion(ctx context.Context, p types.Promotion) (int, error) {
+	var id int64
+	err := db.InTxContext(ctx, s.db, func(tx types.DB) error {
+		res, err := tx.ExecContext(ctx,
+			"INSERT INTO promotions (name, code, type, percentOff, amountOff, buyQuantity, getQuantity, minOrderValue, currency, startsAt, endsAt, usageLimit, perUserLimit, exclusive, priority) "+
+				"VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)",
+			p.Name, sql.NullString{String: p.Code, Valid: p.Code != ""}, p.Type, p.PercentOff, p.AmountOff.Amount, p.BuyQuantity, p.GetQuantity,
+			p.MinOrderValue.Amount, p.AmountOff.Currency, p.StartsAt, p.EndsAt, p.UsageLimit, p.PerUserLimit, p.Exclusive, p.Priority,
+		)
+		if err != nil {
+			return err
+		}
+
+		id, err = res.LastInsertId()
+		if err != nil {
+			return err
+		}
+
+		for _, productId := range p.ProductIDs {
+			if _, err := tx.ExecContext(ctx, "INSERT INTO promotion_targets (promotionId, productId) VALUES (?,?)", id, productId); err != nil {
+				return err
+			}
+		}
+
+		for _, category := range p.Categories {
+			if _, err := tx.ExecContext(ctx, "INSERT INTO promotion_targets (promotionId, category) VALUES (?,?)", id, category); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+func (s *Store) GetPromotions(ctx context.Context) ([]types.Promotion, error) {
+	rows, err := s.db.QueryContext(ctx, selectPromotions+" ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+
+	return s.scanPromotionsWithTargets(ctx, rows)
+}
+
+func (s *Store) SetPromotionActive(ctx context.Context, id int, active bool) error {
+	res, err := s.db.ExecContext(ctx, "UPDATE promotions SET active = ? WHERE id = ?", active, id)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		var exists bool
+		if err := s.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM promotions WHERE id = ?)", id).Scan(&exists); err != nil {
+			return err
+		}
+
+		if !exists {
+			return ErrNotFound
+		}
+	}
+
+	return nil
+}
+
+func (s *Store) GetApplicablePromotions(ctx context.Context, codes []string, at time.Time) ([]types.Promotion, error) {
+	query := selectPromotions + " WHERE active = TRUE AND startsAt <= ? AND (endsAt IS NULL OR endsAt > ?) AND (code IS NULL"
+	args := []interface{}{at, at}
+
+	if len(codes) > 0 {
+		query += fmt.Sprintf(" OR code IN (?%s)", strings.Repeat(",?", len(codes)-1))
+		for _, code := range codes {
+			args = append(args, code)
+		}
+	}
+	query += ")"
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.scanPromotionsWithTargets(ctx, rows)
+}
+
+func (s *Store) GetUsage(ctx context.Context, promotionIDs []int, userId int) (map[int]int, map[int]int, error) {
+	total, byUser := map[int]int{}, map[int]int{}
+	if len(promotionIDs) == 0 {
+		return total, byUser, nil
+	}
+
+	placeholders := strings.Repeat(",?", len(promotionIDs)-1)
+	query := fmt.Sprintf("SELECT promotionId, COUNT(*), COALESCE(SUM(userId = ?), 0) FROM promotion_redemptions "+
+		"WHERE reversedAt IS NULL AND promotionId IN (?%s) GROUP BY promotionId", placeholders)
+
+	args := make([]interface{}, 0, len(promotionIDs)+1)
+	args = append(args, userId)
+	for _, id := range promotionIDs {
+		args = append(args, id)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, count, userCount int
+		if err := rows.Scan(&id, &count, &userCount); err != nil {
+			return nil, nil, err
+		}
+
+		total[id] = count
+		byUser[id] = userCount
+	}
+
+	return total, byUser, rows.Err()
+}
+
+// RecordRedemptions stores the promotions an order used together with its
+// line-level discounts in one transaction.
+func (s *Store) RecordRedemptions(ctx context.Context, redemptions []types.PromotionRedemption, discounts []types.OrderItemDiscount) error {
+	if len(redemptions) == 0 {
+		return nil
+	}
+
+	return db.InTxContext(ctx, s.db, func(tx types.DB) error {
+		for _, r := range redemptions {
+			_, err := tx.ExecContext(ctx,
+				"INSERT INTO promotion_redemptions (promotionId, orderId, userId, code, amount, currency, freeShipping) VALUES (?,?,?,?,?,?,?)",
+				r.PromotionID, r.OrderID, r.UserID, sql.NullString{String: r.Code, Valid: r.Code != ""}, r.Amount.Amount, r.Amount.Currency, r.FreeShipping,
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, d := range discounts {
+			_, err := tx.ExecContext(ctx,
+				"INSERT INTO order_item_discounts (orderId, orderItemId, promotionId, amount, currency) VALUES (?,?,?,?,?)",
+				d.OrderID, d.OrderItemID, d.PromotionID, d.Amount.Amount, d.Amount.Currency,
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// ReverseRedemptions gives back the promotion uses of an order. The
+// redemptions and discounts are kept for the record.
+func (s *Store) ReverseRedemptions(ctx context.Context, orderId int) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE promotion_redemptions SET reversedAt = CURRENT_TIMESTAMP WHERE orderId = ? AND reversedAt IS NULL", orderId)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *Store) GetOrderPromotions(ctx context.Context, orderId int) ([]types.Promotion, error) {
+	rows, err := s.db.QueryContext(ctx,
+		selectPromotions+" WHERE id IN (SELECT promotionId FROM promotion_redemptions WHERE orderId = ? AND reversedAt IS NULL)",
+		orderId,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.scanPromotionsWithTargets(ctx, rows)
+}
+
+func (s *Store) ReviseRedemptions(ctx context.Context, orderId int, redemptions []types.PromotionRedemption) error {
+	return db.InTxContext(ctx, s.db, func(tx types.DB) error {
+		query := "UPDATE promotion_redemptions SET reversedAt = CURRENT_TIMESTAMP WHERE orderId = ? AND reversedAt IS NULL"
+		args := []interface{}{orderId}
+		if len(redemptions) > 0 {
+			query += fmt.Sprintf(" AND promotionId NOT IN (?%s)", strings.Repeat(",?", len(redemptions)-1))
+			for _, r := range redemptions {
+				args = append(args, r.PromotionID)
+			}
+		}
+
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			return err
+		}
+
+		for _, r := range redemptions {
+			_, err := tx.ExecContext(ctx,
+				"UPDATE promotion_redemptions SET amount = ?, freeShipping = ? WHERE orderId = ? AND promotionId = ? AND reversedAt IS NULL",
+				r.Amount.Amount, r.FreeShipping, orderId, r.PromotionID,
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (s *Store) GetOrderItemDiscounts(ctx context.Context, orderId int) ([]types.OrderItemDiscount, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT id, orderId, orderItemId, promotionId, amount, currency FROM order_item_discounts WHERE orderId = ? ORDER BY id", orderId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	discounts := []types.OrderItemDiscount{}
+	for rows.Next() {
+		d := types.OrderItemDiscount{}
+		if err := rows.Scan(&d.ID, &d.OrderID, &d.OrderItemID, &d.PromotionID, &d.Amount.Amount, &d.Amount.Currency); err != nil {
+			return nil, err
+		}
+
+		discounts = append(discounts, d)
+	}
+
+	return discounts, rows.Err()
+}
+
+func (s *Store) UpdateOrderItemDiscounts(ctx context.Context, discounts []types.OrderItemDiscount) error {
+	if len(discounts) == 0 {
+		return nil
+	}
+
+	return db.InTxContext(ctx, s.db, func(tx types.DB) error {
+		for _, d := range discounts {
+			if d.ID == 0 {
+				_, err := tx.ExecContext(ctx,
+					"INSERT INTO order_item_discounts (orderId, orderItemId, promotionId, amount, currency) VALUES (?,?,?,?,?)",
+					d.OrderID, d.OrderItemID, d.PromotionID, d.Amount.Amount, d.Amount.Currency,
+				)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+
+			if _, err := tx.ExecContext(ctx, "UPDATE order_item_discounts SET amount = ? WHERE id = ?", d.Amount.Amount, d.ID); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// scanPromotionsWithTargets reads the promotions and then loads the products
+// and categories each one targets.
+func (s *Store) scanPromotionsWithTargets(ctx context.Context, rows *sql.Rows) ([]types.Promotion, error) {
+	promotions, err := scanRowsIntoPromotions(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(promotions) == 0 {
+		return promotions, nil
+	}
+
+	index := make(map[int]int, len(promotions))
+	args := make([]interface{}, len(promotions))
+	for i, p := range promotions {
+		index[p.ID] = i
+		args[i] = p.ID
+	}
+
+	placeholders := strings.Repeat(",?", len(promotions)-1)
+	targets, err := s.db.QueryContext(ctx, fmt.Sprintf("SELECT promotionId, productId, category FROM promotion_targets WHERE promotionId IN (?%s) ORDER BY id", placeholders), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer targets.Close()
+
+	for targets.Next() {
+		var promotionId int
+		var productId sql.NullInt64
+		var category sql.NullString
+		if err := targets.Scan(&promotionId, &productId, &category); err != nil {
+			return nil, err
+		}
+
+		p := &promotions[index[promotionId]]
+		if productId.Valid {
+			p.ProductIDs = append(p.ProductIDs, int(productId.Int64))
+		}
+		if category.Valid {
+			p.Categories = append(p.Categories, category.String)
+		}
+	}
+
+	return promotions, targets.Err()
+}
+
+func scanRowsIntoPromotions(rows *sql.Rows) ([]types.Promotion, error) {
+	defer rows.Close()
+
+	promotions := []types.Promotion{}
+	for rows.Next() {
+		p := types.Promotion{ProductIDs: []int{}, Categories: []string{}}
+		var code sql.NullString
+		var endsAt sql.NullTime
+		var usageLimit, perUserLimit sql.NullInt64
+		err := rows.Scan(
+			&p.ID,
+			&p.Name,
+			&code,
+			&p.Type,
+			&p.PercentOff,
+			&p.AmountOff.Amount,
+			&p.BuyQuantity,
+			&p.GetQuantity,
+			&p.MinOrderValue.Amount,
+			&p.AmountOff.Currency,
+			&p.StartsAt,
+			&endsAt,
+			&usageLimit,
+			&perUserLimit,
+			&p.Exclusive,
+			&p.Priority,
+			&p.Active,
+			&p.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		p.Code = code.String
+		p.MinOrderValue.Currency = p.AmountOff.Currency
+		if endsAt.Valid {
+			p.EndsAt = &endsAt.Time
+		}
+		if usageLimit.Valid {
+			limit := int(usageLimit.Int64)
+			p.UsageLimit = &limit
+		}
+		if perUserLimit.Valid {
+			limit := int(perUserLimit.Int64)
+			p.PerUserLimit = &limit
+		}
+
+		promotions = append(promotions, p)
+	}
+
+	return promotions, rows.Err()
+}
@@ -0,0 +1,91 @@
+package refund
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"github.com/xelathan/golang_backend/services/auth"
+	"github.com/xelathan/golang_backend/services/idempotency"
+	orderservice "github.com/xelathan/golang_backend/services/order"
+	"github.com/xelathan/golang_backend/types"
+	"github.com/xelathan/golang_backend/utils"
+)
+
+type Handler struct {
+	refunder  *Refunder
+	store     types.RefundStore
+	userStore types.UserStore
+	keys      *idempotency.Keys
+}
+
+func NewHandler(refunder *Refunder, store types.RefundStore, userStore types.UserStore, keys *idempotency.Keys) *Handler {
+	return &Handler{refunder: refunder, store: store, userStore: userStore, keys: keys}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/orders/{id}/refunds", auth.WithAdminAuth(h.keys.Wrap(h.handleRefund), h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/orders/{id}/refunds", auth.WithAdminAuth(h.handleGetRefunds, h.userStore)).Methods(http.MethodGet)
+}
+
+func (h *Handler) handleRefund(w http.ResponseWriter, r *http.Request) {
+	orderId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid order id"))
+		return
+	}
+
+	payload := types.RefundOrderPayload{}
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	userId := auth.GetUserIdFromContext(r.Context())
+	refund, err := h.refunder.Refund(r.Context(), orderId, payload, &userId)
+	if err != nil {
+		utils.WriteError(w, refundStatus(err), err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, refund)
+}
+
+func (h *Handler) handleGetRefunds(w http.ResponseWriter, r *http.Request) {
+	orderId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid order id"))
+		return
+	}
+
+	refunds, err := h.store.GetRefundsByOrderId(r.Context(), orderId)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, refunds)
+}
+
+// refundStatus is the status code for an error from a refund: refunds that
+// cannot be given as asked are bad requests.
+func refundStatus(err error) int {
+	var refused *refundError
+	switch {
+	case errors.As(err, &refused):
+		return http.StatusBadRequest
+	case errors.Is(err, orderservice.ErrNotFound):
+		return http.StatusNotFound
+	}
+
+	return http.StatusInternalServerError
+}
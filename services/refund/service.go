@@ -0,0 +1,256 @@
+package refund
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/xelathan/golang_backend/money"
+	orderservice "github.com/xelathan/golang_backend/services/order"
+	"github.com/xelathan/golang_backend/services/payment"
+	"github.com/xelathan/golang_backend/types"
+)
+
+// refundError is a refund that cannot be given as asked, as opposed to a
+// failure to give it.
+type refundError struct {
+	err error
+}
+
+func (e *refundError) Error() string {
+	return e.err.Error()
+}
+
+// Refunder gives money back on paid orders through the payment processor,
+// to how the customer paid or as store credit. Every refund is recorded with
+// the items it is for, can put those items back in stock, and moves the
+// order to refunded or partially refunded depending on what is left of what
+// was captured.
+//
+// A refund is checked and recorded as pending with the order's payments
+// locked, before any money moves, so refunds given at the same time cannot
+// together give back more than was captured.
+type Refunder struct {
+	uow          types.UnitOfWork
+	store        types.RefundStore
+	orderStore   types.OrderStore
+	productStore types.ProductStore
+	paymentStore types.PaymentStore
+	payments     types.PaymentProcessor
+	transitions  types.OrderTransitioner
+}
+
+func NewRefunder(uow types.UnitOfWork, store types.RefundStore, orderStore types.OrderStore, productStore types.ProductStore, paymentStore types.PaymentStore, payments types.PaymentProcessor, transitions types.OrderTransitioner) *Refunder {
+	return &Refunder{
+		uow:          uow,
+		store:        store,
+		orderStore:   orderStore,
+		productStore: productStore,
+		paymentStore: paymentStore,
+		payments:     payments,
+		transitions:  transitions,
+	}
+}
+
+func (r *Refunder) Refund(ctx context.Context, orderId int, payload types.RefundOrderPayload, createdBy *int) (*types.Refund, error) {
+	order, err := r.orderStore.GetOrderById(ctx, orderId)
+	if err != nil {
+		return nil, err
+	}
+
+	// an order that can be refunded in full can also be refunded in part
+	if !orderservice.CanTransition(order.Status, types.Refunded) {
+		return nil, &refundError{fmt.Errorf("cannot refund an order that is %s", order.Status)}
+	}
+
+	if len(payload.Items) == 0 && payload.Restock {
+		return nil, &refundError{fmt.Errorf("only refunded items can be restocked")}
+	}
+
+	if payload.Amount != nil && payload.Amount.Currency != order.Total.Currency {
+		return nil, &refundError{fmt.Errorf("order was paid in %s", order.Total.Currency)}
+	}
+
+	var refund types.Refund
+	var captured, refunded money.Money
+	quantities := map[int]int{}
+	err = r.uow.Do(ctx, func(tx types.DB) error {
+		payments, err := r.paymentStore.WithTx(tx).LockPaymentsByOrderId(ctx, orderId)
+		if err != nil {
+			return err
+		}
+
+		previous, err := r.store.WithTx(tx).GetRefundsByOrderId(ctx, orderId)
+		if err != nil {
+			return err
+		}
+
+		refund = types.Refund{
+			OrderID:     orderId,
+			Amount:      money.Zero(order.Total.Currency),
+			Status:      types.RefundPending,
+			Reason:      payload.Reason,
+			Note:        payload.Note,
+			Restocked:   payload.Restock,
+			StoreCredit: payload.StoreCredit,
+			CreatedBy:   createdBy,
+			Items:       []types.RefundItem{},
+		}
+		quantities = map[int]int{}
+
+		if len(payload.Items) > 0 {
+			items, err := r.orderStore.WithTx(tx).GetOrderItems(ctx, orderId)
+			if err != nil {
+				return err
+			}
+
+			refund.Items, err = refundItems(order, items, previous, payload.Items)
+			if err != nil {
+				return err
+			}
+
+			productIds := map[int]int{}
+			for _, item := range items {
+				productIds[item.ID] = item.ProductID
+			}
+
+			for _, item := range refund.Items {
+				refund.Amount = refund.Amount.Add(item.Amount)
+				quantities[productIds[item.OrderItemID]] += item.Quantity
+			}
+		}
+
+		if payload.Amount != nil {
+			refund.Amount = *payload.Amount
+		}
+
+		if !refund.Amount.IsPositive() {
+			return &refundError{fmt.Errorf("nothing to refund")}
+		}
+
+		captured, refunded = refundTotals(order, payments, previous)
+		if left := captured.Sub(refunded); refund.Amount.Cmp(left) > 0 {
+			return &refundError{fmt.Errorf("cannot refund %s, %s of the %s captured is left to refund", refund.Amount, left, captured)}
+		}
+
+		refund.ID, err = r.store.WithTx(tx).CreateRefund(ctx, refund)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the refund is recorded, so it is carried through even if the client
+	// goes away
+	ctx = context.WithoutCancel(ctx)
+
+	if refund.StoreCredit {
+		err = r.payments.RefundTo(ctx, orderId, refund.Amount, types.TenderStoreCredit, strconv.Itoa(order.UserId))
+	} else {
+		err = r.payments.Refund(ctx, orderId, refund.Amount)
+	}
+	if err != nil {
+		// a refund that may have given some money back is left pending
+		if errors.Is(err, payment.ErrNotRefunded) {
+			if failErr := r.store.UpdateRefundStatus(ctx, refund.ID, types.RefundFailed); failErr != nil {
+				return nil, failErr
+			}
+		}
+		return nil, err
+	}
+
+	refund.Status = types.RefundCompleted
+	err = r.uow.Do(ctx, func(tx types.DB) error {
+		if err := r.store.WithTx(tx).UpdateRefundStatus(ctx, refund.ID, types.RefundCompleted); err != nil {
+			return err
+		}
+
+		if !refund.Restocked {
+			return nil
+		}
+
+		return orderservice.Restock(ctx, r.productStore.WithTx(tx), quantities)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	status := types.PartiallyRefunded
+	if refunded.Add(refund.Amount).Cmp(captured) >= 0 {
+		status = types.Refunded
+	}
+
+	if _, err := r.transitions.Transition(ctx, orderId, status, createdBy, fmt.Sprintf("refund %d: %s", refund.ID, refund.Reason)); err != nil {
+		return nil, err
+	}
+
+	return &refund, nil
+}
+
+// refundTotals adds up what the order's payments have captured and what the
+// refunds before have given back of that, counting the pending ones.
+func refundTotals(order *types.Order, payments []types.Payment, previous []types.Refund) (captured money.Money, refunded money.Money) {
+	captured, _ = orderservice.PaymentTotals(order, payments)
+
+	refunded = money.Zero(order.Total.Currency)
+	for _, refund := range previous {
+		if refund.Status != types.RefundFailed {
+			refunded = refunded.Add(refund.Amount)
+		}
+	}
+
+	return captured, refunded
+}
+
+// refundItems values the units asked for at what was paid for them. The
+// order's total less shipping is shared across its items by their price, so
+// discounts and tax go back with the items, and each unit is worth its part
+// of its item's share such that refunding every unit, over any number of
+// refunds, gives back exactly the share.
+func refundItems(order *types.Order, items []types.OrderItem, previous []types.Refund, asked []types.RefundItemPayload) ([]types.RefundItem, error) {
+	weights := make([]int64, len(items))
+	for i, item := range items {
+		weights[i] = item.Price.Mul(item.Quantity).Amount
+	}
+
+	paid := money.Max(order.Total.Sub(order.ShippingCost), money.Zero(order.Total.Currency))
+	shares := paid.Allocate(weights)
+
+	lines := map[int]int{}
+	for i, item := range items {
+		lines[item.ID] = i
+	}
+
+	refundedUnits := map[int]int{}
+	for _, refund := range previous {
+		if refund.Status == types.RefundFailed {
+			continue
+		}
+		for _, item := range refund.Items {
+			refundedUnits[item.OrderItemID] += item.Quantity
+		}
+	}
+
+	priced := []types.RefundItem{}
+	for _, a := range asked {
+		i, ok := lines[a.OrderItemID]
+		if !ok {
+			return nil, &refundError{fmt.Errorf("order item %d is not part of the order", a.OrderItemID)}
+		}
+
+		item := items[i]
+		before := refundedUnits[item.ID]
+		if before+a.Quantity > item.Quantity {
+			return nil, &refundError{fmt.Errorf("only %d of order item %d are left to refund", item.Quantity-before, item.ID)}
+		}
+		refundedUnits[item.ID] += a.Quantity
+
+		total := int64(item.Quantity)
+		amount := shares[i].MulRatio(int64(before+a.Quantity), total, money.Down).Sub(shares[i].MulRatio(int64(before), total, money.Down))
+
+		priced = append(priced, types.RefundItem{OrderItemID: item.ID, Quantity: a.Quantity, Amount: amount})
+	}
+
+	return priced, nil
+}
@@ -0,0 +1,301 @@
+package refund
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/xelathan/golang_backend/money"
+	"github.com/xelathan/golang_backend/services/payment"
+	"github.com/xelathan/golang_backend/types"
+)
+
+func TestRefundItems(t *testing.T) {
+	// 27.00 paid for the items after a discount, plus 3.00 shipping
+	order := &types.Order{ID: 1, Total: money.New(3000, "USD"), ShippingCost: money.New(300, "USD")}
+	items := []types.OrderItem{
+		{ID: 10, Quantity: 2, Price: money.New(1000, "USD")},
+		{ID: 11, Quantity: 3, Price: money.New(333, "USD")},
+	}
+
+	t.Run("should value units at their share of what was paid", func(t *testing.T) {
+		refunded, err := refundItems(order, items, nil, []types.RefundItemPayload{{OrderItemID: 10, Quantity: 1}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if refunded[0].Amount != money.New(900, "USD") {
+			t.Errorf("expected 9.00 for one of two units of an 18.00 share, got %s", refunded[0].Amount)
+		}
+	})
+
+	t.Run("should give back exactly the share over several refunds", func(t *testing.T) {
+		previous := []types.Refund{}
+		total := money.Zero("USD")
+		for i := 0; i < 3; i++ {
+			refunded, err := refundItems(order, items, previous, []types.RefundItemPayload{{OrderItemID: 11, Quantity: 1}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			total = total.Add(refunded[0].Amount)
+			previous = append(previous, types.Refund{Items: refunded})
+		}
+
+		share := money.New(2700, "USD").Allocate([]int64{2000, 999})[1]
+		if total != share {
+			t.Errorf("expected the item's %s share back, got %s", share, total)
+		}
+
+		if _, err := refundItems(order, items, previous, []types.RefundItemPayload{{OrderItemID: 11, Quantity: 1}}); err == nil {
+			t.Error("expected no units to be left to refund")
+		}
+	})
+
+	t.Run("should refuse items from other orders", func(t *testing.T) {
+		if _, err := refundItems(order, items, nil, []types.RefundItemPayload{{OrderItemID: 99, Quantity: 1}}); err == nil {
+			t.Error("expected an unknown order item to be refused")
+		}
+	})
+}
+
+func TestRefunder(t *testing.T) {
+	setup := func() (*Refunder, *mockPayments, *mockRefundStore, *mockProductStore, *mockTransitions) {
+		payments := &mockPayments{payments: []types.Payment{
+			{ID: 1, OrderID: 1, Status: types.PaymentCaptured, Amount: money.New(3000, "USD"), Refunded: money.Zero("USD")},
+		}}
+		orders := &mockOrderStore{
+			order: types.Order{ID: 1, Status: types.Delivered, Total: money.New(3000, "USD"), ShippingCost: money.Zero("USD")},
+			items: []types.OrderItem{{ID: 10, OrderID: 1, ProductID: 7, Quantity: 3, Price: money.New(1000, "USD")}},
+		}
+		products := &mockProductStore{quantities: map[int]int{7: 0}}
+		transitions := &mockTransitions{}
+
+		refunds := &mockRefundStore{}
+		refunder := NewRefunder(mockUnitOfWork{}, refunds, orders, products, payments, payments, transitions)
+
+		return refunder, payments, refunds, products, transitions
+	}
+
+	t.Run("should refund and restock items in part", func(t *testing.T) {
+		refunder, payments, refunds, products, transitions := setup()
+
+		refund, err := refunder.Refund(context.Background(), 1, types.RefundOrderPayload{
+			Items:   []types.RefundItemPayload{{OrderItemID: 10, Quantity: 1}},
+			Reason:  types.RefundDamaged,
+			Restock: true,
+		}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if refund.Amount != money.New(1000, "USD") || payments.payments[0].Refunded != refund.Amount {
+			t.Errorf("expected 10.00 refunded through the payment, got %s and %s", refund.Amount, payments.payments[0].Refunded)
+		}
+
+		if products.quantities[7] != 1 || transitions.status != types.PartiallyRefunded {
+			t.Errorf("expected one unit back in stock and a partially refunded order, got %d and %s", products.quantities[7], transitions.status)
+		}
+
+		if refund.Status != types.RefundCompleted || refunds.refunds[0].Status != types.RefundCompleted {
+			t.Errorf("expected the refund completed, got %s", refunds.refunds[0].Status)
+		}
+	})
+
+	t.Run("should mark the order refunded once everything is back", func(t *testing.T) {
+		refunder, _, _, products, transitions := setup()
+
+		amount := money.New(3000, "USD")
+		if _, err := refunder.Refund(context.Background(), 1, types.RefundOrderPayload{Amount: &amount, Reason: types.RefundNotReceived}, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		if products.quantities[7] != 0 || transitions.status != types.Refunded {
+			t.Errorf("expected nothing restocked and a refunded order, got %d and %s", products.quantities[7], transitions.status)
+		}
+	})
+
+	t.Run("should never refund more than was captured", func(t *testing.T) {
+		refunder, payments, _, _, _ := setup()
+
+		amount := money.New(3001, "USD")
+		_, err := refunder.Refund(context.Background(), 1, types.RefundOrderPayload{Amount: &amount, Reason: types.RefundOther}, nil)
+
+		var refused *refundError
+		if !errors.As(err, &refused) {
+			t.Fatalf("expected the refund to be refused, got %v", err)
+		}
+
+		if payments.payments[0].Refunded.IsPositive() {
+			t.Errorf("expected nothing refunded, got %s", payments.payments[0].Refunded)
+		}
+	})
+
+	t.Run("should count pending refunds against what is left", func(t *testing.T) {
+		refunder, _, refunds, _, _ := setup()
+		refunds.refunds = []types.Refund{{ID: 1, OrderID: 1, Amount: money.New(2500, "USD"), Status: types.RefundPending}}
+
+		amount := money.New(1000, "USD")
+		_, err := refunder.Refund(context.Background(), 1, types.RefundOrderPayload{Amount: &amount, Reason: types.RefundOther}, nil)
+
+		var refused *refundError
+		if !errors.As(err, &refused) {
+			t.Errorf("expected the refund to be refused, got %v", err)
+		}
+	})
+
+	t.Run("should record a refund that moved no money as failed", func(t *testing.T) {
+		refunder, payments, refunds, _, _ := setup()
+		payments.err = fmt.Errorf("%w: card expired", payment.ErrNotRefunded)
+
+		amount := money.New(3000, "USD")
+		if _, err := refunder.Refund(context.Background(), 1, types.RefundOrderPayload{Amount: &amount, Reason: types.RefundOther}, nil); err == nil {
+			t.Fatal("expected the refund to fail")
+		}
+
+		if refunds.refunds[0].Status != types.RefundFailed {
+			t.Fatalf("expected the refund recorded as failed, got %s", refunds.refunds[0].Status)
+		}
+
+		// a failed refund leaves the money to refund again
+		payments.err = nil
+		if _, err := refunder.Refund(context.Background(), 1, types.RefundOrderPayload{Amount: &amount, Reason: types.RefundOther}, nil); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("should leave a refund pending when money may have moved", func(t *testing.T) {
+		refunder, payments, refunds, _, _ := setup()
+		payments.err = fmt.Errorf("provider unavailable")
+
+		amount := money.New(1000, "USD")
+		if _, err := refunder.Refund(context.Background(), 1, types.RefundOrderPayload{Amount: &amount, Reason: types.RefundOther}, nil); err == nil {
+			t.Fatal("expected the refund to fail")
+		}
+
+		if refunds.refunds[0].Status != types.RefundPending {
+			t.Errorf("expected the refund left pending, got %s", refunds.refunds[0].Status)
+		}
+	})
+}
+
+type mockUnitOfWork struct{}
+
+func (mockUnitOfWork) Do(ctx context.Context, fn func(tx types.DB) error) error {
+	return fn(nil)
+}
+
+type mockOrderStore struct {
+	types.OrderStore
+
+	order types.Order
+	items []types.OrderItem
+}
+
+func (m *mockOrderStore) GetOrderById(ctx context.Context, orderId int) (*types.Order, error) {
+	order := m.order
+	return &order, nil
+}
+
+func (m *mockOrderStore) GetOrderItems(ctx context.Context, orderId int) ([]types.OrderItem, error) {
+	return m.items, nil
+}
+
+func (m *mockOrderStore) WithTx(tx types.DB) types.OrderStore {
+	return m
+}
+
+type mockRefundStore struct {
+	types.RefundStore
+
+	refunds []types.Refund
+}
+
+func (m *mockRefundStore) CreateRefund(ctx context.Context, refund types.Refund) (int, error) {
+	refund.ID = len(m.refunds) + 1
+	m.refunds = append(m.refunds, refund)
+
+	return refund.ID, nil
+}
+
+func (m *mockRefundStore) GetRefundsByOrderId(ctx context.Context, orderId int) ([]types.Refund, error) {
+	return m.refunds, nil
+}
+
+func (m *mockRefundStore) UpdateRefundStatus(ctx context.Context, id int, status types.RefundStatus) error {
+	m.refunds[id-1].Status = status
+	return nil
+}
+
+func (m *mockRefundStore) WithTx(tx types.DB) types.RefundStore {
+	return m
+}
+
+type mockProductStore struct {
+	types.ProductStore
+
+	quantities map[int]int
+}
+
+func (m *mockProductStore) LockProductsByID(ctx context.Context, ids []int) ([]types.Product, error) {
+	products := []types.Product{}
+	for _, id := range ids {
+		products = append(products, types.Product{ID: id, Quantity: m.quantities[id]})
+	}
+
+	return products, nil
+}
+
+func (m *mockProductStore) UpdateProductBatch(ctx context.Context, products map[int]types.Product) error {
+	for id, product := range products {
+		m.quantities[id] = product.Quantity
+	}
+
+	return nil
+}
+
+func (m *mockProductStore) WithTx(tx types.DB) types.ProductStore {
+	return m
+}
+
+// mockPayments is both the payment store and the processor refunding
+// through it.
+type mockPayments struct {
+	types.PaymentStore
+	types.PaymentProcessor
+
+	payments []types.Payment
+	err      error
+}
+
+func (m *mockPayments) LockPaymentsByOrderId(ctx context.Context, orderId int) ([]types.Payment, error) {
+	return m.payments, nil
+}
+
+func (m *mockPayments) WithTx(tx types.DB) types.PaymentStore {
+	return m
+}
+
+func (m *mockPayments) Refund(ctx context.Context, orderId int, amount money.Money) error {
+	if m.err != nil {
+		return m.err
+	}
+
+	m.payments[0].Refunded = m.payments[0].Refunded.Add(amount)
+	m.payments[0].Status = types.PaymentPartiallyRefunded
+	if m.payments[0].Refunded == m.payments[0].Amount {
+		m.payments[0].Status = types.PaymentRefunded
+	}
+
+	return nil
+}
+
+type mockTransitions struct {
+	status types.OrderStatus
+}
+
+func (m *mockTransitions) Transition(ctx context.Context, orderId int, to types.OrderStatus, changedBy *int, reason string) (*types.Order, error) {
+	m.status = to
+	return &types.Order{ID: orderId, Status: to}, nil
+}
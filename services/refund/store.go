@@ -0,0 +1,143 @@
+package refund
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/xelathan/golang_backend/db"
+	"github.com/xelathan/golang_backend/types"
+)
+
+type Store struct {
+	db types.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+func (s *Store) WithTx(tx types.DB) types.RefundStore {
+	return &Store{db: tx}
+}
+
+func (s *Store) CreateRefund(ctx context.Context, refund types.Refund) (int, error) {
+	var id int64
+	err := db.InTxContext(ctx, s.db, func(tx types.DB) error {
+		res, err := tx.ExecContext(ctx,
+			"INSERT INTO refunds (orderId, amount, currency, status, reason, note, restocked, storeCredit, createdBy) VALUES (?,?,?,?,?,?,?,?,?)",
+			refund.OrderID, refund.Amount.Amount, refund.Amount.Currency, refund.Status, refund.Reason, refund.Note, refund.Restocked, refund.StoreCredit, refund.CreatedBy,
+		)
+		if err != nil {
+			return err
+		}
+
+		id, err = res.LastInsertId()
+		if err != nil {
+			return err
+		}
+
+		for _, item := range refund.Items {
+			_, err := tx.ExecContext(ctx,
+				"INSERT INTO refund_items (refundId, orderItemId, quantity, amount) VALUES (?,?,?,?)",
+				id, item.OrderItemID, item.Quantity, item.Amount.Amount,
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+func (s *Store) GetRefundsByOrderId(ctx context.Context, orderId int) ([]types.Refund, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT id, orderId, amount, currency, status, reason, note, restocked, storeCredit, createdBy, createdAt FROM refunds WHERE orderId = ? ORDER BY id", orderId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	refunds := []types.Refund{}
+	byId := map[int]int{}
+	for rows.Next() {
+		refund := types.Refund{Items: []types.RefundItem{}}
+		var createdBy sql.NullInt64
+		err := rows.Scan(
+			&refund.ID,
+			&refund.OrderID,
+			&refund.Amount.Amount,
+			&refund.Amount.Currency,
+			&refund.Status,
+			&refund.Reason,
+			&refund.Note,
+			&refund.Restocked,
+			&refund.StoreCredit,
+			&createdBy,
+			&refund.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if createdBy.Valid {
+			userId := int(createdBy.Int64)
+			refund.CreatedBy = &userId
+		}
+
+		byId[refund.ID] = len(refunds)
+		refunds = append(refunds, refund)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(refunds) == 0 {
+		return refunds, nil
+	}
+
+	itemRows, err := s.db.QueryContext(ctx,
+		"SELECT ri.id, ri.refundId, ri.orderItemId, ri.quantity, ri.amount FROM refund_items ri JOIN refunds r ON r.id = ri.refundId WHERE r.orderId = ? ORDER BY ri.id",
+		orderId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer itemRows.Close()
+
+	for itemRows.Next() {
+		item := types.RefundItem{}
+		if err := itemRows.Scan(&item.ID, &item.RefundID, &item.OrderItemID, &item.Quantity, &item.Amount.Amount); err != nil {
+			return nil, err
+		}
+
+		refund := &refunds[byId[item.RefundID]]
+		item.Amount.Currency = refund.Amount.Currency
+		refund.Items = append(refund.Items, item)
+	}
+
+	return refunds, itemRows.Err()
+}
+
+func (s *Store) UpdateRefundStatus(ctx context.Context, id int, status types.RefundStatus) error {
+	res, err := s.db.ExecContext(ctx, "UPDATE refunds SET status = ? WHERE id = ? AND status = ?", status, id, types.RefundPending)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return fmt.Errorf("refund %d is no longer pending", id)
+	}
+
+	return nil
+}
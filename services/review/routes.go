@@ -0,0 +1,205 @@
+package review
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"github.com/xelathan/golang_backend/services/auth"
+	"github.com/xelathan/golang_backend/types"
+	"github.com/xelathan/golang_backend/utils"
+)
+
+type Handler struct {
+	store      types.ReviewStore
+	orderStore types.OrderStore
+	userStore  types.UserStore
+}
+
+func NewHandler(store types.ReviewStore, orderStore types.OrderStore, userStore types.UserStore) *Handler {
+	return &Handler{store: store, orderStore: orderStore, userStore: userStore}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/products/{id}/reviews", h.handleGetProductReviews).Methods(http.MethodGet)
+	router.HandleFunc("/products/{id}/reviews", auth.WithJWTAuth(h.handleCreateReview, h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/reviews/{id}/helpful", auth.WithJWTAuth(h.handleHelpfulVote, h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/admin/reviews", auth.WithAdminAuth(h.handleGetReviewsByStatus, h.userStore)).Methods(http.MethodGet)
+	router.HandleFunc("/admin/reviews/{id}/moderate", auth.WithAdminAuth(h.handleModerateReview, h.userStore)).Methods(http.MethodPost)
+}
+
+func (h *Handler) handleGetProductReviews(w http.ResponseWriter, r *http.Request) {
+	productId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid product id"))
+		return
+	}
+
+	reviews, err := h.store.GetReviewsByProductId(r.Context(), productId, types.ReviewApproved)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	summaries, err := h.store.GetRatingSummaries(r.Context(), []int{productId})
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]any{"rating": summaries[productId], "reviews": reviews})
+}
+
+func (h *Handler) handleCreateReview(w http.ResponseWriter, r *http.Request) {
+	userId := auth.GetUserIdFromContext(r.Context())
+	if userId == -1 {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid userId"))
+		return
+	}
+
+	productId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid product id"))
+		return
+	}
+
+	payload := types.CreateReviewPayload{}
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	// only customers who received the product may review it
+	purchased, err := h.orderStore.HasCompletedOrderWithProduct(r.Context(), userId, productId)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if !purchased {
+		utils.WriteError(w, http.StatusForbidden, fmt.Errorf("only customers with a completed order for this product can review it"))
+		return
+	}
+
+	reviewId, err := h.store.CreateReview(r.Context(), types.Review{
+		ProductID: productId,
+		UserID:    userId,
+		Rating:    payload.Rating,
+		Title:     payload.Title,
+		Body:      payload.Body,
+		Status:    types.ReviewPending,
+	})
+	if err != nil {
+		utils.WriteError(w, reviewStatus(err), err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, map[string]any{"reviewId": reviewId, "status": types.ReviewPending})
+}
+
+func (h *Handler) handleHelpfulVote(w http.ResponseWriter, r *http.Request) {
+	userId := auth.GetUserIdFromContext(r.Context())
+	if userId == -1 {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid userId"))
+		return
+	}
+
+	reviewId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid review id"))
+		return
+	}
+
+	review, err := h.store.GetReviewById(r.Context(), reviewId)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// reviews waiting on moderation are reported the same as missing ones
+	if review == nil || review.Status != types.ReviewApproved {
+		utils.WriteError(w, http.StatusNotFound, ErrNotFound)
+		return
+	}
+
+	if review.UserID == userId {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("cannot vote on your own review"))
+		return
+	}
+
+	if err := h.store.CreateHelpfulVote(r.Context(), reviewId, userId); err != nil {
+		utils.WriteError(w, reviewStatus(err), err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]int{"helpfulVotes": review.HelpfulVotes + 1})
+}
+
+func (h *Handler) handleGetReviewsByStatus(w http.ResponseWriter, r *http.Request) {
+	status := types.ReviewStatus(r.URL.Query().Get("status"))
+	if status == "" {
+		status = types.ReviewPending
+	}
+
+	if err := utils.Validate.Struct(types.ModerateReviewPayload{Status: status}); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid status %s", status))
+		return
+	}
+
+	reviews, err := h.store.GetReviewsByStatus(r.Context(), status)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, reviews)
+}
+
+func (h *Handler) handleModerateReview(w http.ResponseWriter, r *http.Request) {
+	reviewId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid review id"))
+		return
+	}
+
+	payload := types.ModerateReviewPayload{}
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	if err := h.store.UpdateReviewStatus(r.Context(), reviewId, payload.Status); err != nil {
+		utils.WriteError(w, reviewStatus(err), err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]any{"reviewId": reviewId, "status": payload.Status})
+}
+
+// reviewStatus is the status code for an error from the store: a second
+// review or vote conflicts with the first.
+func reviewStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrAlreadyReviewed), errors.Is(err, ErrAlreadyVoted):
+		return http.StatusConflict
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	}
+
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,204 @@
+package review
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/xelathan/golang_backend/services/auth"
+	"github.com/xelathan/golang_backend/types"
+)
+
+func TestReviewHandlers(t *testing.T) {
+	setup := func(purchased bool) (*Handler, *mockReviewStore) {
+		store := &mockReviewStore{
+			reviews: map[int]types.Review{
+				1: {ID: 1, ProductID: 3, UserID: 8, Status: types.ReviewApproved},
+				2: {ID: 2, ProductID: 3, UserID: 9, Status: types.ReviewPending},
+			},
+			reviewed: map[string]bool{},
+			voted:    map[string]bool{},
+		}
+
+		return NewHandler(store, &mockOrderStore{purchased: purchased}, nil), store
+	}
+
+	post := func(handler *Handler, path string, body string, userId int) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(http.MethodPost, path, bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserKey, userId))
+
+		rr := httptest.NewRecorder()
+		router := mux.NewRouter()
+		router.HandleFunc("/products/{id}/reviews", handler.handleCreateReview)
+		router.HandleFunc("/reviews/{id}/helpful", handler.handleHelpfulVote)
+		router.HandleFunc("/admin/reviews/{id}/moderate", handler.handleModerateReview)
+		router.ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	review := `{"rating": 5, "title": "Great", "body": "Works well"}`
+
+	t.Run("should only let customers who received the product review it", func(t *testing.T) {
+		handler, _ := setup(false)
+		if rr := post(handler, "/products/3/reviews", review, 7); rr.Code != http.StatusForbidden {
+			t.Errorf("expected status code %d, got %d", http.StatusForbidden, rr.Code)
+		}
+
+		handler, store := setup(true)
+		if rr := post(handler, "/products/3/reviews", review, 7); rr.Code != http.StatusCreated {
+			t.Fatalf("expected status code %d, got %d", http.StatusCreated, rr.Code)
+		}
+
+		if created := store.created[0]; created.Status != types.ReviewPending || created.UserID != 7 {
+			t.Errorf("expected a pending review by user 7, got %+v", created)
+		}
+	})
+
+	t.Run("should allow one review per product", func(t *testing.T) {
+		handler, _ := setup(true)
+
+		post(handler, "/products/3/reviews", review, 7)
+		if rr := post(handler, "/products/3/reviews", review, 7); rr.Code != http.StatusConflict {
+			t.Errorf("expected status code %d, got %d", http.StatusConflict, rr.Code)
+		}
+	})
+
+	t.Run("should allow one helpful vote per review", func(t *testing.T) {
+		handler, _ := setup(true)
+
+		if rr := post(handler, "/reviews/1/helpful", "", 7); rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		if rr := post(handler, "/reviews/1/helpful", "", 7); rr.Code != http.StatusConflict {
+			t.Errorf("expected status code %d, got %d", http.StatusConflict, rr.Code)
+		}
+	})
+
+	t.Run("should not take votes on reviews that are not approved", func(t *testing.T) {
+		handler, _ := setup(true)
+
+		for _, path := range []string{"/reviews/2/helpful", "/reviews/5/helpful"} {
+			if rr := post(handler, path, "", 7); rr.Code != http.StatusNotFound {
+				t.Errorf("expected status code %d for %s, got %d", http.StatusNotFound, path, rr.Code)
+			}
+		}
+	})
+
+	t.Run("should fail to moderate an unknown review", func(t *testing.T) {
+		handler, _ := setup(true)
+
+		if rr := post(handler, "/admin/reviews/5/moderate", `{"status": "approved"}`, 1); rr.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+	})
+
+	t.Run("should report store failures as server errors", func(t *testing.T) {
+		handler, store := setup(true)
+		store.err = fmt.Errorf("connection refused")
+
+		if rr := post(handler, "/products/3/reviews", review, 7); rr.Code != http.StatusInternalServerError {
+			t.Errorf("expected status code %d, got %d", http.StatusInternalServerError, rr.Code)
+		}
+
+		if rr := post(handler, "/admin/reviews/1/moderate", `{"status": "rejected"}`, 1); rr.Code != http.StatusInternalServerError {
+			t.Errorf("expected status code %d, got %d", http.StatusInternalServerError, rr.Code)
+		}
+	})
+}
+
+func TestSummarize(t *testing.T) {
+	cases := []struct {
+		name     string
+		total    int
+		count    int
+		expected types.ProductRating
+	}{
+		{"no reviews", 0, 0, types.ProductRating{}},
+		{"a single review", 4, 1, types.ProductRating{Average: 4, Count: 1}},
+		{"an even average", 9, 2, types.ProductRating{Average: 4.5, Count: 2}},
+		{"an average rounded to two decimals", 14, 3, types.ProductRating{Average: 4.67, Count: 3}},
+	}
+
+	for _, c := range cases {
+		t.Run("should summarize "+c.name, func(t *testing.T) {
+			if got := summarize(c.total, c.count); got != c.expected {
+				t.Errorf("expected %+v, got %+v", c.expected, got)
+			}
+		})
+	}
+}
+
+type mockReviewStore struct {
+	types.ReviewStore
+
+	reviews  map[int]types.Review
+	created  []types.Review
+	reviewed map[string]bool
+	voted    map[string]bool
+	err      error
+}
+
+func (m *mockReviewStore) GetReviewById(ctx context.Context, id int) (*types.Review, error) {
+	review, ok := m.reviews[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return &review, nil
+}
+
+func (m *mockReviewStore) CreateReview(ctx context.Context, review types.Review) (int, error) {
+	if m.err != nil {
+		return 0, m.err
+	}
+
+	key := fmt.Sprintf("%d:%d", review.ProductID, review.UserID)
+	if m.reviewed[key] {
+		return 0, ErrAlreadyReviewed
+	}
+	m.reviewed[key] = true
+	m.created = append(m.created, review)
+
+	return 10 + len(m.created), nil
+}
+
+func (m *mockReviewStore) CreateHelpfulVote(ctx context.Context, reviewId int, userId int) error {
+	key := fmt.Sprintf("%d:%d", reviewId, userId)
+	if m.voted[key] {
+		return ErrAlreadyVoted
+	}
+	m.voted[key] = true
+
+	return nil
+}
+
+func (m *mockReviewStore) UpdateReviewStatus(ctx context.Context, id int, status types.ReviewStatus) error {
+	if m.err != nil {
+		return m.err
+	}
+
+	if _, ok := m.reviews[id]; !ok {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+type mockOrderStore struct {
+	types.OrderStore
+
+	purchased bool
+}
+
+func (m *mockOrderStore) HasCompletedOrderWithProduct(ctx context.Context, userId int, productId int) (bool, error) {
+	return m.purchased, nil
+}
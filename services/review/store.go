@@ -0,0 +1,179 @@
+package review
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/xelathan/golang_backend/types"
+)
+
+const selectReviews = "SELECT r.id, r.productId, r.userId, r.rating, r.title, r.body, r.status, r.createdAt, r.updatedAt, " +
+	"(SELECT COUNT(*) FROM review_votes v WHERE v.reviewId = r.id) FROM reviews r"
+
+var (
+	ErrNotFound        = errors.New("review not found")
+	ErrAlreadyReviewed = errors.New("product already reviewed")
+	ErrAlreadyVoted    = errors.New("review already voted on")
+)
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+func (s *Store) GetReviewById(ctx context.Context, id int) (*types.Review, error) {
+	rows, err := s.db.QueryContext(ctx, selectReviews+" WHERE r.id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+
+	reviews, err := scanRowsIntoReviews(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(reviews) == 0 {
+		return nil, ErrNotFound
+	}
+
+	return &reviews[0], nil
+}
+
+func (s *Store) GetReviewsByProductId(ctx context.Context, productId int, status types.ReviewStatus) ([]types.Review, error) {
+	rows, err := s.db.QueryContext(ctx, selectReviews+" WHERE r.productId = ? AND r.status = ? ORDER BY r.createdAt DESC", productId, status)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanRowsIntoReviews(rows)
+}
+
+func (s *Store) GetReviewsByStatus(ctx context.Context, status types.ReviewStatus) ([]types.Review, error) {
+	rows, err := s.db.QueryContext(ctx, selectReviews+" WHERE r.status = ? ORDER BY r.createdAt", status)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanRowsIntoReviews(rows)
+}
+
+func scanRowsIntoReviews(rows *sql.Rows) ([]types.Review, error) {
+	defer rows.Close()
+
+	reviews := []types.Review{}
+	for rows.Next() {
+		r := types.Review{}
+		err := rows.Scan(&r.ID, &r.ProductID, &r.UserID, &r.Rating, &r.Title, &r.Body, &r.Status, &r.CreatedAt, &r.UpdatedAt, &r.HelpfulVotes)
+		if err != nil {
+			return nil, err
+		}
+
+		reviews = append(reviews, r)
+	}
+
+	return reviews, nil
+}
+
+func (s *Store) CreateReview(ctx context.Context, review types.Review) (int, error) {
+	res, err := s.db.ExecContext(ctx, "INSERT INTO reviews (productId, userId, rating, title, body, status) VALUES (?,?,?,?,?,?)", review.ProductID, review.UserID, review.Rating, review.Title, review.Body, review.Status)
+	if err != nil {
+		if isDuplicateEntry(err) {
+			return 0, ErrAlreadyReviewed
+		}
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+func (s *Store) UpdateReviewStatus(ctx context.Context, id int, status types.ReviewStatus) error {
+	res, err := s.db.ExecContext(ctx, "UPDATE reviews SET status = ? WHERE id = ?", status, id)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *Store) CreateHelpfulVote(ctx context.Context, reviewId int, userId int) error {
+	_, err := s.db.ExecContext(ctx, "INSERT INTO review_votes (reviewId, userId) VALUES (?,?)", reviewId, userId)
+	if err != nil {
+		if isDuplicateEntry(err) {
+			return ErrAlreadyVoted
+		}
+		return err
+	}
+
+	return nil
+}
+
+// GetRatingSummaries returns the average and count of approved reviews for
+// each product. Products without approved reviews are left out of the map.
+func (s *Store) GetRatingSummaries(ctx context.Context, productIDs []int) (map[int]types.ProductRating, error) {
+	summaries := map[int]types.ProductRating{}
+	if len(productIDs) == 0 {
+		return summaries, nil
+	}
+
+	placeholders := strings.Repeat(",?", len(productIDs)-1)
+	query := fmt.Sprintf("SELECT productId, SUM(rating), COUNT(*) FROM reviews WHERE status = ? AND productId IN (?%s) GROUP BY productId", placeholders)
+
+	args := make([]interface{}, 0, len(productIDs)+1)
+	args = append(args, types.ReviewApproved)
+	for _, v := range productIDs {
+		args = append(args, v)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var productId, total, count int
+		if err := rows.Scan(&productId, &total, &count); err != nil {
+			return nil, err
+		}
+
+		summaries[productId] = summarize(total, count)
+	}
+
+	return summaries, nil
+}
+
+// summarize averages count ratings adding up to total, to two decimals.
+func summarize(total int, count int) types.ProductRating {
+	if count == 0 {
+		return types.ProductRating{}
+	}
+
+	return types.ProductRating{Average: math.Round(float64(total)*100/float64(count)) / 100, Count: count}
+}
+
+func isDuplicateEntry(err error) bool {
+	mysqlErr, ok := err.(*mysql.MySQLError)
+	return ok && mysqlErr.Number == 1062
+}
@@ -0,0 +1,172 @@
+package rma
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"github.com/xelathan/golang_backend/services/auth"
+	"github.com/xelathan/golang_backend/services/idempotency"
+	orderservice "github.com/xelathan/golang_backend/services/order"
+	"github.com/xelathan/golang_backend/types"
+	"github.com/xelathan/golang_backend/utils"
+)
+
+type Handler struct {
+	returns   *Returns
+	store     types.ReturnStore
+	userStore types.UserStore
+	keys      *idempotency.Keys
+}
+
+func NewHandler(returns *Returns, store types.ReturnStore, userStore types.UserStore, keys *idempotency.Keys) *Handler {
+	return &Handler{returns: returns, store: store, userStore: userStore, keys: keys}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/orders/{id}/returns", auth.WithJWTAuth(h.keys.Wrap(h.handleRequestReturn), h.userStore)).Methods(http.MethodPost)
+
+	router.HandleFunc("/admin/returns", auth.WithAdminAuth(h.handleGetReturns, h.userStore)).Methods(http.MethodGet)
+	router.HandleFunc("/admin/returns/{id}/approve", auth.WithAdminAuth(h.handleApprove, h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/admin/returns/{id}/reject", auth.WithAdminAuth(h.handleReject, h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/admin/returns/{id}/receive", auth.WithAdminAuth(h.keys.Wrap(h.handleReceive), h.userStore)).Methods(http.MethodPost)
+}
+
+func (h *Handler) handleRequestReturn(w http.ResponseWriter, r *http.Request) {
+	userId := auth.GetUserIdFromContext(r.Context())
+	if userId == -1 {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid userId in token claims"))
+		return
+	}
+
+	orderId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid order id"))
+		return
+	}
+
+	payload := types.CreateReturnPayload{}
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	ret, err := h.returns.Request(r.Context(), userId, orderId, payload)
+	if err != nil {
+		utils.WriteError(w, returnStatus(err), err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, ret)
+}
+
+// handleGetReturns lists returns in a status, the ones waiting on a decision
+// unless ?status= says otherwise.
+func (h *Handler) handleGetReturns(w http.ResponseWriter, r *http.Request) {
+	status := types.ReturnStatus(r.URL.Query().Get("status"))
+	switch status {
+	case "":
+		status = types.ReturnRequested
+	case types.ReturnRequested, types.ReturnApproved, types.ReturnRejected, types.ReturnRefunded:
+	default:
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid status"))
+		return
+	}
+
+	returns, err := h.store.GetReturnsByStatus(r.Context(), status)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, returns)
+}
+
+func (h *Handler) handleApprove(w http.ResponseWriter, r *http.Request) {
+	h.decide(w, r, h.returns.Approve)
+}
+
+func (h *Handler) handleReject(w http.ResponseWriter, r *http.Request) {
+	h.decide(w, r, h.returns.Reject)
+}
+
+func (h *Handler) decide(w http.ResponseWriter, r *http.Request, decide func(ctx context.Context, id int, note string) (*types.Return, error)) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid return id"))
+		return
+	}
+
+	payload := types.ReturnDecisionPayload{}
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	ret, err := decide(r.Context(), id, payload.Note)
+	if err != nil {
+		utils.WriteError(w, returnStatus(err), err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, ret)
+}
+
+func (h *Handler) handleReceive(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid return id"))
+		return
+	}
+
+	payload := types.ReceiveReturnPayload{}
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	staffId := auth.GetUserIdFromContext(r.Context())
+	ret, err := h.returns.Receive(r.Context(), id, payload, &staffId)
+	if err != nil {
+		utils.WriteError(w, returnStatus(err), err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, ret)
+}
+
+// returnStatus is the status code for an error from the return workflow:
+// steps it refuses are bad requests.
+func returnStatus(err error) int {
+	var refused *returnError
+	switch {
+	case errors.As(err, &refused):
+		return http.StatusBadRequest
+	case errors.Is(err, orderservice.ErrNotFound), errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	}
+
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,315 @@
+package rma
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	orderservice "github.com/xelathan/golang_backend/services/order"
+	"github.com/xelathan/golang_backend/types"
+)
+
+// returnError is a step of the return workflow refused for the state of the
+// return or its order, as opposed to a failure to carry it out.
+type returnError struct {
+	err error
+}
+
+func (e *returnError) Error() string {
+	return e.err.Error()
+}
+
+// Returns runs the return workflow. Customers ask to return items of a
+// delivered order within the return window, staff approve the return, which
+// gives it a label to ship with, or reject it, and inspect the items when
+// they arrive. Accepting the inspected items refunds them and puts the ones
+// fit to sell back in stock; the rest are written off.
+//
+// A return is requested with its order locked, so it is checked against
+// the others on the order, and is decided on and received with itself
+// locked, so it is refunded at most once.
+type Returns struct {
+	uow          types.UnitOfWork
+	store        types.ReturnStore
+	orderStore   types.OrderStore
+	productStore types.ProductStore
+	refunder     types.Refunder
+	window       time.Duration
+}
+
+func NewReturns(uow types.UnitOfWork, store types.ReturnStore, orderStore types.OrderStore, productStore types.ProductStore, refunder types.Refunder, window time.Duration) *Returns {
+	return &Returns{uow: uow, store: store, orderStore: orderStore, productStore: productStore, refunder: refunder, window: window}
+}
+
+// Request opens a return for the user's order.
+func (s *Returns) Request(ctx context.Context, userId int, orderId int, payload types.CreateReturnPayload) (*types.Return, error) {
+	var ret types.Return
+	err := s.uow.Do(ctx, func(tx types.DB) error {
+		orderStore, store := s.orderStore.WithTx(tx), s.store.WithTx(tx)
+
+		order, err := orderStore.LockOrderById(ctx, orderId)
+		if err != nil {
+			return err
+		}
+
+		// other users' orders are reported the same as missing ones
+		if order.UserId != userId {
+			return orderservice.ErrNotFound
+		}
+
+		if order.Status != types.Delivered && order.Status != types.PartiallyRefunded {
+			return &returnError{fmt.Errorf("cannot return items of an order that is %s", order.Status)}
+		}
+
+		history, err := orderStore.GetOrderStatusHistory(ctx, orderId)
+		if err != nil {
+			return err
+		}
+
+		var deliveredAt time.Time
+		for _, change := range history {
+			if change.ToStatus == types.Delivered {
+				deliveredAt = change.CreatedAt
+			}
+		}
+
+		if deliveredAt.IsZero() {
+			return &returnError{fmt.Errorf("cannot return items of an order that was not delivered")}
+		}
+
+		if closes := deliveredAt.Add(s.window); time.Now().After(closes) {
+			return &returnError{fmt.Errorf("the return window closed on %s", closes.Format(time.DateOnly))}
+		}
+
+		items, err := orderStore.GetOrderItems(ctx, orderId)
+		if err != nil {
+			return err
+		}
+
+		others, err := store.GetReturnsByOrderId(ctx, orderId)
+		if err != nil {
+			return err
+		}
+
+		returnItems, err := returnableItems(items, others, payload.Items)
+		if err != nil {
+			return err
+		}
+
+		ret = types.Return{
+			OrderID: orderId,
+			UserID:  userId,
+			Status:  types.ReturnRequested,
+			Reason:  payload.Reason,
+			Note:    payload.Note,
+			Items:   returnItems,
+		}
+
+		ret.ID, err = store.CreateReturn(ctx, ret)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &ret, nil
+}
+
+// Approve accepts a requested return and gives it a label to ship with.
+func (s *Returns) Approve(ctx context.Context, id int, note string) (*types.Return, error) {
+	return s.decide(ctx, id, types.ReturnApproved, note)
+}
+
+func (s *Returns) Reject(ctx context.Context, id int, note string) (*types.Return, error) {
+	return s.decide(ctx, id, types.ReturnRejected, note)
+}
+
+func (s *Returns) decide(ctx context.Context, id int, to types.ReturnStatus, note string) (*types.Return, error) {
+	var ret *types.Return
+	err := s.uow.Do(ctx, func(tx types.DB) error {
+		store := s.store.WithTx(tx)
+
+		var err error
+		ret, err = store.LockReturnById(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		if ret.Status != types.ReturnRequested {
+			return &returnError{fmt.Errorf("cannot decide on a return that is %s", ret.Status)}
+		}
+
+		now := time.Now()
+		ret.Status, ret.StaffNote, ret.DecidedAt = to, note, &now
+		if to == types.ReturnApproved {
+			if ret.LabelReference, err = labelReference(ret.ID); err != nil {
+				return err
+			}
+		}
+
+		return store.UpdateReturn(ctx, *ret, types.ReturnRequested)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+// Receive records the inspection of an approved return's items. An accepted
+// return is refunded in full and its items marked for restocking are put
+// back in stock; a rejected one is closed without either.
+func (s *Returns) Receive(ctx context.Context, id int, payload types.ReceiveReturnPayload, staffId *int) (*types.Return, error) {
+	// the customer may be refunded below, so the return is closed even if
+	// the client goes away
+	ctx = context.WithoutCancel(ctx)
+
+	var ret *types.Return
+	quantities := map[int]int{}
+	err := s.uow.Do(ctx, func(tx types.DB) error {
+		store := s.store.WithTx(tx)
+
+		var err error
+		ret, err = store.LockReturnById(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		if ret.Status != types.ReturnApproved {
+			return &returnError{fmt.Errorf("cannot receive a return that is %s", ret.Status)}
+		}
+
+		if err := inspect(ret, payload.Items); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		ret.StaffNote, ret.ReceivedAt = payload.Note, &now
+
+		if !payload.Accept {
+			ret.Status = types.ReturnRejected
+			return store.UpdateReturn(ctx, *ret, types.ReturnApproved)
+		}
+
+		items, err := s.orderStore.GetOrderItems(ctx, ret.OrderID)
+		if err != nil {
+			return err
+		}
+
+		productIds := map[int]int{}
+		for _, item := range items {
+			productIds[item.ID] = item.ProductID
+		}
+
+		refundPayload := types.RefundOrderPayload{Reason: ret.Reason, Note: fmt.Sprintf("return %d", ret.ID)}
+		quantities = map[int]int{}
+		for _, item := range ret.Items {
+			refundPayload.Items = append(refundPayload.Items, types.RefundItemPayload{OrderItemID: item.OrderItemID, Quantity: item.Quantity})
+			if item.Disposition == types.ReturnRestock {
+				quantities[productIds[item.OrderItemID]] += item.Quantity
+			}
+		}
+
+		// the refund is made with the return still locked, so a second
+		// receipt of it waits and then finds it refunded
+		refund, err := s.refunder.Refund(ctx, ret.OrderID, refundPayload, staffId)
+		if err != nil {
+			return err
+		}
+
+		ret.Status, ret.RefundID = types.ReturnRefunded, &refund.ID
+		return store.UpdateReturn(ctx, *ret, types.ReturnApproved)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if ret.Status != types.ReturnRefunded {
+		return ret, nil
+	}
+
+	err = s.uow.Do(ctx, func(tx types.DB) error {
+		return orderservice.Restock(ctx, s.productStore.WithTx(tx), quantities)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+// returnableItems checks the units asked for were bought and are not
+// already part of another return that has not been rejected.
+func returnableItems(items []types.OrderItem, others []types.Return, asked []types.ReturnItemPayload) ([]types.ReturnItem, error) {
+	bought := map[int]int{}
+	for _, item := range items {
+		bought[item.ID] = item.Quantity
+	}
+
+	returned := map[int]int{}
+	for _, other := range others {
+		if other.Status == types.ReturnRejected {
+			continue
+		}
+		for _, item := range other.Items {
+			returned[item.OrderItemID] += item.Quantity
+		}
+	}
+
+	returnItems := []types.ReturnItem{}
+	for _, a := range asked {
+		quantity, ok := bought[a.OrderItemID]
+		if !ok {
+			return nil, &returnError{fmt.Errorf("order item %d is not part of the order", a.OrderItemID)}
+		}
+
+		if returned[a.OrderItemID]+a.Quantity > quantity {
+			return nil, &returnError{fmt.Errorf("only %d of order item %d can be returned", quantity-returned[a.OrderItemID], a.OrderItemID)}
+		}
+		returned[a.OrderItemID] += a.Quantity
+
+		returnItems = append(returnItems, types.ReturnItem{OrderItemID: a.OrderItemID, Quantity: a.Quantity})
+	}
+
+	return returnItems, nil
+}
+
+// inspect sets the disposition of every item of the return, which each has
+// to be given exactly once.
+func inspect(ret *types.Return, inspected []types.InspectedItemPayload) error {
+	dispositions := map[int]types.ReturnDisposition{}
+	for _, item := range inspected {
+		if _, ok := dispositions[item.ReturnItemID]; ok {
+			return &returnError{fmt.Errorf("return item %d is inspected more than once", item.ReturnItemID)}
+		}
+		dispositions[item.ReturnItemID] = item.Disposition
+	}
+
+	if len(dispositions) != len(ret.Items) {
+		return &returnError{fmt.Errorf("every item of the return needs a disposition")}
+	}
+
+	for i, item := range ret.Items {
+		disposition, ok := dispositions[item.ID]
+		if !ok {
+			return &returnError{fmt.Errorf("return item %d needs a disposition", item.ID)}
+		}
+		ret.Items[i].Disposition = disposition
+	}
+
+	return nil
+}
+
+// labelReference names the return label. The random part keeps references
+// from being guessed from the return id.
+func labelReference(returnId int) (string, error) {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("RMA-%06d-%s", returnId, strings.ToUpper(hex.EncodeToString(suffix))), nil
+}
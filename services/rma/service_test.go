@@ -0,0 +1,239 @@
+package rma
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	orderservice "github.com/xelathan/golang_backend/services/order"
+	"github.com/xelathan/golang_backend/types"
+)
+
+func TestReturns(t *testing.T) {
+	setup := func(deliveredAgo time.Duration) (*Returns, *mockReturnStore, *mockRefunder, *mockProductStore) {
+		orders := &mockOrderStore{
+			order:   types.Order{ID: 1, UserId: 7, Status: types.Delivered},
+			items:   []types.OrderItem{{ID: 10, OrderID: 1, ProductID: 3, Quantity: 2}, {ID: 11, OrderID: 1, ProductID: 4, Quantity: 1}},
+			history: []types.OrderStatusChange{{OrderID: 1, FromStatus: types.Shipped, ToStatus: types.Delivered, CreatedAt: time.Now().Add(-deliveredAgo)}},
+		}
+		store := &mockReturnStore{returns: map[int]*types.Return{}}
+		refunder := &mockRefunder{}
+		products := &mockProductStore{quantities: map[int]int{}}
+
+		return NewReturns(mockUnitOfWork{}, store, orders, products, refunder, 30*24*time.Hour), store, refunder, products
+	}
+
+	request := types.CreateReturnPayload{
+		Items:  []types.ReturnItemPayload{{OrderItemID: 10, Quantity: 2}, {OrderItemID: 11, Quantity: 1}},
+		Reason: types.RefundDamaged,
+	}
+
+	t.Run("should open a return within the window", func(t *testing.T) {
+		returns, _, _, _ := setup(24 * time.Hour)
+
+		ret, err := returns.Request(context.Background(), 7, 1, request)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ret.Status != types.ReturnRequested || len(ret.Items) != 2 {
+			t.Errorf("expected a requested return of 2 items, got %s with %d", ret.Status, len(ret.Items))
+		}
+	})
+
+	t.Run("should refuse returns once the window closed", func(t *testing.T) {
+		returns, _, _, _ := setup(31 * 24 * time.Hour)
+
+		var refused *returnError
+		if _, err := returns.Request(context.Background(), 7, 1, request); !errors.As(err, &refused) {
+			t.Errorf("expected the return to be refused, got %v", err)
+		}
+	})
+
+	t.Run("should not return the same units twice", func(t *testing.T) {
+		returns, _, _, _ := setup(24 * time.Hour)
+
+		if _, err := returns.Request(context.Background(), 7, 1, request); err != nil {
+			t.Fatal(err)
+		}
+
+		var refused *returnError
+		if _, err := returns.Request(context.Background(), 7, 1, request); !errors.As(err, &refused) {
+			t.Errorf("expected the second return to be refused, got %v", err)
+		}
+	})
+
+	t.Run("should not show another user's order", func(t *testing.T) {
+		returns, _, _, _ := setup(24 * time.Hour)
+
+		if _, err := returns.Request(context.Background(), 8, 1, request); !errors.Is(err, orderservice.ErrNotFound) {
+			t.Errorf("expected the order not to be found, got %v", err)
+		}
+	})
+
+	t.Run("should refund accepted returns and restock what can be sold", func(t *testing.T) {
+		returns, store, refunder, products := setup(24 * time.Hour)
+
+		ret, err := returns.Request(context.Background(), 7, 1, request)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := returns.Receive(context.Background(), ret.ID, types.ReceiveReturnPayload{}, nil); err == nil {
+			t.Fatal("expected a return that was not approved not to be received")
+		}
+
+		approved, err := returns.Approve(context.Background(), ret.ID, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if approved.LabelReference == "" {
+			t.Error("expected an approved return to get a label")
+		}
+
+		stored := store.returns[ret.ID]
+		ret, err = returns.Receive(context.Background(), ret.ID, types.ReceiveReturnPayload{
+			Items: []types.InspectedItemPayload{
+				{ReturnItemID: stored.Items[0].ID, Disposition: types.ReturnRestock},
+				{ReturnItemID: stored.Items[1].ID, Disposition: types.ReturnWriteOff},
+			},
+			Accept: true,
+		}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ret.Status != types.ReturnRefunded || ret.RefundID == nil || len(refunder.payload.Items) != 2 {
+			t.Errorf("expected the return refunded for both items, got %s and %+v", ret.Status, refunder.payload)
+		}
+
+		if products.quantities[3] != 2 || products.quantities[4] != 0 {
+			t.Errorf("expected only the restocked item back in stock, got %v", products.quantities)
+		}
+	})
+}
+
+type mockUnitOfWork struct{}
+
+func (mockUnitOfWork) Do(ctx context.Context, fn func(tx types.DB) error) error {
+	return fn(nil)
+}
+
+type mockOrderStore struct {
+	types.OrderStore
+
+	order   types.Order
+	items   []types.OrderItem
+	history []types.OrderStatusChange
+}
+
+func (m *mockOrderStore) GetOrderById(ctx context.Context, orderId int) (*types.Order, error) {
+	order := m.order
+	return &order, nil
+}
+
+func (m *mockOrderStore) LockOrderById(ctx context.Context, orderId int) (*types.Order, error) {
+	return m.GetOrderById(ctx, orderId)
+}
+
+func (m *mockOrderStore) GetOrderItems(ctx context.Context, orderId int) ([]types.OrderItem, error) {
+	return m.items, nil
+}
+
+func (m *mockOrderStore) GetOrderStatusHistory(ctx context.Context, orderId int) ([]types.OrderStatusChange, error) {
+	return m.history, nil
+}
+
+func (m *mockOrderStore) WithTx(tx types.DB) types.OrderStore {
+	return m
+}
+
+type mockReturnStore struct {
+	types.ReturnStore
+
+	returns map[int]*types.Return
+	itemIds int
+}
+
+func (m *mockReturnStore) CreateReturn(ctx context.Context, ret types.Return) (int, error) {
+	ret.ID = len(m.returns) + 1
+	ret.Items = append([]types.ReturnItem{}, ret.Items...)
+	for i := range ret.Items {
+		m.itemIds++
+		ret.Items[i].ID, ret.Items[i].ReturnID = m.itemIds, ret.ID
+	}
+	m.returns[ret.ID] = &ret
+
+	return ret.ID, nil
+}
+
+func (m *mockReturnStore) GetReturnById(ctx context.Context, id int) (*types.Return, error) {
+	ret := *m.returns[id]
+	ret.Items = append([]types.ReturnItem{}, ret.Items...)
+
+	return &ret, nil
+}
+
+func (m *mockReturnStore) LockReturnById(ctx context.Context, id int) (*types.Return, error) {
+	return m.GetReturnById(ctx, id)
+}
+
+func (m *mockReturnStore) GetReturnsByOrderId(ctx context.Context, orderId int) ([]types.Return, error) {
+	returns := []types.Return{}
+	for _, ret := range m.returns {
+		returns = append(returns, *ret)
+	}
+
+	return returns, nil
+}
+
+func (m *mockReturnStore) UpdateReturn(ctx context.Context, ret types.Return, from types.ReturnStatus) error {
+	if m.returns[ret.ID].Status != from {
+		return errors.New("return is no longer " + string(from))
+	}
+	m.returns[ret.ID] = &ret
+
+	return nil
+}
+
+func (m *mockReturnStore) WithTx(tx types.DB) types.ReturnStore {
+	return m
+}
+
+type mockRefunder struct {
+	payload types.RefundOrderPayload
+}
+
+func (m *mockRefunder) Refund(ctx context.Context, orderId int, payload types.RefundOrderPayload, createdBy *int) (*types.Refund, error) {
+	m.payload = payload
+	return &types.Refund{ID: 1, OrderID: orderId}, nil
+}
+
+type mockProductStore struct {
+	types.ProductStore
+
+	quantities map[int]int
+}
+
+func (m *mockProductStore) LockProductsByID(ctx context.Context, ids []int) ([]types.Product, error) {
+	products := []types.Product{}
+	for _, id := range ids {
+		products = append(products, types.Product{ID: id, Quantity: m.quantities[id]})
+	}
+
+	return products, nil
+}
+
+func (m *mockProductStore) UpdateProductBatch(ctx context.Context, products map[int]types.Product) error {
+	for id, product := range products {
+		m.quantities[id] = product.Quantity
+	}
+
+	return nil
+}
+
+func (m *mockProductStore) WithTx(tx types.DB) types.ProductStore {
+	return m
+}
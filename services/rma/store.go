@@ -0,0 +1,208 @@
+package rma
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/xelathan/golang_backend/db"
+	"github.com/xelathan/golang_backend/types"
+)
+
+const selectReturns = "SELECT id, orderId, userId, status, reason, note, staffNote, labelReference, refundId, createdAt, decidedAt, receivedAt FROM returns"
+
+var ErrNotFound = errors.New("return not found")
+
+type Store struct {
+	db types.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+func (s *Store) WithTx(tx types.DB) types.ReturnStore {
+	return &Store{db: tx}
+}
+
+func (s *Store) CreateReturn(ctx context.Context, ret types.Return) (int, error) {
+	var id int64
+	err := db.InTxContext(ctx, s.db, func(tx types.DB) error {
+		res, err := tx.ExecContext(ctx,
+			"INSERT INTO returns (orderId, userId, status, reason, note) VALUES (?,?,?,?,?)",
+			ret.OrderID, ret.UserID, ret.Status, ret.Reason, ret.Note,
+		)
+		if err != nil {
+			return err
+		}
+
+		id, err = res.LastInsertId()
+		if err != nil {
+			return err
+		}
+
+		for _, item := range ret.Items {
+			_, err := tx.ExecContext(ctx, "INSERT INTO return_items (returnId, orderItemId, quantity) VALUES (?,?,?)", id, item.OrderItemID, item.Quantity)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+func (s *Store) GetReturnById(ctx context.Context, id int) (*types.Return, error) {
+	return s.getReturnById(ctx, id, "")
+}
+
+func (s *Store) LockReturnById(ctx context.Context, id int) (*types.Return, error) {
+	return s.getReturnById(ctx, id, " FOR UPDATE")
+}
+
+func (s *Store) getReturnById(ctx context.Context, id int, suffix string) (*types.Return, error) {
+	returns, err := s.getReturns(ctx, " WHERE id = ?"+suffix, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(returns) == 0 {
+		return nil, ErrNotFound
+	}
+
+	return &returns[0], nil
+}
+
+func (s *Store) GetReturnsByOrderId(ctx context.Context, orderId int) ([]types.Return, error) {
+	return s.getReturns(ctx, " WHERE orderId = ? ORDER BY id", orderId)
+}
+
+func (s *Store) GetReturnsByStatus(ctx context.Context, status types.ReturnStatus) ([]types.Return, error) {
+	return s.getReturns(ctx, " WHERE status = ? ORDER BY id", status)
+}
+
+func (s *Store) UpdateReturn(ctx context.Context, ret types.Return, from types.ReturnStatus) error {
+	return db.InTxContext(ctx, s.db, func(tx types.DB) error {
+		res, err := tx.ExecContext(ctx,
+			"UPDATE returns SET status = ?, staffNote = ?, labelReference = ?, refundId = ?, decidedAt = ?, receivedAt = ? WHERE id = ? AND status = ?",
+			ret.Status, ret.StaffNote, sql.NullString{String: ret.LabelReference, Valid: ret.LabelReference != ""}, ret.RefundID,
+			ret.DecidedAt, ret.ReceivedAt, ret.ID, from,
+		)
+		if err != nil {
+			return err
+		}
+
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if affected == 0 {
+			return fmt.Errorf("return is no longer %s", from)
+		}
+
+		for _, item := range ret.Items {
+			_, err := tx.ExecContext(ctx,
+				"UPDATE return_items SET disposition = ? WHERE id = ?",
+				sql.NullString{String: string(item.Disposition), Valid: item.Disposition != ""}, item.ID,
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// getReturns reads the returns matching the suffix with their items.
+func (s *Store) getReturns(ctx context.Context, suffix string, args ...any) ([]types.Return, error) {
+	rows, err := s.db.QueryContext(ctx, selectReturns+suffix, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	returns := []types.Return{}
+	byId := map[int]int{}
+	for rows.Next() {
+		ret := types.Return{Items: []types.ReturnItem{}}
+		var labelReference sql.NullString
+		var refundId sql.NullInt64
+		var decidedAt, receivedAt sql.NullTime
+		err := rows.Scan(
+			&ret.ID,
+			&ret.OrderID,
+			&ret.UserID,
+			&ret.Status,
+			&ret.Reason,
+			&ret.Note,
+			&ret.StaffNote,
+			&labelReference,
+			&refundId,
+			&ret.CreatedAt,
+			&decidedAt,
+			&receivedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		ret.LabelReference = labelReference.String
+		if refundId.Valid {
+			id := int(refundId.Int64)
+			ret.RefundID = &id
+		}
+		if decidedAt.Valid {
+			ret.DecidedAt = &decidedAt.Time
+		}
+		if receivedAt.Valid {
+			ret.ReceivedAt = &receivedAt.Time
+		}
+
+		byId[ret.ID] = len(returns)
+		returns = append(returns, ret)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(returns) == 0 {
+		return returns, nil
+	}
+
+	ids := make([]any, 0, len(returns))
+	for _, ret := range returns {
+		ids = append(ids, ret.ID)
+	}
+
+	itemRows, err := s.db.QueryContext(ctx,
+		"SELECT id, returnId, orderItemId, quantity, disposition FROM return_items WHERE returnId IN (?"+strings.Repeat(",?", len(ids)-1)+") ORDER BY id",
+		ids...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer itemRows.Close()
+
+	for itemRows.Next() {
+		item := types.ReturnItem{}
+		var disposition sql.NullString
+		if err := itemRows.Scan(&item.ID, &item.ReturnID, &item.OrderItemID, &item.Quantity, &disposition); err != nil {
+			return nil, err
+		}
+
+		item.Disposition = types.ReturnDisposition(disposition.String)
+		ret := &returns[byId[item.ReturnID]]
+		ret.Items = append(ret.Items, item)
+	}
+
+	return returns, itemRows.Err()
+}
@@ -0,0 +1,48 @@
+package shipment
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/xelathan/golang_backend/types"
+)
+
+const SimulatedCarrierName = "simulated"
+
+// SimulatedCarrier is an in-memory carrier for running fulfilment offline.
+// A parcel is in transit from the first time it is tracked and delivered
+// once the transit time has passed since.
+type SimulatedCarrier struct {
+	transit time.Duration
+	now     func() time.Time
+
+	mu      sync.Mutex
+	parcels map[string]time.Time
+}
+
+func NewSimulatedCarrier(transit time.Duration) *SimulatedCarrier {
+	return &SimulatedCarrier{transit: transit, now: time.Now, parcels: map[string]time.Time{}}
+}
+
+func (c *SimulatedCarrier) Name() string {
+	return SimulatedCarrierName
+}
+
+func (c *SimulatedCarrier) Track(ctx context.Context, trackingNumber string) (types.TrackingUpdate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	pickedUp, ok := c.parcels[trackingNumber]
+	if !ok {
+		pickedUp = now
+		c.parcels[trackingNumber] = pickedUp
+	}
+
+	if arrives := pickedUp.Add(c.transit); !now.Before(arrives) {
+		return types.TrackingUpdate{Status: types.ShipmentDelivered, At: arrives}, nil
+	}
+
+	return types.TrackingUpdate{Status: types.ShipmentInTransit, At: pickedUp}, nil
+}
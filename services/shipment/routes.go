@@ -0,0 +1,125 @@
+package shipment
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"github.com/xelathan/golang_backend/services/auth"
+	"github.com/xelathan/golang_backend/services/idempotency"
+	orderservice "github.com/xelathan/golang_backend/services/order"
+	"github.com/xelathan/golang_backend/types"
+	"github.com/xelathan/golang_backend/utils"
+)
+
+type Handler struct {
+	fulfilment *Fulfilment
+	store      types.ShipmentStore
+	userStore  types.UserStore
+	keys       *idempotency.Keys
+}
+
+func NewHandler(fulfilment *Fulfilment, store types.ShipmentStore, userStore types.UserStore, keys *idempotency.Keys) *Handler {
+	return &Handler{fulfilment: fulfilment, store: store, userStore: userStore, keys: keys}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/admin/orders/{id}/shipments", auth.WithAdminAuth(h.keys.Wrap(h.handleShip), h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/admin/orders/{id}/shipments", auth.WithAdminAuth(h.handleGetShipments, h.userStore)).Methods(http.MethodGet)
+	router.HandleFunc("/admin/shipments/{id}/deliver", auth.WithAdminAuth(h.handleDeliver, h.userStore)).Methods(http.MethodPost)
+}
+
+func (h *Handler) handleShip(w http.ResponseWriter, r *http.Request) {
+	orderId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid order id"))
+		return
+	}
+
+	payload := types.CreateShipmentPayload{}
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	staffId := auth.GetUserIdFromContext(r.Context())
+	shipment, err := h.fulfilment.Ship(r.Context(), orderId, payload, &staffId)
+	if err != nil {
+		utils.WriteError(w, shipmentStatus(err), err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, shipment)
+}
+
+func (h *Handler) handleGetShipments(w http.ResponseWriter, r *http.Request) {
+	orderId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid order id"))
+		return
+	}
+
+	shipments, err := h.store.GetShipmentsByOrderId(r.Context(), orderId)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, shipments)
+}
+
+func (h *Handler) handleDeliver(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid shipment id"))
+		return
+	}
+
+	payload := types.DeliverShipmentPayload{}
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	at := time.Now()
+	if payload.DeliveredAt != nil {
+		if payload.DeliveredAt.After(at) {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("deliveredAt cannot be in the future"))
+			return
+		}
+		at = *payload.DeliveredAt
+	}
+
+	staffId := auth.GetUserIdFromContext(r.Context())
+	shipment, err := h.fulfilment.Deliver(r.Context(), id, at, &staffId)
+	if err != nil {
+		utils.WriteError(w, shipmentStatus(err), err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, shipment)
+}
+
+// shipmentStatus is the status code for an error from fulfilment: shipments
+// it refuses are bad requests.
+func shipmentStatus(err error) int {
+	var refused *shipmentError
+	switch {
+	case errors.As(err, &refused):
+		return http.StatusBadRequest
+	case errors.Is(err, orderservice.ErrNotFound), errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	}
+
+	return http.StatusInternalServerError
+}
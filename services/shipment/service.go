@@ -0,0 +1,309 @@
+package shipment
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	orderservice "github.com/xelathan/golang_backend/services/order"
+	"github.com/xelathan/golang_backend/types"
+)
+
+// shipmentError is a shipment that cannot be made or delivered as asked, as
+// opposed to a failure to record it.
+type shipmentError struct {
+	err error
+}
+
+func (e *shipmentError) Error() string {
+	return e.err.Error()
+}
+
+// Fulfilment ships orders, in one shipment or split over several, and
+// follows the shipments to the customer. The order moves to shipped once all
+// of its items have gone out and to delivered once every shipment has
+// arrived. Shipments with a carrier that can be tracked are marked delivered
+// when the carrier reports them delivered; the others are marked by staff.
+//
+// A shipment is checked against the others on its order with the order
+// locked, so shipments made or delivered at the same time see each other.
+type Fulfilment struct {
+	uow         types.UnitOfWork
+	store       types.ShipmentStore
+	orderStore  types.OrderStore
+	transitions types.OrderTransitioner
+	carriers    map[string]types.Carrier
+}
+
+func NewFulfilment(uow types.UnitOfWork, store types.ShipmentStore, orderStore types.OrderStore, transitions types.OrderTransitioner, carriers ...types.Carrier) *Fulfilment {
+	f := &Fulfilment{uow: uow, store: store, orderStore: orderStore, transitions: transitions, carriers: map[string]types.Carrier{}}
+	for _, carrier := range carriers {
+		f.carriers[carrier.Name()] = carrier
+	}
+
+	return f
+}
+
+// Start tracks the shipments in transit every interval.
+func (f *Fulfilment) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := f.Track(context.Background()); err != nil {
+				log.Printf("shipment: tracking failed: %v", err)
+			}
+		}
+	}()
+}
+
+// Ship sends out the items of a paid order. CreatedBy is the staff member
+// shipping it.
+func (f *Fulfilment) Ship(ctx context.Context, orderId int, payload types.CreateShipmentPayload, createdBy *int) (*types.Shipment, error) {
+	var order *types.Order
+	var shipment types.Shipment
+	var complete bool
+	err := f.uow.Do(ctx, func(tx types.DB) error {
+		orderStore := f.orderStore.WithTx(tx)
+
+		var err error
+		order, err = orderStore.LockOrderById(ctx, orderId)
+		if err != nil {
+			return err
+		}
+
+		history, err := orderStore.GetOrderStatusHistory(ctx, orderId)
+		if err != nil {
+			return err
+		}
+
+		if status := orderservice.FulfilmentStatus(order, history); status != types.Paid && status != types.Processing {
+			return &shipmentError{fmt.Errorf("cannot ship an order that is %s", status)}
+		}
+
+		items, err := orderStore.GetOrderItems(ctx, orderId)
+		if err != nil {
+			return err
+		}
+
+		others, err := f.store.WithTx(tx).GetShipmentsByOrderId(ctx, orderId)
+		if err != nil {
+			return err
+		}
+
+		var shipmentItems []types.ShipmentItem
+		shipmentItems, complete, err = shipItems(items, others, payload.Items)
+		if err != nil {
+			return err
+		}
+
+		shipment = types.Shipment{
+			OrderID:        orderId,
+			Carrier:        payload.Carrier,
+			TrackingNumber: payload.TrackingNumber,
+			Status:         types.ShipmentInTransit,
+			CreatedBy:      createdBy,
+			ShippedAt:      time.Now(),
+			Items:          shipmentItems,
+		}
+
+		shipment.ID, err = f.store.WithTx(tx).CreateShipment(ctx, shipment)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the parcel has gone out, so the order follows even if the client goes
+	// away
+	ctx = context.WithoutCancel(ctx)
+
+	reason := fmt.Sprintf("shipment %d", shipment.ID)
+	if order.Status == types.Paid {
+		if _, err := f.transitions.Transition(ctx, orderId, types.Processing, createdBy, reason); err != nil {
+			return nil, err
+		}
+	}
+
+	if complete {
+		if _, err := f.transitions.Transition(ctx, orderId, types.Shipped, createdBy, reason); err != nil {
+			return nil, err
+		}
+	}
+
+	return &shipment, nil
+}
+
+// Deliver marks a shipment delivered at the given time. ChangedBy is the
+// staff member marking it, nil when the carrier reported it.
+func (f *Fulfilment) Deliver(ctx context.Context, id int, at time.Time, changedBy *int) (*types.Shipment, error) {
+	shipment, err := f.store.GetShipmentById(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// the last of the order's shipments to be delivered delivers the order
+	var delivered bool
+	err = f.uow.Do(ctx, func(tx types.DB) error {
+		orderStore, store := f.orderStore.WithTx(tx), f.store.WithTx(tx)
+
+		order, err := orderStore.LockOrderById(ctx, shipment.OrderID)
+		if err != nil {
+			return err
+		}
+
+		shipment, err = store.GetShipmentById(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		if shipment.Status != types.ShipmentInTransit {
+			return &shipmentError{fmt.Errorf("cannot deliver a shipment that is %s", shipment.Status)}
+		}
+
+		if at.Before(shipment.ShippedAt) {
+			return &shipmentError{fmt.Errorf("cannot deliver a shipment before it was shipped")}
+		}
+
+		if err := store.MarkShipmentDelivered(ctx, id, at); err != nil {
+			return err
+		}
+		shipment.Status, shipment.DeliveredAt = types.ShipmentDelivered, &at
+
+		history, err := orderStore.GetOrderStatusHistory(ctx, shipment.OrderID)
+		if err != nil {
+			return err
+		}
+
+		// the order only counts as shipped once all of its items went out.
+		// A refund since leaves it shipped underneath, but one refunded in
+		// full is final and only its shipments record the delivery.
+		if orderservice.FulfilmentStatus(order, history) != types.Shipped || !orderservice.CanTransition(order.Status, types.Delivered) {
+			delivered = false
+			return nil
+		}
+
+		shipments, err := store.GetShipmentsByOrderId(ctx, shipment.OrderID)
+		if err != nil {
+			return err
+		}
+
+		delivered = true
+		for _, other := range shipments {
+			if other.Status != types.ShipmentDelivered {
+				delivered = false
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !delivered {
+		return shipment, nil
+	}
+
+	ctx = context.WithoutCancel(ctx)
+
+	if _, err := f.transitions.Transition(ctx, shipment.OrderID, types.Delivered, changedBy, fmt.Sprintf("shipment %d delivered", id)); err != nil {
+		return nil, err
+	}
+
+	return shipment, nil
+}
+
+// Track asks the carriers about the shipments in transit and marks the ones
+// they delivered. A carrier failing to answer leaves its shipments for the
+// next run.
+func (f *Fulfilment) Track(ctx context.Context) error {
+	shipments, err := f.store.GetShipmentsByStatus(ctx, types.ShipmentInTransit)
+	if err != nil {
+		return err
+	}
+
+	for _, shipment := range shipments {
+		carrier, ok := f.carriers[shipment.Carrier]
+		if !ok {
+			continue
+		}
+
+		update, err := carrier.Track(ctx, shipment.TrackingNumber)
+		if err != nil {
+			log.Printf("shipment: tracking %s %s failed: %v", shipment.Carrier, shipment.TrackingNumber, err)
+			continue
+		}
+
+		if update.Status != types.ShipmentDelivered {
+			continue
+		}
+
+		if _, err := f.Deliver(ctx, shipment.ID, update.At, nil); err != nil {
+			log.Printf("shipment: delivering shipment %d failed: %v", shipment.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// shipItems checks the units asked for were bought, are in stock and have
+// not gone out in another shipment, and reports whether the order is fully
+// shipped with them. Nothing asked for ships everything left that is in
+// stock.
+func shipItems(items []types.OrderItem, others []types.Shipment, asked []types.ShipmentItemPayload) ([]types.ShipmentItem, bool, error) {
+	bought := map[int]int{}
+	backordered := map[int]bool{}
+	for _, item := range items {
+		bought[item.ID] = item.Quantity
+		backordered[item.ID] = item.FulfilmentStatus == types.FulfilmentBackordered
+	}
+
+	shipped := map[int]int{}
+	for _, other := range others {
+		for _, item := range other.Items {
+			shipped[item.OrderItemID] += item.Quantity
+		}
+	}
+
+	if len(asked) == 0 {
+		for _, item := range items {
+			if left := item.Quantity - shipped[item.ID]; left > 0 && !backordered[item.ID] {
+				asked = append(asked, types.ShipmentItemPayload{OrderItemID: item.ID, Quantity: left})
+			}
+		}
+	}
+
+	shipmentItems := []types.ShipmentItem{}
+	for _, a := range asked {
+		quantity, ok := bought[a.OrderItemID]
+		if !ok {
+			return nil, false, &shipmentError{fmt.Errorf("order item %d is not part of the order", a.OrderItemID)}
+		}
+
+		if backordered[a.OrderItemID] {
+			return nil, false, &shipmentError{fmt.Errorf("order item %d is on backorder", a.OrderItemID)}
+		}
+
+		if shipped[a.OrderItemID]+a.Quantity > quantity {
+			return nil, false, &shipmentError{fmt.Errorf("only %d of order item %d are left to ship", quantity-shipped[a.OrderItemID], a.OrderItemID)}
+		}
+		shipped[a.OrderItemID] += a.Quantity
+
+		shipmentItems = append(shipmentItems, types.ShipmentItem{OrderItemID: a.OrderItemID, Quantity: a.Quantity})
+	}
+
+	if len(shipmentItems) == 0 {
+		return nil, false, &shipmentError{fmt.Errorf("nothing is left to ship")}
+	}
+
+	for _, item := range items {
+		if shipped[item.ID] < item.Quantity {
+			return shipmentItems, false, nil
+		}
+	}
+
+	return shipmentItems, true, nil
+}
@@ -0,0 +1,273 @@
+package shipment
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/xelathan/golang_backend/types"
+)
+
+func TestFulfilment(t *testing.T) {
+	setup := func() (*Fulfilment, *mockShipmentStore, *mockTransitions) {
+		orders := &mockOrderStore{
+			order: types.Order{ID: 1, Status: types.Paid},
+			items: []types.OrderItem{
+				{ID: 10, OrderID: 1, Quantity: 2},
+				{ID: 11, OrderID: 1, Quantity: 1},
+			},
+		}
+		store := &mockShipmentStore{}
+		transitions := &mockTransitions{orders: orders}
+
+		return NewFulfilment(mockUnitOfWork{}, store, orders, transitions, NewSimulatedCarrier(0)), store, transitions
+	}
+
+	t.Run("should ship an order in parts and mark it shipped with the last", func(t *testing.T) {
+		fulfilment, _, transitions := setup()
+
+		_, err := fulfilment.Ship(context.Background(), 1, types.CreateShipmentPayload{
+			Carrier:        "ups",
+			TrackingNumber: "1Z1",
+			Items:          []types.ShipmentItemPayload{{OrderItemID: 10, Quantity: 2}},
+		}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if transitions.orders.order.Status != types.Processing {
+			t.Fatalf("expected a partly shipped order to be processing, got %s", transitions.orders.order.Status)
+		}
+
+		shipment, err := fulfilment.Ship(context.Background(), 1, types.CreateShipmentPayload{Carrier: "ups", TrackingNumber: "1Z2"}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(shipment.Items) != 1 || shipment.Items[0].OrderItemID != 11 || transitions.orders.order.Status != types.Shipped {
+			t.Errorf("expected the rest shipped and the order shipped, got %+v and %s", shipment.Items, transitions.orders.order.Status)
+		}
+
+		_, err = fulfilment.Ship(context.Background(), 1, types.CreateShipmentPayload{Carrier: "ups", TrackingNumber: "1Z3"}, nil)
+
+		var refused *shipmentError
+		if !errors.As(err, &refused) {
+			t.Errorf("expected a shipped order not to ship again, got %v", err)
+		}
+	})
+
+	t.Run("should deliver the order once every shipment arrived", func(t *testing.T) {
+		fulfilment, store, transitions := setup()
+
+		first, err := fulfilment.Ship(context.Background(), 1, types.CreateShipmentPayload{
+			Carrier:        "ups",
+			TrackingNumber: "1Z1",
+			Items:          []types.ShipmentItemPayload{{OrderItemID: 10, Quantity: 2}},
+		}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := fulfilment.Ship(context.Background(), 1, types.CreateShipmentPayload{Carrier: SimulatedCarrierName, TrackingNumber: "SIM1"}, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		// only the simulated carrier can be tracked
+		if err := fulfilment.Track(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		if store.shipments[1].Status != types.ShipmentDelivered || transitions.orders.order.Status != types.Shipped {
+			t.Fatalf("expected the tracked shipment delivered and the order still shipped, got %s and %s", store.shipments[1].Status, transitions.orders.order.Status)
+		}
+
+		if _, err := fulfilment.Deliver(context.Background(), first.ID, time.Now(), nil); err != nil {
+			t.Fatal(err)
+		}
+
+		if transitions.orders.order.Status != types.Delivered {
+			t.Errorf("expected the order delivered, got %s", transitions.orders.order.Status)
+		}
+	})
+
+	t.Run("should deliver a shipped order refunded in part or record the delivery of one refunded in full", func(t *testing.T) {
+		for _, refunded := range []types.OrderStatus{types.PartiallyRefunded, types.Refunded} {
+			fulfilment, store, transitions := setup()
+
+			shipment, err := fulfilment.Ship(context.Background(), 1, types.CreateShipmentPayload{Carrier: "ups", TrackingNumber: "1Z1"}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := transitions.Transition(context.Background(), 1, refunded, nil, "refund"); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := fulfilment.Deliver(context.Background(), shipment.ID, time.Now(), nil); err != nil {
+				t.Fatal(err)
+			}
+
+			expected := types.Delivered
+			if refunded == types.Refunded {
+				expected = types.Refunded
+			}
+
+			if store.shipments[shipment.ID-1].Status != types.ShipmentDelivered || transitions.orders.order.Status != expected {
+				t.Errorf("expected the shipment delivered and a %s order %s, got %s and %s", refunded, expected, store.shipments[shipment.ID-1].Status, transitions.orders.order.Status)
+			}
+		}
+	})
+}
+
+func TestShipItems(t *testing.T) {
+	items := []types.OrderItem{{ID: 10, Quantity: 2}, {ID: 11, Quantity: 0}}
+	others := []types.Shipment{{Items: []types.ShipmentItem{{OrderItemID: 10, Quantity: 1}}}}
+
+	t.Run("should ship what is left when nothing is asked for", func(t *testing.T) {
+		shipped, complete, err := shipItems(items, others, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(shipped) != 1 || shipped[0].Quantity != 1 || !complete {
+			t.Errorf("expected the last unit shipped, completing the order, got %+v and %v", shipped, complete)
+		}
+	})
+
+	t.Run("should refuse units that already shipped", func(t *testing.T) {
+		if _, _, err := shipItems(items, others, []types.ShipmentItemPayload{{OrderItemID: 10, Quantity: 2}}); err == nil {
+			t.Error("expected only one unit to be left to ship")
+		}
+	})
+
+	t.Run("should leave backordered items until they are in stock", func(t *testing.T) {
+		items := []types.OrderItem{{ID: 10, Quantity: 2}, {ID: 11, Quantity: 1, FulfilmentStatus: types.FulfilmentBackordered}}
+
+		shipped, complete, err := shipItems(items, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(shipped) != 1 || shipped[0].OrderItemID != 10 || complete {
+			t.Errorf("expected only the item in stock shipped, got %+v and %v", shipped, complete)
+		}
+
+		if _, _, err := shipItems(items, nil, []types.ShipmentItemPayload{{OrderItemID: 11, Quantity: 1}}); err == nil {
+			t.Error("expected a backordered item not to ship")
+		}
+	})
+}
+
+func TestSimulatedCarrier(t *testing.T) {
+	t.Run("should deliver parcels once the transit time has passed", func(t *testing.T) {
+		now := time.Date(2024, 12, 20, 9, 0, 0, 0, time.UTC)
+		carrier := NewSimulatedCarrier(time.Hour)
+		carrier.now = func() time.Time { return now }
+
+		if update, err := carrier.Track(context.Background(), "SIM1"); err != nil || update.Status != types.ShipmentInTransit {
+			t.Fatalf("expected the parcel in transit, got %+v and %v", update, err)
+		}
+
+		now = now.Add(time.Hour)
+		update, err := carrier.Track(context.Background(), "SIM1")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if update.Status != types.ShipmentDelivered || !update.At.Equal(now) {
+			t.Errorf("expected the parcel delivered at %s, got %+v", now, update)
+		}
+	})
+}
+
+type mockShipmentStore struct {
+	types.ShipmentStore
+
+	shipments []types.Shipment
+}
+
+func (m *mockShipmentStore) CreateShipment(ctx context.Context, shipment types.Shipment) (int, error) {
+	shipment.ID = len(m.shipments) + 1
+	m.shipments = append(m.shipments, shipment)
+
+	return shipment.ID, nil
+}
+
+func (m *mockShipmentStore) GetShipmentById(ctx context.Context, id int) (*types.Shipment, error) {
+	if id < 1 || id > len(m.shipments) {
+		return nil, ErrNotFound
+	}
+
+	shipment := m.shipments[id-1]
+	return &shipment, nil
+}
+
+func (m *mockShipmentStore) GetShipmentsByOrderId(ctx context.Context, orderId int) ([]types.Shipment, error) {
+	return m.shipments, nil
+}
+
+func (m *mockShipmentStore) GetShipmentsByStatus(ctx context.Context, status types.ShipmentStatus) ([]types.Shipment, error) {
+	shipments := []types.Shipment{}
+	for _, shipment := range m.shipments {
+		if shipment.Status == status {
+			shipments = append(shipments, shipment)
+		}
+	}
+
+	return shipments, nil
+}
+
+func (m *mockShipmentStore) MarkShipmentDelivered(ctx context.Context, id int, at time.Time) error {
+	m.shipments[id-1].Status, m.shipments[id-1].DeliveredAt = types.ShipmentDelivered, &at
+	return nil
+}
+
+func (m *mockShipmentStore) WithTx(tx types.DB) types.ShipmentStore {
+	return m
+}
+
+type mockOrderStore struct {
+	types.OrderStore
+
+	order   types.Order
+	items   []types.OrderItem
+	history []types.OrderStatusChange
+}
+
+func (m *mockOrderStore) GetOrderById(ctx context.Context, orderId int) (*types.Order, error) {
+	order := m.order
+	return &order, nil
+}
+
+func (m *mockOrderStore) LockOrderById(ctx context.Context, orderId int) (*types.Order, error) {
+	return m.GetOrderById(ctx, orderId)
+}
+
+func (m *mockOrderStore) GetOrderItems(ctx context.Context, orderId int) ([]types.OrderItem, error) {
+	return m.items, nil
+}
+
+func (m *mockOrderStore) GetOrderStatusHistory(ctx context.Context, orderId int) ([]types.OrderStatusChange, error) {
+	return m.history, nil
+}
+
+func (m *mockOrderStore) WithTx(tx types.DB) types.OrderStore {
+	return m
+}
+
+type mockTransitions struct {
+	orders *mockOrderStore
+}
+
+func (m *mockTransitions) Transition(ctx context.Context, orderId int, to types.OrderStatus, changedBy *int, reason string) (*types.Order, error) {
+	m.orders.history = append(m.orders.history, types.OrderStatusChange{OrderID: orderId, FromStatus: m.orders.order.Status, ToStatus: to})
+	m.orders.order.Status = to
+	return &m.orders.order, nil
+}
+
+type mockUnitOfWork struct{}
+
+func (mockUnitOfWork) Do(ctx context.Context, fn func(tx types.DB) error) error {
+	return fn(nil)
+}
@@ -0,0 +1,180 @@
+package shipment
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/xelathan/golang_backend/db"
+	"github.com/xelathan/golang_backend/types"
+)
+
+const selectShipments = "SELECT id, orderId, carrier, trackingNumber, status, createdBy, shippedAt, deliveredAt FROM shipments"
+
+var ErrNotFound = errors.New("shipment not found")
+
+type Store struct {
+	db types.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+func (s *Store) WithTx(tx types.DB) types.ShipmentStore {
+	return &Store{db: tx}
+}
+
+func (s *Store) CreateShipment(ctx context.Context, shipment types.Shipment) (int, error) {
+	var id int64
+	err := db.InTxContext(ctx, s.db, func(tx types.DB) error {
+		res, err := tx.ExecContext(ctx,
+			"INSERT INTO shipments (orderId, carrier, trackingNumber, status, createdBy, shippedAt) VALUES (?,?,?,?,?,?)",
+			shipment.OrderID, shipment.Carrier, shipment.TrackingNumber, shipment.Status, shipment.CreatedBy, shipment.ShippedAt,
+		)
+		if err != nil {
+			return err
+		}
+
+		id, err = res.LastInsertId()
+		if err != nil {
+			return err
+		}
+
+		for _, item := range shipment.Items {
+			_, err := tx.ExecContext(ctx,
+				"INSERT INTO shipment_items (shipmentId, orderItemId, quantity) VALUES (?,?,?)",
+				id, item.OrderItemID, item.Quantity,
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+func (s *Store) GetShipmentById(ctx context.Context, id int) (*types.Shipment, error) {
+	shipments, err := s.getShipments(ctx, " WHERE id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(shipments) == 0 {
+		return nil, ErrNotFound
+	}
+
+	return &shipments[0], nil
+}
+
+func (s *Store) GetShipmentsByOrderId(ctx context.Context, orderId int) ([]types.Shipment, error) {
+	return s.getShipments(ctx, " WHERE orderId = ? ORDER BY id", orderId)
+}
+
+func (s *Store) GetShipmentsByStatus(ctx context.Context, status types.ShipmentStatus) ([]types.Shipment, error) {
+	return s.getShipments(ctx, " WHERE status = ? ORDER BY id", status)
+}
+
+func (s *Store) MarkShipmentDelivered(ctx context.Context, id int, at time.Time) error {
+	res, err := s.db.ExecContext(ctx,
+		"UPDATE shipments SET status = ?, deliveredAt = ? WHERE id = ? AND status = ?",
+		types.ShipmentDelivered, at, id, types.ShipmentInTransit,
+	)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return fmt.Errorf("shipment is no longer %s", types.ShipmentInTransit)
+	}
+
+	return nil
+}
+
+// getShipments reads the shipments matching the suffix with their items.
+func (s *Store) getShipments(ctx context.Context, suffix string, args ...any) ([]types.Shipment, error) {
+	rows, err := s.db.QueryContext(ctx, selectShipments+suffix, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	shipments := []types.Shipment{}
+	byId := map[int]int{}
+	for rows.Next() {
+		shipment := types.Shipment{Items: []types.ShipmentItem{}}
+		var createdBy sql.NullInt64
+		var deliveredAt sql.NullTime
+		err := rows.Scan(
+			&shipment.ID,
+			&shipment.OrderID,
+			&shipment.Carrier,
+			&shipment.TrackingNumber,
+			&shipment.Status,
+			&createdBy,
+			&shipment.ShippedAt,
+			&deliveredAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if createdBy.Valid {
+			userId := int(createdBy.Int64)
+			shipment.CreatedBy = &userId
+		}
+		if deliveredAt.Valid {
+			shipment.DeliveredAt = &deliveredAt.Time
+		}
+
+		byId[shipment.ID] = len(shipments)
+		shipments = append(shipments, shipment)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(shipments) == 0 {
+		return shipments, nil
+	}
+
+	ids := make([]any, 0, len(shipments))
+	for _, shipment := range shipments {
+		ids = append(ids, shipment.ID)
+	}
+
+	itemRows, err := s.db.QueryContext(ctx,
+		"SELECT id, shipmentId, orderItemId, quantity FROM shipment_items WHERE shipmentId IN (?"+strings.Repeat(",?", len(ids)-1)+") ORDER BY id",
+		ids...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer itemRows.Close()
+
+	for itemRows.Next() {
+		item := types.ShipmentItem{}
+		if err := itemRows.Scan(&item.ID, &item.ShipmentID, &item.OrderItemID, &item.Quantity); err != nil {
+			return nil, err
+		}
+
+		shipment := &shipments[byId[item.ShipmentID]]
+		shipment.Items = append(shipment.Items, item)
+	}
+
+	return shipments, itemRows.Err()
+}
@@ -0,0 +1,204 @@
+package shipping
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/xelathan/golang_backend/money"
+	"github.com/xelathan/golang_backend/services/currency"
+	"github.com/xelathan/golang_backend/services/tax"
+	"github.com/xelathan/golang_backend/types"
+)
+
+// VolumetricDivisor turns a volume in cubic millimetres into grams, the
+// carriers' usual 5000 cm³ per kilogram. A parcel is charged by its
+// volumetric weight when that is higher than what it actually weighs.
+const VolumetricDivisor = 5000
+
+// Parcel is what a cart weighs and is worth for shipping. Subtotal is the
+// discounted subtotal in the cart currency.
+type Parcel struct {
+	WeightGrams int64
+	Subtotal    money.Money
+}
+
+// ParcelFor sums the chargeable weight of the items.
+func ParcelFor(products map[int]types.Product, items []types.CartItem, subtotal money.Money) Parcel {
+	parcel := Parcel{Subtotal: subtotal}
+	for _, item := range items {
+		parcel.WeightGrams += ChargeableWeight(products[item.ProductID]) * int64(item.Quantity)
+	}
+
+	return parcel
+}
+
+// ChargeableWeight is the higher of a product's weight and its volumetric
+// weight, in grams.
+func ChargeableWeight(product types.Product) int64 {
+	volume := int64(product.LengthMm) * int64(product.WidthMm) * int64(product.HeightMm)
+	volumetric := (volume + VolumetricDivisor - 1) / VolumetricDivisor
+
+	return max(int64(product.WeightGrams), volumetric)
+}
+
+// MatchZone returns the zone that covers the destination most specifically,
+// scored the same way as tax rates: a postal prefix beats a region, a longer
+// prefix beats a shorter one, and a region beats a whole country. On a tie
+// the zone created first wins.
+func MatchZone(zones []types.ShippingZone, destination types.Destination) (types.ShippingZone, bool) {
+	destination = tax.NormalizeDestination(destination)
+
+	best, bestScore := types.ShippingZone{}, -1
+	for _, zone := range zones {
+		for _, region := range zone.Regions {
+			if !strings.EqualFold(region.Country, destination.Country) {
+				continue
+			}
+
+			if region.Region != "" && !strings.EqualFold(region.Region, destination.Region) {
+				continue
+			}
+
+			prefix := tax.NormalizeDestination(types.Destination{PostalCode: region.PostalPrefix}).PostalCode
+			if prefix != "" && !strings.HasPrefix(destination.PostalCode, prefix) {
+				continue
+			}
+
+			score := 0
+			if region.Region != "" {
+				score = 1
+			}
+			if prefix != "" {
+				score = 2 + len(prefix)
+			}
+
+			if score > bestScore || score == bestScore && zone.ID < best.ID {
+				best, bestScore = zone, score
+			}
+		}
+	}
+
+	return best, bestScore >= 0
+}
+
+// Rate returns what the method charges for the parcel in the method's own
+// currency. The tier that applies is the one with the lowest bound the
+// parcel fits under; a parcel above every bound cannot be shipped with the
+// method.
+func Rate(method types.ShippingMethod, parcel Parcel, converter *currency.Converter) (money.Money, bool, error) {
+	var measure int64
+	switch method.RateType {
+	case types.ShippingRateFlat:
+		return method.FlatRate, true, nil
+	case types.ShippingRateWeight:
+		measure = parcel.WeightGrams
+	case types.ShippingRatePrice:
+		subtotal, err := converter.Convert(parcel.Subtotal, method.FlatRate.Currency)
+		if err != nil {
+			return money.Money{}, false, err
+		}
+		measure = subtotal.Amount
+	default:
+		return money.Money{}, false, fmt.Errorf("unknown shipping rate type %s", method.RateType)
+	}
+
+	tiers := append([]types.ShippingRateTier{}, method.Tiers...)
+	sort.SliceStable(tiers, func(i, j int) bool {
+		if tiers[i].UpTo == nil || tiers[j].UpTo == nil {
+			return tiers[j].UpTo == nil && tiers[i].UpTo != nil
+		}
+		return *tiers[i].UpTo < *tiers[j].UpTo
+	})
+
+	for _, tier := range tiers {
+		if tier.UpTo == nil || measure <= *tier.UpTo {
+			return tier.Rate, true, nil
+		}
+	}
+
+	return money.Money{}, false, nil
+}
+
+// Cost is what the method charges for the parcel in the cart currency. A
+// free shipping promotion makes standard methods free; express and pickup
+// keep their price.
+func Cost(method types.ShippingMethod, parcel Parcel, freeShipping bool, converter *currency.Converter, currencyCode string) (money.Money, bool, error) {
+	rate, ok, err := Rate(method, parcel, converter)
+	if err != nil || !ok {
+		return money.Money{}, ok, err
+	}
+
+	if freeShipping && method.Kind == types.ShippingStandard {
+		return money.Zero(currencyCode), true, nil
+	}
+
+	cost, err := converter.Convert(rate, currencyCode)
+	if err != nil {
+		return money.Money{}, false, err
+	}
+
+	return cost, true, nil
+}
+
+// QuoteWith lists the active methods of the zone covering the destination
+// with their cost in the cart currency, cheapest first.
+func QuoteWith(zones []types.ShippingZone, methods []types.ShippingMethod, parcel Parcel, destination types.Destination, freeShipping bool, converter *currency.Converter, currencyCode string) ([]types.ShippingQuote, error) {
+	quotes := []types.ShippingQuote{}
+
+	zone, ok := MatchZone(zones, destination)
+	if !ok {
+		return quotes, nil
+	}
+
+	for _, method := range methods {
+		if method.ZoneID != zone.ID || !method.Active {
+			continue
+		}
+
+		cost, ok, err := Cost(method, parcel, freeShipping, converter, currencyCode)
+		if err != nil {
+			return nil, err
+		}
+
+		if !ok {
+			continue
+		}
+
+		quotes = append(quotes, types.ShippingQuote{
+			MethodID: method.ID,
+			Name:     method.Name,
+			Kind:     method.Kind,
+			Cost:     cost,
+			MinDays:  method.MinDays,
+			MaxDays:  method.MaxDays,
+		})
+	}
+
+	sort.SliceStable(quotes, func(i, j int) bool {
+		return quotes[i].Cost.Amount < quotes[j].Cost.Amount
+	})
+
+	return quotes, nil
+}
+
+// Quote loads the zones and methods and quotes the parcel to the destination.
+func Quote(ctx context.Context, store types.ShippingStore, parcel Parcel, destination types.Destination, freeShipping bool, converter *currency.Converter, currencyCode string) ([]types.ShippingQuote, error) {
+	zones, err := store.GetZones(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	zone, ok := MatchZone(zones, destination)
+	if !ok {
+		return []types.ShippingQuote{}, nil
+	}
+
+	methods, err := store.GetMethods(ctx, zone.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return QuoteWith(zones, methods, parcel, destination, freeShipping, converter, currencyCode)
+}
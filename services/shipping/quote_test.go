@@ -0,0 +1,90 @@
+package shipping
+
+import (
+	"testing"
+
+	"github.com/xelathan/golang_backend/money"
+	"github.com/xelathan/golang_backend/services/currency"
+	"github.com/xelathan/golang_backend/types"
+)
+
+func TestQuoteWith(t *testing.T) {
+	converter, err := currency.NewConverter("USD", []types.ExchangeRate{{Currency: "EUR", Rate: "0.5"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	upTo := func(v int64) *int64 { return &v }
+
+	zones := []types.ShippingZone{
+		{ID: 1, Name: "US", Regions: []types.ShippingZoneRegion{{Country: "US"}}},
+		{ID: 2, Name: "Alaska and Hawaii", Regions: []types.ShippingZoneRegion{{Country: "US", Region: "AK"}, {Country: "US", Region: "HI"}}},
+	}
+	methods := []types.ShippingMethod{
+		{ID: 1, ZoneID: 1, Name: "Ground", Kind: types.ShippingStandard, RateType: types.ShippingRateWeight, Active: true, Tiers: []types.ShippingRateTier{
+			{UpTo: nil, Rate: money.New(2500, "USD")},
+			{UpTo: upTo(1000), Rate: money.New(500, "USD")},
+			{UpTo: upTo(5000), Rate: money.New(1200, "USD")},
+		}},
+		{ID: 2, ZoneID: 1, Name: "Overnight", Kind: types.ShippingExpress, RateType: types.ShippingRateFlat, FlatRate: money.New(3000, "USD"), Active: true},
+		{ID: 3, ZoneID: 1, Name: "Retired", Kind: types.ShippingExpress, RateType: types.ShippingRateFlat, FlatRate: money.New(100, "USD")},
+		{ID: 4, ZoneID: 2, Name: "Air", Kind: types.ShippingStandard, RateType: types.ShippingRatePrice, Active: true, FlatRate: money.Zero("USD"), Tiers: []types.ShippingRateTier{
+			{UpTo: upTo(9999), Rate: money.New(1500, "USD")},
+			{UpTo: nil, Rate: money.Zero("USD")},
+		}},
+	}
+
+	t.Run("should charge by the volumetric weight of bulky products", func(t *testing.T) {
+		pillow := types.Product{WeightGrams: 500, LengthMm: 500, WidthMm: 400, HeightMm: 200}
+
+		// 40 000 cm³ / 5000 = 8 kg
+		if weight := ChargeableWeight(pillow); weight != 8000 {
+			t.Errorf("expected 8000g, got %d", weight)
+		}
+	})
+
+	t.Run("should list active methods of the matching zone cheapest first", func(t *testing.T) {
+		quotes, err := QuoteWith(zones, methods, Parcel{WeightGrams: 3000, Subtotal: money.New(2000, "USD")}, types.Destination{Country: "us", Region: "ny"}, false, converter, "USD")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(quotes) != 2 || quotes[0].MethodID != 1 || quotes[0].Cost != money.New(1200, "USD") || quotes[1].MethodID != 2 {
+			t.Errorf("expected ground at 12.00 then overnight, got %+v", quotes)
+		}
+	})
+
+	t.Run("should prefer the region over the whole country", func(t *testing.T) {
+		quotes, err := QuoteWith(zones, methods, Parcel{WeightGrams: 3000, Subtotal: money.New(12000, "USD")}, types.Destination{Country: "US", Region: "HI"}, false, converter, "USD")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the subtotal is over the last bounded price tier
+		if len(quotes) != 1 || quotes[0].MethodID != 4 || !quotes[0].Cost.IsZero() {
+			t.Errorf("expected free air shipping, got %+v", quotes)
+		}
+	})
+
+	t.Run("should convert costs and only make standard methods free", func(t *testing.T) {
+		quotes, err := QuoteWith(zones, methods, Parcel{WeightGrams: 800, Subtotal: money.New(1000, "EUR")}, types.Destination{Country: "US"}, true, converter, "EUR")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(quotes) != 2 || !quotes[0].Cost.IsZero() || quotes[1].Cost != money.New(1500, "EUR") {
+			t.Errorf("expected free ground and overnight at 15.00 EUR, got %+v", quotes)
+		}
+	})
+
+	t.Run("should quote nothing outside every zone", func(t *testing.T) {
+		quotes, err := QuoteWith(zones, methods, Parcel{WeightGrams: 800}, types.Destination{Country: "CA"}, false, converter, "USD")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(quotes) != 0 {
+			t.Errorf("expected no methods, got %+v", quotes)
+		}
+	})
+}
@@ -0,0 +1,228 @@
+package shipping
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"github.com/xelathan/golang_backend/money"
+	"github.com/xelathan/golang_backend/services/auth"
+	"github.com/xelathan/golang_backend/services/tax"
+	"github.com/xelathan/golang_backend/types"
+	"github.com/xelathan/golang_backend/utils"
+)
+
+type Handler struct {
+	store     types.ShippingStore
+	userStore types.UserStore
+}
+
+func NewHandler(store types.ShippingStore, userStore types.UserStore) *Handler {
+	return &Handler{store: store, userStore: userStore}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/admin/shipping_zones", auth.WithAdminAuth(h.handleGetZones, h.userStore)).Methods(http.MethodGet)
+	router.HandleFunc("/admin/shipping_zones", auth.WithAdminAuth(h.handleCreateZone, h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/admin/shipping_methods", auth.WithAdminAuth(h.handleGetMethods, h.userStore)).Methods(http.MethodGet)
+	router.HandleFunc("/admin/shipping_methods", auth.WithAdminAuth(h.handleCreateMethod, h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/admin/shipping_methods/{id}/active", auth.WithAdminAuth(h.handleSetMethodActive, h.userStore)).Methods(http.MethodPost)
+}
+
+func (h *Handler) handleGetZones(w http.ResponseWriter, r *http.Request) {
+	zones, err := h.store.GetZones(r.Context())
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, zones)
+}
+
+func (h *Handler) handleCreateZone(w http.ResponseWriter, r *http.Request) {
+	payload := types.CreateShippingZonePayload{}
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	zone := types.ShippingZone{Name: payload.Name, Regions: make([]types.ShippingZoneRegion, len(payload.Regions))}
+	for i, region := range payload.Regions {
+		destination := tax.NormalizeDestination(types.Destination{
+			Country:    region.Country,
+			Region:     region.Region,
+			PostalCode: region.PostalPrefix,
+		})
+
+		zone.Regions[i] = types.ShippingZoneRegion{
+			Country:      destination.Country,
+			Region:       destination.Region,
+			PostalPrefix: destination.PostalCode,
+		}
+	}
+
+	id, err := h.store.CreateZone(r.Context(), zone)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	zone.ID = id
+
+	utils.WriteJSON(w, http.StatusCreated, zone)
+}
+
+func (h *Handler) handleGetMethods(w http.ResponseWriter, r *http.Request) {
+	zoneIDs := []int{}
+	if zone := r.URL.Query().Get("zoneId"); zone != "" {
+		zoneId, err := strconv.Atoi(zone)
+		if err != nil {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid zone id"))
+			return
+		}
+		zoneIDs = append(zoneIDs, zoneId)
+	}
+
+	methods, err := h.store.GetMethods(r.Context(), zoneIDs...)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, methods)
+}
+
+func (h *Handler) handleCreateMethod(w http.ResponseWriter, r *http.Request) {
+	payload := types.CreateShippingMethodPayload{}
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	method, err := methodFromPayload(payload)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	zones, err := h.store.GetZones(r.Context())
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if !hasZone(zones, method.ZoneID) {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("shipping zone %d does not exist", method.ZoneID))
+		return
+	}
+
+	id, err := h.store.CreateMethod(r.Context(), method)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	method.ID = id
+
+	utils.WriteJSON(w, http.StatusCreated, method)
+}
+
+func (h *Handler) handleSetMethodActive(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid shipping method id"))
+		return
+	}
+
+	payload := types.SetShippingMethodActivePayload{}
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.store.SetMethodActive(r.Context(), id, payload.Active); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			utils.WriteError(w, http.StatusNotFound, err)
+			return
+		}
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]any{"id": id, "active": payload.Active})
+}
+
+// methodFromPayload checks the rate fits the rate type: flat methods have no
+// tiers and tiered methods need at least one, each with a distinct bound.
+func methodFromPayload(payload types.CreateShippingMethodPayload) (types.ShippingMethod, error) {
+	currencyCode := strings.ToUpper(payload.Currency)
+	if !money.IsValidCurrency(currencyCode) {
+		return types.ShippingMethod{}, fmt.Errorf("unsupported currency %s", payload.Currency)
+	}
+
+	method := types.ShippingMethod{
+		ZoneID:   payload.ZoneID,
+		Name:     payload.Name,
+		Kind:     payload.Kind,
+		RateType: payload.RateType,
+		FlatRate: money.New(payload.FlatRate, currencyCode),
+		Tiers:    []types.ShippingRateTier{},
+		MinDays:  payload.MinDays,
+		MaxDays:  payload.MaxDays,
+		Active:   true,
+	}
+
+	if payload.RateType == types.ShippingRateFlat {
+		if len(payload.Tiers) > 0 {
+			return types.ShippingMethod{}, fmt.Errorf("flat rate methods do not take tiers")
+		}
+		return method, nil
+	}
+
+	if len(payload.Tiers) == 0 {
+		return types.ShippingMethod{}, fmt.Errorf("%s rate methods need at least one tier", payload.RateType)
+	}
+
+	bounds := map[string]bool{}
+	for _, tier := range payload.Tiers {
+		bound := "unbounded"
+		if tier.UpTo != nil {
+			bound = strconv.FormatInt(*tier.UpTo, 10)
+		}
+
+		if bounds[bound] {
+			return types.ShippingMethod{}, fmt.Errorf("more than one tier up to %s", bound)
+		}
+		bounds[bound] = true
+
+		method.Tiers = append(method.Tiers, types.ShippingRateTier{UpTo: tier.UpTo, Rate: money.New(tier.Rate, currencyCode)})
+	}
+
+	return method, nil
+}
+
+func hasZone(zones []types.ShippingZone, id int) bool {
+	for _, zone := range zones {
+		if zone.ID == id {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,251 @@
+package shipping
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/xelathan/golang_backend/types"
+)
+
+var ErrNotFound = errors.New("shipping method not found")
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+func (s *Store) CreateZone(ctx context.Context, zone types.ShippingZone) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := tx.ExecContext(ctx, "INSERT INTO shipping_zones (name) VALUES (?)", zone.Name)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	for _, region := range zone.Regions {
+		_, err := tx.ExecContext(ctx,
+			"INSERT INTO shipping_zone_regions (zoneId, country, region, postalPrefix) VALUES (?,?,?,?)",
+			id, region.Country, nullableString(region.Region), nullableString(region.PostalPrefix),
+		)
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+func (s *Store) GetZones(ctx context.Context) ([]types.ShippingZone, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT id, name, createdAt FROM shipping_zones ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	zones := []types.ShippingZone{}
+	index := map[int]int{}
+	for rows.Next() {
+		zone := types.ShippingZone{Regions: []types.ShippingZoneRegion{}}
+		if err := rows.Scan(&zone.ID, &zone.Name, &zone.CreatedAt); err != nil {
+			return nil, err
+		}
+
+		index[zone.ID] = len(zones)
+		zones = append(zones, zone)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	regionRows, err := s.db.QueryContext(ctx, "SELECT zoneId, country, region, postalPrefix FROM shipping_zone_regions ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer regionRows.Close()
+
+	for regionRows.Next() {
+		var zoneId int
+		var region, postalPrefix sql.NullString
+		zoneRegion := types.ShippingZoneRegion{}
+		if err := regionRows.Scan(&zoneId, &zoneRegion.Country, &region, &postalPrefix); err != nil {
+			return nil, err
+		}
+
+		zoneRegion.Region = region.String
+		zoneRegion.PostalPrefix = postalPrefix.String
+
+		if i, ok := index[zoneId]; ok {
+			zones[i].Regions = append(zones[i].Regions, zoneRegion)
+		}
+	}
+
+	return zones, regionRows.Err()
+}
+
+func (s *Store) CreateMethod(ctx context.Context, method types.ShippingMethod) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := tx.ExecContext(ctx,
+		"INSERT INTO shipping_methods (zoneId, name, kind, rateType, flatRate, currency, minDays, maxDays, active) VALUES (?,?,?,?,?,?,?,?,?)",
+		method.ZoneID, method.Name, method.Kind, method.RateType, method.FlatRate.Amount, method.FlatRate.Currency, method.MinDays, method.MaxDays, method.Active,
+	)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	for _, tier := range method.Tiers {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO shipping_rate_tiers (methodId, upTo, rate) VALUES (?,?,?)", id, tier.UpTo, tier.Rate.Amount); err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+func (s *Store) GetMethods(ctx context.Context, zoneIDs ...int) ([]types.ShippingMethod, error) {
+	query, args := "SELECT id, zoneId, name, kind, rateType, flatRate, currency, minDays, maxDays, active, createdAt FROM shipping_methods", []interface{}{}
+	if len(zoneIDs) > 0 {
+		query += fmt.Sprintf(" WHERE zoneId IN (?%s)", strings.Repeat(",?", len(zoneIDs)-1))
+		for _, id := range zoneIDs {
+			args = append(args, id)
+		}
+	}
+
+	rows, err := s.db.QueryContext(ctx, query+" ORDER BY id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	methods := []types.ShippingMethod{}
+	index := map[int]int{}
+	for rows.Next() {
+		method := types.ShippingMethod{Tiers: []types.ShippingRateTier{}}
+		err := rows.Scan(
+			&method.ID,
+			&method.ZoneID,
+			&method.Name,
+			&method.Kind,
+			&method.RateType,
+			&method.FlatRate.Amount,
+			&method.FlatRate.Currency,
+			&method.MinDays,
+			&method.MaxDays,
+			&method.Active,
+			&method.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		index[method.ID] = len(methods)
+		methods = append(methods, method)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(methods) == 0 {
+		return methods, nil
+	}
+
+	methodIds := make([]interface{}, len(methods))
+	for i, method := range methods {
+		methodIds[i] = method.ID
+	}
+
+	tierRows, err := s.db.QueryContext(ctx,
+		fmt.Sprintf("SELECT methodId, upTo, rate FROM shipping_rate_tiers WHERE methodId IN (?%s) ORDER BY methodId, upTo IS NULL, upTo", strings.Repeat(",?", len(methodIds)-1)),
+		methodIds...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer tierRows.Close()
+
+	for tierRows.Next() {
+		var methodId int
+		var upTo sql.NullInt64
+		tier := types.ShippingRateTier{}
+		if err := tierRows.Scan(&methodId, &upTo, &tier.Rate.Amount); err != nil {
+			return nil, err
+		}
+
+		if upTo.Valid {
+			tier.UpTo = &upTo.Int64
+		}
+
+		i := index[methodId]
+		tier.Rate.Currency = methods[i].FlatRate.Currency
+		methods[i].Tiers = append(methods[i].Tiers, tier)
+	}
+
+	return methods, tierRows.Err()
+}
+
+func (s *Store) SetMethodActive(ctx context.Context, id int, active bool) error {
+	res, err := s.db.ExecContext(ctx, "UPDATE shipping_methods SET active = ? WHERE id = ?", active, id)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		var exists bool
+		if err := s.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM shipping_methods WHERE id = ?)", id).Scan(&exists); err != nil {
+			return err
+		}
+
+		if !exists {
+			return ErrNotFound
+		}
+	}
+
+	return nil
+}
+
+func nullableString(value string) sql.NullString {
+	return sql.NullString{String: value, Valid: value != ""}
+}
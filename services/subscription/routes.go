@@ -0,0 +1,332 @@
+package subscription
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"github.com/xelathan/golang_backend/config"
+	"github.com/xelathan/golang_backend/services/auth"
+	"github.com/xelathan/golang_backend/services/currency"
+	"github.com/xelathan/golang_backend/services/idempotency"
+	"github.com/xelathan/golang_backend/services/tax"
+	"github.com/xelathan/golang_backend/types"
+	"github.com/xelathan/golang_backend/utils"
+)
+
+type Handler struct {
+	store        types.SubscriptionStore
+	productStore types.ProductStore
+	userStore    types.UserStore
+	keys         *idempotency.Keys
+}
+
+func NewHandler(store types.SubscriptionStore, productStore types.ProductStore, userStore types.UserStore, keys *idempotency.Keys) *Handler {
+	return &Handler{store: store, productStore: productStore, userStore: userStore, keys: keys}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/products/{id}/subscription_plans", h.handleGetPlans).Methods(http.MethodGet)
+
+	router.HandleFunc("/subscriptions", auth.WithJWTAuth(h.keys.Wrap(h.handleSubscribe), h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/subscriptions", auth.WithJWTAuth(h.handleGetSubscriptions, h.userStore)).Methods(http.MethodGet)
+	router.HandleFunc("/subscriptions/{id}", auth.WithJWTAuth(h.handleGetSubscription, h.userStore)).Methods(http.MethodGet)
+	router.HandleFunc("/subscriptions/{id}/pause", auth.WithJWTAuth(h.handleChange(func(s *types.Subscription, r *http.Request) error {
+		return Pause(s)
+	}), h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/subscriptions/{id}/resume", auth.WithJWTAuth(h.handleChange(func(s *types.Subscription, r *http.Request) error {
+		return Resume(s, time.Now())
+	}), h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/subscriptions/{id}/skip", auth.WithJWTAuth(h.handleChange(func(s *types.Subscription, r *http.Request) error {
+		return Skip(s)
+	}), h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/subscriptions/{id}/cancel", auth.WithJWTAuth(h.handleChange(func(s *types.Subscription, r *http.Request) error {
+		return Cancel(s, time.Now())
+	}), h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/subscriptions/{id}/payment_source", auth.WithJWTAuth(h.handleChange(updatePaymentSource), h.userStore)).Methods(http.MethodPost)
+
+	router.HandleFunc("/admin/products/{id}/subscription_plans", auth.WithAdminAuth(h.handleCreatePlan, h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/admin/subscription_plans/{id}/active", auth.WithAdminAuth(h.handleSetPlanActive, h.userStore)).Methods(http.MethodPost)
+}
+
+// handleGetPlans lists the plans a product can be subscribed to.
+func (h *Handler) handleGetPlans(w http.ResponseWriter, r *http.Request) {
+	productId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid product id"))
+		return
+	}
+
+	plans, err := h.store.GetPlansByProductId(r.Context(), productId)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	active := []types.SubscriptionPlan{}
+	for _, plan := range plans {
+		if plan.Active {
+			active = append(active, plan)
+		}
+	}
+
+	utils.WriteJSON(w, http.StatusOK, active)
+}
+
+func (h *Handler) handleCreatePlan(w http.ResponseWriter, r *http.Request) {
+	productId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid product id"))
+		return
+	}
+
+	payload := types.CreateSubscriptionPlanPayload{}
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	products, err := h.productStore.GetProductsByID(r.Context(), []int{productId})
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if len(products) == 0 {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("product not found"))
+		return
+	}
+
+	plan := types.SubscriptionPlan{
+		ProductID:     productId,
+		Name:          strings.TrimSpace(payload.Name),
+		Interval:      payload.Interval,
+		IntervalCount: payload.IntervalCount,
+		PercentOff:    payload.PercentOff,
+		Active:        true,
+	}
+
+	plan.ID, err = h.store.CreatePlan(r.Context(), plan)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, plan)
+}
+
+// handleSetPlanActive stops or starts a plan being offered. Deactivating a
+// plan leaves the subscriptions to it running.
+func (h *Handler) handleSetPlanActive(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid subscription plan id"))
+		return
+	}
+
+	payload := types.SetSubscriptionPlanActivePayload{}
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.store.SetPlanActive(r.Context(), id, payload.Active); err != nil {
+		utils.WriteError(w, subscriptionStatus(err), err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]any{"id": id, "active": payload.Active})
+}
+
+// handleSubscribe subscribes the caller to a plan in the currency they ask
+// for. Its orders are placed and paid for by the scheduler, the first of
+// them on startAt or at its next sweep.
+func (h *Handler) handleSubscribe(w http.ResponseWriter, r *http.Request) {
+	userId := auth.GetUserIdFromContext(r.Context())
+	if userId == -1 {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid userId"))
+		return
+	}
+
+	payload := types.SubscribePayload{}
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	currencyCode, err := currency.FromRequest(r, config.Envs.BaseCurrency)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	plan, err := h.store.GetPlanById(r.Context(), payload.PlanID)
+	if err != nil {
+		utils.WriteError(w, subscriptionStatus(err), err)
+		return
+	}
+
+	if !plan.Active {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("subscription plan %d is not available", plan.ID))
+		return
+	}
+
+	if payload.ShippingMethodID != 0 && payload.Destination == nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("a destination is needed to choose a shipping method"))
+		return
+	}
+
+	subscription := types.Subscription{
+		UserID:           userId,
+		PlanID:           plan.ID,
+		Quantity:         payload.Quantity,
+		Status:           types.SubscriptionActive,
+		Currency:         currencyCode,
+		PaymentSource:    payload.PaymentSource,
+		ShippingMethodID: payload.ShippingMethodID,
+		NextRunAt:        time.Now(),
+		Plan:             *plan,
+	}
+	if payload.Destination != nil {
+		destination := tax.NormalizeDestination(*payload.Destination)
+		subscription.Destination = &destination
+	}
+	if payload.StartAt != nil && payload.StartAt.After(subscription.NextRunAt) {
+		subscription.NextRunAt = *payload.StartAt
+	}
+
+	subscription.ID, err = h.store.CreateSubscription(r.Context(), subscription)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, subscription)
+}
+
+func (h *Handler) handleGetSubscriptions(w http.ResponseWriter, r *http.Request) {
+	userId := auth.GetUserIdFromContext(r.Context())
+	if userId == -1 {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid userId"))
+		return
+	}
+
+	subscriptions, err := h.store.GetSubscriptionsByUserId(r.Context(), userId)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, subscriptions)
+}
+
+// handleGetSubscription answers with one of the caller's subscriptions and
+// the orders placed for it, newest first.
+func (h *Handler) handleGetSubscription(w http.ResponseWriter, r *http.Request) {
+	subscription, ok := h.ownSubscription(w, r)
+	if !ok {
+		return
+	}
+
+	runs, err := h.store.GetSubscriptionRuns(r.Context(), subscription.ID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+	subscription.Runs = runs
+
+	utils.WriteJSON(w, http.StatusOK, subscription)
+}
+
+// handleChange applies change to one of the caller's subscriptions and
+// saves it.
+func (h *Handler) handleChange(change func(*types.Subscription, *http.Request) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		subscription, ok := h.ownSubscription(w, r)
+		if !ok {
+			return
+		}
+
+		if err := change(subscription, r); err != nil {
+			utils.WriteError(w, subscriptionStatus(err), err)
+			return
+		}
+
+		if err := h.store.UpdateSubscription(r.Context(), *subscription); err != nil {
+			utils.WriteError(w, subscriptionStatus(err), err)
+			return
+		}
+
+		utils.WriteJSON(w, http.StatusOK, subscription)
+	}
+}
+
+func updatePaymentSource(subscription *types.Subscription, r *http.Request) error {
+	payload := types.UpdatePaymentSourcePayload{}
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		return &subscriptionError{err}
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		return &subscriptionError{fmt.Errorf("invalid payload %v", errors)}
+	}
+
+	return UpdatePaymentSource(subscription, payload.PaymentSource, time.Now())
+}
+
+// ownSubscription loads the subscription in the path, answering as if it
+// did not exist when it belongs to someone else.
+func (h *Handler) ownSubscription(w http.ResponseWriter, r *http.Request) (*types.Subscription, bool) {
+	userId := auth.GetUserIdFromContext(r.Context())
+	if userId == -1 {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid userId"))
+		return nil, false
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid subscription id"))
+		return nil, false
+	}
+
+	subscription, err := h.store.GetSubscriptionById(r.Context(), id)
+	if err == nil && subscription.UserID != userId {
+		err = fmt.Errorf("subscription not found")
+	}
+	if err != nil {
+		utils.WriteError(w, subscriptionStatus(err), err)
+		return nil, false
+	}
+
+	return subscription, true
+}
+
+func subscriptionStatus(err error) int {
+	var refused *subscriptionError
+	switch {
+	case errors.As(err, &refused):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrNotFound), errors.Is(err, ErrPlanNotFound):
+		return http.StatusNotFound
+	}
+
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,276 @@
+package subscription
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/xelathan/golang_backend/types"
+)
+
+const (
+	EventOrderPlaced   = "subscription.order_placed"
+	EventPaymentFailed = "subscription.payment_failed"
+	EventCancelled     = "subscription.cancelled"
+)
+
+// Advance moves t on by count of the interval. Months are added by the
+// calendar, so a subscription started on the 31st runs early the month
+// after in shorter months.
+func Advance(t time.Time, interval types.SubscriptionInterval, count int) time.Time {
+	switch interval {
+	case types.IntervalWeek:
+		return t.AddDate(0, 0, 7*count)
+	case types.IntervalMonth:
+		return t.AddDate(0, count, 0)
+	default:
+		return t.AddDate(0, 0, count)
+	}
+}
+
+// Scheduler places the orders of subscriptions that are due and pays for
+// them with the subscription's payment source. A failed payment is retried
+// after retryDelay, doubling every attempt, and the subscription is
+// cancelled once maxRetries retries have failed. The customer is told
+// about every order and failure.
+type Scheduler struct {
+	store      types.SubscriptionStore
+	orders     types.OrderPlacer
+	payments   types.PaymentProcessor
+	userStore  types.UserStore
+	notifier   types.Notifier
+	retryDelay time.Duration
+	maxRetries int
+}
+
+func NewScheduler(store types.SubscriptionStore, orders types.OrderPlacer, payments types.PaymentProcessor, userStore types.UserStore, notifier types.Notifier, retryDelay time.Duration, maxRetries int) *Scheduler {
+	return &Scheduler{
+		store:      store,
+		orders:     orders,
+		payments:   payments,
+		userStore:  userStore,
+		notifier:   notifier,
+		retryDelay: retryDelay,
+		maxRetries: maxRetries,
+	}
+}
+
+// Start runs the subscriptions that are due every interval.
+func (s *Scheduler) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := s.Sweep(context.Background()); err != nil {
+				log.Printf("subscription: sweep failed: %v", err)
+			}
+		}
+	}()
+}
+
+// Sweep runs the subscriptions due now. A subscription that cannot be run
+// is logged and left for the next sweep so it does not hold up the others.
+func (s *Scheduler) Sweep(ctx context.Context) error {
+	now := time.Now()
+	subscriptions, err := s.store.GetDueSubscriptions(ctx, now)
+	if err != nil {
+		return err
+	}
+
+	for _, subscription := range subscriptions {
+		if err := s.run(ctx, subscription, now); err != nil {
+			log.Printf("subscription: failed to run subscription %d: %v", subscription.ID, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *Scheduler) run(ctx context.Context, subscription types.Subscription, now time.Time) error {
+	run := types.SubscriptionRun{SubscriptionID: subscription.ID, Status: types.SubscriptionRunPending}
+
+	// the subscription moves on to its next date in the transaction that
+	// places the order, before it is paid for, so a failure to record what
+	// happened next can leave an order unpaid but never charges for it
+	// twice. A retried run keeps the subscription on its schedule, and one
+	// that was paused past its date is not run again for the dates it
+	// missed.
+	scheduled := subscription
+	for !scheduled.NextRunAt.After(now) {
+		scheduled.NextRunAt = Advance(scheduled.NextRunAt, subscription.Plan.Interval, subscription.Plan.IntervalCount)
+	}
+	scheduled.Status, scheduled.RetryAt = types.SubscriptionActive, nil
+
+	order, err := s.orders.PlaceOrder(ctx, types.PlaceOrderRequest{
+		UserID:           subscription.UserID,
+		Items:            []types.CartItem{{ProductID: subscription.Plan.ProductID, Quantity: subscription.Quantity}},
+		Currency:         subscription.Currency,
+		Destination:      subscription.Destination,
+		ShippingMethodID: subscription.ShippingMethodID,
+		PercentOff:       subscription.Plan.PercentOff,
+		Placed: func(tx types.DB, order types.Order) error {
+			run.OrderID = &order.ID
+			id, err := s.store.WithTx(tx).RecordRun(ctx, run, scheduled)
+			run.ID = id
+			return err
+		},
+	})
+	if err != nil {
+		run.ID, run.OrderID = 0, nil
+		run.Reason = fmt.Sprintf("order could not be placed: %v", err)
+		return s.fail(ctx, subscription, run, now)
+	}
+	subscription = scheduled
+
+	// a declined payment or an unreachable provider releases the order
+	payment, err := s.payments.Charge(ctx, *order, subscription.PaymentSource)
+	switch {
+	case err != nil:
+		run.Reason = fmt.Sprintf("payment failed: %v", err)
+		return s.fail(ctx, subscription, run, now)
+	case payment != nil && (payment.Status == types.PaymentDeclined || payment.Status == types.PaymentFailed):
+		run.Reason = fmt.Sprintf("payment %s: %s", payment.Status, payment.FailureReason)
+		return s.fail(ctx, subscription, run, now)
+	}
+
+	if payment == nil || payment.Status != types.PaymentRequiresAction {
+		run.Status = types.SubscriptionRunPaid
+	}
+	subscription.FailedAttempts = 0
+
+	if _, err := s.store.RecordRun(ctx, run, subscription); err != nil {
+		return err
+	}
+
+	message := fmt.Sprintf("We placed order %d for your %s subscription, %s in total.", order.ID, subscription.Plan.Name, order.Total)
+	if run.Status == types.SubscriptionRunPending {
+		message += " Your bank needs you to confirm the payment."
+	}
+	s.notify(ctx, subscription, EventOrderPlaced, "Your subscription order",
+		fmt.Sprintf("%s Your next order is on %s.", message, subscription.NextRunAt.Format(time.DateOnly)),
+	)
+
+	return nil
+}
+
+// fail records a failed run and schedules the next retry, or cancels the
+// subscription once it is out of retries.
+func (s *Scheduler) fail(ctx context.Context, subscription types.Subscription, run types.SubscriptionRun, now time.Time) error {
+	run.Status = types.SubscriptionRunFailed
+	run.Reason = run.Reason[:min(len(run.Reason), 255)]
+	subscription.FailedAttempts++
+
+	if subscription.FailedAttempts > s.maxRetries {
+		subscription.Status, subscription.RetryAt, subscription.CancelledAt = types.SubscriptionCancelled, nil, &now
+		if _, err := s.store.RecordRun(ctx, run, subscription); err != nil {
+			return err
+		}
+
+		s.notify(ctx, subscription, EventCancelled, "Your subscription was cancelled",
+			fmt.Sprintf("We could not take payment for your %s subscription after %d attempts, so it was cancelled.", subscription.Plan.Name, subscription.FailedAttempts),
+		)
+		return nil
+	}
+
+	retryAt := now.Add(s.retryDelay << (subscription.FailedAttempts - 1))
+	subscription.Status, subscription.RetryAt = types.SubscriptionPastDue, &retryAt
+	if _, err := s.store.RecordRun(ctx, run, subscription); err != nil {
+		return err
+	}
+
+	s.notify(ctx, subscription, EventPaymentFailed, "We could not take payment for your subscription",
+		fmt.Sprintf("We could not take payment for your %s subscription. We will try again on %s; you can update your payment details before then.", subscription.Plan.Name, retryAt.Format(time.DateOnly)),
+	)
+
+	return nil
+}
+
+// notify only logs a failure to send, as the run is recorded either way.
+func (s *Scheduler) notify(ctx context.Context, subscription types.Subscription, event string, subject string, message string) {
+	user, err := s.userStore.GetUserById(ctx, subscription.UserID)
+	if err != nil {
+		log.Printf("subscription: failed to look up user %d: %v", subscription.UserID, err)
+		return
+	}
+
+	if err := s.notifier.Notify(types.Notification{Event: event, Recipient: user.Email, Subject: subject, Message: message}); err != nil {
+		log.Printf("subscription: failed to notify user %d: %v", subscription.UserID, err)
+	}
+}
+
+// subscriptionError is a change to a subscription refused because of the
+// state it is in.
+type subscriptionError struct {
+	err error
+}
+
+func (e *subscriptionError) Error() string {
+	return e.err.Error()
+}
+
+// Pause stops a subscription's orders until it is resumed. A past due
+// subscription cannot be paused to put off paying for its order.
+func Pause(subscription *types.Subscription) error {
+	if subscription.Status != types.SubscriptionActive {
+		return &subscriptionError{fmt.Errorf("cannot pause a %s subscription", subscription.Status)}
+	}
+
+	subscription.Status = types.SubscriptionPaused
+	return nil
+}
+
+// Resume starts a paused subscription's orders again. The dates it missed
+// while it was paused are not made up for: its next order is placed now.
+func Resume(subscription *types.Subscription, now time.Time) error {
+	if subscription.Status != types.SubscriptionPaused {
+		return &subscriptionError{fmt.Errorf("cannot resume a %s subscription", subscription.Status)}
+	}
+
+	subscription.Status = types.SubscriptionActive
+	if subscription.NextRunAt.Before(now) {
+		subscription.NextRunAt = now
+	}
+
+	return nil
+}
+
+// Skip moves the next order on by an interval. Skipping the order of a
+// past due subscription gives up on paying for it and stops its retries.
+func Skip(subscription *types.Subscription) error {
+	switch subscription.Status {
+	case types.SubscriptionActive, types.SubscriptionPaused:
+	case types.SubscriptionPastDue:
+		subscription.Status, subscription.RetryAt, subscription.FailedAttempts = types.SubscriptionActive, nil, 0
+	default:
+		return &subscriptionError{fmt.Errorf("cannot skip an order of a %s subscription", subscription.Status)}
+	}
+
+	subscription.NextRunAt = Advance(subscription.NextRunAt, subscription.Plan.Interval, subscription.Plan.IntervalCount)
+	return nil
+}
+
+func Cancel(subscription *types.Subscription, now time.Time) error {
+	if subscription.Status == types.SubscriptionCancelled {
+		return &subscriptionError{fmt.Errorf("subscription is already cancelled")}
+	}
+
+	subscription.Status, subscription.RetryAt, subscription.CancelledAt = types.SubscriptionCancelled, nil, &now
+	return nil
+}
+
+// UpdatePaymentSource changes what a subscription is paid with. A past due
+// subscription is retried with it straight away.
+func UpdatePaymentSource(subscription *types.Subscription, source string, now time.Time) error {
+	if subscription.Status == types.SubscriptionCancelled {
+		return &subscriptionError{fmt.Errorf("cannot update a cancelled subscription")}
+	}
+
+	subscription.PaymentSource = source
+	if subscription.Status == types.SubscriptionPastDue {
+		subscription.RetryAt = &now
+	}
+
+	return nil
+}
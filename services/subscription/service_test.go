@@ -0,0 +1,257 @@
+package subscription
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/xelathan/golang_backend/money"
+	"github.com/xelathan/golang_backend/types"
+)
+
+func TestAdvance(t *testing.T) {
+	start := time.Date(2025, time.January, 15, 9, 0, 0, 0, time.UTC)
+	cases := []struct {
+		name     string
+		interval types.SubscriptionInterval
+		count    int
+		expected time.Time
+	}{
+		{"days", types.IntervalDay, 10, time.Date(2025, time.January, 25, 9, 0, 0, 0, time.UTC)},
+		{"weeks", types.IntervalWeek, 2, time.Date(2025, time.January, 29, 9, 0, 0, 0, time.UTC)},
+		{"months", types.IntervalMonth, 1, time.Date(2025, time.February, 15, 9, 0, 0, 0, time.UTC)},
+	}
+
+	for _, c := range cases {
+		t.Run("should advance by "+c.name, func(t *testing.T) {
+			if got := Advance(start, c.interval, c.count); !got.Equal(c.expected) {
+				t.Errorf("expected %s, got %s", c.expected, got)
+			}
+		})
+	}
+}
+
+func TestSweep(t *testing.T) {
+	due := func(failedAttempts int) *mockSubscriptionStore {
+		status := types.SubscriptionActive
+		if failedAttempts > 0 {
+			status = types.SubscriptionPastDue
+		}
+
+		return &mockSubscriptionStore{due: []types.Subscription{{
+			ID:             1,
+			UserID:         2,
+			Quantity:       3,
+			Status:         status,
+			Currency:       "USD",
+			PaymentSource:  "tok_visa",
+			NextRunAt:      time.Now().Add(-time.Hour),
+			FailedAttempts: failedAttempts,
+			Plan:           types.SubscriptionPlan{ProductID: 5, Name: "Coffee", Interval: types.IntervalMonth, IntervalCount: 1, PercentOff: 10},
+		}}}
+	}
+
+	t.Run("should place the order at the plan's discount and schedule the next", func(t *testing.T) {
+		store, orders, notifier := due(0), &mockOrderPlacer{}, &mockNotifier{}
+		scheduler := NewScheduler(store, orders, &mockPayments{status: types.PaymentCaptured}, &mockUserStore{}, notifier, time.Hour, 3)
+
+		if err := scheduler.Sweep(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		request := orders.placed[0]
+		if request.Items[0].ProductID != 5 || request.Items[0].Quantity != 3 || request.PercentOff != 10 {
+			t.Errorf("expected 3 of product 5 at 10%% off, got %+v", request)
+		}
+
+		run, sub := store.runs[0], store.saved[len(store.saved)-1]
+		if run.Status != types.SubscriptionRunPaid || *run.OrderID != 100 {
+			t.Errorf("expected a paid run for order 100, got %+v", run)
+		}
+		if !sub.NextRunAt.After(time.Now().AddDate(0, 0, 27)) || sub.Status != types.SubscriptionActive {
+			t.Errorf("expected the next order a month on, got %s", sub.NextRunAt)
+		}
+		if notifier.sent[0].Event != EventOrderPlaced || notifier.sent[0].Recipient != "customer@example.com" {
+			t.Errorf("expected the customer to be told, got %+v", notifier.sent)
+		}
+	})
+
+	t.Run("should move to the next date with the order before charging for it", func(t *testing.T) {
+		store := due(1)
+		payments := &mockPayments{status: types.PaymentCaptured, store: store}
+		scheduler := NewScheduler(store, &mockOrderPlacer{}, payments, &mockUserStore{}, &mockNotifier{}, time.Hour, 3)
+
+		if err := scheduler.Sweep(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		charged := payments.recorded[0]
+		if !charged.NextRunAt.After(time.Now()) || charged.Status != types.SubscriptionActive || charged.RetryAt != nil {
+			t.Errorf("expected the subscription moved on before the charge, got %+v", charged)
+		}
+		if len(store.runs) != 1 || store.runs[0].Status != types.SubscriptionRunPaid {
+			t.Errorf("expected the run recorded with the order to be marked paid, got %+v", store.runs)
+		}
+	})
+
+	t.Run("should retry a declined payment later and later", func(t *testing.T) {
+		store, notifier := due(1), &mockNotifier{}
+		scheduler := NewScheduler(store, &mockOrderPlacer{}, &mockPayments{status: types.PaymentDeclined}, &mockUserStore{}, notifier, time.Hour, 3)
+
+		if err := scheduler.Sweep(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		sub := store.saved[len(store.saved)-1]
+		if sub.Status != types.SubscriptionPastDue || sub.FailedAttempts != 2 {
+			t.Errorf("expected past due after 2 attempts, got %s after %d", sub.Status, sub.FailedAttempts)
+		}
+		if wait := time.Until(*sub.RetryAt); wait < 119*time.Minute || wait > 2*time.Hour {
+			t.Errorf("expected a retry in 2 hours, got %s", wait)
+		}
+		if store.runs[0].Status != types.SubscriptionRunFailed || notifier.sent[0].Event != EventPaymentFailed {
+			t.Errorf("expected a failed run and the customer told, got %+v", store.runs[0])
+		}
+	})
+
+	t.Run("should keep the schedule when a retry succeeds", func(t *testing.T) {
+		store := due(2)
+		scheduled := store.due[0].NextRunAt
+		scheduler := NewScheduler(store, &mockOrderPlacer{}, &mockPayments{status: types.PaymentCaptured}, &mockUserStore{}, &mockNotifier{}, time.Hour, 3)
+
+		if err := scheduler.Sweep(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		sub := store.saved[len(store.saved)-1]
+		if !sub.NextRunAt.Equal(scheduled.AddDate(0, 1, 0)) || sub.FailedAttempts != 0 || sub.RetryAt != nil {
+			t.Errorf("expected the next order a month after the last was due, got %+v", sub)
+		}
+	})
+
+	t.Run("should cancel once out of retries", func(t *testing.T) {
+		store, notifier := due(3), &mockNotifier{}
+		scheduler := NewScheduler(store, &mockOrderPlacer{err: fmt.Errorf("product 5 is out of stock")}, &mockPayments{}, &mockUserStore{}, notifier, time.Hour, 3)
+
+		if err := scheduler.Sweep(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		sub := store.saved[len(store.saved)-1]
+		if sub.Status != types.SubscriptionCancelled || sub.CancelledAt == nil {
+			t.Errorf("expected the subscription cancelled, got %s", sub.Status)
+		}
+		if store.runs[0].OrderID != nil || notifier.sent[0].Event != EventCancelled {
+			t.Errorf("expected a run without an order and the customer told, got %+v", store.runs[0])
+		}
+	})
+}
+
+func TestSkip(t *testing.T) {
+	plan := types.SubscriptionPlan{Interval: types.IntervalWeek, IntervalCount: 1}
+	next := time.Date(2025, time.March, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("should give up on a past due order", func(t *testing.T) {
+		retryAt := next.Add(time.Hour)
+		sub := types.Subscription{Status: types.SubscriptionPastDue, NextRunAt: next, RetryAt: &retryAt, FailedAttempts: 2, Plan: plan}
+
+		if err := Skip(&sub); err != nil {
+			t.Fatal(err)
+		}
+
+		if sub.Status != types.SubscriptionActive || sub.RetryAt != nil || !sub.NextRunAt.Equal(next.AddDate(0, 0, 7)) {
+			t.Errorf("expected the next week's order, got %+v", sub)
+		}
+	})
+
+	t.Run("should refuse a cancelled subscription", func(t *testing.T) {
+		sub := types.Subscription{Status: types.SubscriptionCancelled, NextRunAt: next, Plan: plan}
+
+		if err := Skip(&sub); subscriptionStatus(err) != http.StatusBadRequest {
+			t.Errorf("expected a refusal, got %v", err)
+		}
+	})
+}
+
+type mockSubscriptionStore struct {
+	types.SubscriptionStore
+	due   []types.Subscription
+	runs  []types.SubscriptionRun
+	saved []types.Subscription
+}
+
+func (m *mockSubscriptionStore) GetDueSubscriptions(ctx context.Context, at time.Time) ([]types.Subscription, error) {
+	return m.due, nil
+}
+
+func (m *mockSubscriptionStore) WithTx(tx types.DB) types.SubscriptionStore {
+	return m
+}
+
+func (m *mockSubscriptionStore) RecordRun(ctx context.Context, run types.SubscriptionRun, subscription types.Subscription) (int, error) {
+	m.saved = append(m.saved, subscription)
+	if run.ID != 0 {
+		m.runs[run.ID-1] = run
+		return run.ID, nil
+	}
+
+	m.runs = append(m.runs, run)
+	return len(m.runs), nil
+}
+
+type mockOrderPlacer struct {
+	placed []types.PlaceOrderRequest
+	err    error
+}
+
+func (m *mockOrderPlacer) PlaceOrder(ctx context.Context, request types.PlaceOrderRequest) (*types.Order, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	m.placed = append(m.placed, request)
+
+	order := types.Order{ID: 100, UserId: request.UserID, Total: money.New(2700, request.Currency), Status: types.Pending}
+	if request.Placed != nil {
+		if err := request.Placed(nil, order); err != nil {
+			return nil, err
+		}
+	}
+
+	return &order, nil
+}
+
+// mockPayments keeps what the store had saved of the subscription at each
+// charge.
+type mockPayments struct {
+	types.PaymentProcessor
+	status   types.PaymentStatus
+	store    *mockSubscriptionStore
+	recorded []types.Subscription
+}
+
+func (m *mockPayments) Charge(ctx context.Context, order types.Order, source string) (*types.Payment, error) {
+	if m.store != nil {
+		m.recorded = append(m.recorded, m.store.saved[len(m.store.saved)-1])
+	}
+	return &types.Payment{OrderID: order.ID, Status: m.status, Amount: order.Total}, nil
+}
+
+type mockUserStore struct {
+	types.UserStore
+}
+
+func (m *mockUserStore) GetUserById(ctx context.Context, id int) (*types.User, error) {
+	return &types.User{ID: id, Email: "customer@example.com"}, nil
+}
+
+type mockNotifier struct {
+	sent []types.Notification
+}
+
+func (m *mockNotifier) Notify(notification types.Notification) error {
+	m.sent = append(m.sent, notification)
+	return nil
+}
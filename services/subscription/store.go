@@ -0,0 +1,308 @@
+package subscription
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/xelathan/golang_backend/db"
+	"github.com/xelathan/golang_backend/types"
+)
+
+var (
+	ErrNotFound     = errors.New("subscription not found")
+	ErrPlanNotFound = errors.New("subscription plan not found")
+)
+
+const selectPlans = "SELECT id, productId, name, `interval`, intervalCount, percentOff, active, createdAt FROM subscription_plans"
+
+const selectSubscriptions = "SELECT s.id, s.userId, s.planId, s.quantity, s.status, s.currency, s.paymentSource, s.country, s.region, s.postalCode, " +
+	"s.shippingMethodId, s.nextRunAt, s.retryAt, s.failedAttempts, s.createdAt, s.cancelledAt, " +
+	"p.id, p.productId, p.name, p.`interval`, p.intervalCount, p.percentOff, p.active, p.createdAt " +
+	"FROM subscriptions s JOIN subscription_plans p ON p.id = s.planId"
+
+type Store struct {
+	db types.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+func (s *Store) WithTx(tx types.DB) types.SubscriptionStore {
+	return &Store{db: tx}
+}
+
+func (s *Store) CreatePlan(ctx context.Context, plan types.SubscriptionPlan) (int, error) {
+	res, err := s.db.ExecContext(ctx,
+		"INSERT INTO subscription_plans (productId, name, `interval`, intervalCount, percentOff, active) VALUES (?,?,?,?,?,?)",
+		plan.ProductID, plan.Name, plan.Interval, plan.IntervalCount, plan.PercentOff, plan.Active,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+func (s *Store) GetPlanById(ctx context.Context, id int) (*types.SubscriptionPlan, error) {
+	plans, err := s.getPlans(ctx, " WHERE id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(plans) == 0 {
+		return nil, ErrPlanNotFound
+	}
+
+	return &plans[0], nil
+}
+
+func (s *Store) GetPlansByProductId(ctx context.Context, productId int) ([]types.SubscriptionPlan, error) {
+	return s.getPlans(ctx, " WHERE productId = ? ORDER BY id", productId)
+}
+
+func (s *Store) getPlans(ctx context.Context, suffix string, args ...any) ([]types.SubscriptionPlan, error) {
+	rows, err := s.db.QueryContext(ctx, selectPlans+suffix, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	plans := []types.SubscriptionPlan{}
+	for rows.Next() {
+		p := types.SubscriptionPlan{}
+		if err := rows.Scan(&p.ID, &p.ProductID, &p.Name, &p.Interval, &p.IntervalCount, &p.PercentOff, &p.Active, &p.CreatedAt); err != nil {
+			return nil, err
+		}
+
+		plans = append(plans, p)
+	}
+
+	return plans, rows.Err()
+}
+
+func (s *Store) SetPlanActive(ctx context.Context, id int, active bool) error {
+	res, err := s.db.ExecContext(ctx, "UPDATE subscription_plans SET active = ? WHERE id = ?", active, id)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		var exists bool
+		if err := s.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM subscription_plans WHERE id = ?)", id).Scan(&exists); err != nil {
+			return err
+		}
+
+		if !exists {
+			return ErrPlanNotFound
+		}
+	}
+
+	return nil
+}
+
+func (s *Store) CreateSubscription(ctx context.Context, subscription types.Subscription) (int, error) {
+	country, region, postalCode := destinationColumns(subscription.Destination)
+	res, err := s.db.ExecContext(ctx,
+		"INSERT INTO subscriptions (userId, planId, quantity, status, currency, paymentSource, country, region, postalCode, shippingMethodId, nextRunAt) VALUES (?,?,?,?,?,?,?,?,?,?,?)",
+		subscription.UserID, subscription.PlanID, subscription.Quantity, subscription.Status, subscription.Currency, subscription.PaymentSource,
+		country, region, postalCode, nullableId(subscription.ShippingMethodID), subscription.NextRunAt,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+func (s *Store) GetSubscriptionById(ctx context.Context, id int) (*types.Subscription, error) {
+	subscriptions, err := s.getSubscriptions(ctx, " WHERE s.id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(subscriptions) == 0 {
+		return nil, ErrNotFound
+	}
+
+	return &subscriptions[0], nil
+}
+
+func (s *Store) GetSubscriptionsByUserId(ctx context.Context, userId int) ([]types.Subscription, error) {
+	return s.getSubscriptions(ctx, " WHERE s.userId = ? ORDER BY s.id DESC", userId)
+}
+
+func (s *Store) GetDueSubscriptions(ctx context.Context, at time.Time) ([]types.Subscription, error) {
+	return s.getSubscriptions(ctx,
+		" WHERE p.active AND ((s.status = ? AND s.nextRunAt <= ?) OR (s.status = ? AND s.retryAt <= ?)) ORDER BY s.id",
+		types.SubscriptionActive, at, types.SubscriptionPastDue, at,
+	)
+}
+
+func (s *Store) getSubscriptions(ctx context.Context, suffix string, args ...any) ([]types.Subscription, error) {
+	rows, err := s.db.QueryContext(ctx, selectSubscriptions+suffix, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subscriptions := []types.Subscription{}
+	for rows.Next() {
+		sub := types.Subscription{}
+		var country sql.NullString
+		var region, postalCode string
+		var shippingMethodId sql.NullInt64
+		var retryAt, cancelledAt sql.NullTime
+		err := rows.Scan(
+			&sub.ID, &sub.UserID, &sub.PlanID, &sub.Quantity, &sub.Status, &sub.Currency, &sub.PaymentSource, &country, &region, &postalCode,
+			&shippingMethodId, &sub.NextRunAt, &retryAt, &sub.FailedAttempts, &sub.CreatedAt, &cancelledAt,
+			&sub.Plan.ID, &sub.Plan.ProductID, &sub.Plan.Name, &sub.Plan.Interval, &sub.Plan.IntervalCount, &sub.Plan.PercentOff, &sub.Plan.Active, &sub.Plan.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if country.Valid {
+			sub.Destination = &types.Destination{Country: country.String, Region: region, PostalCode: postalCode}
+		}
+		sub.ShippingMethodID = int(shippingMethodId.Int64)
+		if retryAt.Valid {
+			sub.RetryAt = &retryAt.Time
+		}
+		if cancelledAt.Valid {
+			sub.CancelledAt = &cancelledAt.Time
+		}
+
+		subscriptions = append(subscriptions, sub)
+	}
+
+	return subscriptions, rows.Err()
+}
+
+func (s *Store) UpdateSubscription(ctx context.Context, subscription types.Subscription) error {
+	return updateSubscription(ctx, s.db, subscription)
+}
+
+func updateSubscription(ctx context.Context, tx types.DB, subscription types.Subscription) error {
+	res, err := tx.ExecContext(ctx,
+		"UPDATE subscriptions SET status = ?, paymentSource = ?, nextRunAt = ?, retryAt = ?, failedAttempts = ?, cancelledAt = ? WHERE id = ?",
+		subscription.Status, subscription.PaymentSource, subscription.NextRunAt, subscription.RetryAt, subscription.FailedAttempts, subscription.CancelledAt,
+		subscription.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		var exists bool
+		if err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM subscriptions WHERE id = ?)", subscription.ID).Scan(&exists); err != nil {
+			return err
+		}
+
+		if !exists {
+			return ErrNotFound
+		}
+	}
+
+	return nil
+}
+
+func (s *Store) RecordRun(ctx context.Context, run types.SubscriptionRun, subscription types.Subscription) (int, error) {
+	id := int64(run.ID)
+	err := db.InTxContext(ctx, s.db, func(tx types.DB) error {
+		if id != 0 {
+			_, err := tx.ExecContext(ctx,
+				"UPDATE subscription_runs SET orderId = ?, status = ?, reason = ? WHERE id = ?",
+				run.OrderID, run.Status, run.Reason, id,
+			)
+			if err != nil {
+				return err
+			}
+
+			return updateSubscription(ctx, tx, subscription)
+		}
+
+		res, err := tx.ExecContext(ctx,
+			"INSERT INTO subscription_runs (subscriptionId, orderId, status, reason) VALUES (?,?,?,?)",
+			run.SubscriptionID, run.OrderID, run.Status, run.Reason,
+		)
+		if err != nil {
+			return err
+		}
+
+		id, err = res.LastInsertId()
+		if err != nil {
+			return err
+		}
+
+		return updateSubscription(ctx, tx, subscription)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+func (s *Store) GetSubscriptionRuns(ctx context.Context, subscriptionId int) ([]types.SubscriptionRun, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT id, subscriptionId, orderId, status, reason, createdAt FROM subscription_runs WHERE subscriptionId = ? ORDER BY id DESC",
+		subscriptionId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []types.SubscriptionRun{}
+	for rows.Next() {
+		run := types.SubscriptionRun{}
+		var orderId sql.NullInt64
+		if err := rows.Scan(&run.ID, &run.SubscriptionID, &orderId, &run.Status, &run.Reason, &run.CreatedAt); err != nil {
+			return nil, err
+		}
+
+		if orderId.Valid {
+			id := int(orderId.Int64)
+			run.OrderID = &id
+		}
+
+		runs = append(runs, run)
+	}
+
+	return runs, rows.Err()
+}
+
+func destinationColumns(destination *types.Destination) (sql.NullString, string, string) {
+	if destination == nil {
+		return sql.NullString{}, "", ""
+	}
+
+	return sql.NullString{String: destination.Country, Valid: true}, destination.Region, destination.PostalCode
+}
+
+func nullableId(id int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(id), Valid: id != 0}
+}
@@ -0,0 +1,156 @@
+package tax
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/xelathan/golang_backend/money"
+	"github.com/xelathan/golang_backend/types"
+)
+
+// TableCalculator calculates tax from the rates table. For every line the
+// most specific rate of the line's tax class wins: a matching postal prefix
+// beats a region, a longer prefix beats a shorter one, and a region beats a
+// country-wide rate. Lines whose class has no rate are not taxed.
+type TableCalculator struct {
+	store types.TaxStore
+}
+
+func NewTableCalculator(store types.TaxStore) *TableCalculator {
+	return &TableCalculator{store: store}
+}
+
+func (c *TableCalculator) Calculate(ctx context.Context, lines []types.TaxableLine, destination types.Destination, at time.Time) ([]types.TaxLine, error) {
+	destination = NormalizeDestination(destination)
+
+	rates, err := c.store.GetTaxRates(ctx, destination.Country, at)
+	if err != nil {
+		return nil, err
+	}
+
+	return CalculateWithRates(rates, lines, destination)
+}
+
+// CalculateWithRates applies already loaded rates to the lines.
+func CalculateWithRates(rates []types.TaxRate, lines []types.TaxableLine, destination types.Destination) ([]types.TaxLine, error) {
+	taxLines := []types.TaxLine{}
+	for i, line := range lines {
+		taxClass := line.TaxClass
+		if taxClass == "" {
+			taxClass = types.DefaultTaxClass
+		}
+
+		rate, ok := matchRate(rates, taxClass, destination)
+		if !ok || !line.Amount.IsPositive() {
+			continue
+		}
+
+		amount, err := taxOn(line.Amount, rate)
+		if err != nil {
+			return nil, err
+		}
+
+		taxLines = append(taxLines, types.TaxLine{
+			Line:      i,
+			ProductID: line.ProductID,
+			TaxRateID: rate.ID,
+			Name:      rate.Name,
+			Rate:      rate.Rate,
+			Inclusive: rate.Inclusive,
+			Taxable:   line.Amount,
+			Amount:    amount,
+		})
+	}
+
+	return taxLines, nil
+}
+
+func matchRate(rates []types.TaxRate, taxClass string, destination types.Destination) (types.TaxRate, bool) {
+	best, bestScore := types.TaxRate{}, -1
+	for _, rate := range rates {
+		if rate.TaxClass != taxClass || !strings.EqualFold(rate.Country, destination.Country) {
+			continue
+		}
+
+		if rate.Region != "" && !strings.EqualFold(rate.Region, destination.Region) {
+			continue
+		}
+
+		prefix := normalizePostalCode(rate.PostalPrefix)
+		if prefix != "" && !strings.HasPrefix(destination.PostalCode, prefix) {
+			continue
+		}
+
+		score := 0
+		if rate.Region != "" {
+			score = 1
+		}
+		if prefix != "" {
+			score = 2 + len(prefix)
+		}
+
+		// on a tie the rate that took effect last wins
+		if score > bestScore || score == bestScore && rate.EffectiveFrom.After(best.EffectiveFrom) {
+			best, bestScore = rate, score
+		}
+	}
+
+	return best, bestScore >= 0
+}
+
+// taxOn returns the tax of a rate on an amount, rounded half up to the minor
+// unit. For inclusive rates the tax is the part of the amount that is tax.
+func taxOn(amount money.Money, rate types.TaxRate) (money.Money, error) {
+	r, err := ParseRate(rate.Rate)
+	if err != nil {
+		return money.Money{}, err
+	}
+
+	if rate.Inclusive {
+		r.Quo(r, new(big.Rat).Add(big.NewRat(1, 1), r))
+	}
+
+	return amount.MulRat(r, money.HalfUp), nil
+}
+
+// ParseRate parses a tax rate given as a decimal fraction between 0 and 1.
+func ParseRate(value string) (*big.Rat, error) {
+	rate, ok := new(big.Rat).SetString(strings.TrimSpace(value))
+	if !ok || rate.Sign() < 0 || rate.Cmp(big.NewRat(1, 1)) >= 0 {
+		return nil, fmt.Errorf("invalid tax rate %q, expected a fraction such as 0.0825", value)
+	}
+
+	return rate, nil
+}
+
+// NormalizeDestination upper-cases the country and region and strips spaces
+// from the postal code so rates match however the address was typed.
+func NormalizeDestination(destination types.Destination) types.Destination {
+	destination.Country = strings.ToUpper(strings.TrimSpace(destination.Country))
+	destination.Region = strings.ToUpper(strings.TrimSpace(destination.Region))
+	destination.PostalCode = normalizePostalCode(destination.PostalCode)
+
+	return destination
+}
+
+func normalizePostalCode(postalCode string) string {
+	return strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(postalCode), " ", ""))
+}
+
+// Totals splits the tax of the lines into the part added on top of the
+// prices and the part already included in them.
+func Totals(taxLines []types.TaxLine, currency string) (added money.Money, included money.Money) {
+	added, included = money.Zero(currency), money.Zero(currency)
+	for _, line := range taxLines {
+		if line.Inclusive {
+			included = included.Add(line.Amount)
+		} else {
+			added = added.Add(line.Amount)
+		}
+	}
+
+	return added, included
+}
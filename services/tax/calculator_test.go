@@ -0,0 +1,83 @@
+package tax
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xelathan/golang_backend/money"
+	"github.com/xelathan/golang_backend/types"
+)
+
+func TestCalculateWithRates(t *testing.T) {
+	now := time.Now()
+	rates := []types.TaxRate{
+		{ID: 1, Country: "US", TaxClass: "standard", Name: "Federal", Rate: "0.05", EffectiveFrom: now},
+		{ID: 2, Country: "US", Region: "CA", TaxClass: "standard", Name: "California", Rate: "0.0725", EffectiveFrom: now},
+		{ID: 3, Country: "US", Region: "CA", PostalPrefix: "900", TaxClass: "standard", Name: "Los Angeles", Rate: "0.095", EffectiveFrom: now},
+		{ID: 4, Country: "DE", TaxClass: "standard", Name: "MwSt", Rate: "0.19", Inclusive: true, EffectiveFrom: now},
+		{ID: 5, Country: "DE", TaxClass: "reduced", Name: "MwSt reduced", Rate: "0.07", Inclusive: true, EffectiveFrom: now},
+	}
+
+	line := func(taxClass string, amount int64, currency string) []types.TaxableLine {
+		return []types.TaxableLine{{ProductID: 1, TaxClass: taxClass, Amount: money.New(amount, currency)}}
+	}
+
+	t.Run("should pick the most specific rate", func(t *testing.T) {
+		cases := []struct {
+			destination types.Destination
+			rateID      int
+		}{
+			{types.Destination{Country: "US", Region: "NY", PostalCode: "10001"}, 1},
+			{types.Destination{Country: "US", Region: "CA", PostalCode: "94103"}, 2},
+			{types.Destination{Country: "US", Region: "CA", PostalCode: "90012"}, 3},
+		}
+
+		for _, c := range cases {
+			taxLines, err := CalculateWithRates(rates, line("", 10000, "USD"), c.destination)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(taxLines) != 1 || taxLines[0].TaxRateID != c.rateID {
+				t.Errorf("expected rate %d for %+v, got %+v", c.rateID, c.destination, taxLines)
+			}
+		}
+	})
+
+	t.Run("should add exclusive tax rounded half up", func(t *testing.T) {
+		taxLines, err := CalculateWithRates(rates, line("standard", 1999, "USD"), types.Destination{Country: "US", Region: "CA"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// 19.99 * 7.25% = 1.449275
+		added, included := Totals(taxLines, "USD")
+		if added != money.New(145, "USD") || !included.IsZero() {
+			t.Errorf("expected 1.45 added, got %s added and %s included", added, included)
+		}
+	})
+
+	t.Run("should extract inclusive tax from the price", func(t *testing.T) {
+		taxLines, err := CalculateWithRates(rates, line("reduced", 1070, "EUR"), types.Destination{Country: "DE"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// 10.70 contains 0.70 of 7% tax
+		added, included := Totals(taxLines, "EUR")
+		if included != money.New(70, "EUR") || !added.IsZero() {
+			t.Errorf("expected 0.70 included, got %s added and %s included", added, included)
+		}
+	})
+
+	t.Run("should not tax classes without a rate", func(t *testing.T) {
+		taxLines, err := CalculateWithRates(rates, line("exempt", 1000, "USD"), types.Destination{Country: "US"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(taxLines) != 0 {
+			t.Errorf("expected no tax, got %+v", taxLines)
+		}
+	})
+}
@@ -0,0 +1,116 @@
+package tax
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"github.com/xelathan/golang_backend/services/auth"
+	"github.com/xelathan/golang_backend/types"
+	"github.com/xelathan/golang_backend/utils"
+)
+
+type Handler struct {
+	store     types.TaxStore
+	userStore types.UserStore
+}
+
+func NewHandler(store types.TaxStore, userStore types.UserStore) *Handler {
+	return &Handler{store: store, userStore: userStore}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/admin/tax_rates", auth.WithAdminAuth(h.handleGetTaxRates, h.userStore)).Methods(http.MethodGet)
+	router.HandleFunc("/admin/tax_rates", auth.WithAdminAuth(h.handleCreateTaxRate, h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/admin/tax_rates/{id}", auth.WithAdminAuth(h.handleEndTaxRate, h.userStore)).Methods(http.MethodDelete)
+}
+
+func (h *Handler) handleGetTaxRates(w http.ResponseWriter, r *http.Request) {
+	country := strings.ToUpper(r.URL.Query().Get("country"))
+
+	rates, err := h.store.ListTaxRates(r.Context(), country)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, rates)
+}
+
+func (h *Handler) handleCreateTaxRate(w http.ResponseWriter, r *http.Request) {
+	payload := types.CreateTaxRatePayload{}
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	if _, err := ParseRate(payload.Rate); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	destination := NormalizeDestination(types.Destination{
+		Country:    payload.Country,
+		Region:     payload.Region,
+		PostalCode: payload.PostalPrefix,
+	})
+
+	rate := types.TaxRate{
+		Country:       destination.Country,
+		Region:        destination.Region,
+		PostalPrefix:  destination.PostalCode,
+		TaxClass:      payload.TaxClass,
+		Name:          payload.Name,
+		Rate:          strings.TrimSpace(payload.Rate),
+		Inclusive:     payload.Inclusive,
+		EffectiveFrom: time.Now(),
+	}
+
+	if rate.TaxClass == "" {
+		rate.TaxClass = types.DefaultTaxClass
+	}
+
+	if payload.EffectiveFrom != nil {
+		rate.EffectiveFrom = *payload.EffectiveFrom
+	}
+
+	id, err := h.store.CreateTaxRate(r.Context(), rate)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	rate.ID = id
+
+	utils.WriteJSON(w, http.StatusCreated, rate)
+}
+
+func (h *Handler) handleEndTaxRate(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid tax rate id"))
+		return
+	}
+
+	if err := h.store.EndTaxRate(r.Context(), id, time.Now()); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			utils.WriteError(w, http.StatusNotFound, err)
+			return
+		}
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]string{"status": "ended"})
+}
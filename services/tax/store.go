@@ -0,0 +1,210 @@
+package tax
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/xelathan/golang_backend/db"
+	"github.com/xelathan/golang_backend/types"
+)
+
+var ErrNotFound = errors.New("tax rate not found")
+
+const selectTaxRates = "SELECT id, country, region, postalPrefix, taxClass, name, rate, inclusive, effectiveFrom, effectiveTo, createdAt FROM tax_rates"
+
+type Store struct {
+	db types.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+func (s *Store) WithTx(tx types.DB) types.TaxStore {
+	return &Store{db: tx}
+}
+
+func (s *Store) GetTaxRates(ctx context.Context, country string, at time.Time) ([]types.TaxRate, error) {
+	rows, err := s.db.QueryContext(ctx, selectTaxRates+" WHERE country = ? AND effectiveFrom <= ? AND (effectiveTo IS NULL OR effectiveTo > ?)", country, at, at)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanRowsIntoTaxRates(rows)
+}
+
+func (s *Store) ListTaxRates(ctx context.Context, country string) ([]types.TaxRate, error) {
+	query, args := selectTaxRates, []interface{}{}
+	if country != "" {
+		query += " WHERE country = ?"
+		args = append(args, country)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query+" ORDER BY country, region, postalPrefix, taxClass, effectiveFrom", args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanRowsIntoTaxRates(rows)
+}
+
+// CreateTaxRate adds a new version of the rate for its jurisdiction and tax
+// class. The version in effect at rate.EffectiveFrom is closed then and the
+// new one is bounded by the next version already scheduled, if any.
+func (s *Store) CreateTaxRate(ctx context.Context, rate types.TaxRate) (int, error) {
+	region, postalPrefix := nullableString(rate.Region), nullableString(rate.PostalPrefix)
+	key := "country = ? AND region <=> ? AND postalPrefix <=> ? AND taxClass = ?"
+	keyArgs := []interface{}{rate.Country, region, postalPrefix, rate.TaxClass}
+
+	var id int64
+	err := db.InTxContext(ctx, s.db, func(tx types.DB) error {
+		next := sql.NullTime{}
+		err := tx.QueryRowContext(ctx, "SELECT MIN(effectiveFrom) FROM tax_rates WHERE "+key+" AND effectiveFrom > ?", append(keyArgs, rate.EffectiveFrom)...).Scan(&next)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, "UPDATE tax_rates SET effectiveTo = ? WHERE "+key+" AND effectiveFrom <= ? AND (effectiveTo IS NULL OR effectiveTo > ?)",
+			append(append([]interface{}{rate.EffectiveFrom}, keyArgs...), rate.EffectiveFrom, rate.EffectiveFrom)...)
+		if err != nil {
+			return err
+		}
+
+		res, err := tx.ExecContext(ctx,
+			"INSERT INTO tax_rates (country, region, postalPrefix, taxClass, name, rate, inclusive, effectiveFrom, effectiveTo) VALUES (?,?,?,?,?,?,?,?,?)",
+			rate.Country, region, postalPrefix, rate.TaxClass, rate.Name, rate.Rate, rate.Inclusive, rate.EffectiveFrom, next,
+		)
+		if err != nil {
+			return err
+		}
+
+		id, err = res.LastInsertId()
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// EndTaxRate stops a rate from applying after the given time. Its history is
+// kept for the orders that used it.
+func (s *Store) EndTaxRate(ctx context.Context, id int, at time.Time) error {
+	res, err := s.db.ExecContext(ctx, "UPDATE tax_rates SET effectiveTo = ? WHERE id = ? AND (effectiveTo IS NULL OR effectiveTo > ?)", at, id, at)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *Store) RecordOrderItemTaxes(ctx context.Context, taxes []types.OrderItemTax) error {
+	if len(taxes) == 0 {
+		return nil
+	}
+
+	return db.InTxContext(ctx, s.db, func(tx types.DB) error {
+		for _, t := range taxes {
+			_, err := tx.ExecContext(ctx,
+				"INSERT INTO order_item_taxes (orderId, orderItemId, taxRateId, name, rate, inclusive, taxable, amount, currency) VALUES (?,?,?,?,?,?,?,?,?)",
+				t.OrderID, t.OrderItemID, t.TaxRateID, t.Name, t.Rate, t.Inclusive, t.Taxable.Amount, t.Amount.Amount, t.Amount.Currency,
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (s *Store) GetOrderItemTaxes(ctx context.Context, orderId int) ([]types.OrderItemTax, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT id, orderId, orderItemId, taxRateId, name, rate, inclusive, taxable, amount, currency FROM order_item_taxes WHERE orderId = ? ORDER BY id", orderId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	taxes := []types.OrderItemTax{}
+	for rows.Next() {
+		t := types.OrderItemTax{}
+		err := rows.Scan(&t.ID, &t.OrderID, &t.OrderItemID, &t.TaxRateID, &t.Name, &t.Rate, &t.Inclusive, &t.Taxable.Amount, &t.Amount.Amount, &t.Amount.Currency)
+		if err != nil {
+			return nil, err
+		}
+		t.Taxable.Currency = t.Amount.Currency
+
+		taxes = append(taxes, t)
+	}
+
+	return taxes, rows.Err()
+}
+
+func (s *Store) UpdateOrderItemTaxes(ctx context.Context, taxes []types.OrderItemTax) error {
+	if len(taxes) == 0 {
+		return nil
+	}
+
+	return db.InTxContext(ctx, s.db, func(tx types.DB) error {
+		for _, t := range taxes {
+			if _, err := tx.ExecContext(ctx, "UPDATE order_item_taxes SET taxable = ?, amount = ? WHERE id = ?", t.Taxable.Amount, t.Amount.Amount, t.ID); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func scanRowsIntoTaxRates(rows *sql.Rows) ([]types.TaxRate, error) {
+	defer rows.Close()
+
+	rates := []types.TaxRate{}
+	for rows.Next() {
+		rate := types.TaxRate{}
+		var region, postalPrefix sql.NullString
+		var effectiveTo sql.NullTime
+		err := rows.Scan(
+			&rate.ID,
+			&rate.Country,
+			&region,
+			&postalPrefix,
+			&rate.TaxClass,
+			&rate.Name,
+			&rate.Rate,
+			&rate.Inclusive,
+			&rate.EffectiveFrom,
+			&effectiveTo,
+			&rate.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		rate.Region = region.String
+		rate.PostalPrefix = postalPrefix.String
+		if effectiveTo.Valid {
+			rate.EffectiveTo = &effectiveTo.Time
+		}
+
+		rates = append(rates, rate)
+	}
+
+	return rates, rows.Err()
+}
+
+func nullableString(value string) sql.NullString {
+	return sql.NullString{String: value, Valid: value != ""}
+}
@@ -2,6 +2,7 @@ package user
 
 import (
 	"fmt"
+	"log"
 	"net/http"
 
 	"github.com/go-playground/validator/v10"
@@ -13,11 +14,12 @@ import (
 )
 
 type Handler struct {
-	store types.UserStore
+	store      types.UserStore
+	cartMerger types.CartMerger
 }
 
-func NewHandler(store types.UserStore) *Handler {
-	return &Handler{store: store}
+func NewHandler(store types.UserStore, cartMerger types.CartMerger) *Handler {
+	return &Handler{store: store, cartMerger: cartMerger}
 }
 
 func (h *Handler) RegisterRoutes(router *mux.Router) {
@@ -41,7 +43,7 @@ func (h *Handler) handleLogin(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// check if user currently exists
-	user, err := h.store.GetUserByEmail(payload.Email)
+	user, err := h.store.GetUserByEmail(r.Context(), payload.Email)
 	if err != nil {
 		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("user with email %s doest not exist", payload.Email))
 		return
@@ -60,7 +62,12 @@ func (h *Handler) handleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = utils.WriteJSON(w, http.StatusOK, map[string]string{"token": token})
+	response := map[string]any{"token": token}
+	if adjustments, ok := h.mergeGuestCart(r, user.ID); ok {
+		response["cartAdjustments"] = adjustments
+	}
+
+	err = utils.WriteJSON(w, http.StatusOK, response)
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
@@ -84,7 +91,7 @@ func (h *Handler) handleRegister(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// check if user currently exists
-	user, err := h.store.GetUserByEmail(payload.Email)
+	user, err := h.store.GetUserByEmail(r.Context(), payload.Email)
 	if err != nil && err.Error() != "user not found" || user != nil {
 		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("user with email %s already exists", payload.Email))
 		return
@@ -97,7 +104,7 @@ func (h *Handler) handleRegister(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// if does not exist create the new user
-	err = h.store.CreateUser(
+	err = h.store.CreateUser(r.Context(),
 		types.User{
 			FirstName: payload.FirstName,
 			LastName:  payload.LastName,
@@ -111,7 +118,20 @@ func (h *Handler) handleRegister(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = utils.WriteJSON(w, http.StatusCreated, map[string]string{"registered": payload.Email})
+	response := map[string]any{"registered": payload.Email}
+	if r.Header.Get(auth.CartTokenHeader) != "" {
+		created, err := h.store.GetUserByEmail(r.Context(), payload.Email)
+		if err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		if adjustments, ok := h.mergeGuestCart(r, created.ID); ok {
+			response["cartAdjustments"] = adjustments
+		}
+	}
+
+	err = utils.WriteJSON(w, http.StatusCreated, response)
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
@@ -147,7 +167,7 @@ func (h *Handler) handleSetAddresses(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// insert addresses into store
-	if err := h.store.CreateUpdateAddress(&userAddresses); err != nil {
+	if err := h.store.CreateUpdateAddress(r.Context(), &userAddresses); err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
 	}
@@ -155,6 +175,24 @@ func (h *Handler) handleSetAddresses(w http.ResponseWriter, r *http.Request) {
 	utils.WriteJSON(w, http.StatusCreated, map[string]string{"status": "success"})
 }
 
+// mergeGuestCart merges the guest cart named by the request's cart token into
+// the user's cart. A failed merge does not fail the login; the guest cart is
+// left as it was and ok is false.
+func (h *Handler) mergeGuestCart(r *http.Request, userId int) ([]types.CartAdjustment, bool) {
+	cartToken := r.Header.Get(auth.CartTokenHeader)
+	if cartToken == "" || h.cartMerger == nil {
+		return nil, false
+	}
+
+	adjustments, err := h.cartMerger.MergeGuestCart(r.Context(), cartToken, userId)
+	if err != nil {
+		log.Printf("merging guest cart into user %d: %v", userId, err)
+		return nil, false
+	}
+
+	return adjustments, true
+}
+
 func encryptAddress(address string) (string, error) {
 	if address != "" {
 		encrypted_address, err := auth.EncryptAES(address, []byte(config.Envs.EncryptionKey))
@@ -2,6 +2,7 @@ package user
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
@@ -13,7 +14,7 @@ import (
 
 func TestUserServiceHandlers(t *testing.T) {
 	userStore := &mockUserStore{}
-	handler := NewHandler(userStore)
+	handler := NewHandler(userStore, nil)
 
 	t.Run("should fail if the user payload is invalid", func(t *testing.T) {
 		payload := types.RegisterUserPayload{
@@ -66,21 +67,25 @@ func TestUserServiceHandlers(t *testing.T) {
 
 type mockUserStore struct{}
 
-func (m *mockUserStore) GetUserByEmail(email string) (*types.User, error) {
+func (m *mockUserStore) GetUserByEmail(ctx context.Context, email string) (*types.User, error) {
 	return nil, nil
 }
 
-func (m *mockUserStore) GetUserById(id int) (*types.User, error) {
+func (m *mockUserStore) GetUserById(ctx context.Context, id int) (*types.User, error) {
 	return nil, nil
 }
 
-func (m *mockUserStore) CreateUser(types.User) error {
+func (m *mockUserStore) CreateUser(context.Context, types.User) error {
 	return nil
 }
 
-func (m *mockUserStore) GetUserAddressesByUserId(id int) ([]types.UserAddresses, error) {
+func (m *mockUserStore) GetUserAddressesByUserId(ctx context.Context, id int) ([]types.UserAddresses, error) {
 	return nil, nil
 }
-func (m *mockUserStore) CreateUpdateAddress(*types.UserAddresses) error {
+func (m *mockUserStore) CreateUpdateAddress(context.Context, *types.UserAddresses) error {
 	return nil
 }
+
+func (m *mockUserStore) WithTx(tx types.DB) types.UserStore {
+	return m
+}
@@ -1,6 +1,7 @@
 package user
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 
@@ -8,15 +9,19 @@ import (
 )
 
 type Store struct {
-	db *sql.DB
+	db types.DB
 }
 
 func NewStore(db *sql.DB) *Store {
 	return &Store{db: db}
 }
 
-func (s *Store) GetUserByEmail(email string) (*types.User, error) {
-	rows, err := s.db.Query("SELECT * FROM users WHERE email = ?", email)
+func (s *Store) WithTx(tx types.DB) types.UserStore {
+	return &Store{db: tx}
+}
+
+func (s *Store) GetUserByEmail(ctx context.Context, email string) (*types.User, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT * FROM users WHERE email = ?", email)
 
 	if err != nil {
 		return nil, err
@@ -40,7 +45,7 @@ func (s *Store) GetUserByEmail(email string) (*types.User, error) {
 
 func scanRowIntoUser(rows *sql.Rows) (*types.User, error) {
 	user := new(types.User)
-	err := rows.Scan(&user.ID, &user.FirstName, &user.LastName, &user.Email, &user.Password, &user.CreatedAt)
+	err := rows.Scan(&user.ID, &user.FirstName, &user.LastName, &user.Email, &user.Password, &user.CreatedAt, &user.Role)
 	if err != nil {
 		return nil, err
 	}
@@ -48,8 +53,8 @@ func scanRowIntoUser(rows *sql.Rows) (*types.User, error) {
 	return user, nil
 }
 
-func (s *Store) GetUserById(id int) (*types.User, error) {
-	rows, err := s.db.Query("SELECT * FROM users WHERE id = ?", id)
+func (s *Store) GetUserById(ctx context.Context, id int) (*types.User, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT * FROM users WHERE id = ?", id)
 	if err != nil {
 		return nil, err
 	}
@@ -70,19 +75,20 @@ func (s *Store) GetUserById(id int) (*types.User, error) {
 	return u, nil
 }
 
-func (s *Store) CreateUser(user types.User) error {
-	_, err := s.db.Exec("INSERT INTO users (firstName, lastName, email, password) VALUES (?,?,?,?)", user.FirstName, user.LastName, user.Email, user.Password)
+func (s *Store) CreateUser(ctx context.Context, user types.User) error {
+	_, err := s.db.ExecContext(ctx, "INSERT INTO users (firstName, lastName, email, password) VALUES (?,?,?,?)", user.FirstName, user.LastName, user.Email, user.Password)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (s *Store) GetUserAddressesByUserId(id int) ([]types.UserAddresses, error) {
-	rows, err := s.db.Query("SELECT * FROM user_addresses WHERE userId = ?", id)
+func (s *Store) GetUserAddressesByUserId(ctx context.Context, id int) ([]types.UserAddresses, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT * FROM user_addresses WHERE userId = ?", id)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	addresses := []types.UserAddresses{}
 	for rows.Next() {
@@ -107,9 +113,9 @@ func scanRowIntoUserAddresses(rows *sql.Rows) (*types.UserAddresses, error) {
 	return user_addresses, nil
 }
 
-func (s *Store) CreateUpdateAddress(addresses *types.UserAddresses) error {
+func (s *Store) CreateUpdateAddress(ctx context.Context, addresses *types.UserAddresses) error {
 	query := "INSERT INTO user_addresses (userId, address_type, address) VALUES (?,?,?) ON DUPLICATE KEY UPDATE address = VALUES(address)"
-	_, err := s.db.Exec(query, addresses.UserId, addresses.AddressType, addresses.Address)
+	_, err := s.db.ExecContext(ctx, query, addresses.UserId, addresses.AddressType, addresses.Address)
 	if err != nil {
 		return err
 	}
@@ -1,9 +1,30 @@
 package types
 
 import (
+	"context"
+	"database/sql"
+	"net/http"
 	"time"
+
+	"github.com/xelathan/golang_backend/money"
 )
 
+// DB is what stores run their queries on: the *sql.DB, or the *sql.Tx of a
+// unit of work when the store has been bound to one with WithTx.
+type DB interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// UnitOfWork runs work that has to be applied together in one transaction.
+type UnitOfWork interface {
+	// Do commits what fn does through stores bound to tx, or none of it if
+	// fn fails or ctx is done first. fn is run again when the transaction
+	// deadlocks, so it must not have effects outside of tx.
+	Do(ctx context.Context, fn func(tx DB) error) error
+}
+
 type RegisterUserPayload struct {
 	FirstName string `json:"firstName" validate:"required"`
 	LastName  string `json:"lastName" validate:"required"`
@@ -30,6 +51,13 @@ type SetAddressesPayload struct {
 	Address     string      `json:"address" validate:"required"`
 }
 
+type Role string
+
+const (
+	RoleCustomer Role = "customer"
+	RoleAdmin    Role = "admin"
+)
+
 type User struct {
 	ID        int       `json:"id"`
 	FirstName string    `json:"firstName"`
@@ -37,6 +65,7 @@ type User struct {
 	Email     string    `json:"email"`
 	Password  string    `json:"-"`
 	CreatedAt time.Time `json:"createdAt"`
+	Role      Role      `json:"role"`
 }
 
 type UserAddresses struct {
@@ -47,77 +76,729 @@ type UserAddresses struct {
 }
 
 type UserStore interface {
-	GetUserByEmail(email string) (*User, error)
-	GetUserById(id int) (*User, error)
-	CreateUser(User) error
-	GetUserAddressesByUserId(id int) ([]UserAddresses, error)
-	CreateUpdateAddress(*UserAddresses) error
+	GetUserByEmail(ctx context.Context, email string) (*User, error)
+	GetUserById(ctx context.Context, id int) (*User, error)
+	CreateUser(context.Context, User) error
+	GetUserAddressesByUserId(ctx context.Context, id int) ([]UserAddresses, error)
+	CreateUpdateAddress(context.Context, *UserAddresses) error
+	WithTx(tx DB) UserStore
 }
 
 type CreateProductPayload struct {
-	Name        string  `json:"name" validate:"required"`
-	Description string  `json:"description" validate:"required"`
-	Image       string  `json:"image" validate:"required"`
-	Price       float64 `json:"price" validate:"required,decimal2"`
-	Quantity    int     `json:"quantity"`
+	SKU              string      `json:"sku" validate:"omitempty,max=64"`
+	Name             string      `json:"name" validate:"required"`
+	Description      string      `json:"description" validate:"required"`
+	Image            string      `json:"image" validate:"required"`
+	Price            money.Money `json:"price" validate:"money_positive"`
+	Quantity         int         `json:"quantity"`
+	ReorderThreshold int         `json:"reorderThreshold" validate:"min=0"`
+	Category         string      `json:"category" validate:"omitempty,max=64"`
+	TaxClass         string      `json:"taxClass" validate:"omitempty,max=32"`
+	WeightGrams      int         `json:"weightGrams" validate:"min=0"`
+	LengthMm         int         `json:"lengthMm" validate:"min=0"`
+	WidthMm          int         `json:"widthMm" validate:"min=0"`
+	HeightMm         int         `json:"heightMm" validate:"min=0"`
+	GiftCard         bool        `json:"giftCard"`
+}
+
+type SetReorderThresholdPayload struct {
+	ReorderThreshold int `json:"reorderThreshold" validate:"min=0"`
+}
+
+// StockPolicy is what happens when a product is ordered beyond its stock:
+// the order is refused, or the units missing are backordered until the
+// product is replenished. Pre-orders are backorders for a product that is
+// not out yet and will be available from its AvailableAt.
+type StockPolicy string
+
+const (
+	StockDeny      StockPolicy = "deny"
+	StockBackorder StockPolicy = "backorder"
+	StockPreorder  StockPolicy = "preorder"
+)
+
+// SetStockPolicyPayload sets a product's stock policy. A BackorderLimit of 0
+// lets any number of units be backordered.
+type SetStockPolicyPayload struct {
+	Policy         StockPolicy `json:"policy" validate:"required,oneof=deny backorder preorder"`
+	BackorderLimit int         `json:"backorderLimit" validate:"min=0"`
+	AvailableAt    *time.Time  `json:"availableAt" validate:"required_if=Policy preorder"`
 }
 
 type Product struct {
-	ID          int       `json:"id"`
-	Quantity    int       `json:"quantity"`
-	Name        string    `json:"name"`
-	Description string    `json:"description"`
-	Image       string    `json:"image"`
-	Price       float64   `json:"price"`
-	CreatedAt   time.Time `json:"createdAt"`
+	ID               int         `json:"id"`
+	Quantity         int         `json:"quantity"`
+	Name             string      `json:"name"`
+	Description      string      `json:"description"`
+	Image            string      `json:"image"`
+	Price            money.Money `json:"price"`
+	CreatedAt        time.Time   `json:"createdAt"`
+	ReorderThreshold int         `json:"reorderThreshold"`
+	SKU              string      `json:"sku"`
+	Category         string      `json:"category"`
+	TaxClass         string      `json:"taxClass"`
+	WeightGrams      int         `json:"weightGrams"`
+	LengthMm         int         `json:"lengthMm"`
+	WidthMm          int         `json:"widthMm"`
+	HeightMm         int         `json:"heightMm"`
+	// Quantity is the stock that can be sold; Backordered counts the units
+	// ordered beyond it waiting for the product to be replenished.
+	StockPolicy    StockPolicy `json:"stockPolicy"`
+	BackorderLimit int         `json:"backorderLimit"`
+	Backordered    int         `json:"backordered"`
+	AvailableAt    *time.Time  `json:"availableAt"`
+	// GiftCard products are sold as gift cards worth their price, issued
+	// once the order is paid for.
+	GiftCard bool `json:"giftCard"`
+
+	// CompareAtPrice and Rating are filled in by handlers, they are not columns
+	CompareAtPrice *money.Money  `json:"compareAtPrice"`
+	Rating         ProductRating `json:"rating"`
+}
+
+// ProductPrice is one entry of a product's price history. Rows with a
+// CompareAtPrice are time-boxed sales that take precedence over the base
+// price while they are active.
+type ProductPrice struct {
+	ID             int          `json:"id"`
+	ProductID      int          `json:"productID"`
+	Price          money.Money  `json:"price"`
+	CompareAtPrice *money.Money `json:"compareAtPrice"`
+	EffectiveFrom  time.Time    `json:"effectiveFrom"`
+	EffectiveTo    *time.Time   `json:"effectiveTo"`
+	CreatedAt      time.Time    `json:"createdAt"`
+}
+
+type SchedulePricePayload struct {
+	Price          money.Money  `json:"price" validate:"money_positive"`
+	CompareAtPrice *money.Money `json:"compareAtPrice" validate:"omitempty,money_positive"`
+	EffectiveFrom  *time.Time   `json:"effectiveFrom"`
+	EffectiveTo    *time.Time   `json:"effectiveTo" validate:"required_with=CompareAtPrice"`
+}
+
+type PriceStore interface {
+	GetPriceHistory(ctx context.Context, productId int) ([]ProductPrice, error)
+	SchedulePrice(context.Context, ProductPrice) error
+	GetEffectivePrices(ctx context.Context, productIDs []int, at time.Time) (map[int]ProductPrice, error)
+}
+
+type ProductRating struct {
+	Average float64 `json:"average"`
+	Count   int     `json:"count"`
 }
 
 type ProductStore interface {
-	GetProducts() ([]Product, error)
-	GetProductsByID(productIDs []int) ([]Product, error)
-	CreateProduct(Product) error
-	UpdateProductBatch(map[int]Product) error
+	GetProducts(context.Context) ([]Product, error)
+	GetProductsByID(ctx context.Context, productIDs []int) ([]Product, error)
+	GetProductsBySKU(ctx context.Context, skus []string) ([]Product, error)
+	CreateProduct(context.Context, Product) error
+	UpdateProductBatch(context.Context, map[int]Product) error
+	UpdateReorderThreshold(ctx context.Context, productId int, threshold int) error
+	UpdateStockPolicy(ctx context.Context, productId int, payload SetStockPolicyPayload) error
+	UpsertProductsBySKU(context.Context, []Product) error
+	StreamProducts(context.Context, func(Product) error) error
+	// LockProductsByID is GetProductsByID that also locks the rows until
+	// the transaction the store is bound to ends.
+	LockProductsByID(ctx context.Context, productIDs []int) ([]Product, error)
+	WithTx(tx DB) ProductStore
+}
+
+// ImportRowError lists every problem found on one row of a catalog import.
+// Row is 1-based and counts data rows only, not the CSV header.
+type ImportRowError struct {
+	Row    int      `json:"row"`
+	SKU    string   `json:"sku"`
+	Errors []string `json:"errors"`
+}
+
+type ImportReport struct {
+	DryRun  bool             `json:"dryRun"`
+	Rows    int              `json:"rows"`
+	Created int              `json:"created"`
+	Updated int              `json:"updated"`
+	Failed  int              `json:"failed"`
+	Errors  []ImportRowError `json:"errors"`
+}
+
+// StockLevel is the last quantity the inventory monitor observed for a
+// product, used to detect threshold crossings between two checks.
+type StockLevel struct {
+	ProductID      int       `json:"productID"`
+	LastQuantity   int       `json:"lastQuantity"`
+	BelowThreshold bool      `json:"belowThreshold"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+}
+
+type StockSubscription struct {
+	ID         int        `json:"id"`
+	ProductID  int        `json:"productID"`
+	UserID     int        `json:"userID"`
+	Email      string     `json:"email"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	NotifiedAt *time.Time `json:"notifiedAt"`
+}
+
+type InventoryStore interface {
+	GetStockLevels(ctx context.Context, productIDs []int) (map[int]StockLevel, error)
+	UpsertStockLevel(context.Context, StockLevel) error
+	CreateStockSubscription(context.Context, StockSubscription) error
+	DeleteStockSubscription(ctx context.Context, productId int, userId int) error
+	GetPendingStockSubscriptions(ctx context.Context, productId int) ([]StockSubscription, error)
+	MarkStockSubscriptionNotified(ctx context.Context, id int) error
+}
+
+// Notification is a single message delivered through a Notifier. Event is a
+// machine readable name such as "stock.low" that webhook consumers can route on.
+type Notification struct {
+	Event     string `json:"event"`
+	Recipient string `json:"recipient"`
+	Subject   string `json:"subject"`
+	Message   string `json:"message"`
+}
+
+type Notifier interface {
+	Notify(Notification) error
+}
+
+type ReviewStatus string
+
+const (
+	ReviewPending  ReviewStatus = "pending"
+	ReviewApproved ReviewStatus = "approved"
+	ReviewRejected ReviewStatus = "rejected"
+)
+
+type Review struct {
+	ID           int          `json:"id"`
+	ProductID    int          `json:"productID"`
+	UserID       int          `json:"userID"`
+	Rating       int          `json:"rating"`
+	Title        string       `json:"title"`
+	Body         string       `json:"body"`
+	Status       ReviewStatus `json:"status"`
+	HelpfulVotes int          `json:"helpfulVotes"`
+	CreatedAt    time.Time    `json:"createdAt"`
+	UpdatedAt    time.Time    `json:"updatedAt"`
+}
+
+type CreateReviewPayload struct {
+	Rating int    `json:"rating" validate:"required,min=1,max=5"`
+	Title  string `json:"title" validate:"required,max=255"`
+	Body   string `json:"body" validate:"required"`
+}
+
+type ModerateReviewPayload struct {
+	Status ReviewStatus `json:"status" validate:"required,oneof=pending approved rejected"`
+}
+
+type ReviewStore interface {
+	GetReviewById(ctx context.Context, id int) (*Review, error)
+	GetReviewsByProductId(ctx context.Context, productId int, status ReviewStatus) ([]Review, error)
+	GetReviewsByStatus(ctx context.Context, status ReviewStatus) ([]Review, error)
+	CreateReview(context.Context, Review) (int, error)
+	UpdateReviewStatus(ctx context.Context, id int, status ReviewStatus) error
+	CreateHelpfulVote(ctx context.Context, reviewId int, userId int) error
+	GetRatingSummaries(ctx context.Context, productIDs []int) (map[int]ProductRating, error)
+}
+
+// ExchangeRate is how many units of Currency one unit of the base currency
+// buys. Rate is a decimal string so it is never rounded through a float.
+type ExchangeRate struct {
+	Currency  string    `json:"currency" validate:"required,len=3"`
+	Rate      string    `json:"rate" validate:"required,numeric"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type SetExchangeRatesPayload struct {
+	Rates []ExchangeRate `json:"rates" validate:"required,min=1,dive"`
+}
+
+type SetCurrencyPricePayload struct {
+	Price money.Money `json:"price" validate:"money_positive"`
+}
+
+type CurrencyStore interface {
+	GetExchangeRates(ctx context.Context) ([]ExchangeRate, error)
+	UpsertExchangeRates(context.Context, []ExchangeRate) error
+	GetCurrencyPrices(ctx context.Context, productIDs []int, currency string) (map[int]money.Money, error)
+	SetCurrencyPrice(ctx context.Context, productId int, price money.Money) error
+	DeleteCurrencyPrice(ctx context.Context, productId int, currency string) error
+}
+
+// Order.ExchangeRate snapshots the base to order currency rate used at
+// checkout, so Total can always be traced back to base prices.
+type PromotionType string
+
+const (
+	PromotionPercentage   PromotionType = "percentage"
+	PromotionFixed        PromotionType = "fixed"
+	PromotionBuyXGetY     PromotionType = "buy_x_get_y"
+	PromotionFreeShipping PromotionType = "free_shipping"
+)
+
+// Promotion is a discount that applies automatically when Code is empty and
+// only when its code is entered otherwise. AmountOff and MinOrderValue are in
+// the base currency and converted to the order currency at checkout. A
+// promotion without ProductIDs and Categories applies to every product.
+type Promotion struct {
+	ID            int           `json:"id"`
+	Name          string        `json:"name"`
+	Code          string        `json:"code,omitempty"`
+	Type          PromotionType `json:"type"`
+	PercentOff    int           `json:"percentOff"`
+	AmountOff     money.Money   `json:"amountOff"`
+	BuyQuantity   int           `json:"buyQuantity"`
+	GetQuantity   int           `json:"getQuantity"`
+	MinOrderValue money.Money   `json:"minOrderValue"`
+	StartsAt      time.Time     `json:"startsAt"`
+	EndsAt        *time.Time    `json:"endsAt"`
+	UsageLimit    *int          `json:"usageLimit"`
+	PerUserLimit  *int          `json:"perUserLimit"`
+	Exclusive     bool          `json:"exclusive"`
+	Priority      int           `json:"priority"`
+	Active        bool          `json:"active"`
+	CreatedAt     time.Time     `json:"createdAt"`
+	ProductIDs    []int         `json:"productIds"`
+	Categories    []string      `json:"categories"`
+}
+
+type CreatePromotionPayload struct {
+	Name          string        `json:"name" validate:"required"`
+	Code          string        `json:"code" validate:"omitempty,max=64"`
+	Type          PromotionType `json:"type" validate:"required,oneof=percentage fixed buy_x_get_y free_shipping"`
+	PercentOff    int           `json:"percentOff" validate:"min=0,max=100"`
+	AmountOff     *money.Money  `json:"amountOff" validate:"omitempty,money_positive"`
+	BuyQuantity   int           `json:"buyQuantity" validate:"min=0"`
+	GetQuantity   int           `json:"getQuantity" validate:"min=0"`
+	MinOrderValue *money.Money  `json:"minOrderValue" validate:"omitempty,money"`
+	StartsAt      *time.Time    `json:"startsAt"`
+	EndsAt        *time.Time    `json:"endsAt"`
+	UsageLimit    *int          `json:"usageLimit" validate:"omitempty,min=1"`
+	PerUserLimit  *int          `json:"perUserLimit" validate:"omitempty,min=1"`
+	Exclusive     bool          `json:"exclusive"`
+	Priority      int           `json:"priority"`
+	ProductIDs    []int         `json:"productIds"`
+	Categories    []string      `json:"categories" validate:"dive,max=64"`
+}
+
+type SetPromotionActivePayload struct {
+	Active bool `json:"active"`
+}
+
+// LineDiscount is the part of a promotion taken off one cart line. Line is
+// the index of the line in the checkout.
+type LineDiscount struct {
+	Line      int         `json:"line"`
+	ProductID int         `json:"productId"`
+	Amount    money.Money `json:"amount"`
+}
+
+type AppliedPromotion struct {
+	PromotionID  int            `json:"promotionId"`
+	Name         string         `json:"name"`
+	Code         string         `json:"code,omitempty"`
+	Amount       money.Money    `json:"amount"`
+	FreeShipping bool           `json:"freeShipping"`
+	Lines        []LineDiscount `json:"lines"`
+}
+
+// PromotionRedemption records one use of a promotion by an order. Reversed
+// redemptions no longer count towards usage limits.
+type PromotionRedemption struct {
+	ID           int         `json:"id"`
+	PromotionID  int         `json:"promotionId"`
+	OrderID      int         `json:"orderId"`
+	UserID       int         `json:"userId"`
+	Code         string      `json:"code,omitempty"`
+	Amount       money.Money `json:"amount"`
+	FreeShipping bool        `json:"freeShipping"`
+	CreatedAt    time.Time   `json:"createdAt"`
+	ReversedAt   *time.Time  `json:"reversedAt"`
+}
+
+type OrderItemDiscount struct {
+	ID          int         `json:"id"`
+	OrderID     int         `json:"orderId"`
+	OrderItemID int         `json:"orderItemId"`
+	PromotionID int         `json:"promotionId"`
+	Amount      money.Money `json:"amount"`
+}
+
+type PromotionStore interface {
+	CreatePromotion(context.Context, Promotion) (int, error)
+	GetPromotions(ctx context.Context) ([]Promotion, error)
+	SetPromotionActive(ctx context.Context, id int, active bool) error
+	// GetApplicablePromotions returns the active automatic promotions and the
+	// active promotions with one of the codes that run at the given time.
+	GetApplicablePromotions(ctx context.Context, codes []string, at time.Time) ([]Promotion, error)
+	// GetUsage counts the redemptions that have not been reversed, in total
+	// and by the given user, for each promotion.
+	GetUsage(ctx context.Context, promotionIDs []int, userId int) (total map[int]int, byUser map[int]int, err error)
+	RecordRedemptions(ctx context.Context, redemptions []PromotionRedemption, discounts []OrderItemDiscount) error
+	ReverseRedemptions(ctx context.Context, orderId int) error
+	// GetOrderPromotions returns the promotions the order redeemed that have
+	// not been reversed, whether or not they are still running.
+	GetOrderPromotions(ctx context.Context, orderId int) ([]Promotion, error)
+	// ReviseRedemptions updates the order's redemptions to the ones given
+	// and reverses those of the other promotions.
+	ReviseRedemptions(ctx context.Context, orderId int, redemptions []PromotionRedemption) error
+	GetOrderItemDiscounts(ctx context.Context, orderId int) ([]OrderItemDiscount, error)
+	// UpdateOrderItemDiscounts saves the amount of each discount, adding
+	// the ones without an id.
+	UpdateOrderItemDiscounts(context.Context, []OrderItemDiscount) error
+	WithTx(tx DB) PromotionStore
+}
+
+// Destination is where an order goes, as far as tax and shipping need to
+// know. Country is an ISO 3166-1 alpha-2 code.
+type Destination struct {
+	Country    string `json:"country" validate:"required,len=2"`
+	Region     string `json:"region" validate:"max=64"`
+	PostalCode string `json:"postalCode" validate:"max=16"`
+}
+
+const DefaultTaxClass = "standard"
+
+// TaxRate is one version of the rate for a tax class in a jurisdiction. An
+// empty Region or PostalPrefix matches any. Rate is a decimal fraction such
+// as "0.0825". Inclusive rates are already part of the prices they apply to.
+type TaxRate struct {
+	ID            int        `json:"id"`
+	Country       string     `json:"country"`
+	Region        string     `json:"region"`
+	PostalPrefix  string     `json:"postalPrefix"`
+	TaxClass      string     `json:"taxClass"`
+	Name          string     `json:"name"`
+	Rate          string     `json:"rate"`
+	Inclusive     bool       `json:"inclusive"`
+	EffectiveFrom time.Time  `json:"effectiveFrom"`
+	EffectiveTo   *time.Time `json:"effectiveTo"`
+	CreatedAt     time.Time  `json:"createdAt"`
+}
+
+type CreateTaxRatePayload struct {
+	Country       string     `json:"country" validate:"required,len=2"`
+	Region        string     `json:"region" validate:"max=64"`
+	PostalPrefix  string     `json:"postalPrefix" validate:"max=16"`
+	TaxClass      string     `json:"taxClass" validate:"max=32"`
+	Name          string     `json:"name" validate:"required,max=64"`
+	Rate          string     `json:"rate" validate:"required"`
+	Inclusive     bool       `json:"inclusive"`
+	EffectiveFrom *time.Time `json:"effectiveFrom"`
+}
+
+// TaxableLine is an order line as far as tax is concerned. Amount is the
+// line total after discounts.
+type TaxableLine struct {
+	ProductID int
+	TaxClass  string
+	Amount    money.Money
 }
 
+// TaxLine is the tax of one rate on one line. Line is the index of the line
+// that was passed to the calculator.
+type TaxLine struct {
+	Line      int         `json:"line"`
+	ProductID int         `json:"productId"`
+	TaxRateID int         `json:"taxRateId"`
+	Name      string      `json:"name"`
+	Rate      string      `json:"rate"`
+	Inclusive bool        `json:"inclusive"`
+	Taxable   money.Money `json:"taxable"`
+	Amount    money.Money `json:"amount"`
+}
+
+type TaxCalculator interface {
+	Calculate(ctx context.Context, lines []TaxableLine, destination Destination, at time.Time) ([]TaxLine, error)
+}
+
+type OrderItemTax struct {
+	ID          int `json:"id"`
+	OrderID     int `json:"orderId"`
+	OrderItemID int `json:"orderItemId"`
+	TaxLine
+}
+
+type TaxStore interface {
+	// GetTaxRates returns the rates of a country in effect at the given time.
+	GetTaxRates(ctx context.Context, country string, at time.Time) ([]TaxRate, error)
+	ListTaxRates(ctx context.Context, country string) ([]TaxRate, error)
+	CreateTaxRate(context.Context, TaxRate) (int, error)
+	EndTaxRate(ctx context.Context, id int, at time.Time) error
+	RecordOrderItemTaxes(context.Context, []OrderItemTax) error
+	GetOrderItemTaxes(ctx context.Context, orderId int) ([]OrderItemTax, error)
+	// UpdateOrderItemTaxes saves the taxable amount and tax of each line.
+	UpdateOrderItemTaxes(context.Context, []OrderItemTax) error
+	WithTx(tx DB) TaxStore
+}
+
+type ShippingMethodKind string
+
+const (
+	ShippingStandard ShippingMethodKind = "standard"
+	ShippingExpress  ShippingMethodKind = "express"
+	ShippingPickup   ShippingMethodKind = "pickup"
+)
+
+// ShippingRateType says what a method's rate depends on. Weight tiers are
+// measured in grams of chargeable weight and price tiers in minor units of
+// the method's currency.
+type ShippingRateType string
+
+const (
+	ShippingRateFlat   ShippingRateType = "flat"
+	ShippingRateWeight ShippingRateType = "weight"
+	ShippingRatePrice  ShippingRateType = "price"
+)
+
+// ShippingZoneRegion is one area a zone covers. An empty Region or
+// PostalPrefix matches any.
+type ShippingZoneRegion struct {
+	Country      string `json:"country" validate:"required,len=2"`
+	Region       string `json:"region" validate:"max=64"`
+	PostalPrefix string `json:"postalPrefix" validate:"max=16"`
+}
+
+type ShippingZone struct {
+	ID        int                  `json:"id"`
+	Name      string               `json:"name"`
+	Regions   []ShippingZoneRegion `json:"regions"`
+	CreatedAt time.Time            `json:"createdAt"`
+}
+
+type CreateShippingZonePayload struct {
+	Name    string               `json:"name" validate:"required,max=64"`
+	Regions []ShippingZoneRegion `json:"regions" validate:"required,min=1,dive"`
+}
+
+// ShippingRateTier charges Rate up to and including UpTo. The tier without
+// UpTo has no upper bound.
+type ShippingRateTier struct {
+	UpTo *int64      `json:"upTo"`
+	Rate money.Money `json:"rate"`
+}
+
+type ShippingMethod struct {
+	ID        int                `json:"id"`
+	ZoneID    int                `json:"zoneId"`
+	Name      string             `json:"name"`
+	Kind      ShippingMethodKind `json:"kind"`
+	RateType  ShippingRateType   `json:"rateType"`
+	FlatRate  money.Money        `json:"flatRate"`
+	Tiers     []ShippingRateTier `json:"tiers"`
+	MinDays   int                `json:"minDays"`
+	MaxDays   int                `json:"maxDays"`
+	Active    bool               `json:"active"`
+	CreatedAt time.Time          `json:"createdAt"`
+}
+
+type ShippingRateTierPayload struct {
+	UpTo *int64 `json:"upTo" validate:"omitempty,min=0"`
+	Rate int64  `json:"rate" validate:"min=0"`
+}
+
+type CreateShippingMethodPayload struct {
+	ZoneID   int                       `json:"zoneId" validate:"required"`
+	Name     string                    `json:"name" validate:"required,max=64"`
+	Kind     ShippingMethodKind        `json:"kind" validate:"required,oneof=standard express pickup"`
+	RateType ShippingRateType          `json:"rateType" validate:"required,oneof=flat weight price"`
+	FlatRate int64                     `json:"flatRate" validate:"min=0"`
+	Currency string                    `json:"currency" validate:"required,len=3"`
+	Tiers    []ShippingRateTierPayload `json:"tiers" validate:"dive"`
+	MinDays  int                       `json:"minDays" validate:"min=0"`
+	MaxDays  int                       `json:"maxDays" validate:"min=0,gtefield=MinDays"`
+}
+
+type SetShippingMethodActivePayload struct {
+	Active bool `json:"active"`
+}
+
+// ShippingQuote is what a method costs for a particular cart and address,
+// in the currency the cart is priced in.
+type ShippingQuote struct {
+	MethodID int                `json:"methodId"`
+	Name     string             `json:"name"`
+	Kind     ShippingMethodKind `json:"kind"`
+	Cost     money.Money        `json:"cost"`
+	MinDays  int                `json:"minDays"`
+	MaxDays  int                `json:"maxDays"`
+}
+
+// ShippingQuotePayload asks what the listed items, or the caller's stored
+// cart when Items is omitted, would cost to ship to Destination.
+type ShippingQuotePayload struct {
+	Items       []CartItem  `json:"items"`
+	Codes       []string    `json:"codes" validate:"dive,required,max=64"`
+	Destination Destination `json:"destination" validate:"required"`
+}
+
+type ShippingStore interface {
+	CreateZone(context.Context, ShippingZone) (int, error)
+	GetZones(ctx context.Context) ([]ShippingZone, error)
+	CreateMethod(context.Context, ShippingMethod) (int, error)
+	// GetMethods returns the methods of the given zones, or of every zone
+	// when none are given.
+	GetMethods(ctx context.Context, zoneIDs ...int) ([]ShippingMethod, error)
+	SetMethodActive(ctx context.Context, id int, active bool) error
+}
+
+// Order.Total is what the customer pays, after Discount has been taken off
+// and Tax and ShippingCost added. Tax includes tax that was already part of
+// the prices.
 type Order struct {
-	ID        int       `json:"id"`
-	UserId    int       `json:"userID"`
-	Total     float64   `json:"total"`
-	Status    string    `json:"status"`
-	Address   string    `json:"address"`
-	CreatedAt time.Time `json:"createdAt"`
+	ID           int         `json:"id"`
+	UserId       int         `json:"userID"`
+	Total        money.Money `json:"total"`
+	Discount     money.Money `json:"discount"`
+	Tax          money.Money `json:"tax"`
+	ShippingCost money.Money `json:"shippingCost"`
+	// ShippingMethodID is 0 for orders placed without a shipping method
+	ShippingMethodID int         `json:"shippingMethodId"`
+	ShippingMethod   string      `json:"shippingMethod"`
+	ExchangeRate     string      `json:"exchangeRate"`
+	Status           OrderStatus `json:"status"`
+	Address          string      `json:"address"`
+	CreatedAt        time.Time   `json:"createdAt"`
 }
 
+// FulfilmentStatus is whether the units of an order item have been taken
+// from stock or are waiting for the product to be replenished.
+type FulfilmentStatus string
+
+const (
+	FulfilmentAllocated   FulfilmentStatus = "allocated"
+	FulfilmentBackordered FulfilmentStatus = "backordered"
+)
+
+// OrderItem.ProductName and ProductImage are copied from the product when
+// the order is placed, so the order shows what was bought even after the
+// product changes. ExpectedAt is when a pre-ordered item is expected to be
+// available.
 type OrderItem struct {
-	ID        int       `json:"id"`
-	OrderID   int       `json:"orderID"`
-	ProductID int       `json:"productID"`
-	Quantity  int       `json:"quantity"`
-	Price     float64   `json:"price"`
-	CreatedAt time.Time `json:"createdAt"`
+	ID               int              `json:"id"`
+	OrderID          int              `json:"orderID"`
+	ProductID        int              `json:"productID"`
+	ProductName      string           `json:"productName"`
+	ProductImage     string           `json:"productImage"`
+	Quantity         int              `json:"quantity"`
+	Price            money.Money      `json:"price"`
+	FulfilmentStatus FulfilmentStatus `json:"fulfilmentStatus"`
+	ExpectedAt       *time.Time       `json:"expectedAt"`
+	CreatedAt        time.Time        `json:"createdAt"`
 }
 
-type OrderHistory struct {
-	OrderId   int       `json:orderId`
-	Total     float64   `json:total`
-	Status    string    `json:status`
-	Address   string    `json:address`
-	CreatedAt time.Time `json:"createdAt"`
-	ProductId int       `json:productId`
-	Quantity  int       `json:quantity`
-	Price     float64   `json:price`
+// OrderHistoryFilter narrows a user's orders. From and To bound when the
+// order was placed, To exclusive; a zero Status, From or To leaves that
+// side open.
+type OrderHistoryFilter struct {
+	Status OrderStatus
+	From   time.Time
+	To     time.Time
+	Limit  int
+	Offset int
+}
+
+// OrderDetail is an order with its items, the statuses it has been through,
+// the shipments it went out in, the refunds given on it, the returns asked
+// for and the edits made to it.
+type OrderDetail struct {
+	Order
+	Items     []OrderItem         `json:"items"`
+	History   []OrderStatusChange `json:"history"`
+	Refunds   []Refund            `json:"refunds"`
+	Returns   []Return            `json:"returns"`
+	Revisions []OrderRevision     `json:"revisions"`
+	Shipments []Shipment          `json:"shipments"`
 }
 
 type CancelOrderPayload struct {
-	OrderId int `json:orderId`
+	OrderId int `json:"orderId"`
 }
 
 type OrderStore interface {
-	CreateOrder(Order) (int, error)
-	CreateOrderItem(OrderItem) error
-	UpdateOrder(Order) error
-	GetOrderById(int) (*Order, error)
-	GetOrderHistoryByUserId(int) ([]OrderHistory, error)
+	CreateOrder(context.Context, Order) (int, error)
+	CreateOrderItem(context.Context, OrderItem) (int, error)
+	UpdateOrder(context.Context, Order) error
+	// UpdateOrderStatus applies the change only if the order is still in
+	// change.FromStatus, and records it in the order's history.
+	UpdateOrderStatus(ctx context.Context, change OrderStatusChange) error
+	GetOrderStatusHistory(ctx context.Context, orderId int) ([]OrderStatusChange, error)
+	GetOrderItems(ctx context.Context, orderId int) ([]OrderItem, error)
+	GetOrderById(ctx context.Context, orderId int) (*Order, error)
+	// LockOrderById reads the order like GetOrderById and locks it until the
+	// transaction ends, so the store should be bound to one.
+	LockOrderById(ctx context.Context, orderId int) (*Order, error)
+	// GetOrderHistoryByUserId returns a page of the user's orders, newest
+	// first, and how many orders match the filter in total.
+	GetOrderHistoryByUserId(ctx context.Context, userId int, filter OrderHistoryFilter) ([]Order, int, error)
+	// HasCompletedOrderWithProduct reports whether the user has had an order
+	// with the product delivered.
+	HasCompletedOrderWithProduct(ctx context.Context, userId int, productId int) (bool, error)
+	// ReviseOrder saves the order's amounts and address and its items'
+	// quantities as revised, only if neither has changed since they were
+	// read, and records the revision.
+	ReviseOrder(ctx context.Context, order Order, revision OrderRevision) (int, error)
+	// GetOrderRevisions returns the order's revisions with their items,
+	// oldest first.
+	GetOrderRevisions(ctx context.Context, orderId int) ([]OrderRevision, error)
+	// LockOrderRevisionById reads a revision, without its items, and locks
+	// it until the transaction the store is bound to ends.
+	LockOrderRevisionById(ctx context.Context, id int) (*OrderRevision, error)
+	// GetUnsettledOrderRevisions returns the revisions that still owe a
+	// refund, without their items, oldest first.
+	GetUnsettledOrderRevisions(ctx context.Context) ([]OrderRevision, error)
+	// SettleOrderRevision clears what the revision owes and records the
+	// refund that paid it, if one was needed.
+	SettleOrderRevision(ctx context.Context, id int, refundId *int) error
+	// GetBackorderedItems returns the backordered items of the product on
+	// orders that are still open, oldest first.
+	GetBackorderedItems(ctx context.Context, productId int) ([]OrderItem, error)
+	AllocateOrderItems(ctx context.Context, ids []int) error
+	WithTx(tx DB) OrderStore
+}
+
+// OrderRevision is an edit made to an order before it shipped: items taken
+// off it and the address it ships to. ChangedBy is the customer or staff
+// member who made the edit. Address and PreviousAddress are empty when the
+// address was left alone. RefundDue is what the edit still owes the
+// customer, zero once RefundID records the refund that paid it.
+type OrderRevision struct {
+	ID              int                 `json:"id"`
+	OrderID         int                 `json:"orderId"`
+	ChangedBy       *int                `json:"changedBy"`
+	Reason          string              `json:"reason"`
+	PreviousTotal   money.Money         `json:"previousTotal"`
+	Total           money.Money         `json:"total"`
+	RefundDue       money.Money         `json:"refundDue"`
+	RefundID        *int                `json:"refundId"`
+	PreviousAddress string              `json:"previousAddress,omitempty"`
+	Address         string              `json:"address,omitempty"`
+	CreatedAt       time.Time           `json:"createdAt"`
+	Items           []OrderRevisionItem `json:"items"`
+}
+
+// OrderRevisionItem is the quantity of an order item before and after a
+// revision. An item taken off the order entirely is left at quantity 0.
+type OrderRevisionItem struct {
+	ID           int `json:"id"`
+	RevisionID   int `json:"revisionId"`
+	OrderItemID  int `json:"orderItemId"`
+	FromQuantity int `json:"fromQuantity"`
+	ToQuantity   int `json:"toQuantity"`
+}
+
+// EditOrderPayload lowers the quantities of the listed items, 0 taking the
+// item off the order, and changes the address the order ships to when
+// Address is set.
+type EditOrderPayload struct {
+	Items   []EditOrderItemPayload `json:"items" validate:"dive"`
+	Address *string                `json:"address" validate:"omitempty,min=1,max=255"`
+	Reason  string                 `json:"reason" validate:"max=255"`
+}
+
+type EditOrderItemPayload struct {
+	OrderItemID int `json:"orderItemId" validate:"required"`
+	Quantity    int `json:"quantity" validate:"min=0"`
 }
 
 type CartItem struct {
@@ -125,12 +806,963 @@ type CartItem struct {
 	Quantity  int `json:"quantity"`
 }
 
+// CartCheckoutPayload checks out the listed items. When Items is omitted the
+// caller's stored cart is checked out instead. Codes are promotion codes and
+// Destination is where tax is calculated for; without it no tax is charged.
+// ShippingMethodID picks one of the methods quoted for the destination and
+// PaymentSource is the payment provider's token for how the customer pays.
+// GiftCards are gift card codes to pay with, LoyaltyPoints the number of
+// points to spend and UseStoreCredit pays with the customer's store credit,
+// in that order, before the rest is charged.
 type CartCheckoutPayload struct {
-	Items []CartItem `json:"items" validate:"required"`
+	Items            []CartItem   `json:"items"`
+	Codes            []string     `json:"codes" validate:"dive,required,max=64"`
+	Destination      *Destination `json:"destination"`
+	ShippingMethodID int          `json:"shippingMethodId"`
+	PaymentSource    string       `json:"paymentSource" validate:"max=128"`
+	GiftCards        []string     `json:"giftCards" validate:"max=5,dive,required,max=32"`
+	LoyaltyPoints    int          `json:"loyaltyPoints" validate:"min=0"`
+	UseStoreCredit   bool         `json:"useStoreCredit"`
+}
+
+// Cart belongs to a user, or to a guest when UserID is 0, in which case
+// GuestToken is the id named by the guest's cart token.
+type Cart struct {
+	ID         int       `json:"id"`
+	UserID     int       `json:"userId,omitempty"`
+	GuestToken string    `json:"-"`
+	CreatedAt  time.Time `json:"createdAt"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+// CartLine is an item of a stored cart. AddedPrice is the unit price when the
+// line was last added to, so the cart can tell the shopper it changed since.
+type CartLine struct {
+	ID         int         `json:"id"`
+	CartID     int         `json:"cartId"`
+	ProductID  int         `json:"productId"`
+	Quantity   int         `json:"quantity"`
+	AddedPrice money.Money `json:"addedPrice"`
+	CreatedAt  time.Time   `json:"createdAt"`
+}
+
+const (
+	CartIssueUnavailable       = "unavailable"
+	CartIssueInsufficientStock = "insufficient_stock"
+	CartIssuePriceChanged      = "price_changed"
+)
+
+// CartLineView is a stored cart line revalidated against the current price
+// and stock of its product. FulfilmentStatus is how the line would be
+// fulfilled if the cart were checked out now, and ExpectedAt when a
+// pre-ordered line is expected to be available.
+type CartLineView struct {
+	CartLine
+	Name             string           `json:"name"`
+	Image            string           `json:"image"`
+	Available        int              `json:"available"`
+	UnitPrice        money.Money      `json:"unitPrice"`
+	LineTotal        money.Money      `json:"lineTotal"`
+	FulfilmentStatus FulfilmentStatus `json:"fulfilmentStatus,omitempty"`
+	ExpectedAt       *time.Time       `json:"expectedAt"`
+	Issues           []string         `json:"issues"`
 }
 
+type CartView struct {
+	ID               int            `json:"id"`
+	Items            []CartLineView `json:"items"`
+	Subtotal         money.Money    `json:"subtotal"`
+	ReadyForCheckout bool           `json:"readyForCheckout"`
+}
+
+type AddCartItemPayload struct {
+	ProductID int `json:"productID" validate:"required"`
+	Quantity  int `json:"quantity" validate:"required,min=1"`
+}
+
+type UpdateCartItemPayload struct {
+	Quantity int `json:"quantity" validate:"required,min=1"`
+}
+
+const (
+	CartAdjustmentMerged  = "merged"
+	CartAdjustmentCapped  = "capped_to_stock"
+	CartAdjustmentRemoved = "removed_unavailable"
+)
+
+// CartAdjustment reports how a guest cart line changed when it was merged
+// into a user's cart.
+type CartAdjustment struct {
+	ProductID int    `json:"productId"`
+	Requested int    `json:"requested"`
+	Quantity  int    `json:"quantity"`
+	Reason    string `json:"reason"`
+}
+
+// CartMerger moves a guest cart into a user's cart on login or register.
+type CartMerger interface {
+	MergeGuestCart(ctx context.Context, cartToken string, userId int) ([]CartAdjustment, error)
+}
+
+type CartStore interface {
+	GetOrCreateCartByUserId(ctx context.Context, userId int) (*Cart, error)
+	CreateGuestCart(ctx context.Context, guestToken string) (*Cart, error)
+	GetCartByGuestToken(ctx context.Context, guestToken string) (*Cart, error)
+	MergeCart(ctx context.Context, guestCartId int, userCartId int, lines []CartLine) error
+	GetCartLines(ctx context.Context, cartId int) ([]CartLine, error)
+	AddCartLine(ctx context.Context, line CartLine) error
+	UpdateCartLineQuantity(ctx context.Context, cartId int, lineId int, quantity int) error
+	DeleteCartLine(ctx context.Context, cartId int, lineId int) error
+	ClearCart(ctx context.Context, cartId int) error
+}
+
+// OrderStatus is where an order is in its life. The statuses an order can
+// move between are defined by the order service's state machine.
+type OrderStatus string
+
 const (
-	Pending   string = "pending"
-	Completed string = "completed"
-	Cancelled string = "cancelled"
+	Pending           OrderStatus = "pending"
+	Paid              OrderStatus = "paid"
+	Processing        OrderStatus = "processing"
+	Shipped           OrderStatus = "shipped"
+	Delivered         OrderStatus = "delivered"
+	Cancelled         OrderStatus = "cancelled"
+	Refunded          OrderStatus = "refunded"
+	PartiallyRefunded OrderStatus = "partially_refunded"
+	Returned          OrderStatus = "returned"
 )
+
+// OrderStatusChange records one transition of an order. ChangedBy is the
+// user who made it, or nil when the system did, such as on a payment
+// webhook.
+type OrderStatusChange struct {
+	ID         int         `json:"id"`
+	OrderID    int         `json:"orderId"`
+	FromStatus OrderStatus `json:"from"`
+	ToStatus   OrderStatus `json:"to"`
+	ChangedBy  *int        `json:"changedBy"`
+	Reason     string      `json:"reason"`
+	CreatedAt  time.Time   `json:"createdAt"`
+}
+
+type OrderTransitionPayload struct {
+	Status OrderStatus `json:"status" validate:"required"`
+	Reason string      `json:"reason" validate:"max=255"`
+}
+
+// OrderTransitioner moves orders between statuses, checking the move is
+// allowed and recording it in the order's history.
+type OrderTransitioner interface {
+	Transition(ctx context.Context, orderId int, to OrderStatus, changedBy *int, reason string) (*Order, error)
+}
+
+type PaymentStatus string
+
+// A payment starts out authorized, declined or waiting on the customer
+// (requires_action) and is settled from there, either right away or when
+// the provider's webhook arrives.
+const (
+	PaymentRequiresAction    PaymentStatus = "requires_action"
+	PaymentAuthorized        PaymentStatus = "authorized"
+	PaymentCaptured          PaymentStatus = "captured"
+	PaymentDeclined          PaymentStatus = "declined"
+	PaymentFailed            PaymentStatus = "failed"
+	PaymentVoided            PaymentStatus = "voided"
+	PaymentPartiallyRefunded PaymentStatus = "partially_refunded"
+	PaymentRefunded          PaymentStatus = "refunded"
+)
+
+// Payment is one attempt to pay for an order. Reference is the provider's id
+// for it. NextAction tells the customer what to do when the payment requires
+// action and is not stored.
+type Payment struct {
+	ID            int           `json:"id"`
+	OrderID       int           `json:"orderId"`
+	Provider      string        `json:"provider"`
+	Reference     string        `json:"reference"`
+	Status        PaymentStatus `json:"status"`
+	Amount        money.Money   `json:"amount"`
+	Refunded      money.Money   `json:"refunded"`
+	FailureReason string        `json:"failureReason,omitempty"`
+	NextAction    string        `json:"nextAction,omitempty"`
+	CreatedAt     time.Time     `json:"createdAt"`
+	UpdatedAt     time.Time     `json:"updatedAt"`
+}
+
+// PaymentRequest asks a provider to authorize Amount for an order. Source
+// is the provider's token for how the customer pays, such as a card token.
+type PaymentRequest struct {
+	OrderID int
+	Amount  money.Money
+	Source  string
+}
+
+// PaymentResult is what a provider answered to a request about a payment.
+type PaymentResult struct {
+	Reference     string
+	Status        PaymentStatus
+	FailureReason string
+	NextAction    string
+}
+
+// PaymentEvent is a verified webhook telling us a payment changed status.
+type PaymentEvent struct {
+	Reference     string        `json:"reference"`
+	Status        PaymentStatus `json:"status"`
+	FailureReason string        `json:"failureReason,omitempty"`
+}
+
+type PaymentProvider interface {
+	Name() string
+	Authorize(PaymentRequest) (PaymentResult, error)
+	Capture(reference string, amount money.Money) (PaymentResult, error)
+	Void(reference string) (PaymentResult, error)
+	Refund(reference string, amount money.Money) (PaymentResult, error)
+	// VerifyWebhook checks a webhook came from the provider and returns the
+	// event it carries.
+	VerifyWebhook(header http.Header, body []byte) (PaymentEvent, error)
+}
+
+// PaymentProcessor takes payment for orders and keeps them in step with
+// their payments.
+type PaymentProcessor interface {
+	// Charge pays for a pending order. A declined payment releases the order
+	// and is returned without an error.
+	Charge(ctx context.Context, order Order, source string) (*Payment, error)
+	// VoidOrderPayments voids the payments of an order that have not been
+	// captured yet and gives back what it took from tenders.
+	VoidOrderPayments(ctx context.Context, orderId int) error
+	// Refund gives amount back through the order's captured payments. It
+	// fails without refunding anything if they have less than amount left
+	// to refund.
+	Refund(ctx context.Context, orderId int, amount money.Money) error
+	// RefundTo is Refund giving the whole amount back as credit on the
+	// tender's account, such as the customer's store credit, instead of
+	// through the payments it was taken with.
+	RefundTo(ctx context.Context, orderId int, amount money.Money, tender string, account string) error
+}
+
+type PaymentStore interface {
+	CreatePayment(context.Context, Payment) (int, error)
+	GetPaymentByReference(ctx context.Context, provider string, reference string) (*Payment, error)
+	GetPaymentsByOrderId(ctx context.Context, orderId int) ([]Payment, error)
+	// LockPaymentByReference and LockPaymentsByOrderId read payments like
+	// their Get counterparts and lock them until the transaction ends, so
+	// the store should be bound to one.
+	LockPaymentByReference(ctx context.Context, provider string, reference string) (*Payment, error)
+	LockPaymentsByOrderId(ctx context.Context, orderId int) ([]Payment, error)
+	UpdatePayment(context.Context, Payment) error
+	WithTx(tx DB) PaymentStore
+}
+
+// RefundReason says why money was given back, for reporting.
+type RefundReason string
+
+const (
+	RefundCustomerRequest RefundReason = "customer_request"
+	RefundDamaged         RefundReason = "damaged"
+	RefundWrongItem       RefundReason = "wrong_item"
+	RefundNotReceived     RefundReason = "not_received"
+	RefundDuplicate       RefundReason = "duplicate"
+	RefundOther           RefundReason = "other"
+	// RefundOrderEdited gives back the difference when an order is edited
+	// down after it was paid for
+	RefundOrderEdited RefundReason = "order_edited"
+)
+
+// RefundStatus is where a refund is with the money it gives back. A refund
+// is recorded as pending before any money moves and completed once it has;
+// one that moved nothing is failed. A refund left pending may have given
+// money back, so it counts against what is left to refund until it is
+// settled by hand.
+type RefundStatus string
+
+const (
+	RefundPending   RefundStatus = "pending"
+	RefundCompleted RefundStatus = "completed"
+	RefundFailed    RefundStatus = "failed"
+)
+
+// Refund is money given back on an order, either for some of its items or
+// an arbitrary amount when Items is empty. Restocked tells whether the
+// refunded units were put back in stock and StoreCredit whether the money
+// went to the customer's store credit rather than back to how they paid.
+// CreatedBy is the staff member who gave the refund.
+type Refund struct {
+	ID          int          `json:"id"`
+	OrderID     int          `json:"orderId"`
+	Amount      money.Money  `json:"amount"`
+	Status      RefundStatus `json:"status"`
+	Reason      RefundReason `json:"reason"`
+	Note        string       `json:"note"`
+	Restocked   bool         `json:"restocked"`
+	StoreCredit bool         `json:"storeCredit"`
+	CreatedBy   *int         `json:"createdBy"`
+	CreatedAt   time.Time    `json:"createdAt"`
+	Items       []RefundItem `json:"items"`
+}
+
+// RefundItem is the units of one order item a refund is for and what they
+// were worth.
+type RefundItem struct {
+	ID          int         `json:"id"`
+	RefundID    int         `json:"refundId"`
+	OrderItemID int         `json:"orderItemId"`
+	Quantity    int         `json:"quantity"`
+	Amount      money.Money `json:"amount"`
+}
+
+// RefundOrderPayload refunds the listed items, what was paid for them unless
+// Amount says otherwise, or just Amount when no items are listed. Restock
+// puts the items back in stock and StoreCredit gives the money as store
+// credit instead of back to how the customer paid.
+type RefundOrderPayload struct {
+	Items       []RefundItemPayload `json:"items" validate:"dive"`
+	Amount      *money.Money        `json:"amount" validate:"omitempty,money_positive"`
+	Reason      RefundReason        `json:"reason" validate:"required,oneof=customer_request damaged wrong_item not_received duplicate other"`
+	Note        string              `json:"note" validate:"max=255"`
+	Restock     bool                `json:"restock"`
+	StoreCredit bool                `json:"storeCredit"`
+}
+
+type RefundItemPayload struct {
+	OrderItemID int `json:"orderItemId" validate:"required"`
+	Quantity    int `json:"quantity" validate:"required,min=1"`
+}
+
+// Refunder gives refunds on orders. CreatedBy is the staff member giving
+// the refund.
+type Refunder interface {
+	Refund(ctx context.Context, orderId int, payload RefundOrderPayload, createdBy *int) (*Refund, error)
+}
+
+type RefundStore interface {
+	// CreateRefund stores the refund with its items.
+	CreateRefund(ctx context.Context, refund Refund) (int, error)
+	// GetRefundsByOrderId returns the order's refunds with their items,
+	// oldest first.
+	GetRefundsByOrderId(ctx context.Context, orderId int) ([]Refund, error)
+	// UpdateRefundStatus moves a pending refund to status.
+	UpdateRefundStatus(ctx context.Context, id int, status RefundStatus) error
+	WithTx(tx DB) RefundStore
+}
+
+// ReturnStatus is where a return is in the return workflow: requested by the
+// customer, approved or rejected by staff and, once the items are received
+// and accepted, refunded. Returns that fail inspection are rejected too.
+type ReturnStatus string
+
+const (
+	ReturnRequested ReturnStatus = "requested"
+	ReturnApproved  ReturnStatus = "approved"
+	ReturnRejected  ReturnStatus = "rejected"
+	ReturnRefunded  ReturnStatus = "refunded"
+)
+
+// ReturnDisposition is what inspection decided to do with a returned item.
+type ReturnDisposition string
+
+const (
+	ReturnRestock  ReturnDisposition = "restock"
+	ReturnWriteOff ReturnDisposition = "write_off"
+)
+
+// Return is a customer's request to send items of a delivered order back.
+// Note is the customer's and StaffNote explains the decision or the
+// inspection. LabelReference is the return label the customer ships with,
+// given once the return is approved, and RefundID the refund given once
+// the items are accepted.
+type Return struct {
+	ID             int          `json:"id"`
+	OrderID        int          `json:"orderId"`
+	UserID         int          `json:"userId"`
+	Status         ReturnStatus `json:"status"`
+	Reason         RefundReason `json:"reason"`
+	Note           string       `json:"note"`
+	StaffNote      string       `json:"staffNote"`
+	LabelReference string       `json:"labelReference,omitempty"`
+	RefundID       *int         `json:"refundId"`
+	CreatedAt      time.Time    `json:"createdAt"`
+	DecidedAt      *time.Time   `json:"decidedAt"`
+	ReceivedAt     *time.Time   `json:"receivedAt"`
+	Items          []ReturnItem `json:"items"`
+}
+
+// ReturnItem is the units of one order item being returned. Disposition is
+// empty until the item has been inspected.
+type ReturnItem struct {
+	ID          int               `json:"id"`
+	ReturnID    int               `json:"returnId"`
+	OrderItemID int               `json:"orderItemId"`
+	Quantity    int               `json:"quantity"`
+	Disposition ReturnDisposition `json:"disposition,omitempty"`
+}
+
+type CreateReturnPayload struct {
+	Items  []ReturnItemPayload `json:"items" validate:"required,min=1,dive"`
+	Reason RefundReason        `json:"reason" validate:"required,oneof=customer_request damaged wrong_item other"`
+	Note   string              `json:"note" validate:"max=255"`
+}
+
+type ReturnItemPayload struct {
+	OrderItemID int `json:"orderItemId" validate:"required"`
+	Quantity    int `json:"quantity" validate:"required,min=1"`
+}
+
+// ReturnDecisionPayload approves or rejects a return with a note for the
+// customer.
+type ReturnDecisionPayload struct {
+	Note string `json:"note" validate:"max=255"`
+}
+
+// ReceiveReturnPayload records the inspection of a return's items. Every
+// item needs a disposition; accepting the return refunds it and restocks the
+// items marked for it.
+type ReceiveReturnPayload struct {
+	Items  []InspectedItemPayload `json:"items" validate:"required,min=1,dive"`
+	Accept bool                   `json:"accept"`
+	Note   string                 `json:"note" validate:"max=255"`
+}
+
+type InspectedItemPayload struct {
+	ReturnItemID int               `json:"returnItemId" validate:"required"`
+	Disposition  ReturnDisposition `json:"disposition" validate:"required,oneof=restock write_off"`
+}
+
+type ReturnStore interface {
+	// CreateReturn stores the return with its items.
+	CreateReturn(ctx context.Context, ret Return) (int, error)
+	GetReturnById(ctx context.Context, id int) (*Return, error)
+	// LockReturnById reads the return like GetReturnById and locks it until
+	// the transaction ends, so the store should be bound to one.
+	LockReturnById(ctx context.Context, id int) (*Return, error)
+	GetReturnsByOrderId(ctx context.Context, orderId int) ([]Return, error)
+	GetReturnsByStatus(ctx context.Context, status ReturnStatus) ([]Return, error)
+	// UpdateReturn saves the return's status, decision and the inspection of
+	// its items, only if it is still in from.
+	UpdateReturn(ctx context.Context, ret Return, from ReturnStatus) error
+	WithTx(tx DB) ReturnStore
+}
+
+// ShipmentStatus is where a shipment is: on its way to the customer or
+// delivered.
+type ShipmentStatus string
+
+const (
+	ShipmentInTransit ShipmentStatus = "in_transit"
+	ShipmentDelivered ShipmentStatus = "delivered"
+)
+
+// Shipment is a parcel sent out for an order with the units of the order's
+// items it holds. An order can go out in several shipments. CreatedBy is the
+// staff member who shipped it.
+type Shipment struct {
+	ID             int            `json:"id"`
+	OrderID        int            `json:"orderId"`
+	Carrier        string         `json:"carrier"`
+	TrackingNumber string         `json:"trackingNumber"`
+	Status         ShipmentStatus `json:"status"`
+	CreatedBy      *int           `json:"createdBy"`
+	ShippedAt      time.Time      `json:"shippedAt"`
+	DeliveredAt    *time.Time     `json:"deliveredAt"`
+	Items          []ShipmentItem `json:"items"`
+}
+
+type ShipmentItem struct {
+	ID          int `json:"id"`
+	ShipmentID  int `json:"shipmentId"`
+	OrderItemID int `json:"orderItemId"`
+	Quantity    int `json:"quantity"`
+}
+
+// CreateShipmentPayload ships the listed items, or everything on the order
+// not shipped yet when no items are listed.
+type CreateShipmentPayload struct {
+	Carrier        string                `json:"carrier" validate:"required,max=64"`
+	TrackingNumber string                `json:"trackingNumber" validate:"required,max=128"`
+	Items          []ShipmentItemPayload `json:"items" validate:"dive"`
+}
+
+type ShipmentItemPayload struct {
+	OrderItemID int `json:"orderItemId" validate:"required"`
+	Quantity    int `json:"quantity" validate:"required,min=1"`
+}
+
+// DeliverShipmentPayload marks a shipment delivered at DeliveredAt, or now
+// when it is not set.
+type DeliverShipmentPayload struct {
+	DeliveredAt *time.Time `json:"deliveredAt"`
+}
+
+type ShipmentStore interface {
+	// CreateShipment stores the shipment with its items.
+	CreateShipment(ctx context.Context, shipment Shipment) (int, error)
+	GetShipmentById(ctx context.Context, id int) (*Shipment, error)
+	// GetShipmentsByOrderId returns the order's shipments with their items,
+	// oldest first.
+	GetShipmentsByOrderId(ctx context.Context, orderId int) ([]Shipment, error)
+	GetShipmentsByStatus(ctx context.Context, status ShipmentStatus) ([]Shipment, error)
+	// MarkShipmentDelivered marks the shipment delivered only if it is still
+	// in transit.
+	MarkShipmentDelivered(ctx context.Context, id int, at time.Time) error
+	WithTx(tx DB) ShipmentStore
+}
+
+// TrackingUpdate is what a carrier reports about a parcel. At is when the
+// parcel got to where it is.
+type TrackingUpdate struct {
+	Status ShipmentStatus
+	At     time.Time
+}
+
+// Carrier tracks the parcels it was given by their tracking number.
+type Carrier interface {
+	Name() string
+	Track(ctx context.Context, trackingNumber string) (TrackingUpdate, error)
+}
+
+// IdempotencyRecord is a request made with an Idempotency-Key and, once it
+// has finished, the response it got. Scope keeps the keys of different
+// clients apart and Fingerprint identifies the request the key was first
+// used with. Status is 0 while the request is still in progress.
+type IdempotencyRecord struct {
+	Scope       string
+	Key         string
+	Fingerprint string
+	Status      int
+	ContentType string
+	Body        []byte
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+}
+
+type IdempotencyStore interface {
+	// ClaimKey records the request as in progress. If the key is already
+	// in use and has not expired, nothing is recorded and the record holding
+	// it is returned instead.
+	ClaimKey(ctx context.Context, record IdempotencyRecord) (*IdempotencyRecord, error)
+	SaveResponse(ctx context.Context, record IdempotencyRecord) error
+	// ReleaseKey frees a key whose request did not finish so it can be
+	// retried.
+	ReleaseKey(ctx context.Context, scope string, key string) error
+	DeleteExpiredKeys(ctx context.Context) (int64, error)
+}
+
+// Tenders the shop keeps the balances of itself, which pay for orders
+// alongside the payment provider.
+const (
+	TenderGiftCard      = "gift_card"
+	TenderStoreCredit   = "store_credit"
+	TenderLoyaltyPoints = "loyalty_points"
+)
+
+// Tender is a balance kept by the shop, a gift card or a customer's store
+// credit, that can pay for part or all of an order. Payments taken from a
+// tender have its name as their provider and the ledger entry of the debit
+// as their reference. Accounts are gift card ids and user ids.
+type Tender interface {
+	Name() string
+	// Debit takes amount off the account for the order and returns the
+	// reference of the ledger entry. It fails if the account does not have
+	// amount left.
+	Debit(ctx context.Context, account string, amount money.Money, orderId int) (string, error)
+	// Credit adds amount to the account on behalf of the order.
+	Credit(ctx context.Context, account string, amount money.Money, orderId int) error
+	// Reverse gives amount of the debit back to the account it was taken
+	// from.
+	Reverse(ctx context.Context, reference string, amount money.Money) error
+	WithTx(tx DB) Tender
+}
+
+type GiftCardStatus string
+
+const (
+	GiftCardActive   GiftCardStatus = "active"
+	GiftCardDisabled GiftCardStatus = "disabled"
+	GiftCardExpired  GiftCardStatus = "expired"
+)
+
+// GiftCard is a balance that whoever holds its code can pay with. Only the
+// code's hash is kept, Last4 is there to tell cards apart. Cards bought in
+// the shop point to the order item they were bought with; the others were
+// issued by IssuedBy.
+type GiftCard struct {
+	ID            int                   `json:"id"`
+	CodeHash      string                `json:"-"`
+	Last4         string                `json:"last4"`
+	InitialAmount money.Money           `json:"initialAmount"`
+	Balance       money.Money           `json:"balance"`
+	Status        GiftCardStatus        `json:"status"`
+	Recipient     string                `json:"recipient,omitempty"`
+	OrderItemID   *int                  `json:"orderItemId,omitempty"`
+	IssuedBy      *int                  `json:"issuedBy,omitempty"`
+	ExpiresAt     *time.Time            `json:"expiresAt"`
+	CreatedAt     time.Time             `json:"createdAt"`
+	Transactions  []GiftCardTransaction `json:"transactions,omitempty"`
+}
+
+type LedgerEntryKind string
+
+// Issue opens a balance, debit pays for an order, reversal gives a debit
+// back, credit adds to a balance and expire clears what was left of it.
+const (
+	LedgerIssue    LedgerEntryKind = "issue"
+	LedgerDebit    LedgerEntryKind = "debit"
+	LedgerReversal LedgerEntryKind = "reversal"
+	LedgerCredit   LedgerEntryKind = "credit"
+	LedgerExpire   LedgerEntryKind = "expire"
+)
+
+// GiftCardTransaction is an entry of a gift card's ledger. Amount is
+// negative for what was taken off the card, and the entries add up to its
+// balance.
+type GiftCardTransaction struct {
+	ID         int             `json:"id"`
+	GiftCardID int             `json:"giftCardId"`
+	Kind       LedgerEntryKind `json:"kind"`
+	Amount     money.Money     `json:"amount"`
+	OrderID    *int            `json:"orderId,omitempty"`
+	CreatedAt  time.Time       `json:"createdAt"`
+}
+
+// IssueGiftCardPayload issues a gift card worth Amount. The code is sent to
+// Recipient when one is given.
+type IssueGiftCardPayload struct {
+	Amount    money.Money `json:"amount" validate:"money_positive"`
+	Recipient string      `json:"recipient" validate:"omitempty,email"`
+	ExpiresAt *time.Time  `json:"expiresAt"`
+}
+
+type GiftCardBalancePayload struct {
+	Code string `json:"code" validate:"required,max=32"`
+}
+
+// GiftCardPurchase is an order item of gift cards on a paid order and how
+// many of its units have been issued as cards.
+type GiftCardPurchase struct {
+	OrderItem OrderItem
+	Email     string
+	Issued    int
+}
+
+// Wallet is a customer's store credit.
+type Wallet struct {
+	UserID       int                 `json:"userId"`
+	Balance      money.Money         `json:"balance"`
+	UpdatedAt    time.Time           `json:"updatedAt"`
+	Transactions []WalletTransaction `json:"transactions"`
+}
+
+// WalletTransaction is an entry of a wallet's ledger, negative for what was
+// taken out.
+type WalletTransaction struct {
+	ID        int             `json:"id"`
+	UserID    int             `json:"userId"`
+	Kind      LedgerEntryKind `json:"kind"`
+	Amount    money.Money     `json:"amount"`
+	OrderID   *int            `json:"orderId,omitempty"`
+	CreatedAt time.Time       `json:"createdAt"`
+}
+
+type GiftCardStore interface {
+	// CreateGiftCard stores the card with the ledger entry issuing it.
+	CreateGiftCard(ctx context.Context, card GiftCard) (int, error)
+	GetGiftCardById(ctx context.Context, id int) (*GiftCard, error)
+	GetGiftCardByCode(ctx context.Context, codeHash string) (*GiftCard, error)
+	GetGiftCardTransactions(ctx context.Context, id int) ([]GiftCardTransaction, error)
+	// AddToGiftCard adds amount, negative to take it off, to the card's
+	// balance and records it in the ledger. Amounts are only taken off
+	// active cards that have not expired and have them left.
+	AddToGiftCard(ctx context.Context, id int, kind LedgerEntryKind, amount money.Money, orderId *int) (int, error)
+	GetGiftCardTransaction(ctx context.Context, transactionId int) (*GiftCardTransaction, error)
+	// ExpireGiftCards marks the active cards that expired before at as
+	// expired and clears their balances.
+	ExpireGiftCards(ctx context.Context, at time.Time) (int, error)
+	SetGiftCardStatus(ctx context.Context, id int, status GiftCardStatus) error
+	// GetUnissuedGiftCards lists the gift card items of paid orders that
+	// have units left to issue.
+	GetUnissuedGiftCards(ctx context.Context) ([]GiftCardPurchase, error)
+
+	// GetWallet returns the user's wallet, empty in currency when they have
+	// none yet.
+	GetWallet(ctx context.Context, userId int, currency string) (*Wallet, error)
+	GetWalletTransactions(ctx context.Context, userId int) ([]WalletTransaction, error)
+	// AddToWallet is AddToGiftCard for the user's store credit, opening
+	// their wallet on the first credit.
+	AddToWallet(ctx context.Context, userId int, kind LedgerEntryKind, amount money.Money, orderId *int) (int, error)
+	GetWalletTransaction(ctx context.Context, transactionId int) (*WalletTransaction, error)
+	WithTx(tx DB) GiftCardStore
+}
+
+// LoyaltyRuleKind is how a loyalty rule earns points on a delivered order.
+type LoyaltyRuleKind string
+
+const (
+	// LoyaltyPerUnit earns Points for every whole unit of the base currency
+	// spent on the order's items.
+	LoyaltyPerUnit LoyaltyRuleKind = "per_unit"
+	// LoyaltyCategory earns Points for every whole unit spent on items in
+	// Category, on top of the other rules.
+	LoyaltyCategory LoyaltyRuleKind = "category"
+	// LoyaltyPromotion earns Points once for an order that used the
+	// promotion.
+	LoyaltyPromotion LoyaltyRuleKind = "promotion"
+)
+
+// LoyaltyRule is a way customers earn points. Every active rule applies to
+// every order.
+type LoyaltyRule struct {
+	ID          int             `json:"id"`
+	Name        string          `json:"name"`
+	Kind        LoyaltyRuleKind `json:"kind"`
+	Points      int             `json:"points"`
+	Category    string          `json:"category,omitempty"`
+	PromotionID *int            `json:"promotionId,omitempty"`
+	Active      bool            `json:"active"`
+	CreatedAt   time.Time       `json:"createdAt"`
+}
+
+type CreateLoyaltyRulePayload struct {
+	Name        string          `json:"name" validate:"required,max=255"`
+	Kind        LoyaltyRuleKind `json:"kind" validate:"required,oneof=per_unit category promotion"`
+	Points      int             `json:"points" validate:"required,min=1"`
+	Category    string          `json:"category" validate:"max=64"`
+	PromotionID *int            `json:"promotionId"`
+}
+
+type SetLoyaltyRuleActivePayload struct {
+	Active bool `json:"active"`
+}
+
+// LoyaltyEntryKind is what a loyalty ledger entry was for.
+type LoyaltyEntryKind string
+
+const (
+	LoyaltyEarn     LoyaltyEntryKind = "earn"
+	LoyaltyRedeem   LoyaltyEntryKind = "redeem"
+	LoyaltyRefund   LoyaltyEntryKind = "refund"
+	LoyaltyReversal LoyaltyEntryKind = "reversal"
+	LoyaltyExpire   LoyaltyEntryKind = "expire"
+)
+
+// LoyaltyAccount is a customer's points. Balance can be spent; Pending were
+// earned on orders still within their return window. Points expire when
+// nothing was earned or spent since LastActivityAt for long enough.
+type LoyaltyAccount struct {
+	UserID         int                  `json:"userId"`
+	Balance        int                  `json:"balance"`
+	Pending        int                  `json:"pending"`
+	LastActivityAt *time.Time           `json:"lastActivityAt"`
+	Transactions   []LoyaltyTransaction `json:"transactions"`
+}
+
+// LoyaltyTransaction is an entry of a customer's points ledger, negative
+// for points taken off. Pending entries count towards the account's pending
+// points until AvailableAt, and its balance after.
+type LoyaltyTransaction struct {
+	ID          int              `json:"id"`
+	UserID      int              `json:"userId"`
+	Kind        LoyaltyEntryKind `json:"kind"`
+	Points      int              `json:"points"`
+	OrderID     *int             `json:"orderId,omitempty"`
+	RefundID    *int             `json:"refundId,omitempty"`
+	Pending     bool             `json:"pending"`
+	AvailableAt *time.Time       `json:"availableAt,omitempty"`
+	CreatedAt   time.Time        `json:"createdAt"`
+}
+
+// LoyaltyOrder is a delivered order points can be earned on, with when it
+// was delivered and the promotions it used.
+type LoyaltyOrder struct {
+	Order        Order
+	DeliveredAt  time.Time
+	PromotionIDs []int
+}
+
+// EarnedRefund is a refund of an order that earned points, with the points
+// earned on the order and those already taken back for its other refunds.
+type EarnedRefund struct {
+	Refund     Refund
+	UserID     int
+	OrderTotal money.Money
+	Earned     int
+	Reversed   int
+}
+
+type LoyaltyStore interface {
+	CreateLoyaltyRule(ctx context.Context, rule LoyaltyRule) (int, error)
+	GetLoyaltyRules(ctx context.Context) ([]LoyaltyRule, error)
+	SetLoyaltyRuleActive(ctx context.Context, id int, active bool) error
+
+	// GetLoyaltyAccount returns the user's account, empty when they have
+	// none yet.
+	GetLoyaltyAccount(ctx context.Context, userId int) (*LoyaltyAccount, error)
+	GetLoyaltyTransactions(ctx context.Context, userId int) ([]LoyaltyTransaction, error)
+	GetLoyaltyTransaction(ctx context.Context, transactionId int) (*LoyaltyTransaction, error)
+	// AddPoints adds points, negative to take them off, to the user's
+	// balance and records it in the ledger. Points are only taken off a
+	// balance that has them. Earning or spending points counts as activity.
+	AddPoints(ctx context.Context, userId int, kind LoyaltyEntryKind, points int, orderId *int) (int, error)
+
+	// GetUnearnedOrders lists the orders delivered since the given time
+	// that have not earned points yet.
+	GetUnearnedOrders(ctx context.Context, deliveredSince time.Time) ([]LoyaltyOrder, error)
+	// EarnPoints records points earned on the order as pending until
+	// availableAt, opening the user's account on their first order.
+	EarnPoints(ctx context.Context, userId int, orderId int, points int, availableAt time.Time) error
+	// ReleasePoints moves the pending points available before at to their
+	// accounts' balances.
+	ReleasePoints(ctx context.Context, at time.Time) (int, error)
+	// GetUnreversedRefunds lists the refunds of orders that earned points
+	// that have not had their points taken back yet.
+	GetUnreversedRefunds(ctx context.Context) ([]EarnedRefund, error)
+	// ReversePoints takes points earned on the refund's order back: off the
+	// pending points while the order's are, and off the balance, as far as
+	// it goes, after. It returns the points taken back.
+	ReversePoints(ctx context.Context, refund EarnedRefund, points int) (int, error)
+	// ExpirePoints clears the balances of the accounts without activity
+	// since the given time.
+	ExpirePoints(ctx context.Context, inactiveSince time.Time) (int, error)
+	WithTx(tx DB) LoyaltyStore
+}
+
+// PlaceOrderRequest is an order placed on a customer's behalf, priced the
+// way checkout would price their cart. PercentOff lowers every unit price
+// before promotions.
+type PlaceOrderRequest struct {
+	UserID           int
+	Items            []CartItem
+	Currency         string
+	Destination      *Destination
+	ShippingMethodID int
+	PercentOff       int
+	// Placed, when set, runs in the transaction the order is placed in, so
+	// what the caller records about the order commits along with it.
+	Placed func(tx DB, order Order) error
+}
+
+// OrderPlacer places pending orders without taking payment for them.
+type OrderPlacer interface {
+	PlaceOrder(ctx context.Context, request PlaceOrderRequest) (*Order, error)
+}
+
+type SubscriptionInterval string
+
+const (
+	IntervalDay   SubscriptionInterval = "day"
+	IntervalWeek  SubscriptionInterval = "week"
+	IntervalMonth SubscriptionInterval = "month"
+)
+
+// SubscriptionPlan is a way to subscribe to a product: an order every
+// IntervalCount intervals, PercentOff the price it would sell for.
+type SubscriptionPlan struct {
+	ID            int                  `json:"id"`
+	ProductID     int                  `json:"productId"`
+	Name          string               `json:"name"`
+	Interval      SubscriptionInterval `json:"interval"`
+	IntervalCount int                  `json:"intervalCount"`
+	PercentOff    int                  `json:"percentOff"`
+	Active        bool                 `json:"active"`
+	CreatedAt     time.Time            `json:"createdAt"`
+}
+
+type CreateSubscriptionPlanPayload struct {
+	Name          string               `json:"name" validate:"required,max=255"`
+	Interval      SubscriptionInterval `json:"interval" validate:"required,oneof=day week month"`
+	IntervalCount int                  `json:"intervalCount" validate:"required,min=1,max=365"`
+	PercentOff    int                  `json:"percentOff" validate:"min=0,max=100"`
+}
+
+type SetSubscriptionPlanActivePayload struct {
+	Active bool `json:"active"`
+}
+
+type SubscriptionStatus string
+
+const (
+	SubscriptionActive    SubscriptionStatus = "active"
+	SubscriptionPaused    SubscriptionStatus = "paused"
+	SubscriptionPastDue   SubscriptionStatus = "past_due"
+	SubscriptionCancelled SubscriptionStatus = "cancelled"
+)
+
+// Subscription orders Quantity of its plan's product every interval, on
+// NextRunAt. A subscription whose payment failed is past due until the
+// retry on RetryAt succeeds, or it runs out of retries and is cancelled.
+type Subscription struct {
+	ID               int                `json:"id"`
+	UserID           int                `json:"userId"`
+	PlanID           int                `json:"planId"`
+	Quantity         int                `json:"quantity"`
+	Status           SubscriptionStatus `json:"status"`
+	Currency         string             `json:"currency"`
+	PaymentSource    string             `json:"-"`
+	Destination      *Destination       `json:"destination"`
+	ShippingMethodID int                `json:"shippingMethodId"`
+	NextRunAt        time.Time          `json:"nextRunAt"`
+	RetryAt          *time.Time         `json:"retryAt"`
+	FailedAttempts   int                `json:"failedAttempts"`
+	CreatedAt        time.Time          `json:"createdAt"`
+	CancelledAt      *time.Time         `json:"cancelledAt"`
+	Plan             SubscriptionPlan   `json:"plan"`
+	Runs             []SubscriptionRun  `json:"runs,omitempty"`
+}
+
+// SubscribePayload subscribes to a plan, paying with PaymentSource. The
+// first order is placed on StartAt, or straight away.
+type SubscribePayload struct {
+	PlanID           int          `json:"planId" validate:"required"`
+	Quantity         int          `json:"quantity" validate:"required,min=1"`
+	PaymentSource    string       `json:"paymentSource" validate:"required,max=128"`
+	Destination      *Destination `json:"destination"`
+	ShippingMethodID int          `json:"shippingMethodId"`
+	StartAt          *time.Time   `json:"startAt"`
+}
+
+type UpdatePaymentSourcePayload struct {
+	PaymentSource string `json:"paymentSource" validate:"required,max=128"`
+}
+
+type SubscriptionRunStatus string
+
+const (
+	SubscriptionRunPaid    SubscriptionRunStatus = "paid"
+	SubscriptionRunPending SubscriptionRunStatus = "pending"
+	SubscriptionRunFailed  SubscriptionRunStatus = "failed"
+)
+
+// SubscriptionRun is an attempt to place and pay for a subscription's
+// order. OrderID is nil when the order could not be placed.
+type SubscriptionRun struct {
+	ID             int                   `json:"id"`
+	SubscriptionID int                   `json:"subscriptionId"`
+	OrderID        *int                  `json:"orderId"`
+	Status         SubscriptionRunStatus `json:"status"`
+	Reason         string                `json:"reason,omitempty"`
+	CreatedAt      time.Time             `json:"createdAt"`
+}
+
+type SubscriptionStore interface {
+	WithTx(tx DB) SubscriptionStore
+
+	CreatePlan(ctx context.Context, plan SubscriptionPlan) (int, error)
+	GetPlanById(ctx context.Context, id int) (*SubscriptionPlan, error)
+	GetPlansByProductId(ctx context.Context, productId int) ([]SubscriptionPlan, error)
+	SetPlanActive(ctx context.Context, id int, active bool) error
+
+	CreateSubscription(ctx context.Context, subscription Subscription) (int, error)
+	GetSubscriptionById(ctx context.Context, id int) (*Subscription, error)
+	GetSubscriptionsByUserId(ctx context.Context, userId int) ([]Subscription, error)
+	// GetDueSubscriptions lists the active subscriptions due to run and the
+	// past due ones due to be retried at the time, leaving out those on
+	// plans that were withdrawn.
+	GetDueSubscriptions(ctx context.Context, at time.Time) ([]Subscription, error)
+	// UpdateSubscription saves the status, schedule, failed attempts and
+	// payment source of the subscription.
+	UpdateSubscription(ctx context.Context, subscription Subscription) error
+	// RecordRun saves the run along with the subscription as it is after it.
+	// A run that already has an id is updated.
+	RecordRun(ctx context.Context, run SubscriptionRun, subscription Subscription) (int, error)
+	GetSubscriptionRuns(ctx context.Context, subscriptionId int) ([]SubscriptionRun, error)
+}
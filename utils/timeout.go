@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// RouteTimeouts gives every request a deadline, Default unless its route's
+// path template is listed in Routes. Stores take the request's context, so
+// queries still running at the deadline are cancelled.
+type RouteTimeouts struct {
+	Default time.Duration
+	Routes  map[string]time.Duration
+}
+
+// Middleware is meant for router.Use, which runs it after the route is
+// matched.
+func (t RouteTimeouts) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timeout := t.For(r)
+		if timeout <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (t RouteTimeouts) For(r *http.Request) time.Duration {
+	if route := mux.CurrentRoute(r); route != nil {
+		if template, err := route.GetPathTemplate(); err == nil {
+			if timeout, ok := t.Routes[template]; ok {
+				return timeout
+			}
+		}
+	}
+
+	return t.Default
+}
@@ -1,12 +1,14 @@
 package utils
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"math"
 	"net/http"
 
 	"github.com/go-playground/validator/v10"
+	"github.com/xelathan/golang_backend/money"
 )
 
 func ParseJSON(r *http.Request, payload any) error {
@@ -30,18 +32,50 @@ func WriteJSON(w http.ResponseWriter, status int, v any) error {
 	return err                  // Return any potential write error
 }
 
+// StatusClientClosedRequest is the non-standard status nginx logs for a
+// client that went away before it was answered.
+const StatusClientClosedRequest = 499
+
+// WriteError responds with err. Requests cut short by their context are not
+// server faults, so a 500 for one becomes a 499 when the client went away
+// and a 503 when the route's deadline passed.
 func WriteError(w http.ResponseWriter, status int, err error) {
+	if status == http.StatusInternalServerError {
+		switch {
+		case errors.Is(err, context.Canceled):
+			status = StatusClientClosedRequest
+		case errors.Is(err, context.DeadlineExceeded):
+			status = http.StatusServiceUnavailable
+		}
+	}
+
 	WriteJSON(w, status, map[string]string{"error": err.Error()})
 }
 
 var Validate = validator.New()
 
-func ValidateDecimalPlaces(fl validator.FieldLevel) bool {
-	value, ok := fl.Field().Interface().(float64)
+func init() {
+	Validate.RegisterValidation("money", ValidateMoney)
+	Validate.RegisterValidation("money_positive", ValidatePositiveMoney)
+}
+
+// ValidateMoney accepts a money.Money in a supported currency that is not
+// negative. Decimal places are already enforced when the amount is parsed.
+func ValidateMoney(fl validator.FieldLevel) bool {
+	value, ok := fl.Field().Interface().(money.Money)
+	if !ok {
+		return false
+	}
+
+	return money.IsValidCurrency(value.Currency) && !value.IsNegative()
+}
+
+// ValidatePositiveMoney is ValidateMoney that also rejects zero amounts.
+func ValidatePositiveMoney(fl validator.FieldLevel) bool {
+	value, ok := fl.Field().Interface().(money.Money)
 	if !ok {
 		return false
 	}
 
-	truncated := math.Trunc(value * 100)
-	return value*100 == truncated
+	return money.IsValidCurrency(value.Currency) && value.IsPositive()
 }
@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestWriteError(t *testing.T) {
+	cases := map[string]struct {
+		err    error
+		status int
+	}{
+		"should report a client that went away": {fmt.Errorf("query: %w", context.Canceled), StatusClientClosedRequest},
+		"should report a deadline that passed":  {context.DeadlineExceeded, http.StatusServiceUnavailable},
+		"should keep other errors as they are":  {fmt.Errorf("database unavailable"), http.StatusInternalServerError},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			WriteError(rr, http.StatusInternalServerError, c.err)
+
+			if rr.Code != c.status {
+				t.Errorf("expected status code %d, got %d", c.status, rr.Code)
+			}
+		})
+	}
+}
+
+func TestRouteTimeouts(t *testing.T) {
+	timeouts := RouteTimeouts{Default: time.Second, Routes: map[string]time.Duration{"/slow/{id}": time.Minute}}
+
+	deadline := func(path string) time.Duration {
+		var left time.Duration
+		router := mux.NewRouter()
+		router.Use(timeouts.Middleware)
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			if d, ok := r.Context().Deadline(); ok {
+				left = time.Until(d)
+			}
+		}
+		router.HandleFunc("/slow/{id}", handler)
+		router.HandleFunc("/fast", handler)
+
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+
+		return left
+	}
+
+	t.Run("should give listed routes their own deadline", func(t *testing.T) {
+		if left := deadline("/slow/1"); left <= time.Second || left > time.Minute {
+			t.Errorf("expected a minute to run, got %s", left)
+		}
+	})
+
+	t.Run("should give other routes the default deadline", func(t *testing.T) {
+		if left := deadline("/fast"); left <= 0 || left > time.Second {
+			t.Errorf("expected a second to run, got %s", left)
+		}
+	})
+}